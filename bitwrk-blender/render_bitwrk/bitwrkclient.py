@@ -47,6 +47,12 @@ def do_probe_bitwrk_client(settings):
         LAST_PROBE_SETTINGS = settings_string(settings)
         LAST_PROBE_THREAD = None
     
+def auth_headers(settings):
+    """Returns the headers presenting the API token to the BitWrk client, if one is defined"""
+    if settings.bitwrk_client_token:
+        return {'Authorization': "Bearer " + settings.bitwrk_client_token}
+    return {}
+
 def settings_string(settings):
     return "{}:{}".format(settings.bitwrk_client_host, settings.bitwrk_client_port)
     
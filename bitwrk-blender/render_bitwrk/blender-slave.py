@@ -30,7 +30,12 @@ if sys.version_info[:2] < (3,2):
 import urllib.request, urllib.parse, urllib.error
 import http.server, socket, struct, os, tempfile, subprocess
 from select import select
-from threading import Thread
+from threading import Thread, Event
+
+# Lease requested from the BitWrk client, which unregisters the worker unless it is renewed
+LEASE = "2m"
+# Seconds between heartbeats sent to the BitWrk client for renewing the lease
+HEARTBEAT_INTERVAL = 30
         
 # decode http chunked encoding
 class Unchunked:
@@ -353,6 +358,13 @@ def get_bitwrk_url():
     else:
         return "http://%s:%d" % (BITWRK_HOST, BITWRK_PORT)
 
+def open_bitwrk_url(path, data=None):
+    """Sends a request to the BitWrk client, presenting the API token if one is defined"""
+    request = urllib.request.Request(get_bitwrk_url() + path, data)
+    if BITWRK_TOKEN:
+        request.add_header("Authorization", "Bearer " + BITWRK_TOKEN)
+    return urllib.request.urlopen(request, None, 10)
+
 def probe_bitwrk_client():
     """Tries to find out whether there is a BitWrk client at the defined URL"""
     bitwrkurl = get_bitwrk_url()
@@ -393,16 +405,19 @@ def register_with_bitwrk_client():
     query = urllib.parse.urlencode({
         'id' : get_worker_id(),
         'article' : ARTICLE_ID,
-        'pushurl' : get_push_url()
+        'pushurl' : get_push_url(),
+        'lease' : LEASE
     })
     
     bitwrkurl = get_bitwrk_url()
     try:
-        urllib.request.urlopen(bitwrkurl + "/registerworker", query.encode('ascii'), 10)
+        open_bitwrk_url("/registerworker", query.encode('ascii'))
     except urllib.error.HTTPError as ex:
         print(" > Got a {} ({}) error when trying to register on {}"
               .format(ex.code, ex.reason, bitwrkurl + "/registerworker"))
-        if ex.code == 404:
+        if ex.code in (401, 403):
+            print("   Please pass an API token with scope 'worker' using --bitwrk-token!")
+        elif ex.code == 404:
             print("   This usually means that the BitWrk client does not accept workers.")
             print("   Please start it with the -extport argument!")
         return False
@@ -456,6 +471,9 @@ def parse_args():
     parser.add_argument('--blender', metavar='PATH', help="Blender executable to call", required=True)
     parser.add_argument('--bitwrk-host', metavar='HOST', help="BitWrk client host [localhost]", default="localhost")
     parser.add_argument('--bitwrk-port', metavar='PORT', help="BitWrk client port [8081]", type=int, default=8081)
+    parser.add_argument('--bitwrk-token', metavar='TOKEN',
+        help="API token for accessing the BitWrk client from another host [$BITWRK_TOKEN]",
+        default=os.environ.get('BITWRK_TOKEN', ''))
     parser.add_argument('--max-cost', metavar='CLASS', help="Maximum cost of one task (in mega- and giga-rays) [512M]",
         choices=["512M", "2G", "8G", "32G"], default="512M")
     parser.add_argument('--listen-port', metavar='PORT',
@@ -482,6 +500,7 @@ if __name__ == "__main__":
     
     BITWRK_HOST=args.bitwrk_host
     BITWRK_PORT=args.bitwrk_port
+    BITWRK_TOKEN=args.bitwrk_token
     ARTICLE_ID="net.bitwrk/blender/0/{}/{}".format(BLENDER_VERSION, args.max_cost)
     if args.trusted:
         ARTICLE_ID=ARTICLE_ID+"~trusted"
@@ -537,7 +556,29 @@ if __name__ == "__main__":
     def reregister_with_bitwrk_client():
         return register_with_bitwrk_client()
     
+    # Renew this worker's lease at the BitWrk client, or it will be unregistered
+    stopping = Event()
+    def send_heartbeats():
+        query = urllib.parse.urlencode({
+            'id' : get_worker_id()
+        })
+        while not stopping.wait(HEARTBEAT_INTERVAL):
+            try:
+                open_bitwrk_url("/worker/heartbeat", query.encode('ascii'))
+            except urllib.error.HTTPError as ex:
+                if ex.code == 404:
+                    print(" > Worker was unregistered by BitWrk client. Registering again.")
+                    register_with_bitwrk_client()
+                else:
+                    print(" > Got a {} ({}) error when sending heartbeat".format(ex.code, ex.reason))
+            except urllib.error.URLError as ex:
+                print(" > Couldn't send heartbeat to BitWrk client:", ex.reason)
+    heartbeats = Thread(target=send_heartbeats)
+    heartbeats.daemon = True
+    heartbeats.start()
+
     def handler(sig, stack):
+        stopping.set()
         t = Thread(target=httpd.shutdown)
         t.start()
     signal.signal(signal.SIGINT, handler)
@@ -551,5 +592,5 @@ if __name__ == "__main__":
     query = urllib.parse.urlencode({
         'id' : get_worker_id()
     })
-    urllib.request.urlopen("http://%s:%d/unregisterworker" % (BITWRK_HOST, BITWRK_PORT), query.encode('ascii'), 10)
+    open_bitwrk_url("/unregisterworker", query.encode('ascii'))
     print(" > Worker stopped")
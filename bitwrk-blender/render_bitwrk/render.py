@@ -24,7 +24,7 @@ from render_bitwrk.tagged import Tagged
 from render_bitwrk.common import get_article_id, max_tilesize, render_resolution
 from render_bitwrk.tiling import optimal_tiling
 from render_bitwrk.blendfile import save_copy, process_file
-from render_bitwrk.bitwrkclient import probe_bitwrk_client
+from render_bitwrk.bitwrkclient import probe_bitwrk_client, auth_headers
 from cycles.engine import register_passes
 
 class Tile:
@@ -55,6 +55,8 @@ class Tile:
         try:
             self.conn.putrequest("POST", "/buy/" + get_article_id(settings.complexity, settings.trusted_render))
             self.conn.putheader('Transfer-Encoding', 'chunked')
+            for name, value in auth_headers(settings).items():
+                self.conn.putheader(name, value)
             self.conn.endheaders()
             chunked = Chunked(self.conn)
             try:
@@ -93,10 +95,10 @@ class Tile:
                     with tempfile.TemporaryDirectory() as tmpdir:
                         filename = os.path.join(tmpdir, "result.exr")
                         with open(filename, "wb") as tmpfile,\
-                            urllib.request.urlopen("http://{}:{}{}".format(
+                            urllib.request.urlopen(urllib.request.Request("http://{}:{}{}".format(
                                 settings.bitwrk_client_host,
                                 settings.bitwrk_client_port,
-                                location)) as response:
+                                location), headers=auth_headers(settings))) as response:
                             data = response.read(32768)
                             while len(data) > 0:
                                 tmpfile.write(data)
@@ -41,6 +41,12 @@ class BitWrkSettings(bpy.types.PropertyGroup):
             default=8081,
             min=1,
             max=65535)
+        settings.bitwrk_client_token = StringProperty(
+            name="BitWrk client token",
+            description="API token for accessing a BitWrk client on another host (needs scopes 'buy' and 'worker')",
+            maxlen=180,
+            subtype='PASSWORD',
+            default="")
         settings.complexity = EnumProperty(
             name="Complexity",
             description="Defines the maximum allowed computation complexity for each rendered tile",
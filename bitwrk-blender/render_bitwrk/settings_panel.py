@@ -18,7 +18,7 @@
 #
 # ##### END GPL LICENSE BLOCK #####
 
-import bpy, webbrowser
+import bpy, urllib.parse, webbrowser
 from bpy.props import StringProperty, IntProperty, PointerProperty, EnumProperty, FloatProperty
 from render_bitwrk.common import get_article_id, max_tilesize, render_resolution
 from render_bitwrk.tiling import optimal_tiling
@@ -37,7 +37,10 @@ class StartBrowserOperator(bpy.types.Operator):
     
     def execute(self, context):
         settings=context.scene.bitwrk_settings
-        webbrowser.open("http://{}:{}/".format(settings.bitwrk_client_host, settings.bitwrk_client_port)) 
+        url = "http://{}:{}/".format(settings.bitwrk_client_host, settings.bitwrk_client_port)
+        if settings.bitwrk_client_token:
+            url += "?" + urllib.parse.urlencode({'token': settings.bitwrk_client_token})
+        webbrowser.open(url)
         return {'FINISHED'}
 
     def invoke(self, context, event):
@@ -136,6 +139,9 @@ class RENDER_PT_bitwrk_settings(bpy.types.Panel):
             row = layout.split(factor=0.5)
             row.label(text="BitWrk client port:")
             row.prop(settings, "bitwrk_client_port", text="")
+            row = layout.split(factor=0.5)
+            row.label(text="BitWrk client token:")
+            row.prop(settings, "bitwrk_client_token", text="")
         
         if not bitwrkclient.probe_bitwrk_client(settings):
             row = self.layout.split(factor=0.5)
@@ -64,8 +64,13 @@ def start_worker(settings):
     if settings.trusted_render:
         args += ["--trusted"]
 
+    # The token is passed via environment so that it doesn't show up in process listings
+    env = dict(os.environ)
+    if settings.bitwrk_client_token:
+        env['BITWRK_TOKEN'] = settings.bitwrk_client_token
+
     print("Starting worker:", args)
-    WORKER_PROC = subprocess.Popen(args)
+    WORKER_PROC = subprocess.Popen(args, env=env)
     atexit.register(_exithandler)
     
 def can_stop_worker():
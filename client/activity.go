@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/indyjo/bitwrk/client/diskstorage"
 	"github.com/indyjo/bitwrk/common/bitcoin"
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/common/money"
@@ -37,6 +38,8 @@ var ErrInterrupted = errors.New("The request was interrupted")
 var ErrBidExpired = errors.New("Bid expired without match")
 var ErrTxExpired = errors.New("Transaction no longer active")
 var ErrTxUnexpectedState = errors.New("Transaction in unexpected state")
+var ErrResultRejected = errors.New("Result was rejected by validator")
+var ErrNoVerdict = errors.New("Validator couldn't reach a verdict on the result")
 
 // Activity keys identify activities (trades), as well as mandates within
 // the activity manager. Name spaces may overlap.
@@ -66,6 +69,13 @@ type ActivityState struct {
 	Info        string
 	Phase       string // The phase the activity's active object is in
 
+	// Outcome of validating a buy's result (VerdictAccepted or VerdictRejected),
+	// empty if no validator was applied
+	Verdict, VerdictReason string
+
+	// Failed attempts of a buy to trade with a remote seller, oldest first
+	Attempts []TradeAttempt
+
 	// Information about a transmission in progress
 	BytesTotal       int64
 	BytesToTransfer  int64
@@ -80,7 +90,11 @@ type ActivityManager struct {
 	history    []Activity
 	nextKey    ActivityKey
 	storage    cafs.FileStorage
-	bidTokens  map[string]chan bool
+	bidTokens  map[string]int    // Number of tokens checked out per resource
+	tokenWake  chan bool         // Closed and replaced whenever a token is returned
+	stateFile  string            // Where the state is persisted, see InitPersistence
+	saveChan   chan bool         // Requests saving the state
+	exhausted  map[string]string // Definitions of expired configured mandates, by origin
 }
 
 var activityManager = ActivityManager{
@@ -90,10 +104,24 @@ var activityManager = ActivityManager{
 	make(map[ActivityKey]*Mandate),
 	make([]Activity, 0, 5), //history
 	1,
-	ram.NewRamStorage(3 * 512 * 1024 * 1024), // 1536 MByte
-	make(map[string]chan bool),
+	nil, // storage, see InitStorage
+	make(map[string]int),
+	make(chan bool),
+	"", // stateFile, see InitPersistence
+	make(chan bool, 1),
+	make(map[string]string),
 }
 
+// Size of the in-memory content-addressable storage, in megabytes.
+var StorageRamMegabytes int64 = 1536
+
+// Directory where content-addressable storage is persisted across restarts.
+// If empty, files are only kept in memory.
+var StorageDir string
+
+// Maximum size of the persisted content-addressable storage, in megabytes.
+var StorageDiskMegabytes int64 = 8192
+
 func GetActivityManager() *ActivityManager {
 	return &activityManager
 }
@@ -113,6 +141,7 @@ func (m *ActivityManager) NewBuy(article bitwrk.ArticleId, identity *bitcoin.Key
 			awaitingClearance: true,
 			identity:          identity,
 		},
+		retryPolicy: CurrentSettings().Retries,
 	}
 	// This will local-match the buy if possible.
 	// Don't apply any mandates if a price was set by the requester.
@@ -135,7 +164,7 @@ func (m *ActivityManager) NewSell(worker Worker, identity *bitcoin.KeyPair, loca
 			started:           now,
 			lastUpdate:        now,
 			bidType:           bitwrk.Sell,
-			article:           worker.GetWorkerState().Info.Article,
+			article:           worker.GetWorkerInfo().Article,
 			encResultKey:      new(bitwrk.Tkey),
 			alive:             true,
 			awaitingClearance: true,
@@ -154,6 +183,20 @@ func (m *ActivityManager) NewSell(worker Worker, identity *bitcoin.KeyPair, loca
 	return result, nil
 }
 
+// Creates the content-addressable storage according to StorageRamMegabytes, StorageDir and
+// StorageDiskMegabytes. Must be called before any activity is created.
+func (m *ActivityManager) InitStorage() error {
+	cache := ram.NewRamStorage(StorageRamMegabytes * 1024 * 1024)
+	if StorageDir == "" {
+		m.storage = cache
+	} else if s, err := diskstorage.New(cache, StorageDir, StorageDiskMegabytes*1024*1024); err != nil {
+		return err
+	} else {
+		m.storage = s
+	}
+	return nil
+}
+
 func (m *ActivityManager) GetStorage() cafs.FileStorage {
 	return m.storage
 }
@@ -279,9 +322,10 @@ func (m *ActivityManager) register(key ActivityKey, activity Activity, skipManda
 	for mandateKey, mandate := range m.mandates {
 		applied := mandate.Apply(activity, now)
 		if mandate.Expired() {
-			delete(m.mandates, mandateKey)
+			m.dropExpiredMandate(mandateKey, mandate)
 		}
 		if applied {
+			m.requestSave()
 			break
 		}
 	}
@@ -296,12 +340,17 @@ func (m *ActivityManager) unregister(key ActivityKey) {
 	}
 
 	// Append to history
-	if len(m.history) == 100 {
+	if len(m.history) == HistorySize {
 		copy(m.history[:len(m.history)-1], m.history[1:])
 		m.history = m.history[:len(m.history)-1]
 	}
-	m.history = append(m.history, activity)
+	m.history = append(m.history, &archivedActivity{
+		key:      key,
+		live:     activity,
+		archived: time.Now(),
+	})
 	delete(m.activities, key)
+	m.requestSave()
 }
 
 // Registers the mandate (using an activity key for identification)
@@ -310,12 +359,56 @@ func (m *ActivityManager) RegisterMandate(key ActivityKey, mandate *Mandate) {
 	m.mandates[key] = mandate
 	m.mutex.Unlock()
 	m.applyMandate(m.GetActivities(), mandate, key)
+	m.requestSave()
 }
 
 func (m *ActivityManager) UnregisterMandate(key ActivityKey) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	delete(m.mandates, key)
+	m.requestSave()
+}
+
+// Removes a mandate that has expired. Mandates defined in the configuration are remembered
+// as exhausted, so that they aren't created anew as long as their definition is unchanged.
+// Must be called with the lock held.
+func (m *ActivityManager) dropExpiredMandate(key ActivityKey, mandate *Mandate) {
+	delete(m.mandates, key)
+	if mandate.Origin != "" {
+		m.exhausted[mandate.Origin] = mandate.Definition
+	}
+	m.requestSave()
+}
+
+// Returns the definitions of expired mandates defined in the configuration, by origin.
+func (m *ActivityManager) ExhaustedMandates() map[string]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := make(map[string]string, len(m.exhausted))
+	for origin, definition := range m.exhausted {
+		result[origin] = definition
+	}
+	return result
+}
+
+// Forgets that the mandate defined by the given configuration entry has expired, e.g.
+// because the entry was changed or removed.
+func (m *ActivityManager) ForgetExhaustedMandate(origin string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.exhausted[origin]; ok {
+		delete(m.exhausted, origin)
+		m.requestSave()
+	}
+}
+
+// Applies all mandates to the activities still awaiting publication. This is necessary
+// for activities held back by a mandate's spending limits when these limits change.
+func (m *ActivityManager) ReapplyMandates() {
+	activities := m.GetActivities()
+	for key, mandate := range m.GetMandates() {
+		m.applyMandate(activities, mandate, key)
+	}
 }
 
 func (m *ActivityManager) applyMandate(activities []Activity, mandate *Mandate, mandateKey ActivityKey) {
@@ -324,40 +417,56 @@ func (m *ActivityManager) applyMandate(activities []Activity, mandate *Mandate,
 	// If the mandate expires, remove it.
 	for _, a := range activities {
 		state := a.GetState()
-		if !state.Accepted {
+		if state.Alive && !state.Accepted {
 			mandate.Apply(a, now)
 			if mandate.Expired() {
-				m.UnregisterMandate(mandateKey)
+				m.mutex.Lock()
+				m.dropExpiredMandate(mandateKey, mandate)
+				m.mutex.Unlock()
 			}
 		}
 	}
 }
 
 // Consume a limited resource. The resource is named by the key parameter and limited to up
-// to 'limit' checked out tokens.
-func (m *ActivityManager) checkoutToken(ctx context.Context, key string, limit int) error {
+// to 'limit' checked out tokens, which is taken from the current settings. The limit is
+// re-evaluated whenever a token is returned or the settings change.
+func (m *ActivityManager) checkoutToken(ctx context.Context, key string, limit func(Settings) int) error {
 	m.mutex.Lock()
-	tokenChan := m.bidTokens[key]
-	if tokenChan == nil {
-		// Initialize tokens if not done so already
-		tokenChan = make(chan bool, limit)
-		m.bidTokens[key] = tokenChan
-		for i := 0; i < limit; i++ {
-			tokenChan <- true
+	defer m.mutex.Unlock()
+	for m.bidTokens[key] >= limit(CurrentSettings()) {
+		wake := m.tokenWake
+		m.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			m.mutex.Lock()
+			return ErrInterrupted
+		case <-wake:
 		}
+		m.mutex.Lock()
 	}
-	m.mutex.Unlock()
-	select {
-	case <-ctx.Done():
-		return ErrInterrupted
-	case <-tokenChan:
-		return nil
-	}
+	m.bidTokens[key]++
+	return nil
 }
 
 func (m *ActivityManager) returnToken(key string) {
 	m.mutex.Lock()
-	tokenChan := m.bidTokens[key]
-	m.mutex.Unlock()
-	tokenChan <- true
+	defer m.mutex.Unlock()
+	m.bidTokens[key]--
+	m.wakeTokenWaiters()
+}
+
+// Lets all callers waiting for tokens re-evaluate their limits. Must be called with the
+// lock held.
+func (m *ActivityManager) wakeTokenWaiters() {
+	close(m.tokenWake)
+	m.tokenWake = make(chan bool)
+}
+
+// Function LimitsChanged must be called after the limits on tokens (e.g.
+// Settings.NumUnmatchedBids) have been changed at runtime. SetSettings calls it.
+func (m *ActivityManager) LimitsChanged() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.wakeTokenWaiters()
 }
@@ -0,0 +1,93 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	. "github.com/indyjo/bitwrk/common/protocol"
+)
+
+// How long catalog entries fetched from the server are kept before being fetched again
+var ArticleCacheDuration = 10 * time.Minute
+
+type cachedArticle struct {
+	article *bitwrk.Article
+	fetched time.Time
+}
+
+// The fee ratio the server applies to articles whose catalog entry doesn't specify one
+var DefaultFeeRatioNumerator, DefaultFeeRatioDenominator int64 = 3, 100
+
+var articlesMutex sync.Mutex
+var articles = make(map[bitwrk.ArticleId]cachedArticle)
+
+// Function GetArticleInfo returns the server's catalog entry for an article, using a
+// cached copy if available. If the entry can't be fetched again, an outdated copy is used.
+func GetArticleInfo(article bitwrk.ArticleId) (*bitwrk.Article, error) {
+	articlesMutex.Lock()
+	cached, ok := articles[article]
+	articlesMutex.Unlock()
+	if ok && time.Since(cached.fetched) < ArticleCacheDuration {
+		return cached.article, nil
+	}
+
+	info, err := FetchArticle(article)
+	if err != nil && ok {
+		return cached.article, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	articlesMutex.Lock()
+	articles[article] = cachedArticle{info, time.Now()}
+	articlesMutex.Unlock()
+	return info, nil
+}
+
+// Function CheckWorkSize returns an error if work data of the given size exceeds the
+// limit configured in the article catalog. If the catalog entry isn't available, e.g.
+// because of network problems or because the server has no catalog, no limit is applied.
+func CheckWorkSize(article bitwrk.ArticleId, size int64) error {
+	info, err := GetArticleInfo(article)
+	if err != nil {
+		bitwrk.Root().Printf("Not checking work size, error fetching info on article %v: %v", article, err)
+		return nil
+	}
+	if info.MaxWorkSize != 0 && size > info.MaxWorkSize {
+		return fmt.Errorf("Work data of %v bytes exceeds maximum of %v bytes for article %v",
+			size, info.MaxWorkSize, article)
+	}
+	return nil
+}
+
+// Function EstimateFee returns the fee the server is expected to charge on a bid of the
+// given price, rounding up like the server does. Only an already cached catalog entry is
+// consulted, so calling it never blocks. Buys fetch the entry before being published.
+func EstimateFee(article bitwrk.ArticleId, price money.Money) money.Money {
+	num, den := DefaultFeeRatioNumerator, DefaultFeeRatioDenominator
+	articlesMutex.Lock()
+	if cached, ok := articles[article]; ok && cached.article.FeeRatioDenominator != 0 {
+		num, den = cached.article.FeeRatioNumerator, cached.article.FeeRatioDenominator
+	}
+	articlesMutex.Unlock()
+	return money.Money{Currency: price.Currency, Amount: (num*price.Amount + den - 1) / den}
+}
@@ -43,6 +43,10 @@ import (
 
 type BuyActivity struct {
 	Trade
+
+	retryPolicy RetryPolicy
+	redundancy  *RedundantBuy // Set if the buy is part of a redundant buy
+	sellers     *sellerClaims // Set if sellers are shared with other buys of the same work
 }
 
 // Manages the complete lifecycle of a buy, which can either be local or remote.
@@ -55,10 +59,14 @@ func (a *BuyActivity) PerformBuy(ctx context.Context, log bitwrk.Logger, workFil
 		a.alive = false
 		log.Printf("Buy finished")
 	})
+	defer a.releaseMandate()
 
 	file, err := a.doPerformBuy(ctx, log)
 	if err != nil {
 		a.execSync(func() { a.lastError = err })
+		if a.redundancy != nil {
+			a.redundancy.reportFailure(a)
+		}
 	}
 
 	return file, err
@@ -66,15 +74,23 @@ func (a *BuyActivity) PerformBuy(ctx context.Context, log bitwrk.Logger, workFil
 
 // Waits for clearance and then performs either a local or a remote buy, depending on the decision taken.
 func (a *BuyActivity) doPerformBuy(ctx context.Context, log bitwrk.Logger) (cafs.File, error) {
+	if err := CheckWorkSize(a.article, a.workFile.Size()); err != nil {
+		return nil, err
+	}
+
 	if err := a.awaitClearance(ctx, log); err != nil {
 		return nil, err
 	}
 
-	if a.localMatch != nil {
-		return a.doLocalBuy(ctx, log)
-	} else {
-		return a.doRemoteBuy(ctx, log)
+	if a.localMatch == nil {
+		return a.doRemoteBuyWithRetries(ctx, log)
+	}
+
+	result, err := a.doLocalBuy(ctx, log)
+	if err == nil && a.redundancy != nil && !a.redundancy.crossCheck(ctx, log, a, result) {
+		return nil, ErrResultMismatch
 	}
+	return result, err
 }
 
 // Performs a local buy.
@@ -105,12 +121,23 @@ func (a *BuyActivity) doLocalBuy(ctx context.Context, log bitwrk.Logger) (cafs.F
 	}
 }
 
-// Performs a remote buy once it has been cleared.
-func (a *BuyActivity) doRemoteBuy(ctx context.Context, log bitwrk.Logger) (cafs.File, error) {
+// Performs a remote buy once it has been cleared. Transactions with one of the
+// excluded sellers are abandoned.
+func (a *BuyActivity) doRemoteBuy(ctx context.Context, log bitwrk.Logger, excluded map[string]bool) (cafs.File, error) {
 	defer a.returnTransmissionToken()
 	if err := a.beginRemoteTrade(ctx, log); err != nil {
 		return nil, err
 	}
+	if excluded[a.tx.Seller] {
+		return nil, ErrExcludedSeller
+	}
+	if a.sellers != nil && !a.sellers.claim(a.key, a.tx.Seller) {
+		return nil, ErrExcludedSeller
+	}
+	if ctx.Err() != nil {
+		// Not established yet, the transaction will time out and reimburse the buyer
+		return nil, ErrInterrupted
+	}
 
 	// draw random bytes for buyer's secret
 	var secret bitwrk.Thash
@@ -147,15 +174,24 @@ func (a *BuyActivity) doRemoteBuy(ctx context.Context, log bitwrk.Logger) (cafs.
 		return nil, fmt.Errorf("Error awaiting TRANSMITTING phase: %v", err)
 	}
 
+	// Grant the seller more time if asked for, as long as the policy allows
+	exitGranting := make(chan bool)
+	go a.grantExtensions(log, exitGranting)
+
 	var sellerErr error
-	if err := a.interactWithSeller(log.New("transmitting")); err != nil {
+	if err := a.interactWithSeller(ctx, log.New("transmitting")); err != nil {
 		sellerErr = fmt.Errorf("Error transmitting work and receiving encrypted result: %v", err)
 	}
+	if ctx.Err() != nil {
+		close(exitGranting)
+		return nil, a.abortTrade(log)
+	}
 
 	var phaseErr error
 	if err := a.waitForTransactionPhase(log, bitwrk.PhaseUnverified, bitwrk.PhaseTransmitting, bitwrk.PhaseWorking); err != nil {
 		phaseErr = fmt.Errorf("Error awaiting UNVERIFIED phase: %v", err)
 	}
+	close(exitGranting)
 
 	if sellerErr == nil && phaseErr == nil {
 		// Everythong went fine, continue
@@ -173,18 +209,71 @@ func (a *BuyActivity) doRemoteBuy(ctx context.Context, log bitwrk.Logger) (cafs.
 		return nil, fmt.Errorf("Error decrypting result: %v", err)
 	}
 
-	// In normal buys (without verifying), we can leave the rest as homework
-	// for a goroutine and exit here.
+	if ctx.Err() != nil {
+		return nil, a.abortTrade(log)
+	}
+
+	var rejection error
+	if accept, err := a.validateResult(log.New("validating")); err != nil {
+		// Without a verdict, the result is neither accepted nor disputed. The transaction
+		// is left to time out.
+		log.Printf("Leaving transaction alone: %v", err)
+		return nil, ErrNoVerdict
+	} else if !accept {
+		rejection = ErrResultRejected
+	} else if a.redundancy != nil && !a.redundancy.crossCheck(ctx, log.New("cross-checking"), a, a.resultFile) {
+		rejection = ErrResultMismatch
+	}
+
+	// Accepting or rejecting the result on the server is left as homework
+	// for a goroutine and we can exit here.
 	go func() {
-		if err := a.finishBuy(log); err != nil {
+		if err := a.finishBuy(log, rejection == nil); err != nil {
 			log.Printf("Error finishing buy: %v", err)
 		}
 	}()
 
+	if rejection != nil {
+		return nil, rejection
+	}
 	return a.resultFile, nil
 }
 
-func (a *BuyActivity) finishBuy(log bitwrk.Logger) error {
+// Applies the result validator configured for the article, if any, and records the verdict.
+// Returns true if the result is to be accepted. An error means that no verdict could be
+// reached, e.g. because the validator timed out or couldn't be run.
+// Validation isn't bound to the buy's context: once the result has been delivered, a
+// disconnecting caller must not turn into a rejection.
+func (a *BuyActivity) validateResult(log bitwrk.Logger) (bool, error) {
+	validator := GetResultValidator(a.article)
+	if validator == nil {
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CurrentSettings().ValidationTimeout)
+	defer cancel()
+	accept, reason, err := validator.Validate(ctx, a.article, a.resultFile)
+	if err != nil {
+		log.Printf("Validator %v failed: %v", validator, err)
+		a.execSync(func() { a.verdictReason = fmt.Sprintf("Validation failed: %v", err) })
+		return false, err
+	}
+
+	verdict := VerdictAccepted
+	if !accept {
+		verdict = VerdictRejected
+	}
+	log.Printf("Validator %v: %v %v", validator, verdict, reason)
+	a.execSync(func() {
+		a.verdict = verdict
+		a.verdictReason = reason
+	})
+	return accept, nil
+}
+
+// Tells the server whether the result is accepted or rejected and waits for the
+// transaction to end.
+func (a *BuyActivity) finishBuy(log bitwrk.Logger, accept bool) error {
 	// Start polling for transaction state changes in background
 	abortPolling := make(chan bool)
 	defer func() {
@@ -194,11 +283,18 @@ func (a *BuyActivity) finishBuy(log bitwrk.Logger) error {
 		a.pollTransaction(log, abortPolling)
 	}()
 
-	if err := SendTxMessageAcceptResult(a.txId, a.identity); err != nil {
-		return fmt.Errorf("Failed to send 'accept result' message: %v", err)
+	if accept {
+		if err := SendTxMessageAcceptResult(a.txId, a.identity); err != nil {
+			return fmt.Errorf("Failed to send 'accept result' message: %v", err)
+		}
+	} else if err := SendTxMessageRejectResult(a.txId, a.identity); err != nil {
+		return fmt.Errorf("Failed to send 'reject result' message: %v", err)
 	}
 
-	a.waitWhile(func() bool { return a.tx.State == bitwrk.StateActive })
+	// A rejected result is disputed until an arbiter rules on it. Don't wait for that.
+	a.waitWhile(func() bool {
+		return a.tx.State == bitwrk.StateActive && a.tx.Phase != bitwrk.PhaseResultDisputed
+	})
 	a.execSync(func() { a.alive = false })
 	return nil
 }
@@ -245,15 +341,15 @@ func (a *BuyActivity) testSellerForCapabilities(log bitwrk.Logger, client *http.
 // The result is either an error or nil. In the latter case, a.encResultFile contains
 // the result data encrypted with a key that the seller will hand out after we have signed
 // a receipt for the encrypted result.
-func (a *BuyActivity) interactWithSeller(log bitwrk.Logger) error {
+func (a *BuyActivity) interactWithSeller(ctx context.Context, log bitwrk.Logger) error {
 	// Use a watchdog to make sure that all connections created in the call time of this
 	// function are closed when the transaction leaves the active state or the allowed
-	// phases.
+	// phases, or when the buy is interrupted.
 	// Transaction polling is guaranteed by the calling function.
 	exitChan := make(chan bool)
 	connChan := make(chan io.Closer)
 	go a.watchdog(log, exitChan, connChan, func() bool {
-		return a.tx.State == bitwrk.StateActive &&
+		return ctx.Err() == nil && a.tx.State == bitwrk.StateActive &&
 			(a.tx.Phase == bitwrk.PhaseSellerEstablished ||
 				a.tx.Phase == bitwrk.PhaseTransmitting ||
 				a.tx.Phase == bitwrk.PhaseWorking)
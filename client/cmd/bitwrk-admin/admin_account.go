@@ -0,0 +1,145 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Number of ledger entries shown per page unless specified otherwise.
+const ledgerPageSize = 20
+
+// Shows the balances and deposit information of an account, defaulting to the current
+// identity's account.
+func cmdAccount(identity *bitcoin.KeyPair, trustedAccount string, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Wrong number of arguments for account. Expected: 1 or 2, got: %v.", len(args))
+	}
+	participant := identity.GetAddress()
+	if len(args) == 2 {
+		participant = args[1]
+	}
+
+	account, err := protocol.FetchAccount(participant)
+	if err != nil {
+		return err
+	}
+	log.Printf("Account: %v", account.Participant)
+	log.Printf("Available: %v", money.Money{Amount: account.AvailableAmount, Currency: account.Currency})
+	log.Printf("Blocked: %v", money.Money{Amount: account.BlockedAmount, Currency: account.Currency})
+	if account.LastMovementKey != nil {
+		log.Printf("Last ledger entry: %v", *account.LastMovementKey)
+	}
+
+	if account.DepositInfo != "" {
+		var m bitwrk.DepositAddressMessage
+		if v, err := url.ParseQuery(account.DepositInfo); err != nil {
+			log.Printf("Deposit info is malformed: %v", err)
+		} else {
+			m.FromValues(v)
+			if err := m.VerifyWith(trustedAccount); err != nil {
+				log.Printf("Deposit address: %v (INVALID: %v)", m.DepositAddress, err)
+			} else {
+				log.Printf("Deposit address: %v (verified, %v)", m.DepositAddress, account.LastDepositInfo)
+			}
+		}
+	}
+	if account.DepositAddressRequest != "" {
+		log.Printf("A new deposit address has been requested")
+	}
+	return nil
+}
+
+// Pages through an account's ledger, newest entries first. Each page ends with the
+// arguments needed to show the next one.
+func cmdLedger(identity *bitcoin.KeyPair, args []string) error {
+	participant := identity.GetAddress()
+	var from *string
+	count := ledgerPageSize
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "from=") {
+			key := arg[len("from="):]
+			from = &key
+		} else if strings.HasPrefix(arg, "count=") {
+			if n, err := strconv.Atoi(arg[len("count="):]); err != nil || n <= 0 {
+				return fmt.Errorf("Illegal count: %#v", arg)
+			} else {
+				count = n
+			}
+		} else if strings.Contains(arg, "=") {
+			return fmt.Errorf("Unknown ledger option: %#v", arg)
+		} else {
+			participant = arg
+		}
+	}
+
+	if from == nil {
+		if account, err := protocol.FetchAccount(participant); err != nil {
+			return err
+		} else {
+			from = account.LastMovementKey
+		}
+	}
+
+	key := from
+	for i := 0; i < count && key != nil; i++ {
+		m, err := protocol.FetchAccountMovement(*key)
+		if err != nil {
+			return fmt.Errorf("Error fetching ledger entry %v: %v", *key, err)
+		}
+		log.Printf("%v %v %v", m.Timestamp.Format("2006-01-02 15:04:05"), m.Type, *key)
+		if m.AvailableAccount == participant {
+			log.Printf("    available: %v", m.AvailableDelta)
+		}
+		if m.BlockedAccount == participant {
+			log.Printf("    blocked: %v", m.BlockedDelta)
+		}
+		for _, ref := range []struct {
+			name string
+			key  *string
+		}{{"bid", m.BidKey}, {"tx", m.TxKey}, {"deposit", m.DepositKey}, {"withdrawal", m.WithdrawalKey}} {
+			if ref.key != nil {
+				log.Printf("    %v: %v", ref.name, *ref.key)
+			}
+		}
+
+		// Same rule as in bitwrk.VerifyLedger
+		if m.AvailableAccount == participant {
+			key = m.AvailablePredecessorKey
+		} else if m.BlockedAccount == participant {
+			key = m.BlockedPredecessorKey
+		} else {
+			return fmt.Errorf("Ledger entry %v doesn't refer to %v", *key, participant)
+		}
+	}
+
+	if key != nil {
+		log.Printf("More entries: ledger %v from=%v count=%v", participant, *key, count)
+	} else {
+		log.Printf("End of ledger")
+	}
+	return nil
+}
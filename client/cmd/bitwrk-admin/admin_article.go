@@ -0,0 +1,83 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Prints the catalog entry of an article or, if more arguments are given, replaces it
+// with a new entry signed by the current identity (which must be the server's catalog
+// account).
+func cmdArticle(identity *bitcoin.KeyPair, args []string) error {
+	if len(args) == 2 {
+		if article, err := protocol.FetchArticle(bitwrk.ArticleId(args[1])); err != nil {
+			return err
+		} else {
+			log.Printf("Article: %v", article)
+			log.Printf("Description: %v", article.Description)
+			return nil
+		}
+	} else if len(args) < 3 {
+		return fmt.Errorf("Wrong number of arguments for article. Expected: at least 2, got: %v.", len(args))
+	}
+
+	var description, timeouts, maxworksize, feeratio string
+	for _, arg := range args[3:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Expected key=value, got: %#v", arg)
+		}
+		switch kv[0] {
+		case "description":
+			description = kv[1]
+		case "timeouts":
+			timeouts = kv[1]
+		case "maxworksize":
+			maxworksize = kv[1]
+		case "feeratio":
+			feeratio = kv[1]
+		default:
+			return fmt.Errorf("Unknown article property: %#v", kv[0])
+		}
+	}
+
+	nonce, err := protocol.GetNonce()
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %v", err)
+	}
+	article, err := bitwrk.ParseArticle(args[1], description, args[2], timeouts, maxworksize, feeratio, nonce, "")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Setting article: %v", article)
+
+	err = article.SignWith(identity, rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+
+	return protocol.SendArticle(article, nonce)
+}
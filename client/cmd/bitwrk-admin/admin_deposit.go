@@ -0,0 +1,105 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Shows a deposit or, if more arguments are given, places a new deposit signed by the
+// current identity (which must be the server's trusted account).
+func cmdDeposit(identity *bitcoin.KeyPair, trustedAccount string, args []string) error {
+	if len(args) == 2 {
+		return showDeposit(trustedAccount, args[1])
+	} else if len(args) < 4 {
+		return fmt.Errorf("Wrong number of arguments for deposit. Expected: 2 or at least 4, got: %v.", len(args))
+	}
+
+	uid := args[1]
+	deposit := bitwrk.Deposit{
+		Type:    bitwrk.DEPOSIT_TYPE_BITCOIN,
+		Account: args[2],
+	}
+	if err := deposit.Amount.Parse(args[3]); err != nil {
+		return err
+	}
+	for _, arg := range args[4:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Expected key=value, got: %#v", arg)
+		}
+		switch {
+		case kv[0] == "ref":
+			deposit.Reference = kv[1]
+		case kv[0] == "type" && kv[1] == "bitcoin":
+			deposit.Type = bitwrk.DEPOSIT_TYPE_BITCOIN
+		case kv[0] == "type" && kv[1] == "injection":
+			deposit.Type = bitwrk.DEPOSIT_TYPE_INJECTION
+		default:
+			return fmt.Errorf("Unknown deposit property: %#v", arg)
+		}
+	}
+
+	nonce, err := protocol.GetNonce()
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %v", err)
+	}
+	if err := deposit.SignWith(identity, rand.Reader, uid, nonce); err != nil {
+		return err
+	}
+
+	log.Printf("Placing deposit %v: %v to %v", uid, deposit.Amount, deposit.Account)
+	if err := protocol.SendDeposit(&deposit); err != nil {
+		return err
+	}
+	return showDeposit(trustedAccount, uid)
+}
+
+func showDeposit(trustedAccount, uid string) error {
+	deposit, err := protocol.FetchDeposit(uid)
+	if err != nil {
+		return err
+	}
+	log.Printf("Deposit: %v", uid)
+	log.Printf("Type: %v", depositTypeName(deposit.Type))
+	log.Printf("Account: %v", deposit.Account)
+	log.Printf("Amount: %v", deposit.Amount)
+	log.Printf("Reference: %v", deposit.Reference)
+	log.Printf("Created: %v", deposit.Created)
+	if err := deposit.Verify(trustedAccount); err != nil {
+		return fmt.Errorf("Deposit %v doesn't verify against %v: %v", uid, trustedAccount, err)
+	}
+	log.Printf("Signature by %v: OK", trustedAccount)
+	return nil
+}
+
+func depositTypeName(t bitwrk.DepositType) string {
+	switch t {
+	case bitwrk.DEPOSIT_TYPE_INJECTION:
+		return "injection"
+	case bitwrk.DEPOSIT_TYPE_BITCOIN:
+		return "bitcoin"
+	}
+	return fmt.Sprintf("DepositType(%d)", t)
+}
@@ -0,0 +1,67 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Number of accounts verified if no participants are given.
+const verifyLedgerLimit = 1000
+
+// Verifies the ledgers of the given participants, or of all participants known to the server,
+// by fetching account movements one by one. Requires no privileges as all data is public.
+func cmdVerifyLedger(args []string) error {
+	participants := args[1:]
+	if len(participants) == 0 {
+		if p, err := protocol.GetParticipants(verifyLedgerLimit); err != nil {
+			return err
+		} else {
+			participants = p
+		}
+	}
+
+	failed := 0
+	for _, participant := range participants {
+		account, err := protocol.FetchAccount(participant)
+		if err != nil {
+			return err
+		}
+		report, err := bitwrk.VerifyLedger(account, protocol.FetchAccountMovement)
+		if err != nil {
+			return err
+		}
+		if report.Ok() {
+			log.Printf("%v: OK (%v movements, available: %v, blocked: %v)",
+				participant, report.Movements, report.Available, report.Blocked)
+		} else {
+			failed++
+			for _, d := range report.Discrepancies {
+				log.Printf("%v: %v", participant, d)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Found discrepancies in %v of %v accounts", failed, len(participants))
+	}
+	return nil
+}
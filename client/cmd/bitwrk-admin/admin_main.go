@@ -42,6 +42,10 @@ func main() {
 	flags.StringVar(&identityFile, "identity", "",
 		"WIF file to read private key from")
 
+	var trustedAccount string
+	flags.StringVar(&trustedAccount, "trusted-account", "1TrsjuCvBch1D9h6nRkadGKakv9KyaiP6",
+		"Account to trust when verifying deposits and deposit information")
+
 	err := flags.Parse(os.Args[1:])
 	if err == flag.ErrHelp {
 		flags.Usage()
@@ -70,8 +74,26 @@ func main() {
 		command = listCommandsAndExit
 	} else if args[0] == "info" {
 		command = cmdInfo
+	} else if args[0] == "account" {
+		command = func() error { return cmdAccount(identity, trustedAccount, args) }
+	} else if args[0] == "ledger" {
+		command = func() error { return cmdLedger(identity, args) }
+	} else if args[0] == "bid" {
+		command = func() error { return cmdBid(args) }
+	} else if args[0] == "tx" {
+		command = func() error { return cmdTx(args) }
+	} else if args[0] == "deposit" {
+		command = func() error { return cmdDeposit(identity, trustedAccount, args) }
+	} else if args[0] == "article" {
+		command = func() error { return cmdArticle(identity, args) }
 	} else if args[0] == "relation" {
 		command = func() error { return cmdRelation(identity, args) }
+	} else if args[0] == "ruling" {
+		command = func() error { return cmdRuling(identity, args) }
+	} else if args[0] == "verifyledger" {
+		command = func() error { return cmdVerifyLedger(args) }
+	} else if args[0] == "withdrawal" {
+		command = func() error { return cmdWithdrawal(identity, args) }
 	} else {
 		command = listCommandsAndExit
 	}
@@ -88,8 +110,27 @@ func listCommandsAndExit() error {
 	log.Print("Valid commands:")
 	log.Print("  info")
 	log.Print("     Just print info about arguments and account and quit.")
-	log.Print("  relation (trusts|worksfor) <target participant> (true|false)")
+	log.Print("  account [<participant>]")
+	log.Print("     Shows the balances and deposit address of an account (default: the identity's).")
+	log.Print("  ledger [<participant>] [from=<ledger entry key>] [count=<n>]")
+	log.Print("     Pages through the ledger entries of an account, newest first.")
+	log.Print("  bid <bid id>")
+	log.Print("     Shows a bid and verifies its signature.")
+	log.Print("  tx <transaction id>")
+	log.Print("     Shows a transaction and its messages and verifies the signatures of all participants.")
+	log.Print("  deposit <deposit uid> [<account> <amount> [ref=<reference>] [type=(bitcoin|injection)]]")
+	log.Print("     Shows or, as the trusted account, places a deposit.")
+	log.Print("  article <article id> [(true|false) [description=...] [timeouts=<establishing>,<transmitting>,<working>,<unverified>]")
+	log.Print("          [maxworksize=<bytes>] [feeratio=<numerator>/<denominator>]]")
+	log.Print("     Shows or, as the catalog account, updates an entry of the article catalog.")
+	log.Print("  relation (trusts|worksfor|arbiter) <target participant> (true|false)")
 	log.Print("     Updates a relation between the current and another participant.")
+	log.Print("  ruling <transaction id> <seller share in percent>")
+	log.Print("     As an arbiter, settles a transaction whose result has been disputed.")
+	log.Print("  verifyledger [<participant>...]")
+	log.Print("     Replays the account movements of the given (or all) participants and reports discrepancies.")
+	log.Print("  withdrawal <withdrawal uid> (confirmed|rejected) [<reference>]")
+	log.Print("     As the payment processor, settles a requested withdrawal.")
 	os.Exit(1)
 	return nil
 }
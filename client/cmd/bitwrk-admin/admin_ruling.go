@@ -0,0 +1,47 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+func cmdRuling(identity *bitcoin.KeyPair, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("Wrong number of arguments for ruling. Expected: 3, got: %v.", len(args))
+	}
+
+	txId := args[1]
+	share, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("Invalid seller share: %v", err)
+	}
+
+	if tx, _, err := protocol.FetchTx(txId, ""); err != nil {
+		return err
+	} else {
+		log.Printf("Transaction %v: %v -> %v, price %v, phase %v", txId, tx.Buyer, tx.Seller, tx.Price, tx.Phase)
+	}
+
+	log.Printf("Ruling: seller receives %v%% of the price", share)
+	return protocol.SendTxMessageRuling(txId, identity, share)
+}
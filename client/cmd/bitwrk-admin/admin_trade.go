@@ -0,0 +1,133 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Fetches a bid and verifies its signature.
+func cmdBid(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Wrong number of arguments for bid. Expected: 2, got: %v.", len(args))
+	}
+	bid, err := fetchAndVerifyBid(args[1])
+	if err != nil {
+		return err
+	}
+	log.Printf("Bid: %v", args[1])
+	log.Printf("Type: %v, state: %v", bid.Type, bid.State)
+	log.Printf("Article: %v", bid.Article)
+	log.Printf("Price: %v, fee: %v", bid.Price, bid.Fee)
+	log.Printf("Participant: %v", bid.Participant)
+	log.Printf("Created: %v, expires: %v", bid.Created, bid.Expires)
+	if bid.Transaction != nil {
+		log.Printf("Transaction: %v (matched %v)", *bid.Transaction, bid.Matched)
+	}
+	return nil
+}
+
+func fetchAndVerifyBid(bidId string) (*bitwrk.Bid, error) {
+	bid, _, err := protocol.FetchBid(bidId, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := bid.Verify(); err != nil {
+		return nil, fmt.Errorf("Bid %v doesn't verify: %v", bidId, err)
+	}
+	log.Printf("Signature of bid %v by %v: OK", bidId, bid.Participant)
+	return bid, nil
+}
+
+// Fetches a transaction together with its bids and messages and verifies all signatures.
+func cmdTx(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Wrong number of arguments for tx. Expected: 2, got: %v.", len(args))
+	}
+	txId := args[1]
+	tx, _, err := protocol.FetchTx(txId, "")
+	if err != nil {
+		return err
+	}
+	log.Printf("Transaction: %v", txId)
+	log.Printf("Article: %v", tx.Article)
+	log.Printf("Price: %v, fee: %v", tx.Price, tx.Fee)
+	log.Printf("Buyer: %v (bid %v)", tx.Buyer, tx.BuyerBid)
+	log.Printf("Seller: %v (bid %v)", tx.Seller, tx.SellerBid)
+	log.Printf("Matched: %v", tx.Matched)
+	log.Printf("State: %v, phase: %v, timeout: %v", tx.State, tx.Phase, tx.Timeout)
+	if tx.Arbiter != nil && tx.SellerShare != nil {
+		log.Printf("Arbiter: %v, seller's share: %v%%", *tx.Arbiter, *tx.SellerShare)
+	}
+
+	failures := 0
+	for _, bidId := range []string{tx.BuyerBid, tx.SellerBid} {
+		if _, err := fetchAndVerifyBid(bidId); err != nil {
+			log.Print(err)
+			failures++
+		}
+	}
+
+	messages, err := protocol.FetchTxMessages(txId)
+	if err != nil {
+		return err
+	}
+	for i, m := range messages {
+		status := "accepted"
+		if !m.Accepted {
+			status = "rejected: " + m.RejectMessage
+		}
+		log.Printf("Message %v from %v at %v: %v -> %v (%v)", i+1, m.From, m.Received, m.PrePhase, m.PostPhase, status)
+		log.Printf("    %v", m.Document)
+		if signer := messageSigner(tx, m.From); signer == "" {
+			log.Printf("    Signature can't be verified: unknown sender")
+		} else if err := bitcoin.VerifySignatureBase64(m.Document, signer, m.Signature); err != nil {
+			log.Printf("    Signature by %v: INVALID: %v", signer, err)
+			if m.Accepted {
+				failures++
+			}
+		} else {
+			log.Printf("    Signature by %v: OK", signer)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%v signatures of transaction %v failed to verify", failures, txId)
+	}
+	return nil
+}
+
+// Returns the address that must have signed a message from the given origin, or an empty
+// string if it is not known.
+func messageSigner(tx *bitwrk.Transaction, from bitwrk.Origin) string {
+	switch from {
+	case bitwrk.FromBuyer:
+		return tx.Buyer
+	case bitwrk.FromSeller:
+		return tx.Seller
+	case bitwrk.FromArbiter:
+		if tx.Arbiter != nil {
+			return *tx.Arbiter
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+func cmdWithdrawal(identity *bitcoin.KeyPair, args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return fmt.Errorf("Wrong number of arguments for withdrawal. Expected: 3 or 4, got: %v.", len(args))
+	}
+
+	s := bitwrk.WithdrawalSettlement{
+		Uid:     args[1],
+		Outcome: args[2],
+	}
+	if s.Outcome != bitwrk.WithdrawalConfirmed && s.Outcome != bitwrk.WithdrawalRejected {
+		return fmt.Errorf("Outcome must be %v or %v", bitwrk.WithdrawalConfirmed, bitwrk.WithdrawalRejected)
+	}
+	if len(args) == 4 {
+		s.Reference = args[3]
+	}
+
+	if nonce, err := protocol.GetNonce(); err != nil {
+		return err
+	} else {
+		s.Nonce = nonce
+	}
+
+	if err := s.SignWith(identity, rand.Reader); err != nil {
+		return err
+	}
+	return protocol.SendWithdrawalSettlement(&s)
+}
@@ -0,0 +1,372 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/indyjo/bitwrk/client"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Time to wait for a job's status to change per request
+const pollInterval = time.Minute
+
+// As returned by the client's "/activities" handler
+type activityInfo struct {
+	Key  client.ActivityKey
+	Info *client.ActivityState
+}
+
+// As returned by the client's "/mandates" handler
+type keyedMandateInfo struct {
+	Key  client.ActivityKey
+	Info *client.MandateInfo
+}
+
+// The part of the client's "/myaccount" response needed for deposits
+type depositInfo struct {
+	DepositAddress      string
+	DepositAddressValid bool
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func cmdActivities() error {
+	var infos []activityInfo
+	if err := getJson("activities", nil, &infos); err != nil {
+		return err
+	} else if JsonOutput {
+		return printJson(infos)
+	}
+	t := newTable()
+	fmt.Fprintln(t, "KEY\tTYPE\tARTICLE\tPHASE\tAMOUNT\tINFO")
+	for _, a := range infos {
+		phase := a.Info.Phase
+		if !a.Info.Alive {
+			phase = "(done) " + phase
+		}
+		info := a.Info.Info
+		if a.Info.Verdict != "" {
+			info = strings.TrimSpace(fmt.Sprintf("%v %v: %v", info, a.Info.Verdict, a.Info.VerdictReason))
+		}
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			a.Key, a.Info.Type, a.Info.Article, phase, a.Info.Amount, info)
+	}
+	return t.Flush()
+}
+
+func cmdWorkers() error {
+	var workers []client.WorkerState
+	if err := getJson("workers", nil, &workers); err != nil {
+		return err
+	} else if JsonOutput {
+		return printJson(workers)
+	}
+	t := newTable()
+	fmt.Fprintln(t, "ID\tARTICLE\tMETHOD\tSTATE\tSLOTS\tHEALTH\tLAST SEEN\tLAST ERROR")
+	for _, w := range workers {
+		state := "busy"
+		if w.Unregistered {
+			state = "unregistered"
+		} else if w.Blockers > 0 {
+			state = "blocked"
+		} else if w.IdleSlots > 0 {
+			state = "idle"
+		}
+		lastSeen := time.Since(w.LastSeen).Truncate(time.Second).String() + " ago"
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			w.Info.Id, w.Info.Article, w.Info.Method, state, slotStates(w.Slots), w.Health, lastSeen, w.LastError)
+	}
+	return t.Flush()
+}
+
+// Function slotStates summarizes what each of a worker's slots is doing.
+func slotStates(slots []client.WorkerSlot) string {
+	states := make([]string, len(slots))
+	for i, slot := range slots {
+		if slot.Busy {
+			states[i] = fmt.Sprintf("working(%v)", time.Since(slot.BusySince).Truncate(time.Second))
+		} else if slot.Sell != 0 {
+			states[i] = fmt.Sprintf("selling(#%v)", slot.Sell)
+		} else {
+			states[i] = "idle"
+		}
+	}
+	return strings.Join(states, ",")
+}
+
+func cmdMandates() error {
+	var mandates []keyedMandateInfo
+	if err := getJson("mandates", nil, &mandates); err != nil {
+		return err
+	} else if JsonOutput {
+		return printJson(mandates)
+	}
+	t := newTable()
+	fmt.Fprintln(t, "KEY\tTYPE\tARTICLE\tPRICE\tPRICING\tTRADES LEFT\tUNTIL\tBUDGET LEFT\tLEFT TODAY")
+	for _, m := range mandates {
+		i := m.Info
+		trades, until, budget, today := "-", "-", "-", "-"
+		if i.UseTradesLeft {
+			trades = strconv.Itoa(i.TradesLeft)
+		}
+		if i.UseUntil {
+			until = i.Until.Local().Format("2006-01-02 15:04")
+		}
+		if i.UseBudget {
+			budget = i.BudgetLeft.String()
+		}
+		if i.UseDailyCap {
+			today = i.DailyCapLeft.String()
+		}
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			m.Key, i.Type, i.Article, i.CurrentPrice, i.Pricing, trades, until, budget, today)
+	}
+	return t.Flush()
+}
+
+// Grants a mandate using the same form as the client's UI.
+func cmdGrant(args []string) error {
+	if len(args) < 4 {
+		return fmt.Errorf("Wrong number of arguments for grant. Expected: at least 4, got: %v.", len(args))
+	}
+	opts, err := parseOptions(args[4:], "trades", "minutes", "budget", "dailycap",
+		"pricing", "min", "max", "offset", "period", "step", "stepminutes")
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("action", "permit")
+	form.Set("type", strings.ToUpper(args[1]))
+	form.Set("articleid", args[2])
+	form.Set("price", args[3])
+	form.Set("tradesleft", "1")
+	form.Set("validminutes", "1")
+	if v, ok := opts["trades"]; ok {
+		form.Set("usetradesleft", "on")
+		form.Set("tradesleft", v)
+	}
+	if v, ok := opts["minutes"]; ok {
+		form.Set("usevaliduntil", "on")
+		form.Set("validminutes", v)
+	}
+	if v, ok := opts["budget"]; ok {
+		form.Set("usebudget", "on")
+		form.Set("budget", v)
+	}
+	if v, ok := opts["dailycap"]; ok {
+		form.Set("usedailycap", "on")
+		form.Set("dailycap", v)
+	}
+	form.Set("pricing", opts["pricing"])
+	form.Set("minprice", opts["min"])
+	form.Set("maxprice", opts["max"])
+	form.Set("offset", opts["offset"])
+	form.Set("period", opts["period"])
+	form.Set("step", opts["step"])
+	form.Set("stepminutes", opts["stepminutes"])
+
+	if err := postForm("", form); err != nil {
+		return err
+	}
+	log.Printf("Granted mandate to %v %v", strings.ToLower(args[1]), args[2])
+	return nil
+}
+
+func cmdRevoke(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Wrong number of arguments for revoke. Expected: 2, got: %v.", len(args))
+	}
+	if _, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+		return fmt.Errorf("Illegal mandate key: %#v", args[1])
+	}
+	if err := postForm("revokemandate", url.Values{"key": {args[1]}}); err != nil {
+		return err
+	}
+	log.Printf("Revoked mandate %v", args[1])
+	return nil
+}
+
+// Submits a job, waits for it to finish and downloads the result.
+func cmdSubmit(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("Wrong number of arguments for submit. Expected: at least 3, got: %v.", len(args))
+	}
+	opts, err := parseOptions(args[3:], "price", "out", "timeout")
+	if err != nil {
+		return err
+	}
+	query := url.Values{"article": {args[1]}}
+	if v, ok := opts["price"]; ok {
+		if _, err := money.Parse(v); err != nil {
+			return fmt.Errorf("Illegal price: %v", err)
+		}
+		query.Set("price", v)
+	}
+	var timeout time.Duration
+	if v, ok := opts["timeout"]; ok {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return err
+		}
+	}
+	if JsonOutput && opts["out"] == "" {
+		return fmt.Errorf("JSON output requires a result file to be given")
+	}
+
+	var work io.Reader
+	if args[2] == "-" {
+		work = os.Stdin
+	} else if f, err := os.Open(args[2]); err != nil {
+		return err
+	} else {
+		defer f.Close()
+		work = f
+	}
+
+	var job client.JobInfo
+	if resp, err := request("POST", "jobs", query, work, "application/octet-stream"); err != nil {
+		return err
+	} else if err := decodeJsonAndClose(resp.Body, &job); err != nil {
+		return err
+	}
+	log.Printf("Submitted job %v", job.Id)
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	jobPath := fmt.Sprintf("jobs/%v", job.Id)
+	phase := ""
+	for job.Status == client.JobRunning {
+		if job.Activity != nil && job.Activity.Phase != phase {
+			phase = job.Activity.Phase
+			log.Printf("Job %v: %v", job.Id, phase)
+		}
+		wait := pollInterval
+		if !deadline.IsZero() {
+			if left := time.Until(deadline); left <= 0 {
+				if err := postForm(jobPath+"/cancel", nil); err != nil {
+					log.Printf("Error canceling job: %v", err)
+				}
+				return fmt.Errorf("Job %v timed out after %v", job.Id, timeout)
+			} else if left < wait {
+				wait = left
+			}
+		}
+		if err := getJson(jobPath, url.Values{"wait": {wait.String()}}, &job); err != nil {
+			return err
+		}
+	}
+
+	if job.Status != client.JobDone {
+		if JsonOutput {
+			printJson(job)
+		}
+		return fmt.Errorf("Job %v %v: %v", job.Id, strings.ToLower(string(job.Status)), job.Error)
+	}
+
+	if err := downloadResult(jobPath+"/result", opts["out"]); err != nil {
+		return err
+	}
+	if JsonOutput {
+		return printJson(job)
+	}
+	log.Printf("Job %v done, result %v", job.Id, job.ResultKey)
+	return nil
+}
+
+// Writes the result of a job to the given file, or to stdout if no file is given.
+func downloadResult(path, filename string) error {
+	resp, err := request("GET", path, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if filename == "" {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Requests a new deposit address and waits until the account information shows it.
+func cmdDeposit(args []string) error {
+	opts, err := parseOptions(args[1:], "timeout")
+	if err != nil {
+		return err
+	}
+	timeout := 2 * time.Minute
+	if v, ok := opts["timeout"]; ok {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return err
+		}
+	}
+
+	var before depositInfo
+	if err := getJson("myaccount", nil, &before); err != nil {
+		return err
+	}
+	if err := postForm("requestdepositaddress", nil); err != nil {
+		return err
+	}
+	log.Printf("Requested deposit address, waiting for it to arrive")
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var info depositInfo
+		if err := getJson("myaccount", nil, &info); err != nil {
+			return err
+		} else if info.DepositAddressValid && info.DepositAddress != before.DepositAddress {
+			if JsonOutput {
+				return printJson(info)
+			}
+			fmt.Println(info.DepositAddress)
+			return nil
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("No deposit address received within %v", timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func cmdReload() error {
+	if err := postForm("reloadconfig", nil); err != nil {
+		return err
+	}
+	log.Printf("Configuration reloaded")
+	return nil
+}
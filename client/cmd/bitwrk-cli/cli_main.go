@@ -0,0 +1,214 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Command bitwrk-cli controls a running BitWrk client through its internal port.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/indyjo/bitwrk/client/common"
+)
+
+const ToolName = "bitwrk-cli"
+
+// URL of the client's internal port
+var ClientUrl string
+
+// Whether to print JSON instead of human-readable output
+var JsonOutput bool
+
+// API token presented to the client, required unless connecting via loopback
+var Token string
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix(ToolName + ": ")
+
+	flags := flag.NewFlagSet(ToolName, flag.ExitOnError)
+	flags.StringVar(&ClientUrl, "clienturl", "http://127.0.0.1:8081/",
+		"URL of the BitWrk client's internal port")
+	flags.BoolVar(&JsonOutput, "json", false,
+		"Print JSON instead of human-readable output")
+	flags.StringVar(&Token, "token", os.Getenv("BITWRK_TOKEN"),
+		"API token for accessing the client from another host (default: $BITWRK_TOKEN)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%v %v %v\n", ToolName, common.ClientVersion, common.CommitSHA)
+		fmt.Fprintf(os.Stderr, "Usage: %v [options] <command> [arguments]\n", ToolName)
+		flags.PrintDefaults()
+		listCommands()
+	}
+
+	err := flags.Parse(os.Args[1:])
+	if err == flag.ErrHelp {
+		flags.Usage()
+	} else if err != nil {
+		log.Fatalf("Error parsing command line: %v", err)
+	}
+
+	if !strings.HasSuffix(ClientUrl, "/") {
+		ClientUrl += "/"
+	}
+
+	args := flags.Args()
+
+	var command func() error
+	if len(args) == 0 {
+		command = listCommandsAndExit
+	} else if args[0] == "activities" {
+		command = cmdActivities
+	} else if args[0] == "workers" {
+		command = cmdWorkers
+	} else if args[0] == "mandates" {
+		command = cmdMandates
+	} else if args[0] == "grant" {
+		command = func() error { return cmdGrant(args) }
+	} else if args[0] == "revoke" {
+		command = func() error { return cmdRevoke(args) }
+	} else if args[0] == "submit" {
+		command = func() error { return cmdSubmit(args) }
+	} else if args[0] == "deposit" {
+		command = func() error { return cmdDeposit(args) }
+	} else if args[0] == "reload" {
+		command = cmdReload
+	} else {
+		command = listCommandsAndExit
+	}
+
+	if err := command(); err != nil {
+		log.Fatalf("Failure: %v", err)
+	}
+}
+
+func listCommands() {
+	fmt.Fprint(os.Stderr, `Valid commands:
+  activities
+     Lists the client's current and recent activities.
+  workers
+     Lists the workers registered with the client.
+  mandates
+     Lists the mandates granted to the client.
+  grant (buy|sell) <article id> <price> [trades=<n>] [minutes=<n>] [budget=<price>] [dailycap=<price>]
+        [pricing=(market|escalate) min=<price> max=<price> [offset=<percent>] [period=<period>]
+        [step=<price>] [stepminutes=<n>]]
+     Grants a mandate to buy or sell an article. Without limits, the mandate expires after one trade.
+  revoke <mandate key>
+     Revokes a mandate.
+  submit <article id> <work file> [price=<price>] [out=<result file>] [timeout=<duration>]
+     Buys the computation of a work file ("-" for stdin) and waits for the result, which is
+     written to stdout unless a result file is given.
+  deposit [timeout=<duration>]
+     Requests a new deposit address for the client's account and waits for it to arrive.
+  reload
+     Makes the client reload its configuration file.
+`)
+}
+
+func listCommandsAndExit() error {
+	listCommands()
+	os.Exit(1)
+	return nil
+}
+
+// Parses arguments of the form key=value. Only the given keys are accepted.
+func parseOptions(args []string, keys ...string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Expected key=value, got: %#v", arg)
+		}
+		known := false
+		for _, key := range keys {
+			known = known || key == kv[0]
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown option: %#v", kv[0])
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// Sends a request to the client's internal port. Responses with a status other than
+// 2xx are returned as errors. The response body must be closed by the caller.
+func request(method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := ClientUrl + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if Token != "" {
+		req.Header.Set("Authorization", "Bearer "+Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%v %v: %v: %v", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Sends a form to the client's internal port.
+func postForm(path string, form url.Values) error {
+	resp, err := request("POST", path, nil, strings.NewReader(form.Encode()),
+		"application/x-www-form-urlencoded")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Queries JSON data from the client's internal port and decodes it into v.
+func getJson(path string, query url.Values, v interface{}) error {
+	resp, err := request("GET", path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	return decodeJsonAndClose(resp.Body, v)
+}
+
+func decodeJsonAndClose(body io.ReadCloser, v interface{}) error {
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// Prints a value as indented JSON.
+func printJson(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,180 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Scopes of API tokens. Each handler on the internal port requires one of them.
+const (
+	ScopeUI     = "ui"     // The browser UI, mandates, account and diagnostics
+	ScopeBuy    = "buy"    // Buying and fetching results
+	ScopeWorker = "worker" // Registering and unregistering workers
+)
+
+// Name of the cookie the UI's token is stored in after logging in via "?token=..."
+const tokenCookie = "bitwrk-token"
+
+// Path of the file containing the API tokens. Defaults to "tokens.json" in the client's
+// configuration directory.
+var TokenFile string
+
+// Whether requests from the loopback interface are granted all scopes without a token
+var TrustLoopback = true
+
+// Type apiToken is an entry of the token file.
+type apiToken struct {
+	Name   string   // For identifying the token's owner in logs
+	Token  string   // The secret presented by the client
+	Scopes []string // What the token grants access to
+}
+
+func (t *apiToken) grants(scopes []string) bool {
+	for _, s := range t.Scopes {
+		for _, required := range scopes {
+			if s == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var tokensMutex sync.Mutex
+var tokens []apiToken
+
+// Loads the API tokens from TokenFile. If the file doesn't exist, it is created containing a
+// single token granting all scopes.
+func loadTokens() error {
+	var loaded []apiToken
+	if data, err := ioutil.ReadFile(TokenFile); os.IsNotExist(err) {
+		if t, err := newToken("default", ScopeUI, ScopeBuy, ScopeWorker); err != nil {
+			return err
+		} else {
+			loaded = []apiToken{t}
+		}
+		if data, err := json.MarshalIndent(loaded, "", "  "); err != nil {
+			return err
+		} else if err := ioutil.WriteFile(TokenFile, data, 0600); err != nil {
+			return err
+		}
+		log.Printf("Created API token file: %v", TokenFile)
+	} else if err != nil {
+		return err
+	} else if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("Error parsing %v: %v", TokenFile, err)
+	}
+
+	for _, t := range loaded {
+		if len(t.Token) < 16 {
+			return fmt.Errorf("Token %#v is too short, must have at least 16 characters", t.Name)
+		}
+		for _, s := range t.Scopes {
+			if s != ScopeUI && s != ScopeBuy && s != ScopeWorker {
+				return fmt.Errorf("Token %#v has unknown scope: %#v", t.Name, s)
+			}
+		}
+	}
+
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	tokens = loaded
+	return nil
+}
+
+func newToken(name string, scopes ...string) (apiToken, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return apiToken{}, err
+	}
+	return apiToken{Name: name, Token: hex.EncodeToString(secret), Scopes: scopes}, nil
+}
+
+// Returns the token matching the given secret, or nil.
+func findToken(secret string) *apiToken {
+	if secret == "" {
+		return nil
+	}
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	for i := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tokens[i].Token), []byte(secret)) == 1 {
+			t := tokens[i]
+			return &t
+		}
+	}
+	return nil
+}
+
+// A wrapper around http.Handler that requires the request to present a token granting one
+// of the given scopes. Tokens are accepted as "Authorization: Bearer" header, as "token"
+// query parameter (which also sets a cookie for the browser UI) or as cookie.
+type authorizer struct {
+	h      http.Handler
+	scopes []string
+}
+
+func (a authorizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if TrustLoopback && allowed(r.RemoteAddr) {
+		a.h.ServeHTTP(w, r)
+		return
+	}
+
+	var secret string
+	fromQuery := false
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		secret = strings.TrimSpace(auth[len("Bearer "):])
+	} else if s := r.URL.Query().Get("token"); s != "" {
+		secret = s
+		fromQuery = true
+	} else if c, err := r.Cookie(tokenCookie); err == nil {
+		secret = c.Value
+	}
+
+	token := findToken(secret)
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bitwrk-client"`)
+		http.Error(w, "Valid API token required", http.StatusUnauthorized)
+		return
+	} else if !token.grants(a.scopes) {
+		log.Printf("Token %#v denied access to %v from %v", token.Name, r.URL.Path, r.RemoteAddr)
+		http.Error(w, fmt.Sprintf("Token lacks scope %v", strings.Join(a.scopes, " or ")), http.StatusForbidden)
+		return
+	}
+
+	if fromQuery {
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookie,
+			Value:    secret,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	a.h.ServeHTTP(w, r)
+}
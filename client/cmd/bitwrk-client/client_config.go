@@ -0,0 +1,378 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/indyjo/bitwrk/client"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Path of the configuration file. Defaults to "config.json" in the client's
+// configuration directory.
+var ConfigFile string
+
+// Type clientConfig is the content of the configuration file. Settings are named like
+// command line flags, which take precedence. Mandates and workers defined in the file
+// are created by the client on start and updated on reload.
+type clientConfig struct {
+	Settings map[string]json.RawMessage
+	Mandates []mandateConfig
+	Workers  []client.WorkerInfo
+}
+
+// Type mandateConfig defines a mandate in the configuration file.
+type mandateConfig struct {
+	Name     string           // Identifies the mandate across reloads
+	Type     string           // "BUY" or "SELL"
+	Article  bitwrk.ArticleId // Which article to buy or sell
+	Price    money.Money      // Which price to bid/ask for
+	Pricing  client.PricingStrategy
+	Trades   int          // Number of trades permitted, 0 for unlimited
+	Budget   *money.Money // Optional total budget (buys only)
+	DailyCap *money.Money // Optional daily spending cap (buys only)
+}
+
+// Settings that may change without restarting the client
+var reloadableSettings = map[string]bool{
+	"num-unmatched-bids":       true,
+	"num-transmitting-bids":    true,
+	"validation-timeout":       true,
+	"extension-propose-before": true,
+	"extension-amount":         true,
+	"extension-max-granted":    true,
+	"buy-attempts":             true,
+	"buy-exclude-failing":      true,
+	"buy-retry-delay":          true,
+	"abort-accept-finished":    true,
+	"redundant-escalate":       true,
+	"worker-lease":             true,
+}
+
+// Serializes reloads of the configuration
+var configMutex sync.Mutex
+
+// The reloadable settings the command line flags are bound to. This is a private copy,
+// guarded by configMutex, which is installed using client.SetSettings once all settings
+// have been applied. Trades never see it half-way updated.
+var settings = client.CurrentSettings()
+
+// The client's command line flags, which settings are applied to
+var configFlags *flag.FlagSet
+
+// Names of the flags given on the command line, which the configuration file can't override
+var commandLineFlags = make(map[string]bool)
+
+// Settings as last applied, for detecting changes on reload
+var appliedSettings = make(map[string]string)
+
+// IDs of the workers defined by the configuration file, as last applied
+var configuredWorkers = make(map[string]client.WorkerInfo)
+
+// Loads the configuration file after the command line has been parsed and applies its
+// settings. Mandates and workers are applied later, using applyMandates and applyWorkers.
+func loadConfig(flags *flag.FlagSet) (*clientConfig, error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	configFlags = flags
+	flags.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+	if config, err := readConfig(); err != nil {
+		return nil, err
+	} else if err := applySettings(config, false); err != nil {
+		return nil, err
+	} else {
+		return config, nil
+	}
+}
+
+// Reads the configuration file. A missing file is treated like an empty one.
+func readConfig() (*clientConfig, error) {
+	var config clientConfig
+	if data, err := ioutil.ReadFile(ConfigFile); os.IsNotExist(err) {
+		return &config, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Error parsing %v: %v", ConfigFile, err)
+	}
+	return &config, nil
+}
+
+// Applies the settings of the configuration file to all flags not given on the command
+// line. If onlyReloadable is set, settings requiring a restart are skipped with a warning.
+// The reloadable settings are installed only if all of them could be applied.
+func applySettings(config *clientConfig, onlyReloadable bool) error {
+	if err := applySettingFlags(config, onlyReloadable); err != nil {
+		// Discard the settings applied so far
+		settings = client.CurrentSettings()
+		return err
+	}
+	client.SetSettings(settings)
+	return nil
+}
+
+func applySettingFlags(config *clientConfig, onlyReloadable bool) error {
+	for name, raw := range config.Settings {
+		f := configFlags.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("Unknown setting in %v: %v", ConfigFile, name)
+		}
+		if commandLineFlags[name] {
+			continue
+		}
+		values, err := settingValues(raw)
+		if err != nil {
+			return fmt.Errorf("Illegal value for setting %v: %v", name, err)
+		}
+		if onlyReloadable && !reloadableSettings[name] {
+			if appliedSettings[name] != string(raw) {
+				log.Printf("Changing setting %v requires a restart", name)
+			}
+			continue
+		}
+		appliedSettings[name] = string(raw)
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("Illegal value for setting %v: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Converts a setting's JSON value into flag values. Arrays are used for flags that may be
+// given more than once.
+func settingValues(raw json.RawMessage) ([]string, error) {
+	var list []interface{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var single interface{}
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, err
+		}
+		list = []interface{}{single}
+	}
+	result := make([]string, 0, len(list))
+	for _, v := range list {
+		switch v := v.(type) {
+		case string:
+			result = append(result, v)
+		case float64, bool:
+			result = append(result, fmt.Sprint(v))
+		default:
+			return nil, fmt.Errorf("Expected string, number or boolean, got: %v", v)
+		}
+	}
+	return result, nil
+}
+
+// Creates, replaces or revokes mandates so that they match the configuration file.
+// Mandates whose definition didn't change keep their state, e.g. the budget spent.
+// Mandates that have expired are only created anew when their definition changes.
+func applyMandates(config *clientConfig) error {
+	manager := client.GetActivityManager()
+	mandates := manager.GetMandates()
+	exhausted := manager.ExhaustedMandates()
+	existing := make(map[string]client.ActivityKey)
+	for key, mandate := range mandates {
+		if mandate.Origin != "" {
+			existing[mandate.Origin] = key
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, mc := range config.Mandates {
+		if mc.Name == "" || wanted[mc.Name] {
+			return fmt.Errorf("Mandates need unique names: %#v", mc.Name)
+		}
+		wanted[mc.Name] = true
+
+		definition, err := json.Marshal(mc)
+		if err != nil {
+			return err
+		}
+		if key, ok := existing[mc.Name]; ok {
+			if mandates[key].Definition == string(definition) {
+				continue
+			}
+			log.Printf("Replacing mandate %v", mc.Name)
+			manager.UnregisterMandate(key)
+		} else if d, ok := exhausted[mc.Name]; ok && d == string(definition) {
+			log.Printf("Not creating mandate %v, it has expired", mc.Name)
+			continue
+		} else {
+			log.Printf("Creating mandate %v", mc.Name)
+		}
+
+		mandate, err := mc.newMandate()
+		if err != nil {
+			return fmt.Errorf("Illegal mandate %v: %v", mc.Name, err)
+		}
+		mandate.Definition = string(definition)
+		if err := mandate.UpdateMarketPrice(); err != nil {
+			log.Printf("Error querying market price for mandate %v: %v", mc.Name, err)
+		}
+		manager.ForgetExhaustedMandate(mc.Name)
+		manager.RegisterMandate(manager.NewKey(), mandate)
+	}
+
+	for name := range exhausted {
+		if !wanted[name] {
+			manager.ForgetExhaustedMandate(name)
+		}
+	}
+
+	for name, key := range existing {
+		if !wanted[name] {
+			log.Printf("Revoking mandate %v", name)
+			manager.UnregisterMandate(key)
+		}
+	}
+	return nil
+}
+
+func (mc *mandateConfig) newMandate() (*client.Mandate, error) {
+	mandate := &client.Mandate{
+		Article: mc.Article,
+		Price:   mc.Price,
+		Pricing: mc.Pricing,
+		Origin:  mc.Name,
+	}
+	if mc.Type == "BUY" {
+		mandate.BidType = bitwrk.Buy
+	} else if mc.Type == "SELL" {
+		mandate.BidType = bitwrk.Sell
+	} else {
+		return nil, fmt.Errorf("Illegal trade type: %v", mc.Type)
+	}
+	if err := mc.Pricing.Validate(); err != nil {
+		return nil, err
+	}
+	if mc.Trades < 0 {
+		return nil, fmt.Errorf("Number of trades must not be negative")
+	} else if mc.Trades > 0 {
+		mandate.UseTradesLeft = true
+		mandate.TradesLeft = mc.Trades
+	}
+	if (mc.Budget != nil || mc.DailyCap != nil) && mandate.BidType != bitwrk.Buy {
+		return nil, fmt.Errorf("Spending limits only apply to buys")
+	}
+	if mc.Budget != nil {
+		mandate.UseBudget = true
+		mandate.Budget = *mc.Budget
+	}
+	if mc.DailyCap != nil {
+		mandate.UseDailyCap = true
+		mandate.DailyCap = *mc.DailyCap
+	}
+	return mandate, nil
+}
+
+// Registers or unregisters workers so that they match the configuration file. Workers
+// registered through the API are left alone.
+func applyWorkers(workerManager *client.WorkerManager, config *clientConfig) error {
+	wanted := make(map[string]client.WorkerInfo)
+	for _, info := range config.Workers {
+		if info.Method == "" {
+			info.Method = client.WorkerMethodPush
+		}
+		if info.Capacity == 0 {
+			info.Capacity = 1
+		}
+		if info.Id == "" || info.Article == "" {
+			return fmt.Errorf("Workers need an Id and an Article: %v", info)
+		} else if info.Method != client.WorkerMethodPush && info.Method != client.WorkerMethodPull &&
+			info.Method != client.WorkerMethodExec {
+			return fmt.Errorf("Unknown method of worker %v: %#v", info.Id, info.Method)
+		} else if info.Method == client.WorkerMethodPush && info.PushURL == "" {
+			return fmt.Errorf("Worker %v needs a PushURL: %v", info.Id, info)
+		} else if info.Method == client.WorkerMethodExec && strings.TrimSpace(info.Command) == "" {
+			return fmt.Errorf("Worker %v needs a Command: %v", info.Id, info)
+		} else if info.Capacity < 0 || info.MaxCPUSeconds < 0 || info.MaxMemoryMegabytes < 0 {
+			return fmt.Errorf("Invalid capacity or limits of worker %v: %v", info.Id, info)
+		} else if _, ok := wanted[info.Id]; ok {
+			return fmt.Errorf("Duplicate worker: %v", info.Id)
+		}
+		wanted[info.Id] = info
+	}
+
+	for id, info := range configuredWorkers {
+		if w, ok := wanted[id]; !ok || w != info {
+			workerManager.UnregisterWorker(id)
+			delete(configuredWorkers, id)
+		}
+	}
+	for id, info := range wanted {
+		if _, ok := configuredWorkers[id]; !ok {
+			// The client itself keeps configured workers registered, so they get no lease
+			workerManager.RegisterWorker(info, BitcoinIdentity, 0)
+			configuredWorkers[id] = info
+		}
+	}
+	return nil
+}
+
+// Re-reads the configuration file and applies the settings, mandates and workers that
+// can change at runtime. API tokens are reloaded, too.
+func reloadConfig(workerManager *client.WorkerManager) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	log.Printf("Reloading configuration from %v", ConfigFile)
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if err := applySettings(config, true); err != nil {
+		return err
+	}
+	if err := loadTokens(); err != nil {
+		return err
+	}
+	return applyEntries(workerManager, config)
+}
+
+// Applies the mandates and workers of the configuration file.
+func applyEntries(workerManager *client.WorkerManager, config *clientConfig) error {
+	if err := applyMandates(config); err != nil {
+		return err
+	}
+	return applyWorkers(workerManager, config)
+}
+
+func handleReloadConfig(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(workerManager); err != nil {
+		log.Printf("Error reloading configuration: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("Configuration reloaded"))
+}
@@ -0,0 +1,144 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/indyjo/bitwrk/client"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Longest time a status request may wait for a job to finish.
+const maxJobWait = 5 * time.Minute
+
+// Handler function for /jobs. GET lists all jobs. POST with parameters "article" and,
+// optionally, "price" submits the work data in the body (like /buy/) and returns the new
+// job's status at once.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJson(w, client.GetJobManager().List())
+		return
+	} else if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	article := bitwrk.ArticleId(r.URL.Query().Get("article"))
+	if article == "" {
+		http.Error(w, "Parameter 'article' is required", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Handling job for %#v from %v", article, r.RemoteAddr)
+
+	var price *money.Money
+	if p, err := parsePrice(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else {
+		price = p
+	}
+
+	workFile, err := receiveWork(r, fmt.Sprintf("job for %v: work", article))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Error receiving work data from client: %v", err)
+		return
+	}
+	defer workFile.Dispose()
+
+	if job, err := client.GetJobManager().Submit(article, BitcoinIdentity, price, workFile); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Error submitting job: %v", err)
+	} else {
+		w.Header().Set("Location", fmt.Sprintf("/jobs/%v", job.GetId()))
+		writeJsonStatus(w, http.StatusAccepted, job.Info())
+	}
+}
+
+// Handler function for /jobs/<id>, /jobs/<id>/result and /jobs/<id>/cancel.
+// GET on /jobs/<id> returns the job's status. With parameter "wait", it waits up to the
+// given duration for the job to finish first. GET on /jobs/<id>/result redirects to the
+// job's result file. POST on /jobs/<id>/cancel aborts the job.
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(r.URL.Path[len("/jobs/"):], "/", 2)
+	var job *client.Job
+	if id, err := strconv.ParseInt(parts[0], 10, 64); err != nil {
+		http.NotFound(w, r)
+		return
+	} else if job = client.GetJobManager().Get(client.ActivityKey(id)); job == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == "GET":
+		if waitStr := r.FormValue("wait"); waitStr != "" {
+			wait, err := time.ParseDuration(waitStr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if wait > maxJobWait {
+				wait = maxJobWait
+			}
+			select {
+			case <-job.Done():
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		writeJson(w, job.Info())
+	case action == "result" && r.Method == "GET":
+		if result, err := job.Result(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			defer result.Dispose()
+			http.Redirect(w, r, "/file/"+result.Key().String(), http.StatusSeeOther)
+		}
+	case action == "cancel" && r.Method == "POST":
+		job.Cancel()
+		writeJson(w, job.Info())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	writeJsonStatus(w, http.StatusOK, v)
+}
+
+func writeJsonStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON: %v", err)
+	}
+}
@@ -17,19 +17,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/pprof"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/indyjo/bitwrk/client/assist"
@@ -72,12 +77,48 @@ func main() {
 		"URL to contact the bitwrk service at")
 	flags.BoolVar(&cafs.LoggingEnabled, "log-cafs", cafs.LoggingEnabled,
 		"Enable logging for content-addressable file storage")
-	flags.IntVar(&client.NumUnmatchedBids, "num-unmatched-bids", client.NumUnmatchedBids,
+	flags.Int64Var(&client.StorageRamMegabytes, "cafs-ram-size", client.StorageRamMegabytes,
+		"Size of the in-memory content-addressable file storage in megabytes")
+	flags.StringVar(&client.StorageDir, "cafs-dir", client.StorageDir,
+		"Directory to persist content-addressable file storage in, so it survives restarts (empty: memory only)")
+	flags.Int64Var(&client.StorageDiskMegabytes, "cafs-disk-size", client.StorageDiskMegabytes,
+		"Maximum size of the persisted content-addressable file storage in megabytes")
+	flags.IntVar(&settings.NumUnmatchedBids, "num-unmatched-bids", settings.NumUnmatchedBids,
 		"Maximum number of unmatched bids for an article on server")
-	flags.IntVar(&client.NumTransmittingBids, "num-transmitting-bids", client.NumTransmittingBids,
+	flags.IntVar(&settings.NumTransmittingBids, "num-transmitting-bids", settings.NumTransmittingBids,
 		"Maximum number of transmissions at the same time")
 	flags.StringVar(&TrustedAccount, "trusted-account", "1TrsjuCvBch1D9h6nRkadGKakv9KyaiP6",
 		"Account to trust when verifying deposit information.")
+	flags.Var(validatorFlag{}, "validator",
+		"Validate results of buys before accepting them, given as ARTICLE=COMMAND or ARTICLE=URL (may be repeated)")
+	flags.DurationVar(&settings.ValidationTimeout, "validation-timeout", settings.ValidationTimeout,
+		"Maximum time a result validator may take before the buy is given up without a verdict")
+	flags.DurationVar(&settings.Extensions.ProposeBefore, "extension-propose-before", settings.Extensions.ProposeBefore,
+		"When selling, propose extending the timeout when less than this time is left (0 disables)")
+	flags.DurationVar(&settings.Extensions.ProposeAmount, "extension-amount", settings.Extensions.ProposeAmount,
+		"When selling, the amount of time to ask for per extension")
+	flags.DurationVar(&settings.Extensions.MaxGranted, "extension-max-granted", settings.Extensions.MaxGranted,
+		"When buying, the maximum total extension granted to a seller (0 disables)")
+	flags.BoolVar(&settings.Aborts.AcceptFinished, "abort-accept-finished", settings.Aborts.AcceptFinished,
+		"When a buy is aborted, accept results already delivered instead of leaving the transaction to time out")
+	flags.Var(redundantFlag{}, "redundant",
+		"Cross-check results of buys of ARTICLE by buying from two sellers (may be repeated)")
+	flags.BoolVar(&settings.Redundancy.Escalate, "redundant-escalate", settings.Redundancy.Escalate,
+		"When redundant buys disagree, buy from a third seller to break the tie")
+	flags.IntVar(&settings.Retries.MaxAttempts, "buy-attempts", settings.Retries.MaxAttempts,
+		"Maximum number of bids placed per buy when trading with remote sellers fails")
+	flags.BoolVar(&settings.Retries.ExcludeFailingSellers, "buy-exclude-failing", settings.Retries.ExcludeFailingSellers,
+		"When retrying a buy, avoid sellers that failed it before")
+	flags.DurationVar(&settings.Retries.Delay, "buy-retry-delay", settings.Retries.Delay,
+		"Time to wait before retrying a failed buy")
+	flags.DurationVar(&settings.WorkerLease, "worker-lease", settings.WorkerLease,
+		"Unregister workers not sending heartbeats for this long, unless they ask for another lease (0: only if they ask)")
+	flags.StringVar(&ConfigFile, "config", "",
+		"Configuration file with settings, mandates and workers (default: config.json in the configuration directory)")
+	flags.StringVar(&TokenFile, "tokens", "",
+		"File with API tokens for the internal port (default: tokens.json in the configuration directory)")
+	flags.BoolVar(&TrustLoopback, "trust-loopback", TrustLoopback,
+		"Grant requests from the loopback interface access to the internal port without API token")
 	err := flags.Parse(os.Args[1:])
 	if err == flag.ErrHelp {
 		flags.Usage()
@@ -85,6 +126,24 @@ func main() {
 		log.Fatalf("Error parsing command line: %v", err)
 	}
 
+	configDir, err := common.GetConfigDir("bitwrk-client")
+	if err != nil {
+		log.Fatalf("Error accessing configuration directory: %v", err)
+	}
+	if ConfigFile == "" {
+		ConfigFile = filepath.Join(configDir, "config.json")
+	}
+	config, err := loadConfig(flags)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if TokenFile == "" {
+		TokenFile = filepath.Join(configDir, "tokens.json")
+	}
+	if err := loadTokens(); err != nil {
+		log.Fatalf("Error loading API tokens: %v", err)
+	}
+
 	if ResourceDir == "auto" {
 		if dir, err := AutoFindResourceDir("bitwrk-client", common.ClientVersion); err != nil {
 			log.Fatalf("Error finding resource directory: %v", err)
@@ -109,17 +168,53 @@ func main() {
 	log.Printf("Resource directory: %v\n", ResourceDir)
 	initTemplates()
 
+	if err := client.GetActivityManager().InitStorage(); err != nil {
+		log.Fatalf("Error initializing storage: %v", err)
+	}
+	if client.StorageDir != "" {
+		log.Printf("Persisting storage in: %v", client.StorageDir)
+	}
+
+	// Mandates and the activity history survive restarts
+	if err := client.GetActivityManager().InitPersistence(filepath.Join(configDir, "activities.json")); err != nil {
+		log.Fatalf("Error restoring mandates and activity history: %v", err)
+	}
+
+	// Mandates follow market prices, escalate prices and reset daily spending caps over time
+	go func() {
+		for range time.Tick(time.Minute) {
+			client.GetActivityManager().UpdateMandatePrices()
+			client.GetActivityManager().ReapplyMandates()
+		}
+	}()
+
 	receiveManager := startReceiveManager()
 
 	log.Printf("Internal network interface for UI and workers: %v\n", InternalIface)
 	log.Printf("Internal network port for UI and workers: %v\n", InternalPort)
 	log.Printf("Own BitWrk account: %v\n", BitcoinIdentity.GetAddress())
 	log.Printf("Trusted account: %v", TrustedAccount)
-	log.Printf("Limiting to %v unmatched and %v transferring bids.\n", client.NumUnmatchedBids, client.NumTransmittingBids)
+	log.Printf("Limiting to %v unmatched and %v transferring bids.\n",
+		client.CurrentSettings().NumUnmatchedBids, client.CurrentSettings().NumTransmittingBids)
 
 	// Create local-only worker manager if no external port has been specified
 	workerManager := client.NewWorkerManager(client.GetActivityManager(), receiveManager, ExternalPort <= 0)
 
+	if err := applyEntries(workerManager, config); err != nil {
+		log.Fatalf("Error applying configuration: %v", err)
+	}
+
+	// Reload the configuration file on SIGHUP
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := reloadConfig(workerManager); err != nil {
+				log.Printf("Error reloading configuration: %v", err)
+			}
+		}
+	}()
+
 	exit := make(chan error)
 	if InternalPort > 0 {
 		go serveInternal(workerManager, exit)
@@ -135,6 +230,46 @@ func main() {
 	}
 }
 
+// Type validatorFlag parses "-validator" command line arguments and registers
+// the validators with the client.
+type validatorFlag struct{}
+
+func (validatorFlag) String() string {
+	return ""
+}
+
+func (validatorFlag) Set(value string) error {
+	idx := strings.Index(value, "=")
+	if idx <= 0 {
+		return fmt.Errorf("Expected ARTICLE=COMMAND or ARTICLE=URL, got: %#v", value)
+	}
+	article := bitwrk.ArticleId(value[:idx])
+	if validator, err := client.ParseResultValidator(value[idx+1:]); err != nil {
+		return err
+	} else {
+		log.Printf("Validating results of %v using: %v", article, validator)
+		client.SetResultValidator(article, validator)
+	}
+	return nil
+}
+
+// Type redundantFlag parses "-redundant" command line arguments and marks the
+// articles for redundant buying.
+type redundantFlag struct{}
+
+func (redundantFlag) String() string {
+	return ""
+}
+
+func (redundantFlag) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("Expected ARTICLE")
+	}
+	log.Printf("Buying %v redundantly", value)
+	client.SetRedundantArticle(bitwrk.ArticleId(value), true)
+	return nil
+}
+
 func getReceiveManagerPrefix(addr string) (prefix string) {
 	if strings.Contains(addr, ":") {
 		addr = "[" + addr + "]"
@@ -189,19 +324,7 @@ func startReceiveManager() (receiveManager *receiveman.ReceiveManager) {
 	return
 }
 
-// A wrapper around http.Handler that denies access from non-loopback sources
-type protector struct{ h http.Handler }
-
-// Denies requests from prohibited addresses
-func (p protector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if allowed(r.RemoteAddr) {
-		p.h.ServeHTTP(w, r)
-	} else {
-		http.Error(w, "Access only allowed from loopback interface, not from "+r.RemoteAddr, http.StatusForbidden)
-	}
-}
-
-// Deny access to everybody except localhost
+// Returns true if the request originates from the loopback interface
 func allowed(hostport string) bool {
 	host, _, err := net.SplitHostPort(hostport)
 	if err != nil {
@@ -224,24 +347,26 @@ func serveInternal(workerManager *client.WorkerManager, exit chan<- error) {
 	}
 	relay := NewHttpRelay("/", protocol.BitwrkUrl, protocol.NewClient(&http.Transport{}))
 
-	// Some shortcuts for API declaration
+	// Some shortcuts for API declaration. Except for static resources and some harmless
+	// information, all handlers require a token granting one of the given scopes.
 	public := func(pattern string, handler http.Handler) {
 		mux.Handle(pattern, handler)
 	}
-	protected := func(pattern string, handler http.Handler) {
-		mux.Handle(pattern, protector{handler})
+	protected := func(pattern string, handler http.Handler, scopes ...string) {
+		mux.Handle(pattern, authorizer{handler, scopes})
 	}
 	publicFunc := func(pattern string, handler func(http.ResponseWriter, *http.Request)) {
 		public(pattern, http.HandlerFunc(handler))
 	}
-	protectedFunc := func(pattern string, handler func(http.ResponseWriter, *http.Request)) {
-		protected(pattern, protector{http.HandlerFunc(handler)})
+	protectedFunc := func(pattern string, handler func(http.ResponseWriter, *http.Request), scopes ...string) {
+		protected(pattern, http.HandlerFunc(handler), scopes...)
 	}
 
-	public("/account/", relay)
-	public("/bid/", relay)
-	public("/deposit/", relay)
-	public("/tx/", relay)
+	protected("/account/", relay, ScopeUI)
+	protected("/bid/", relay, ScopeUI)
+	protected("/deposit/", relay, ScopeUI)
+	protected("/withdrawal/", relay, ScopeUI)
+	protected("/tx/", relay, ScopeUI)
 	public("/motd", relay)
 
 	accountFilter := func(data []byte) ([]byte, error) {
@@ -272,35 +397,52 @@ func serveInternal(workerManager *client.WorkerManager, exit chan<- error) {
 	}
 	myAccountUrl := fmt.Sprintf("%saccount/%s", protocol.BitwrkUrl, BitcoinIdentity.GetAddress())
 	myAccountRelay := NewHttpRelay("/myaccount", myAccountUrl, relay.client).WithFilterFunc(accountFilter)
-	public("/myaccount", myAccountRelay)
+	protected("/myaccount", myAccountRelay, ScopeUI)
 
 	resource := http.FileServer(http.Dir(filepath.Join(ResourceDir, "htroot")))
 	public("/js/", resource)
 	public("/css/", resource)
 	public("/img/", resource)
 
-	protectedFunc("/buy/", handleBuy)
-	publicFunc("/file/", handleFile)
-	protectedFunc("/", handleHome)
-	protectedFunc("/ui/", handleHome)
-	protectedFunc("/activities", handleActivities)
-	publicFunc("/registerworker", func(w http.ResponseWriter, r *http.Request) {
+	protectedFunc("/buy/", handleBuy, ScopeBuy)
+	protectedFunc("/jobs", handleJobs, ScopeBuy)
+	protectedFunc("/jobs/", handleJob, ScopeBuy)
+	protectedFunc("/file/", handleFile, ScopeBuy, ScopeUI)
+	protectedFunc("/", handleHome, ScopeUI)
+	protectedFunc("/ui/", handleHome, ScopeUI)
+	protectedFunc("/activities", handleActivities, ScopeUI, ScopeBuy)
+	protectedFunc("/registerworker", func(w http.ResponseWriter, r *http.Request) {
 		handleRegisterWorker(workerManager, w, r)
-	})
-	publicFunc("/unregisterworker", func(w http.ResponseWriter, r *http.Request) {
+	}, ScopeWorker)
+	protectedFunc("/worker/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		handleWorkerHeartbeat(workerManager, w, r)
+	}, ScopeWorker)
+	protectedFunc("/worker/work", func(w http.ResponseWriter, r *http.Request) {
+		handleWorkerWork(workerManager, w, r)
+	}, ScopeWorker)
+	protectedFunc("/worker/result", func(w http.ResponseWriter, r *http.Request) {
+		handleWorkerResult(workerManager, w, r)
+	}, ScopeWorker)
+	protectedFunc("/worker/error", func(w http.ResponseWriter, r *http.Request) {
+		handleWorkerError(workerManager, w, r)
+	}, ScopeWorker)
+	protectedFunc("/unregisterworker", func(w http.ResponseWriter, r *http.Request) {
 		handleUnregisterWorker(workerManager, w, r)
-	})
+	}, ScopeWorker, ScopeUI)
 	protectedFunc("/workers", func(w http.ResponseWriter, r *http.Request) {
 		handleWorkers(workerManager, w, r)
-	})
+	}, ScopeUI, ScopeWorker)
 	protectedFunc("/mandates", func(w http.ResponseWriter, r *http.Request) {
 		handleMandates(client.GetActivityManager(), w, r)
-	})
+	}, ScopeUI)
+	protectedFunc("/reloadconfig", func(w http.ResponseWriter, r *http.Request) {
+		handleReloadConfig(workerManager, w, r)
+	}, ScopeUI)
 	protectedFunc("/revokemandate", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleRevokeMandate(r); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}, ScopeUI)
 	protectedFunc("/requestdepositaddress", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleRequestDepositAddress(r); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -308,14 +450,22 @@ func serveInternal(workerManager *client.WorkerManager, exit chan<- error) {
 			// Account information is now stale
 			myAccountRelay.InvalidateCache()
 		}
-	})
+	}, ScopeUI)
+	protectedFunc("/requestwithdrawal", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleRequestWithdrawal(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else {
+			// Account information is now stale
+			myAccountRelay.InvalidateCache()
+		}
+	}, ScopeUI)
 	publicFunc("/id", handleId)
 	publicFunc("/version", handleVersion)
 	publicFunc("/myip", handleMyIp)
 	protectedFunc("/cafsdebug", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		client.GetActivityManager().GetStorage().DumpStatistics(cafs.NewWriterPrinter(w))
-	})
+	}, ScopeUI)
 	protectedFunc("/stackdump", func(w http.ResponseWriter, r *http.Request) {
 		name := r.FormValue("name")
 		if len(name) == 0 {
@@ -330,12 +480,12 @@ func serveInternal(workerManager *client.WorkerManager, exit chan<- error) {
 		if err != nil {
 			log.Printf("Error in profile.WriteTo: %v\n", err)
 		}
-	})
+	}, ScopeUI)
 	protectedFunc("/tickets", func(w http.ResponseWriter, r *http.Request) {
 		if err := assist.Tickets.Dump(w); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-	})
+	}, ScopeUI)
 	exit <- s.ListenAndServe()
 }
 
@@ -399,67 +549,46 @@ func handleBuy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var price *money.Money
-	if priceStr := r.URL.Query().Get("price"); priceStr == "" {
-		// No price given, ok
-	} else if m, err := money.Parse(priceStr); err != nil {
-		// Parsing failed
+	if p, err := parsePrice(r); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	} else {
-		// Good case
-		price = &m
+		price = p
 	}
 
-	var reader io.Reader
-	if multipart, err := r.MultipartReader(); err != nil {
-		// read directly from body
-		reader = r.Body
-	} else {
-		// Iterate through parts of multipart body, find the one called "data"
-		for {
-			if part, err := multipart.NextPart(); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				log.Printf("Error iterating through multipart content: %v", err)
-				return
-			} else {
-				if part.FormName() == "data" {
-					reader = part
-					break
-				} else {
-					log.Printf("Skipping form part %v", part)
-				}
-			}
+	var buy buyer
+	var err error
+	manager := client.GetActivityManager()
+	if s := r.URL.Query().Get("speculative"); s != "" {
+		// Dispatch the work to several sellers, the first result wins
+		var n int
+		if n, err = strconv.Atoi(s); err == nil {
+			buy, err = manager.NewSpeculativeBuy(article, BitcoinIdentity, price, n)
 		}
+	} else if r.URL.Query().Get("redundant") == "true" || client.IsRedundantArticle(article) {
+		buy, err = manager.NewRedundantBuy(article, BitcoinIdentity, price)
+	} else {
+		buy, err = manager.NewBuy(article, BitcoinIdentity, price)
 	}
-
-	var buy *client.BuyActivity
-	if _buy, err := client.GetActivityManager().NewBuy(article, BitcoinIdentity, price); err != nil {
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Printf("Error creating buy activity: %v", err)
 		return
-	} else {
-		buy = _buy
 	}
 	defer buy.Dispose()
 
 	log := bitwrk.Root().Newf("Buy #%v", buy.GetKey())
 
-	workTemp := client.GetActivityManager().GetStorage().Create(fmt.Sprintf("buy #%v: work", buy.GetKey()))
-	defer workTemp.Dispose()
-	if _, err := io.Copy(workTemp, reader); err != nil {
+	var workFile cafs.File
+	if f, err := receiveWork(r, fmt.Sprintf("buy #%v: work", buy.GetKey())); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Printf("Error receiving work data from client: %v", err)
 		return
 	} else {
-		if err := workTemp.Close(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Printf("Error writing work data to storage: %v", err)
-			return
-		}
+		workFile = f
 	}
-
-	workFile := workTemp.File()
 	defer workFile.Dispose()
+
 	var result cafs.File
 	if res, err := buy.PerformBuy(r.Context(), log, workFile); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -472,6 +601,60 @@ func handleBuy(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/file/"+result.Key().String(), http.StatusSeeOther)
 }
 
+// Type buyer is implemented by both ordinary and redundant buys.
+type buyer interface {
+	GetKey() client.ActivityKey
+	PerformBuy(ctx context.Context, log bitwrk.Logger, workFile cafs.File) (cafs.File, error)
+	Dispose()
+}
+
+// Reads the optional "price" query parameter of a buy request.
+func parsePrice(r *http.Request) (*money.Money, error) {
+	if priceStr := r.URL.Query().Get("price"); priceStr == "" {
+		// No price given, ok
+		return nil, nil
+	} else if m, err := money.Parse(priceStr); err != nil {
+		return nil, err
+	} else {
+		return &m, nil
+	}
+}
+
+// Reads work data from a request's body or, if the body is multipart encoded, from the
+// part called "data", and stores it. The returned file must be disposed by the caller.
+func receiveWork(r *http.Request, info string) (cafs.File, error) {
+	var reader io.Reader
+	if multipart, err := r.MultipartReader(); err != nil {
+		// read directly from body
+		reader = r.Body
+	} else {
+		// Iterate through parts of multipart body, find the one called "data"
+		for {
+			if part, err := multipart.NextPart(); err != nil {
+				return nil, fmt.Errorf("Error iterating through multipart content: %v", err)
+			} else {
+				if part.FormName() == "data" {
+					reader = part
+					break
+				} else {
+					log.Printf("Skipping form part %v", part)
+				}
+			}
+		}
+	}
+
+	workTemp := client.GetActivityManager().GetStorage().Create(info)
+	defer workTemp.Dispose()
+	if _, err := io.Copy(workTemp, reader); err != nil {
+		workTemp.Close()
+		return nil, err
+	}
+	if err := workTemp.Close(); err != nil {
+		return nil, fmt.Errorf("Error writing work data to storage: %v", err)
+	}
+	return workTemp.File(), nil
+}
+
 var registerWorkerTemplate = template.Must(template.New("registerWorker").Parse(`
 <!doctype html>
 <html>
@@ -481,7 +664,12 @@ var registerWorkerTemplate = template.Must(template.New("registerWorker").Parse(
 <form method="POST">
 <input type="text" name="id" value="{{if .Id}}{{.Id}}{{else}}worker-1{{end}}" /> Worker's ID<br/>
 <input type="text" name="article" value="{{if .Article}}{{.Article}}{{else}}foobar{{end}}" /> Worker's article<br/>
-<input type="text" name="pushurl" value="{{if .PushURL}}{{.PushURL}}{{else}}http://localhost:1234/{{end}}" /> URL the worker accepts work on<br/>
+<select name="method">
+<option value="http-push"{{if eq .Method "http-push"}} selected{{end}}>http-push</option>
+<option value="http-pull"{{if eq .Method "http-pull"}} selected{{end}}>http-pull</option>
+</select> How the worker receives work<br/>
+<input type="text" name="pushurl" value="{{if .PushURL}}{{.PushURL}}{{else}}http://localhost:1234/{{end}}" /> URL the worker accepts work on (http-push only)<br/>
+<input type="text" name="capacity" value="{{if .Capacity}}{{.Capacity}}{{else}}1{{end}}" /> Number of jobs the worker processes concurrently<br/>
 <input type="submit" />
 </form>
 </body>
@@ -492,15 +680,142 @@ func handleRegisterWorker(workerManager *client.WorkerManager, w http.ResponseWr
 	info := client.WorkerInfo{
 		Id:      r.FormValue("id"),
 		Article: bitwrk.ArticleId(r.FormValue("article")),
-		Method:  "http-push",
+		Method:  r.FormValue("method"),
 		PushURL: r.FormValue("pushurl"),
 	}
+	if info.Method == "" {
+		info.Method = client.WorkerMethodPush
+	}
+	info.Capacity = 1
+	if s := r.FormValue("capacity"); s != "" {
+		if n, err := strconv.Atoi(s); err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("Invalid capacity: %#v", s), http.StatusBadRequest)
+			return
+		} else {
+			info.Capacity = n
+		}
+	}
 
-	if r.Method != "POST" || info.Id == "" || info.PushURL == "" {
+	lease := client.CurrentSettings().WorkerLease
+	if s := r.FormValue("lease"); s != "" {
+		if d, err := time.ParseDuration(s); err != nil || d < minWorkerLease {
+			http.Error(w, fmt.Sprintf("Invalid lease (minimum is %v): %#v", minWorkerLease, s), http.StatusBadRequest)
+			return
+		} else {
+			lease = d
+		}
+	}
+
+	if r.Method != "POST" || info.Id == "" || info.Method == client.WorkerMethodPush && info.PushURL == "" {
 		registerWorkerTemplate.Execute(w, info)
+		return
+	}
+	if info.Method == client.WorkerMethodExec {
+		// Would let anybody holding a worker token run arbitrary commands
+		http.Error(w, "Method exec is only available to workers from the configuration file", http.StatusForbidden)
+		return
+	} else if info.Method != client.WorkerMethodPush && info.Method != client.WorkerMethodPull {
+		http.Error(w, fmt.Sprintf("Unknown worker method: %#v", info.Method), http.StatusBadRequest)
+		return
 	}
 
-	workerManager.RegisterWorker(info, BitcoinIdentity)
+	workerManager.RegisterWorker(info, BitcoinIdentity, lease)
+}
+
+// Renews the lease of a worker. Answers with "404 Not Found" if the worker needs to
+// register again.
+func handleWorkerHeartbeat(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
+	if err := workerManager.Heartbeat(r.FormValue("id")); err == client.ErrNoSuchWorker {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// The longest a worker may wait for work in a single request.
+const maxWorkerWait = 5 * time.Minute
+
+// The shortest lease a worker may ask for.
+const minWorkerLease = 10 * time.Second
+
+// Parses the job id given by a worker using method http-pull.
+func workerJobId(r *http.Request) (int64, error) {
+	if jobId, err := strconv.ParseInt(r.FormValue("job"), 10, 64); err != nil {
+		return 0, fmt.Errorf("Invalid job id: %v", err)
+	} else {
+		return jobId, nil
+	}
+}
+
+// Hands the next work item to a worker using method http-pull. The job id is sent in
+// the X-BitWrk-Job header. Answers with "204 No Content" if no work arrived in time.
+func handleWorkerWork(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	wait := 60 * time.Second
+	if s := r.FormValue("wait"); s != "" {
+		if d, err := time.ParseDuration(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if d > maxWorkerWait {
+			wait = maxWorkerWait
+		} else {
+			wait = d
+		}
+	}
+
+	id := r.FormValue("id")
+	jobId, work, err := workerManager.FetchWork(r.Context(), id, wait)
+	if err == client.ErrNoSuchWorker {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if work == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-BitWrk-Job", strconv.FormatInt(jobId, 10))
+	if _, err := io.Copy(w, work); err != nil {
+		workerManager.ReportError(id, jobId, fmt.Sprintf("Error transmitting work: %v", err))
+	}
+}
+
+// Accepts the result of a job from a worker using method http-pull.
+func handleWorkerResult(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if jobId, err := workerJobId(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if err := workerManager.SubmitResult(r.Context(), r.FormValue("id"), jobId, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
+
+// Accepts an error report from a worker using method http-pull. The request body
+// contains the error message.
+func handleWorkerError(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	message, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if jobId, err := workerJobId(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if err := workerManager.ReportError(r.FormValue("id"), jobId, string(message)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
 }
 
 func handleUnregisterWorker(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
@@ -18,6 +18,7 @@ package main
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -160,6 +161,9 @@ func handleGrantMandate(r *http.Request) error {
 	if err := mandate.Price.Parse(r.FormValue("price")); err != nil {
 		return err
 	}
+	if err := parsePricing(r, &mandate.Pricing); err != nil {
+		return err
+	}
 	mandate.UseTradesLeft = "on" == r.FormValue("usetradesleft")
 	mandate.UseUntil = "on" == r.FormValue("usevaliduntil")
 	if n, err := strconv.ParseInt(r.FormValue("tradesleft"), 10, 32); err != nil {
@@ -176,15 +180,69 @@ func handleGrantMandate(r *http.Request) error {
 	} else {
 		mandate.Until = time.Now().Add(time.Duration(n) * time.Minute)
 	}
-	if !mandate.UseTradesLeft && !mandate.UseUntil {
+	mandate.UseBudget = "on" == r.FormValue("usebudget")
+	mandate.UseDailyCap = "on" == r.FormValue("usedailycap")
+	if (mandate.UseBudget || mandate.UseDailyCap) && mandate.BidType != bitwrk.Buy {
+		return fmt.Errorf("Spending limits only apply to buys")
+	}
+	if mandate.UseBudget {
+		if err := mandate.Budget.Parse(r.FormValue("budget")); err != nil {
+			return fmt.Errorf("Illegal value for budget: %v", err)
+		} else if mandate.Budget.Amount <= 0 {
+			return fmt.Errorf("Budget must be positive, but is: %v", mandate.Budget)
+		}
+	}
+	if mandate.UseDailyCap {
+		if err := mandate.DailyCap.Parse(r.FormValue("dailycap")); err != nil {
+			return fmt.Errorf("Illegal value for daily cap: %v", err)
+		} else if mandate.DailyCap.Amount <= 0 {
+			return fmt.Errorf("Daily cap must be positive, but is: %v", mandate.DailyCap)
+		}
+	}
+	if !mandate.UseTradesLeft && !mandate.UseUntil && !mandate.UseBudget {
 		mandate.UseTradesLeft = true
 		mandate.TradesLeft = 1
 	}
+	if err := mandate.UpdateMarketPrice(); err != nil {
+		log.Printf("Error querying market price, using %v until next update: %v", mandate.Price, err)
+	}
 	key := client.GetActivityManager().NewKey()
 	client.GetActivityManager().RegisterMandate(key, &mandate)
 	return nil
 }
 
+// Reads the pricing strategy of a mandate from the mandate form.
+func parsePricing(r *http.Request, pricing *client.PricingStrategy) error {
+	pricing.Kind = r.FormValue("pricing")
+	if pricing.Kind == client.PricingFixed {
+		return nil
+	}
+	if err := pricing.Min.Parse(r.FormValue("minprice")); err != nil {
+		return fmt.Errorf("Illegal value for minimum price: %v", err)
+	}
+	if err := pricing.Max.Parse(r.FormValue("maxprice")); err != nil {
+		return fmt.Errorf("Illegal value for maximum price: %v", err)
+	}
+	if pricing.Kind == client.PricingMarket {
+		if n, err := strconv.ParseInt(r.FormValue("offset"), 10, 32); err != nil {
+			return fmt.Errorf("Illegal value for price offset: %v", err)
+		} else {
+			pricing.Offset = int(n)
+		}
+		pricing.Period = r.FormValue("period")
+	} else if pricing.Kind == client.PricingEscalating {
+		if err := pricing.Step.Parse(r.FormValue("step")); err != nil {
+			return fmt.Errorf("Illegal value for price step: %v", err)
+		}
+		if n, err := strconv.ParseInt(r.FormValue("stepminutes"), 10, 32); err != nil {
+			return fmt.Errorf("Illegal value for step interval: %v", err)
+		} else {
+			pricing.Interval = time.Duration(n) * time.Minute
+		}
+	}
+	return pricing.Validate()
+}
+
 func handleRevokeMandate(r *http.Request) error {
 	if key, err := strconv.ParseInt(r.FormValue("key"), 10, 64); err != nil {
 		return err
@@ -211,6 +269,29 @@ func handleRequestDepositAddress(r *http.Request) error {
 	}
 }
 
+func handleRequestWithdrawal(r *http.Request) error {
+	uid := make([]byte, 16)
+	if _, err := rand.Read(uid); err != nil {
+		return err
+	}
+	if nonce, err := protocol.GetNonce(); err != nil {
+		return err
+	} else {
+		req := &bitwrk.WithdrawalRequest{
+			Nonce:       nonce,
+			Participant: BitcoinIdentity.GetAddress(),
+			Amount:      strings.TrimSpace(r.FormValue("amount")),
+			Address:     strings.TrimSpace(r.FormValue("destaddress")),
+			Uid:         hex.EncodeToString(uid),
+		}
+		if err := req.SignWith(BitcoinIdentity, rand.Reader); err != nil {
+			return err
+		} else {
+			return protocol.SendWithdrawalRequest(req)
+		}
+	}
+}
+
 func handleWorkers(workerManager *client.WorkerManager, w http.ResponseWriter, r *http.Request) {
 	workerStates := workerManager.ListWorkers()
 	w.Header().Set("Content-Type", "application/json")
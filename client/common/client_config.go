@@ -37,6 +37,19 @@ func getMainConfigDir(name string) (string, error) {
 	return filepath.Join(usr.HomeDir, "."+name), nil
 }
 
+// Function GetConfigDir returns the configuration directory of the named program
+// (e.g. "bitwrk-client"), creating it if it doesn't exist.
+func GetConfigDir(name string) (string, error) {
+	dir, err := getMainConfigDir(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dir, os.ModeDir|0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("couldn't create configuration directory [%v]: %v", dir, err)
+	}
+	return dir, nil
+}
+
 func MustLoadOrCreateIdentity(name string, addrVersion byte) *bitcoin.KeyPair {
 	result, err := LoadOrCreateIdentity(name, addrVersion)
 	if err != nil {
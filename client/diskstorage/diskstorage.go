@@ -0,0 +1,375 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package diskstorage implements a content-addressable file storage that survives restarts.
+// Files are kept in a bounded in-memory cafs storage, which takes care of chunking, and are
+// additionally written to disk chunk by chunk. Files evicted from memory (or not yet loaded
+// after a restart) are restored from disk on demand.
+package diskstorage
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indyjo/cafs"
+)
+
+// When the size limit is exceeded, files are evicted until this fraction of the limit is reached.
+const evictionTarget = 0.9
+
+type DiskStorage struct {
+	cache    cafs.BoundedStorage
+	dir      string
+	maxBytes int64
+
+	writing sync.Mutex // Serializes writing to disk, so that every file is written and counted once
+
+	mutex     sync.Mutex
+	usedBytes int64
+	persisted int64 // Number of files written to disk since start
+	restored  int64 // Number of files restored from disk since start
+	evicted   int64 // Number of disk files evicted since start
+}
+
+// Make sure cafs.FileStorage is implemented
+var _ cafs.FileStorage = &DiskStorage{}
+
+// Function New creates a storage which uses cache for keeping files in memory and persists
+// them to dir. The files in dir may take up to maxBytes bytes before the least recently used
+// ones are deleted. Files left by a previous session are reused.
+func New(cache cafs.BoundedStorage, dir string, maxBytes int64) (*DiskStorage, error) {
+	s := &DiskStorage{
+		cache:    cache,
+		dir:      dir,
+		maxBytes: maxBytes,
+	}
+	for _, sub := range []string{"chunks", "files"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	if entries, err := s.scan(); err != nil {
+		return nil, err
+	} else {
+		for _, e := range entries {
+			s.usedBytes += e.size
+		}
+	}
+	log.Printf("Disk storage at %v: %v of %v bytes used", dir, s.usedBytes, maxBytes)
+	return s, nil
+}
+
+func keyName(key cafs.SKey) string {
+	return hex.EncodeToString(key[:])
+}
+
+// Returns the path of the file containing a chunk's data.
+func (s *DiskStorage) chunkPath(key cafs.SKey) string {
+	name := keyName(key)
+	return filepath.Join(s.dir, "chunks", name[:2], name)
+}
+
+// Returns the path of the file listing the chunks of a chunked file.
+func (s *DiskStorage) filePath(key cafs.SKey) string {
+	return filepath.Join(s.dir, "files", keyName(key))
+}
+
+func (s *DiskStorage) Create(info string) cafs.Temporary {
+	return &temporary{s, s.cache.Create(info)}
+}
+
+func (s *DiskStorage) Get(key *cafs.SKey) (cafs.File, error) {
+	if f, err := s.cache.Get(key); err == nil {
+		return f, nil
+	} else if err != cafs.ErrNotFound {
+		return nil, err
+	}
+	return s.restore(*key)
+}
+
+func (s *DiskStorage) DumpStatistics(p cafs.Printer) {
+	s.mutex.Lock()
+	p.Printf("Disk storage at %v: %v of %v bytes used. Persisted: %v, restored: %v, evicted: %v",
+		s.dir, s.usedBytes, s.maxBytes, s.persisted, s.restored, s.evicted)
+	s.mutex.Unlock()
+	s.cache.DumpStatistics(p)
+}
+
+// Type temporary passes data to the in-memory storage and persists the resulting
+// file once it has been closed.
+type temporary struct {
+	s     *DiskStorage
+	inner cafs.Temporary
+}
+
+func (t *temporary) Write(b []byte) (int, error) {
+	return t.inner.Write(b)
+}
+
+func (t *temporary) Close() error {
+	if err := t.inner.Close(); err != nil {
+		return err
+	}
+	// Write to disk in the background. Holding a reference keeps the file's chunks in
+	// memory until then.
+	f := t.inner.File()
+	go func() {
+		defer f.Dispose()
+		if err := t.s.persist(f); err != nil {
+			log.Printf("Error persisting %v to disk: %v", f.Key(), err)
+		}
+	}()
+	return nil
+}
+
+func (t *temporary) File() cafs.File {
+	return t.inner.File()
+}
+
+func (t *temporary) Dispose() {
+	t.inner.Dispose()
+}
+
+// Writes a file's chunks to disk, skipping those already present, followed by the list
+// of chunks. Chunks may have been evicted since the file was last persisted, so every
+// chunk is checked.
+func (s *DiskStorage) persist(f cafs.File) error {
+	if !f.IsChunked() {
+		if err := s.writeChunk(f.Key(), f); err != nil {
+			return err
+		}
+	} else {
+		var list strings.Builder
+		iter := f.Chunks()
+		defer iter.Dispose()
+		for iter.Next() {
+			chunk := iter.File()
+			err := s.writeChunk(iter.Key(), chunk)
+			chunk.Dispose()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(&list, keyName(iter.Key()))
+		}
+		if err := s.writeOnce(s.filePath(f.Key()), func() io.ReadCloser {
+			return ioutil.NopCloser(strings.NewReader(list.String()))
+		}); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	s.persisted++
+	exceeded := s.usedBytes > s.maxBytes
+	s.mutex.Unlock()
+
+	if exceeded {
+		return s.evict()
+	}
+	return nil
+}
+
+// Writes a chunk to disk unless it exists already.
+func (s *DiskStorage) writeChunk(key cafs.SKey, chunk cafs.File) error {
+	path := s.chunkPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return s.writeOnce(path, chunk.Open)
+}
+
+// Writes data to path unless a file exists there already, in which case it is marked as
+// recently used. Written bytes are added to the used size.
+func (s *DiskStorage) writeOnce(path string, open func() io.ReadCloser) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		s.touch(path)
+		return nil
+	}
+
+	r := open()
+	defer r.Close()
+	n, err := s.writeAtomically(path, r)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.usedBytes += n
+	s.mutex.Unlock()
+	return nil
+}
+
+// Writes data to a temporary file first and renames it, so that no partial files are left
+// behind if the client is terminated.
+func (s *DiskStorage) writeAtomically(path string, r io.Reader) (int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+// Marks a file on disk as recently used.
+func (s *DiskStorage) touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// Reads a file from disk back into the in-memory storage. Returns cafs.ErrNotFound if the
+// file (or any of its chunks) isn't on disk.
+func (s *DiskStorage) restore(key cafs.SKey) (cafs.File, error) {
+	var chunkPaths []string
+	if listFile, err := os.Open(s.filePath(key)); err == nil {
+		scanner := bufio.NewScanner(listFile)
+		for scanner.Scan() {
+			var chunkKey cafs.SKey
+			if b, err := hex.DecodeString(scanner.Text()); err != nil || len(b) != len(chunkKey) {
+				listFile.Close()
+				return nil, fmt.Errorf("Corrupt chunk list of %v", keyName(key))
+			} else {
+				copy(chunkKey[:], b)
+			}
+			chunkPaths = append(chunkPaths, s.chunkPath(chunkKey))
+		}
+		listFile.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		s.touch(s.filePath(key))
+	} else if _, err := os.Stat(s.chunkPath(key)); err == nil {
+		chunkPaths = []string{s.chunkPath(key)}
+	} else {
+		return nil, cafs.ErrNotFound
+	}
+
+	temp := s.cache.Create(fmt.Sprintf("Restored %v from disk", keyName(key)))
+	defer temp.Dispose()
+	for _, path := range chunkPaths {
+		if err := s.copyChunk(temp, path); os.IsNotExist(err) {
+			// A chunk has been evicted. The file can't be restored anymore.
+			temp.Close()
+			return nil, cafs.ErrNotFound
+		} else if err != nil {
+			temp.Close()
+			return nil, err
+		}
+	}
+	if err := temp.Close(); err != nil {
+		return nil, err
+	}
+
+	f := temp.File()
+	if f.Key() != key {
+		f.Dispose()
+		return nil, fmt.Errorf("Restoring %v from disk yielded %v", keyName(key), keyName(f.Key()))
+	}
+
+	s.mutex.Lock()
+	s.restored++
+	s.mutex.Unlock()
+	return f, nil
+}
+
+func (s *DiskStorage) copyChunk(w io.Writer, path string) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	s.touch(path)
+	return nil
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Lists all chunk and chunk list files on disk.
+func (s *DiskStorage) scan() ([]entry, error) {
+	var entries []entry
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".tmp-") {
+			entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Deletes the least recently used files on disk until the used size drops below the
+// eviction target.
+func (s *DiskStorage) evict() error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.scan()
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var used int64
+	for _, e := range entries {
+		used += e.size
+	}
+	target := int64(float64(s.maxBytes) * evictionTarget)
+	for _, e := range entries {
+		if used <= target {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		used -= e.size
+		s.evicted++
+	}
+	s.usedBytes = used
+	return nil
+}
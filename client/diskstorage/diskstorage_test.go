@@ -0,0 +1,192 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diskstorage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/indyjo/cafs"
+	"github.com/indyjo/cafs/ram"
+)
+
+func newTestStorage(t *testing.T, dir string, maxBytes int64) *DiskStorage {
+	s, err := New(ram.NewRamStorage(64*1024*1024), dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Creates a file of random data in the in-memory part of the storage, without persisting it.
+func createFile(t *testing.T, s *DiskStorage, size int, seed int64) (cafs.File, []byte) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	temp := s.cache.Create("test data")
+	defer temp.Dispose()
+	if _, err := temp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := temp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return temp.File(), data
+}
+
+func checkRestored(t *testing.T, s *DiskStorage, key cafs.SKey, data []byte) {
+	f, err := s.restore(key)
+	if err != nil {
+		t.Fatalf("Error restoring %v: %v", keyName(key), err)
+	}
+	defer f.Dispose()
+	r := f.Open()
+	defer r.Close()
+	if restored, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(restored, data) {
+		t.Errorf("Restored data of %v differs", keyName(key))
+	}
+}
+
+// Checks that the used size matches what is actually on disk.
+func checkUsedBytes(t *testing.T, s *DiskStorage) {
+	entries, err := s.scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk int64
+	for _, e := range entries {
+		onDisk += e.size
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.usedBytes != onDisk {
+		t.Errorf("Used bytes: %v, but %v bytes on disk", s.usedBytes, onDisk)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "diskstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func Test_PersistAndRestore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestStorage(t, dir, 1024*1024)
+
+	small, smallData := createFile(t, s, 100, 1)
+	large, largeData := createFile(t, s, 100000, 2)
+	for _, f := range []cafs.File{small, large} {
+		if err := s.persist(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkUsedBytes(t, s)
+
+	// A new storage on the same directory finds the files left by the previous one
+	s = newTestStorage(t, dir, 1024*1024)
+	checkUsedBytes(t, s)
+	checkRestored(t, s, small.Key(), smallData)
+	checkRestored(t, s, large.Key(), largeData)
+	if _, err := s.restore(cafs.SKey{}); err != cafs.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for unknown key, got: %v", err)
+	}
+}
+
+func Test_PersistRewritesEvictedChunks(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestStorage(t, dir, 1024*1024)
+
+	f, data := createFile(t, s, 100000, 3)
+	if err := s.persist(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete one of the chunks, as eviction would
+	iter := f.Chunks()
+	iter.Next()
+	iter.Next()
+	chunkKey := iter.Key()
+	iter.Dispose()
+	if info, err := os.Stat(s.chunkPath(chunkKey)); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(s.chunkPath(chunkKey)); err != nil {
+		t.Fatal(err)
+	} else {
+		s.usedBytes -= info.Size()
+	}
+	if _, err := s.restore(f.Key()); err != cafs.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound with missing chunk, got: %v", err)
+	}
+
+	if err := s.persist(f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.chunkPath(chunkKey)); err != nil {
+		t.Errorf("Chunk wasn't written again: %v", err)
+	}
+	checkRestored(t, s, f.Key(), data)
+}
+
+func Test_ConcurrentPersistCountsOnce(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestStorage(t, dir, 1024*1024)
+
+	f, _ := createFile(t, s, 100000, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.persist(f); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	checkUsedBytes(t, s)
+}
+
+func Test_Evict(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newTestStorage(t, dir, 200000)
+
+	for i := 0; i < 5; i++ {
+		f, _ := createFile(t, s, 100000, int64(10+i))
+		if err := s.persist(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkUsedBytes(t, s)
+	if s.usedBytes > s.maxBytes {
+		t.Errorf("Used bytes %v exceed the maximum of %v", s.usedBytes, s.maxBytes)
+	}
+	if s.evicted == 0 {
+		t.Errorf("Expected files to be evicted")
+	}
+}
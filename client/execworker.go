@@ -0,0 +1,153 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/indyjo/cafs"
+)
+
+// How much of a worker command's stderr output is kept for error messages
+const maxStderrBytes = 256
+
+// Returns the command to run for each job of a worker using WorkerMethodExec, applying
+// the worker's resource limits. On Unix-like systems, limits are set using the shell's
+// ulimit builtin before the shell replaces itself with the actual command. The command
+// runs in a process group of its own, see killProcessGroup.
+func execCommand(info WorkerInfo) (*exec.Cmd, error) {
+	fields := strings.Fields(info.Command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Worker %#v has no command", info.Id)
+	}
+
+	var limits []string
+	if info.MaxCPUSeconds > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", info.MaxCPUSeconds))
+	}
+	if info.MaxMemoryMegabytes > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", info.MaxMemoryMegabytes*1024))
+	}
+
+	var cmd *exec.Cmd
+	if len(limits) == 0 {
+		cmd = exec.Command(fields[0], fields[1:]...)
+	} else if runtime.GOOS == "windows" {
+		return nil, errors.New("Resource limits for workers are not supported on Windows")
+	} else {
+		script := strings.Join(limits, " && ") + ` && exec "$@"`
+		cmd = exec.Command("/bin/sh", append([]string{"-c", script, "sh"}, fields...)...)
+	}
+	cmd.Env = append(os.Environ(), "BITWRK_ARTICLE="+string(info.Article))
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+// Type limitedBuffer keeps the first bytes written to it, up to limit, and discards the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// Type fileResult is a result kept in temporary storage. Closing it disposes the file.
+type fileResult struct {
+	io.ReadCloser
+	file cafs.File
+}
+
+func (r *fileResult) Close() error {
+	defer r.file.Dispose()
+	return r.ReadCloser.Close()
+}
+
+// Runs the worker's command on the work, which it receives on stdin. The result is what the
+// command writes to stdout. It is only returned if the command exits successfully, so a failing
+// command leads to the work being rejected. The command, including any processes it has started,
+// is killed when the context is cancelled, which happens when the transaction ends.
+// Assumes that the slot has already been marked busy.
+func (s *WorkerState) doExecWork(ctx context.Context, slot int, workReader io.Reader) (io.ReadCloser, error) {
+	defer s.setBusy(slot, false)
+
+	cmd, err := execCommand(s.Info)
+	if err != nil {
+		return nil, err
+	}
+
+	temp := s.m.activityManager.GetStorage().Create(fmt.Sprintf("Result of worker %v", s.Info.Id))
+	defer temp.Dispose()
+	stderr := limitedBuffer{limit: maxStderrBytes}
+	cmd.Stdin = workReader
+	cmd.Stdout = temp
+	cmd.Stderr = &stderr
+
+	err = cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	if err != nil {
+		temp.Close()
+		message := strings.TrimSpace(stderr.String())
+		if stderr.truncated {
+			message += "..."
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if message != "" {
+			return nil, fmt.Errorf("Worker command failed: %v: %v", err, message)
+		}
+		return nil, fmt.Errorf("Worker command failed: %v", err)
+	}
+	if err := temp.Close(); err != nil {
+		return nil, fmt.Errorf("Error storing result of worker command: %v", err)
+	}
+
+	file := temp.File()
+	return &fileResult{file.Open(), file}, nil
+}
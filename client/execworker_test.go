@@ -0,0 +1,95 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indyjo/cafs/ram"
+)
+
+func newExecWorker(command string) *WorkerState {
+	manager := &WorkerManager{activityManager: &ActivityManager{storage: ram.NewRamStorage(16 * 1024 * 1024)}}
+	return &WorkerState{
+		m:     manager,
+		cond:  sync.NewCond(new(sync.Mutex)),
+		Info:  WorkerInfo{Id: "test", Method: WorkerMethodExec, Command: command},
+		Slots: make([]WorkerSlot, 1),
+	}
+}
+
+func Test_ExecWorkerCat(t *testing.T) {
+	s := newExecWorker("cat")
+	work := bytes.Repeat([]byte("BitWrk "), 100000)
+	s.setBusy(0, true)
+	result, err := s.doExecWork(context.Background(), 0, bytes.NewReader(work))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+	if data, err := ioutil.ReadAll(result); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, work) {
+		t.Errorf("Expected result to equal work, got %v bytes", len(data))
+	}
+	if s.Slots[0].Busy {
+		t.Errorf("Expected slot to be idle after work")
+	}
+}
+
+func Test_ExecWorkerFailure(t *testing.T) {
+	s := newExecWorker("false")
+	if result, err := s.doExecWork(context.Background(), 0, strings.NewReader("work")); err == nil {
+		result.Close()
+		t.Fatal("Expected failing command to return an error")
+	}
+
+	// Writes the work to stderr, then fails writing to a file
+	s = newExecWorker("tee /dev/stderr /nonexistent/dir/file")
+	work := strings.Repeat("x", 100000)
+	if result, err := s.doExecWork(context.Background(), 0, strings.NewReader(work)); err == nil {
+		result.Close()
+		t.Fatal("Expected failing command to return an error")
+	} else if !strings.HasSuffix(err.Error(), "...") || len(err.Error()) > maxStderrBytes+100 {
+		t.Errorf("Expected error message to be truncated, got %v bytes", len(err.Error()))
+	}
+}
+
+func Test_ExecWorkerCancel(t *testing.T) {
+	s := newExecWorker("sleep 30")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if result, err := s.doExecWork(ctx, 0, strings.NewReader("")); err != context.DeadlineExceeded {
+		if result != nil {
+			result.Close()
+		}
+		t.Errorf("Expected context error, got: %v", err)
+	}
+	if d := time.Since(started); d > 10*time.Second {
+		t.Errorf("Command wasn't killed in time, took %v", d)
+	}
+}
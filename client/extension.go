@@ -0,0 +1,124 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	. "github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Type ExtensionPolicy defines how trades handle extensions of a transaction's timeout
+// during the WORKING phase.
+type ExtensionPolicy struct {
+	// Sellers propose an extension when less than this much time is left before the
+	// transaction times out. Zero disables proposing extensions.
+	ProposeBefore time.Duration
+	// The amount of time proposed by a seller per extension.
+	ProposeAmount time.Duration
+	// Buyers grant proposed extensions as long as the total extension of a transaction
+	// doesn't exceed this amount. Zero disables granting extensions.
+	MaxGranted time.Duration
+}
+
+// How often the transaction is checked for the need to propose or grant an extension
+var extensionCheckInterval = 5 * time.Second
+
+// Proposes extensions of the transaction's timeout while the seller is working, as
+// long as the policy allows. Returns when a value is read from exit.
+func (a *SellActivity) proposeExtensions(log bitwrk.Logger, exit <-chan bool) {
+	policy := CurrentSettings().Extensions
+	if policy.ProposeBefore == 0 || policy.ProposeAmount == 0 {
+		return
+	}
+
+	// Proposals are sent at most once per timeout value
+	var proposedFor time.Time
+	ticker := time.NewTicker(extensionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+		}
+
+		var propose bool
+		var timeout time.Time
+		a.execSync(func() {
+			if a.tx == nil || a.tx.State != bitwrk.StateActive || a.tx.Phase != bitwrk.PhaseWorking {
+				return
+			}
+			timeout = a.tx.Timeout
+			propose = a.tx.ProposedExtension == 0 &&
+				!timeout.Equal(proposedFor) &&
+				time.Until(timeout) < policy.ProposeBefore &&
+				a.tx.Extension+policy.ProposeAmount <= bitwrk.MaxTimeoutExtension
+		})
+		if !propose {
+			continue
+		}
+
+		proposedFor = timeout
+		log.Printf("Proposing to extend timeout by %v", policy.ProposeAmount)
+		if err := SendTxMessageExtendTimeout(a.txId, a.identity, policy.ProposeAmount); err != nil {
+			log.Printf("Error proposing extension: %v", err)
+		}
+	}
+}
+
+// Grants extensions of the transaction's timeout proposed by the seller, as long as the
+// policy allows. Returns when a value is read from exit.
+func (a *BuyActivity) grantExtensions(log bitwrk.Logger, exit <-chan bool) {
+	policy := CurrentSettings().Extensions
+
+	// Each proposal, identified by the timeout it was made for, is handled only once
+	var handledFor time.Time
+	ticker := time.NewTicker(extensionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+		}
+
+		var proposed, extension time.Duration
+		var timeout time.Time
+		a.execSync(func() {
+			if a.tx == nil || a.tx.State != bitwrk.StateActive || a.tx.Phase != bitwrk.PhaseWorking {
+				return
+			}
+			proposed, extension, timeout = a.tx.ProposedExtension, a.tx.Extension, a.tx.Timeout
+		})
+		if proposed == 0 || timeout.Equal(handledFor) {
+			continue
+		}
+
+		handledFor = timeout
+		if extension+proposed > policy.MaxGranted {
+			log.Printf("Declining to extend timeout by %v (already extended by %v, maximum is %v)",
+				proposed, extension, policy.MaxGranted)
+			continue
+		}
+		log.Printf("Granting extension of timeout by %v", proposed)
+		if err := SendTxMessageExtendTimeout(a.txId, a.identity, proposed); err != nil {
+			log.Printf("Error granting extension: %v", err)
+		}
+	}
+}
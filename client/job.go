@@ -0,0 +1,227 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/cafs"
+)
+
+type JobStatus string
+
+const (
+	JobRunning  JobStatus = "RUNNING"
+	JobDone     JobStatus = "DONE"
+	JobFailed   JobStatus = "FAILED"
+	JobCanceled JobStatus = "CANCELED"
+)
+
+// How long finished jobs (and their results) are kept before being forgotten.
+var JobRetention = 1 * time.Hour
+
+// Type Job is a buy which runs independently of the request that submitted it.
+// Its status can be polled and its result fetched later.
+type Job struct {
+	id      ActivityKey
+	article bitwrk.ArticleId
+	buy     *BuyActivity
+	cancel  context.CancelFunc
+	done    chan struct{}
+	created time.Time
+
+	mutex    sync.Mutex
+	status   JobStatus
+	err      error
+	result   cafs.File
+	finished time.Time
+}
+
+// Struct JobInfo describes a job's status. It is displayed to the user.
+type JobInfo struct {
+	Id        ActivityKey
+	Article   bitwrk.ArticleId
+	Status    JobStatus
+	Error     string `json:",omitempty"`
+	Created   time.Time
+	Finished  time.Time
+	ResultKey string         `json:",omitempty"`
+	Activity  *ActivityState `json:",omitempty"` // The underlying buy's state
+}
+
+type JobManager struct {
+	activityManager *ActivityManager
+	mutex           sync.Mutex
+	jobs            map[ActivityKey]*Job
+}
+
+var jobManager = JobManager{
+	activityManager: &activityManager,
+	jobs:            make(map[ActivityKey]*Job),
+}
+
+func GetJobManager() *JobManager {
+	return &jobManager
+}
+
+// Submits a buy of the given work as a job and returns immediately. The work file is
+// duplicated, so the caller may dispose it.
+func (m *JobManager) Submit(article bitwrk.ArticleId, identity *bitcoin.KeyPair, price *money.Money, workFile cafs.File) (*Job, error) {
+	buy, err := m.activityManager.NewBuy(article, identity, price)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		id:      buy.GetKey(),
+		article: article,
+		buy:     buy,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		created: time.Now(),
+		status:  JobRunning,
+	}
+
+	m.mutex.Lock()
+	m.prune(job.created)
+	m.jobs[job.id] = job
+	m.mutex.Unlock()
+
+	go job.run(ctx, workFile.Duplicate())
+	return job, nil
+}
+
+// Returns the job with the given ID, or nil.
+func (m *JobManager) Get(id ActivityKey) *Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.prune(time.Now())
+	return m.jobs[id]
+}
+
+// Returns information on all jobs, ordered by ID.
+func (m *JobManager) List() []JobInfo {
+	m.mutex.Lock()
+	m.prune(time.Now())
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mutex.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].id < jobs[j].id })
+	result := make([]JobInfo, len(jobs))
+	for i, job := range jobs {
+		result[i] = job.Info()
+	}
+	return result
+}
+
+// Forgets about jobs which have finished longer than JobRetention ago.
+// Must be called with the mutex held.
+func (m *JobManager) prune(now time.Time) {
+	for id, job := range m.jobs {
+		job.mutex.Lock()
+		expired := job.status != JobRunning && now.Sub(job.finished) > JobRetention
+		if expired && job.result != nil {
+			job.result.Dispose()
+			job.result = nil
+		}
+		job.mutex.Unlock()
+		if expired {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (j *Job) run(ctx context.Context, workFile cafs.File) {
+	defer close(j.done)
+	defer j.buy.Dispose()
+	defer workFile.Dispose()
+
+	log := bitwrk.Root().Newf("Job #%v", j.id)
+	result, err := j.buy.PerformBuy(ctx, log, workFile)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.finished = time.Now()
+	if err == nil {
+		j.status = JobDone
+		// The buy's reference to the result goes away when the buy is disposed
+		j.result = result.Duplicate()
+	} else if ctx.Err() != nil {
+		j.status = JobCanceled
+		j.err = err
+	} else {
+		j.status = JobFailed
+		j.err = err
+	}
+}
+
+func (j *Job) GetId() ActivityKey {
+	return j.id
+}
+
+// Returns a channel which is closed when the job has finished.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Aborts a running job. Has no effect on finished jobs.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) Info() JobInfo {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	info := JobInfo{
+		Id:       j.id,
+		Article:  j.article,
+		Status:   j.status,
+		Created:  j.created,
+		Finished: j.finished,
+		Activity: j.buy.GetState(),
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if j.result != nil {
+		info.ResultKey = j.result.Key().String()
+	}
+	return info
+}
+
+// Returns the job's result, which must be disposed by the caller.
+func (j *Job) Result() (cafs.File, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.status != JobDone {
+		return nil, fmt.Errorf("Job #%v has no result, status is %v", j.id, j.status)
+	} else if j.result == nil {
+		return nil, fmt.Errorf("Result of job #%v has expired", j.id)
+	}
+	return j.result.Duplicate(), nil
+}
@@ -17,6 +17,7 @@
 package client
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -30,11 +31,28 @@ type Mandate struct {
 	expired       bool             // Initially false
 	BidType       bitwrk.BidType   // Buy or Sell
 	Article       bitwrk.ArticleId // Which article to buy or sell
-	Price         money.Money      // Which price to bid/ask for
+	Price         money.Money      // Which price to bid/ask for (base price if not fixed)
+	Pricing       PricingStrategy  // How the price of trades is determined
 	UseTradesLeft bool             // Whether TradesLeft should be regarded
 	TradesLeft    int              // Remaining number of trades until expiration
 	UseUntil      bool             // Whether Until should be regarded
 	Until         time.Time        // Time at which mandate should expire
+	Origin        string           // Name of the configuration entry defining the mandate, if any
+	Definition    string           // The configuration entry, for detecting changes on reload
+
+	// Spending limits, only applicable to buys. Spending is tracked from the price plus
+	// fee of the transactions matched with published buys. The price plus estimated fee
+	// of bids not matched yet is reserved against the limits.
+	UseBudget   bool        // Whether Budget should be regarded
+	Budget      money.Money // Total amount to spend until expiration
+	UseDailyCap bool        // Whether DailyCap should be regarded
+	DailyCap    money.Money // Amount to spend per calendar day
+	Spent       money.Money // Amount spent so far
+	SpentToday  money.Money // Amount spent since the beginning of Today
+	Today       time.Time   // Beginning of the day SpentToday refers to
+	reserved    money.Money // Costs of published bids not matched yet
+
+	marketPrice *money.Money // Median price of the article, for PricingMarket
 }
 
 // Shown to user
@@ -42,10 +60,19 @@ type MandateInfo struct {
 	Type          bitwrk.BidType // Buy or Sell
 	Article       bitwrk.ArticleId
 	Price         money.Money
+	Pricing       string
+	CurrentPrice  money.Money
 	UseTradesLeft bool
 	TradesLeft    int
 	UseUntil      bool
 	Until         time.Time
+	UseBudget     bool
+	Budget        money.Money
+	BudgetLeft    money.Money
+	UseDailyCap   bool
+	DailyCap      money.Money
+	DailyCapLeft  money.Money
+	Spent         money.Money
 }
 
 // Returns true if the mandate has expired
@@ -62,14 +89,26 @@ func (m *Mandate) GetInfo() *MandateInfo {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	now := time.Now()
+	m.startDay(now)
 	return &MandateInfo{
-		m.BidType,
-		m.Article,
-		m.Price,
-		m.UseTradesLeft,
-		m.TradesLeft,
-		m.UseUntil,
-		m.Until}
+		Type:          m.BidType,
+		Article:       m.Article,
+		Price:         m.Price,
+		Pricing:       m.Pricing.String(),
+		CurrentPrice:  m.currentPrice(0),
+		UseTradesLeft: m.UseTradesLeft,
+		TradesLeft:    m.TradesLeft,
+		UseUntil:      m.UseUntil,
+		Until:         m.Until,
+		UseBudget:     m.UseBudget,
+		Budget:        m.Budget,
+		BudgetLeft:    m.Budget.Sub(m.Spent),
+		UseDailyCap:   m.UseDailyCap,
+		DailyCap:      m.DailyCap,
+		DailyCapLeft:  m.DailyCap.Sub(m.SpentToday),
+		Spent:         m.Spent,
+	}
 }
 
 // Applies the mandate to the given activity and returns whether the
@@ -110,10 +149,243 @@ func (m *Mandate) Apply(activity Activity, now time.Time) bool {
 		return false
 	}
 
-	result := t.Publish(m.Price)
+	price := m.currentPrice(0)
+	cost := m.cost(price)
+
+	// Check spending limits
+	if m.BidType == bitwrk.Buy && !m.affordable(cost, now) {
+		return false
+	}
+
+	result := t.Publish(price)
 	if result {
 		m.TradesLeft--
+		t.execSync(func() { t.mandate, t.publishedAt = m, now })
+		if m.BidType == bitwrk.Buy {
+			m.reserved = m.reserved.Add(cost)
+			t.execSync(func() { t.mandateReserved = cost })
+		}
 	}
 
 	return result
 }
+
+// Grants a trade published by the mandate another bid and returns the price to bid at,
+// escalated from the time the trade was published. If newTrade is true, e.g. when a buy
+// is retried, the bid counts as another trade. Otherwise it replaces a bid that expired
+// unmatched. The bid's cost is reserved against the spending limits after releasing the
+// previous reservation, which is passed in. Returns false if the mandate doesn't allow
+// another bid.
+func (m *Mandate) renew(previous money.Money, published, now time.Time, newTrade bool) (money.Money, money.Money, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.reserved = m.reserved.Sub(previous)
+	if newTrade && m.expired {
+		return money.Money{}, money.Money{}, false
+	}
+	if newTrade && m.UseTradesLeft && m.TradesLeft <= 0 || m.UseUntil && !m.Until.After(now) {
+		m.expired = true
+		return money.Money{}, money.Money{}, false
+	}
+
+	price := m.currentPrice(now.Sub(published))
+	cost := m.cost(price)
+	if m.BidType == bitwrk.Buy && !m.affordable(cost, now) {
+		return money.Money{}, money.Money{}, false
+	}
+
+	if newTrade {
+		m.TradesLeft--
+	}
+	if m.BidType != bitwrk.Buy {
+		cost = money.Money{Currency: price.Currency}
+	}
+	m.reserved = m.reserved.Add(cost)
+	return price, cost, true
+}
+
+// Returns the expected cost of a bid at the given price, i.e. the price plus the fee
+// estimated from the article catalog.
+func (m *Mandate) cost(price money.Money) money.Money {
+	return price.Add(EstimateFee(m.Article, price))
+}
+
+// Returns the price of a trade published by the mandate that has been unmatched for the
+// given duration. With a duration of zero, this is the price new trades are published at.
+// Must be called with the lock held.
+func (m *Mandate) currentPrice(unmatched time.Duration) money.Money {
+	return m.Pricing.price(m.BidType, m.Price, m.marketPrice, unmatched)
+}
+
+// Returns whether the mandate raises (or lowers) the price of its trades until matched.
+func (m *Mandate) escalating() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Pricing.Kind == PricingEscalating
+}
+
+func (m *Mandate) pricing() (bitwrk.ArticleId, PricingStrategy) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Article, m.Pricing
+}
+
+// Sets the market price followed by mandates with PricingMarket. Nil means that
+// the market price is unknown and the mandate's price is used.
+func (m *Mandate) setMarketPrice(price *money.Money) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.marketPrice = price
+}
+
+// Queries the market price if the mandate follows the market.
+func (m *Mandate) UpdateMarketPrice() error {
+	article, strategy := m.pricing()
+	if strategy.Kind != PricingMarket {
+		return nil
+	}
+	if price, err := MarketMedian(article, strategy.period()); err != nil {
+		return err
+	} else {
+		m.setMarketPrice(price)
+		return nil
+	}
+}
+
+// Encodes the mandate's state as JSON.
+func (m *Mandate) marshal() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return json.Marshal(m)
+}
+
+// Marks the mandate as expired if it has run out of trades, time or budget.
+// Returns true if the mandate has expired.
+func (m *Mandate) checkExpiry(now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.UseTradesLeft && m.TradesLeft <= 0 ||
+		m.UseUntil && !m.Until.After(now) ||
+		m.UseBudget && m.Budget.Sub(m.Spent).Amount < m.cost(m.currentPrice(0)).Amount {
+		m.expired = true
+	}
+	return m.expired
+}
+
+// Resets the amount spent today when a new day has begun.
+// Must be called with the lock held.
+func (m *Mandate) startDay(now time.Time) {
+	y, mon, d := now.Date()
+	today := time.Date(y, mon, d, 0, 0, 0, 0, now.Location())
+	if !m.Today.Equal(today) {
+		m.Today = today
+		m.SpentToday = money.Money{Currency: m.Price.Currency}
+	}
+}
+
+// Returns whether the spending limits allow for placing another buy bid of the given
+// cost, i.e. price plus fee. Expires the mandate if the budget has been exhausted. A
+// daily cap that has been reached only holds back buys until the next day.
+// Must be called with the lock held.
+func (m *Mandate) affordable(cost money.Money, now time.Time) bool {
+	m.startDay(now)
+	if m.UseBudget {
+		left := m.Budget.Sub(m.Spent)
+		if left.Amount < cost.Amount {
+			m.expired = true
+			return false
+		}
+		if left.Sub(m.reserved).Amount < cost.Amount {
+			return false
+		}
+	}
+	if m.UseDailyCap && m.DailyCap.Sub(m.SpentToday).Sub(m.reserved).Amount < cost.Amount {
+		return false
+	}
+	return true
+}
+
+// Books the cost of a transaction matched with a trade published by the mandate and
+// releases the amount reserved for it. Only buys have a cost.
+func (m *Mandate) matched(reserved, cost money.Money, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.BidType != bitwrk.Buy {
+		return
+	}
+	m.startDay(now)
+	m.reserved = m.reserved.Sub(reserved)
+	m.Spent = m.Spent.Add(cost)
+	m.SpentToday = m.SpentToday.Add(cost)
+	if m.UseBudget && m.Budget.Sub(m.Spent).Amount < m.cost(m.Price).Amount {
+		m.expired = true
+	}
+}
+
+// Releases the amount reserved for a buy that ended without being matched.
+func (m *Mandate) release(reserved money.Money) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reserved = m.reserved.Sub(reserved)
+}
+
+// Informs the mandate that published the trade, if any, of the matched transaction.
+func (t *Trade) mandateMatched(tx *bitwrk.Transaction, now time.Time) {
+	var m *Mandate
+	var reserved money.Money
+	t.execSync(func() {
+		m, reserved = t.mandate, t.mandateReserved
+		t.mandateReserved = money.Money{Currency: reserved.Currency}
+	})
+	if m != nil {
+		m.matched(reserved, tx.Price.Add(tx.Fee), now)
+		t.manager.requestSave()
+	}
+}
+
+// Obtains the mandate's permission for a trade that is about to place a new bid, if it
+// was published by a mandate, and updates the trade's price to the mandate's current one.
+// See Mandate.renew for the meaning of newTrade. Returns false if the mandate doesn't
+// allow another bid.
+func (t *Trade) renewFromMandate(now time.Time, newTrade bool) bool {
+	var m *Mandate
+	var previous money.Money
+	var published time.Time
+	t.execSync(func() {
+		m, previous, published = t.mandate, t.mandateReserved, t.publishedAt
+		t.mandateReserved = money.Money{Currency: previous.Currency}
+	})
+	if m == nil {
+		return true
+	}
+	price, cost, ok := m.renew(previous, published, now, newTrade)
+	if !ok {
+		t.manager.requestSave()
+		return false
+	}
+	t.execSync(func() { t.price, t.mandateReserved = price, cost })
+	t.manager.requestSave()
+	return true
+}
+
+// Returns whether the trade was published by a mandate with escalating prices.
+func (t *Trade) escalatedByMandate() bool {
+	var m *Mandate
+	t.execSync(func() { m = t.mandate })
+	return m != nil && m.escalating()
+}
+
+// Releases what is still reserved for the trade by the mandate that published it, if any.
+func (t *Trade) releaseMandate() {
+	var m *Mandate
+	var reserved money.Money
+	t.execSync(func() {
+		m, reserved = t.mandate, t.mandateReserved
+		t.mandateReserved = money.Money{Currency: reserved.Currency}
+	})
+	if m != nil && reserved.Amount != 0 {
+		m.release(reserved)
+		t.manager.ReapplyMandates()
+	}
+}
@@ -0,0 +1,127 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+func newTestMandate() *Mandate {
+	return &Mandate{
+		BidType: bitwrk.Buy,
+		Article: "net.bitwrk/test",
+		Price:   money.MustParse("mBTC 1"),
+	}
+}
+
+func Test_AffordableBudget(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := newTestMandate()
+	m.UseBudget = true
+	m.Budget = money.MustParse("mBTC 3")
+	m.Spent = money.MustParse("mBTC 1")
+	m.reserved = money.MustParse("mBTC 1")
+	cost := money.MustParse("mBTC 1.03")
+
+	if !m.affordable(money.MustParse("mBTC 1"), now) {
+		t.Errorf("Expected mBTC 1 to be affordable")
+	}
+	if m.affordable(cost, now) {
+		t.Errorf("Expected %v to exceed the budget left after reservations", cost)
+	}
+	if m.expired {
+		t.Errorf("Reservations must not expire the mandate")
+	}
+
+	m.reserved = money.MustParse("mBTC 0")
+	m.Spent = money.MustParse("mBTC 2")
+	if m.affordable(cost, now) {
+		t.Errorf("Expected %v to exceed the budget", cost)
+	}
+	if !m.expired {
+		t.Errorf("Expected exhausted budget to expire the mandate")
+	}
+}
+
+func Test_AffordableDailyCap(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := newTestMandate()
+	m.UseDailyCap = true
+	m.DailyCap = money.MustParse("mBTC 2")
+	m.startDay(now)
+	m.SpentToday = money.MustParse("mBTC 1.5")
+	cost := money.MustParse("mBTC 1.03")
+
+	if m.affordable(cost, now) {
+		t.Errorf("Expected %v to exceed the daily cap", cost)
+	}
+	if m.expired {
+		t.Errorf("Reaching the daily cap must not expire the mandate")
+	}
+	if !m.affordable(cost, now.Add(24*time.Hour)) {
+		t.Errorf("Expected %v to be affordable on the next day", cost)
+	}
+	if m.SpentToday.Amount != 0 {
+		t.Errorf("Expected spending to be reset on the next day, got %v", m.SpentToday)
+	}
+}
+
+func Test_RenewEscalatesFromPublishing(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := newTestMandate()
+	m.Pricing = PricingStrategy{
+		Kind:     PricingEscalating,
+		Step:     money.MustParse("mBTC 1"),
+		Interval: time.Minute,
+		Min:      money.MustParse("mBTC 1"),
+		Max:      money.MustParse("mBTC 10"),
+	}
+	m.UseTradesLeft = true
+	m.TradesLeft = 1
+	m.UseBudget = true
+	m.Budget = money.MustParse("mBTC 5")
+
+	if p := m.currentPrice(0); p != m.Price {
+		t.Errorf("Expected new trades to be published at %v, got %v", m.Price, p)
+	}
+
+	// Replacing an expired bid doesn't count as a trade
+	previous := money.MustParse("mBTC 1.03")
+	m.reserved = previous
+	price, cost, ok := m.renew(previous, now.Add(-2*time.Minute), now, false)
+	if !ok || price != money.MustParse("mBTC 3") || cost != money.MustParse("mBTC 3.09") {
+		t.Fatalf("Unexpected renewal: %v %v %v", price, cost, ok)
+	}
+	if m.TradesLeft != 1 || m.reserved != cost {
+		t.Errorf("Unexpected state after renewal: %v trades left, %v reserved", m.TradesLeft, m.reserved)
+	}
+
+	// A new trade counts and is held back by the reservations
+	if _, _, ok := m.renew(money.MustParse("mBTC 0"), now.Add(-time.Minute), now, true); ok {
+		t.Errorf("Expected renewal to exceed the budget")
+	}
+	if _, _, ok := m.renew(cost, now.Add(-time.Minute), now, true); !ok {
+		t.Errorf("Expected renewal after releasing reservation")
+	}
+	if m.TradesLeft != 0 {
+		t.Errorf("Expected new trade to be counted, %v trades left", m.TradesLeft)
+	}
+}
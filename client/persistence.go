@@ -0,0 +1,227 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Maximum number of finished activities kept in the history
+var HistorySize = 100
+
+// Finished activities older than this are pruned from the history
+var HistoryRetention = 7 * 24 * time.Hour
+
+// How often the activity manager's state is saved even if no change was requested,
+// e.g. to capture state changes of mandates
+var saveInterval = time.Minute
+
+// Type archivedActivity is an entry of the activity history. Activities that finished
+// since the client was started are still available, while those restored from disk only
+// carry their last known state.
+type archivedActivity struct {
+	key      ActivityKey
+	live     Activity       // nil if restored from disk
+	state    *ActivityState // last known state if restored from disk
+	archived time.Time      // when the activity was moved to the history
+}
+
+func (a *archivedActivity) GetKey() ActivityKey {
+	return a.key
+}
+
+func (a *archivedActivity) GetState() *ActivityState {
+	if a.live != nil {
+		return a.live.GetState()
+	}
+	state := *a.state
+	return &state
+}
+
+func (a *archivedActivity) Publish(price money.Money) bool {
+	return false
+}
+
+// Activities in the history are no longer available for trading.
+func (a *archivedActivity) GetTrade() *Trade {
+	return nil
+}
+
+// Type ActivityRecord is how an entry of the activity history is persisted.
+type ActivityRecord struct {
+	Key      ActivityKey
+	State    *ActivityState
+	Archived time.Time
+}
+
+// Type MandateRecord is how a mandate is persisted.
+type MandateRecord struct {
+	Key     ActivityKey
+	Mandate json.RawMessage
+}
+
+// Type persistentState is the part of the activity manager's state surviving restarts.
+type persistentState struct {
+	NextKey   ActivityKey
+	Mandates  []MandateRecord
+	History   []ActivityRecord
+	Exhausted map[string]string // Definitions of expired configured mandates, by origin
+}
+
+// Sets the file the activity manager's state is persisted in, restores mandates and
+// history from it and starts saving changes in the background.
+func (m *ActivityManager) InitPersistence(stateFile string) error {
+	if err := m.restoreState(stateFile, time.Now()); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.stateFile = stateFile
+	m.mutex.Unlock()
+	go m.saveLoop()
+	return nil
+}
+
+// Asks the background saver to save the state. Doesn't block.
+func (m *ActivityManager) requestSave() {
+	select {
+	case m.saveChan <- true:
+	default:
+		// A save is already pending
+	}
+}
+
+func (m *ActivityManager) saveLoop() {
+	for {
+		select {
+		case <-m.saveChan:
+		case <-time.After(saveInterval):
+		}
+		if err := m.saveState(time.Now()); err != nil {
+			m.logger.Printf("Error saving state: %v", err)
+		}
+		// Coalesce bursts of changes
+		time.Sleep(time.Second)
+	}
+}
+
+func (m *ActivityManager) restoreState(stateFile string, now time.Time) error {
+	var state persistentState
+	if data, err := ioutil.ReadFile(stateFile); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Error parsing %v: %v", stateFile, err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if state.NextKey > m.nextKey {
+		m.nextKey = state.NextKey
+	}
+	for _, record := range state.Mandates {
+		mandate := new(Mandate)
+		if err := json.Unmarshal(record.Mandate, mandate); err != nil {
+			m.logger.Printf("Dropping unreadable mandate #%v: %v", record.Key, err)
+		} else if mandate.checkExpiry(now) {
+			m.logger.Printf("Dropping expired mandate #%v", record.Key)
+			m.dropExpiredMandate(record.Key, mandate)
+		} else {
+			m.mandates[record.Key] = mandate
+		}
+	}
+	for origin, definition := range state.Exhausted {
+		if _, ok := m.exhausted[origin]; !ok {
+			m.exhausted[origin] = definition
+		}
+	}
+	for _, record := range state.History {
+		if record.State == nil || now.Sub(record.Archived) > HistoryRetention {
+			continue
+		}
+		record.State.Alive = false
+		m.history = append(m.history, &archivedActivity{
+			key:      record.Key,
+			state:    record.State,
+			archived: record.Archived,
+		})
+	}
+	if len(m.history) > HistorySize {
+		m.history = m.history[len(m.history)-HistorySize:]
+	}
+	m.logger.Printf("Restored %v mandates and %v finished activities from %v",
+		len(m.mandates), len(m.history), stateFile)
+	return nil
+}
+
+func (m *ActivityManager) saveState(now time.Time) error {
+	var state persistentState
+	var mandates map[ActivityKey]*Mandate
+	var history []*archivedActivity
+	var stateFile string
+	m.mutex.Lock()
+	stateFile = m.stateFile
+	state.NextKey = m.nextKey
+	mandates = make(map[ActivityKey]*Mandate, len(m.mandates))
+	for k, v := range m.mandates {
+		mandates[k] = v
+	}
+	// Prune outdated entries of the history
+	for len(m.history) > 0 && now.Sub(m.history[0].(*archivedActivity).archived) > HistoryRetention {
+		m.history = m.history[1:]
+	}
+	for _, a := range m.history {
+		history = append(history, a.(*archivedActivity))
+	}
+	m.mutex.Unlock()
+
+	for key, mandate := range mandates {
+		if mandate.checkExpiry(now) {
+			m.mutex.Lock()
+			m.dropExpiredMandate(key, mandate)
+			m.mutex.Unlock()
+			continue
+		}
+		if data, err := mandate.marshal(); err != nil {
+			return err
+		} else {
+			state.Mandates = append(state.Mandates, MandateRecord{key, data})
+		}
+	}
+	state.Exhausted = m.ExhaustedMandates()
+	for _, a := range history {
+		state.History = append(state.History, ActivityRecord{a.key, a.GetState(), a.archived})
+	}
+
+	data, err := json.MarshalIndent(&state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write atomically, so that a crash can't leave a truncated file
+	tmpFile := filepath.Join(filepath.Dir(stateFile), "."+filepath.Base(stateFile)+".tmp")
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, stateFile)
+}
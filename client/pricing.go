@@ -0,0 +1,206 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+// Names of the pricing strategies a mandate can follow
+const (
+	PricingFixed      = ""         // Always use the mandate's price
+	PricingMarket     = "market"   // Follow the recent median price of the article's transactions
+	PricingEscalating = "escalate" // Start at the mandate's price, become more attractive until matched
+)
+
+// Type PricingStrategy defines how a mandate determines the price of the trades it publishes.
+// Except for PricingFixed, prices are kept within Min and Max.
+type PricingStrategy struct {
+	Kind string
+	// PricingMarket: Percentage added to the median price. Negative values undercut
+	// the market.
+	Offset int
+	// PricingMarket: Period the median is computed over, in the notation of the server's
+	// price query, e.g. "1h". Empty means "1h".
+	Period string
+	// PricingEscalating: Buys raise, sells lower their price by Step for every Interval
+	// that passes between a trade being published and being matched.
+	Step     money.Money
+	Interval time.Duration
+	// Bounds of the price
+	Min, Max money.Money
+}
+
+// Checks the strategy for consistency.
+func (s PricingStrategy) Validate() error {
+	switch s.Kind {
+	case PricingFixed:
+		return nil
+	case PricingMarket:
+		if s.Offset <= -100 {
+			return fmt.Errorf("Offset must be greater than -100%%, but is: %v%%", s.Offset)
+		}
+		if _, ok := priceResolutions[s.period()]; !ok {
+			return fmt.Errorf("Unsupported period: %#v", s.Period)
+		}
+	case PricingEscalating:
+		if s.Step.Amount <= 0 || s.Interval <= 0 {
+			return fmt.Errorf("Escalating prices need a positive step and interval")
+		}
+	default:
+		return fmt.Errorf("Unknown pricing strategy: %#v", s.Kind)
+	}
+	if s.Min.Amount < 0 || s.Max.Amount <= 0 || s.Min.Amount > s.Max.Amount {
+		return fmt.Errorf("Illegal price bounds: %v - %v", s.Min, s.Max)
+	}
+	return nil
+}
+
+func (s PricingStrategy) period() string {
+	if s.Period == "" {
+		return "1h"
+	}
+	return s.Period
+}
+
+func (s PricingStrategy) String() string {
+	switch s.Kind {
+	case PricingMarket:
+		return fmt.Sprintf("market median (%v) %+d%% within %v - %v", s.period(), s.Offset, s.Min, s.Max)
+	case PricingEscalating:
+		return fmt.Sprintf("escalating by %v every %v within %v - %v", s.Step, s.Interval, s.Min, s.Max)
+	default:
+		return "fixed"
+	}
+}
+
+// Computes the price to publish at, given the mandate's base price, the current market
+// price (may be nil if unknown) and the time the trade has been unmatched since publishing.
+func (s PricingStrategy) price(bidType bitwrk.BidType, base money.Money, market *money.Money, unmatched time.Duration) money.Money {
+	result := base
+	switch s.Kind {
+	case PricingFixed:
+		return base
+	case PricingMarket:
+		if market != nil {
+			result.Amount = market.Amount * int64(100+s.Offset) / 100
+		}
+	case PricingEscalating:
+		steps := int64(unmatched / s.Interval)
+		if bidType == bitwrk.Buy {
+			result.Amount += steps * s.Step.Amount
+		} else {
+			result.Amount -= steps * s.Step.Amount
+		}
+	}
+	if result.Amount < s.Min.Amount {
+		result.Amount = s.Min.Amount
+	}
+	if result.Amount > s.Max.Amount {
+		result.Amount = s.Max.Amount
+	}
+	return result
+}
+
+// Resolutions of the price statistics used for computing medians over a period
+var priceResolutions = map[string]string{
+	"12m": "30s",
+	"1h":  "3m",
+	"6h":  "3m",
+	"1d":  "12m",
+	"1w":  "1h",
+}
+
+// Function MarketMedian queries the server for the median price of an article's
+// transactions within the given period. Returns nil if there were no transactions.
+func MarketMedian(article bitwrk.ArticleId, period string) (*money.Money, error) {
+	resolution, ok := priceResolutions[period]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported period: %#v", period)
+	}
+	slots, err := protocol.FetchPrices(article, period, resolution)
+	if err != nil {
+		return nil, err
+	}
+	return medianOfSlots(slots), nil
+}
+
+// Returns the median of the slots' average prices, weighted by the number of transactions.
+func medianOfSlots(slots []protocol.PriceSlot) *money.Money {
+	type weighted struct {
+		price money.Money
+		count int
+	}
+	prices := make([]weighted, 0, len(slots))
+	total := 0
+	for _, slot := range slots {
+		if slot.Count <= 0 {
+			continue
+		}
+		avg := money.Money{Currency: slot.Sum.Currency, Amount: slot.Sum.Amount / int64(slot.Count)}
+		prices = append(prices, weighted{avg, slot.Count})
+		total += slot.Count
+	}
+	if total == 0 {
+		return nil
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i].price.Amount < prices[j].price.Amount })
+	seen := 0
+	for _, p := range prices {
+		seen += p.count
+		if 2*seen >= total {
+			result := p.price
+			return &result
+		}
+	}
+	panic("not reached")
+}
+
+// Updates the market prices of mandates following the market. Each article's median is
+// queried only once.
+func (m *ActivityManager) UpdateMandatePrices() {
+	type query struct {
+		article bitwrk.ArticleId
+		period  string
+	}
+	medians := make(map[query]*money.Money)
+	for _, mandate := range m.GetMandates() {
+		article, strategy := mandate.pricing()
+		if strategy.Kind != PricingMarket {
+			continue
+		}
+		q := query{article, strategy.period()}
+		median, ok := medians[q]
+		if !ok {
+			if p, err := MarketMedian(q.article, q.period); err != nil {
+				m.logger.Printf("Error querying market price of %v: %v", q.article, err)
+				continue
+			} else {
+				median = p
+				medians[q] = p
+			}
+		}
+		mandate.setMarketPrice(median)
+	}
+}
@@ -0,0 +1,114 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/common/protocol"
+)
+
+func Test_PriceFixed(t *testing.T) {
+	s := PricingStrategy{}
+	base := money.MustParse("mBTC 1")
+	market := money.MustParse("mBTC 5")
+	if p := s.price(bitwrk.Buy, base, &market, time.Hour); p != base {
+		t.Errorf("Fixed pricing should keep base price, got %v", p)
+	}
+}
+
+func Test_PriceMarket(t *testing.T) {
+	s := PricingStrategy{
+		Kind:   PricingMarket,
+		Offset: 10,
+		Min:    money.MustParse("mBTC 1"),
+		Max:    money.MustParse("mBTC 10"),
+	}
+	base := money.MustParse("mBTC 2")
+	for _, c := range []struct {
+		market   string
+		expected string
+	}{
+		{"mBTC 4", "mBTC 4.4"},
+		{"mBTC 0.5", "mBTC 1"},
+		{"mBTC 20", "mBTC 10"},
+	} {
+		market := money.MustParse(c.market)
+		if p := s.price(bitwrk.Buy, base, &market, 0); p != money.MustParse(c.expected) {
+			t.Errorf("Market price %v: expected %v, got %v", c.market, c.expected, p)
+		}
+	}
+	if p := s.price(bitwrk.Buy, base, nil, 0); p != base {
+		t.Errorf("Unknown market price should result in base price, got %v", p)
+	}
+}
+
+func Test_PriceEscalating(t *testing.T) {
+	s := PricingStrategy{
+		Kind:     PricingEscalating,
+		Step:     money.MustParse("mBTC 1"),
+		Interval: time.Minute,
+		Min:      money.MustParse("mBTC 1"),
+		Max:      money.MustParse("mBTC 5"),
+	}
+	base := money.MustParse("mBTC 3")
+	for _, c := range []struct {
+		bidType   bitwrk.BidType
+		unmatched time.Duration
+		expected  string
+	}{
+		{bitwrk.Buy, 0, "mBTC 3"},
+		{bitwrk.Buy, 59 * time.Second, "mBTC 3"},
+		{bitwrk.Buy, time.Minute, "mBTC 4"},
+		{bitwrk.Buy, time.Hour, "mBTC 5"},
+		{bitwrk.Sell, 2 * time.Minute, "mBTC 1"},
+		{bitwrk.Sell, time.Hour, "mBTC 1"},
+	} {
+		if p := s.price(c.bidType, base, nil, c.unmatched); p != money.MustParse(c.expected) {
+			t.Errorf("%v unmatched for %v: expected %v, got %v", c.bidType, c.unmatched, c.expected, p)
+		}
+	}
+}
+
+func Test_MedianOfSlots(t *testing.T) {
+	slot := func(sum string, count int) protocol.PriceSlot {
+		return protocol.PriceSlot{Sum: money.MustParse(sum), Count: count}
+	}
+	if m := medianOfSlots(nil); m != nil {
+		t.Errorf("Expected no median without slots, got %v", *m)
+	}
+	if m := medianOfSlots([]protocol.PriceSlot{slot("mBTC 0", 0)}); m != nil {
+		t.Errorf("Expected no median without transactions, got %v", *m)
+	}
+	for _, c := range []struct {
+		slots    []protocol.PriceSlot
+		expected string
+	}{
+		{[]protocol.PriceSlot{slot("mBTC 6", 3)}, "mBTC 2"},
+		{[]protocol.PriceSlot{slot("mBTC 9", 1), slot("mBTC 0", 0), slot("mBTC 1", 1)}, "mBTC 1"},
+		// Weighted by count: three transactions at 1, one at 9
+		{[]protocol.PriceSlot{slot("mBTC 9", 1), slot("mBTC 3", 3)}, "mBTC 1"},
+		{[]protocol.PriceSlot{slot("mBTC 5", 1), slot("mBTC 27", 3), slot("mBTC 1", 1)}, "mBTC 9"},
+	} {
+		if m := medianOfSlots(c.slots); m == nil || *m != money.MustParse(c.expected) {
+			t.Errorf("Expected median %v of %v, got %v", c.expected, c.slots, m)
+		}
+	}
+}
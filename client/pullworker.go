@@ -0,0 +1,254 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrNoSuchJob = errors.New("No such job")
+var ErrWorkerNotPolling = errors.New("Worker didn't fetch work in time")
+var ErrJobAbandoned = errors.New("Job was abandoned")
+
+// How long work is offered to a pulling worker before giving up.
+var pullFetchTimeout = 30 * time.Second
+
+// Type pullQueue connects sells to a worker using WorkerMethodPull. Work is handed over
+// on an unbuffered channel, so it is only accepted while the worker is polling.
+// Jobs the worker has fetched are kept as pending until it reports back.
+type pullQueue struct {
+	jobs      chan *pullJob
+	mutex     sync.Mutex
+	pending   map[int64]*pullJob
+	nextId    int64
+	gone      chan struct{} // closed when the worker is unregistered
+	closeOnce sync.Once
+}
+
+type pullJob struct {
+	id        int64
+	slot      int // the worker slot kept busy by the job
+	work      io.Reader
+	outcome   chan pullOutcome // receives exactly one outcome
+	abandoned chan struct{}    // closed when nobody waits for the outcome anymore
+}
+
+type pullOutcome struct {
+	result io.ReadCloser
+	err    error
+}
+
+// Type pullResult wraps the body of a result request. Closing it tells the request
+// handler that the result has been consumed.
+type pullResult struct {
+	io.Reader
+	done chan struct{}
+	once sync.Once
+}
+
+func (r *pullResult) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return nil
+}
+
+func newPullQueue() *pullQueue {
+	return &pullQueue{
+		jobs:    make(chan *pullJob),
+		pending: make(map[int64]*pullJob),
+		gone:    make(chan struct{}),
+	}
+}
+
+func (q *pullQueue) close() {
+	q.closeOnce.Do(func() { close(q.gone) })
+}
+
+// Registers a fetched job as pending and assigns an id to it.
+func (q *pullQueue) add(job *pullJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.nextId++
+	job.id = q.nextId
+	q.pending[job.id] = job
+}
+
+// Removes a pending job. The caller is responsible for delivering its outcome.
+func (q *pullQueue) take(jobId int64) (*pullJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if job, ok := q.pending[jobId]; !ok {
+		return nil, ErrNoSuchJob
+	} else {
+		delete(q.pending, jobId)
+		return job, nil
+	}
+}
+
+// Removes and returns the oldest pending job if there are at least max pending jobs.
+func (q *pullQueue) takeStale(max int) *pullJob {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.pending) < max {
+		return nil
+	}
+	var oldest *pullJob
+	for _, job := range q.pending {
+		if oldest == nil || job.id < oldest.id {
+			oldest = job
+		}
+	}
+	delete(q.pending, oldest.id)
+	return oldest
+}
+
+// Offers work to a pulling worker and waits until it reports back.
+// Assumes that the slot has already been marked busy.
+func (s *WorkerState) doPullWork(ctx context.Context, slot int, workReader io.Reader) (io.ReadCloser, error) {
+	q := s.pull
+	job := &pullJob{
+		slot:      slot,
+		work:      workReader,
+		outcome:   make(chan pullOutcome, 1),
+		abandoned: make(chan struct{}),
+	}
+
+	timer := time.NewTimer(pullFetchTimeout)
+	defer timer.Stop()
+	select {
+	case q.jobs <- job:
+	case <-timer.C:
+		s.setBusy(slot, false)
+		return nil, ErrWorkerNotPolling
+	case <-ctx.Done():
+		s.setBusy(slot, false)
+		return nil, ctx.Err()
+	case <-q.gone:
+		s.setBusy(slot, false)
+		return nil, ErrNoSuchWorker
+	}
+
+	// From now on, the slot is busy until the worker reports back on the job, or until
+	// the job is considered stale.
+	select {
+	case o := <-job.outcome:
+		return o.result, o.err
+	case <-ctx.Done():
+		close(job.abandoned)
+		return nil, ctx.Err()
+	case <-q.gone:
+		close(job.abandoned)
+		return nil, ErrNoSuchWorker
+	}
+}
+
+// Looks up a worker using WorkerMethodPull.
+func (m *WorkerManager) pullWorker(id string) (*WorkerState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s, ok := m.workers[id]; !ok {
+		return nil, ErrNoSuchWorker
+	} else if s.pull == nil {
+		return nil, fmt.Errorf("Worker %#v doesn't use method %v", id, WorkerMethodPull)
+	} else {
+		return s, nil
+	}
+}
+
+// Called by a worker using WorkerMethodPull for fetching the next work item. Waits for
+// at most the given duration. On timeout, the returned reader is nil.
+// Polling implies that the worker has a free slot. If all of its slots are taken by jobs
+// it hasn't reported back on, the oldest of them is failed.
+// The wait is cut short if the worker's lease would otherwise expire.
+func (m *WorkerManager) FetchWork(ctx context.Context, id string, wait time.Duration) (int64, io.Reader, error) {
+	s, err := m.pullWorker(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	s.touch()
+	// Make sure the worker comes back in time to keep its lease
+	s.cond.L.Lock()
+	lease := s.Lease
+	s.cond.L.Unlock()
+	if lease > 0 && wait > lease/2 {
+		wait = lease / 2
+	}
+	if job := s.pull.takeStale(len(s.Slots)); job != nil {
+		job.outcome <- pullOutcome{err: fmt.Errorf("Worker %#v fetched new work without reporting back", id)}
+		s.setBusy(job.slot, false)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case job := <-s.pull.jobs:
+		s.pull.add(job)
+		return job.id, job.work, nil
+	case <-timer.C:
+		return 0, nil, nil
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case <-s.pull.gone:
+		return 0, nil, ErrNoSuchWorker
+	}
+}
+
+// Called by a worker using WorkerMethodPull for delivering the result of a job.
+// Returns after the result has been consumed.
+func (m *WorkerManager) SubmitResult(ctx context.Context, id string, jobId int64, result io.Reader) error {
+	s, err := m.pullWorker(id)
+	if err != nil {
+		return err
+	}
+	s.touch()
+	job, err := s.pull.take(jobId)
+	if err != nil {
+		return err
+	}
+	defer s.setBusy(job.slot, false)
+
+	r := &pullResult{Reader: result, done: make(chan struct{})}
+	job.outcome <- pullOutcome{result: r}
+	select {
+	case <-r.done:
+		return nil
+	case <-job.abandoned:
+		return ErrJobAbandoned
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Called by a worker using WorkerMethodPull if it couldn't complete a job.
+func (m *WorkerManager) ReportError(id string, jobId int64, message string) error {
+	s, err := m.pullWorker(id)
+	if err != nil {
+		return err
+	}
+	s.touch()
+	if job, err := s.pull.take(jobId); err != nil {
+		return err
+	} else {
+		job.outcome <- pullOutcome{err: fmt.Errorf("Worker reported error: %v", message)}
+		s.setBusy(job.slot, false)
+		return nil
+	}
+}
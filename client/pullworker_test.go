@@ -0,0 +1,175 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newPullWorker(slots int) (*WorkerManager, *WorkerState) {
+	m := &WorkerManager{
+		workers:         make(map[string]*WorkerState),
+		activityManager: newTestActivityManager(),
+	}
+	s := &WorkerState{
+		m:     m,
+		cond:  sync.NewCond(new(sync.Mutex)),
+		Info:  WorkerInfo{Id: "test", Method: WorkerMethodPull, Capacity: slots},
+		Slots: make([]WorkerSlot, slots),
+		pull:  newPullQueue(),
+		gone:  make(chan struct{}),
+	}
+	m.workers[s.Info.Id] = s
+	return m, s
+}
+
+type pullWorkResult struct {
+	result io.ReadCloser
+	err    error
+}
+
+// Dispatches work to the worker in the background, like a sell does.
+func startPullWork(ctx context.Context, s *WorkerState, slot int, work string) <-chan pullWorkResult {
+	done := make(chan pullWorkResult, 1)
+	go func() {
+		result, err := s.doWork(ctx, slot, strings.NewReader(work), nil)
+		done <- pullWorkResult{result, err}
+	}()
+	return done
+}
+
+func awaitPullWork(t *testing.T, done <-chan pullWorkResult) pullWorkResult {
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Work wasn't finished")
+		return pullWorkResult{}
+	}
+}
+
+// Fetches work as the worker would, expecting to get some.
+func fetchPullWork(t *testing.T, m *WorkerManager, expected string) int64 {
+	id, work, err := m.FetchWork(context.Background(), "test", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if work == nil {
+		t.Fatal("Expected to fetch work")
+	}
+	if data, err := ioutil.ReadAll(work); err != nil {
+		t.Fatal(err)
+	} else if string(data) != expected {
+		t.Errorf("Expected work %#v, got %#v", expected, string(data))
+	}
+	return id
+}
+
+func expectIdle(t *testing.T, s *WorkerState, slot int) {
+	if s.snapshot().Slots[slot].Busy {
+		t.Errorf("Expected slot %v to be idle", slot)
+	}
+}
+
+func Test_PullWorkerSubmit(t *testing.T) {
+	m, s := newPullWorker(1)
+	done := startPullWork(context.Background(), s, 0, "work")
+	id := fetchPullWork(t, m, "work")
+
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- m.SubmitResult(context.Background(), "test", id, strings.NewReader("result"))
+	}()
+	r := awaitPullWork(t, done)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if data, err := ioutil.ReadAll(r.result); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "result" {
+		t.Errorf("Expected result %#v, got %#v", "result", string(data))
+	}
+	r.result.Close()
+	if err := <-submitted; err != nil {
+		t.Errorf("Expected result to be consumed, got: %v", err)
+	}
+	expectIdle(t, s, 0)
+
+	if err := m.SubmitResult(context.Background(), "test", id, strings.NewReader("again")); err != ErrNoSuchJob {
+		t.Errorf("Expected %v on submitting twice, got: %v", ErrNoSuchJob, err)
+	}
+}
+
+func Test_PullWorkerReportError(t *testing.T) {
+	m, s := newPullWorker(1)
+	done := startPullWork(context.Background(), s, 0, "work")
+	id := fetchPullWork(t, m, "work")
+
+	if err := m.ReportError("test", id, "out of memory"); err != nil {
+		t.Fatal(err)
+	}
+	if r := awaitPullWork(t, done); r.err == nil {
+		r.result.Close()
+		t.Errorf("Expected reported error to fail the work")
+	} else if !strings.Contains(r.err.Error(), "out of memory") {
+		t.Errorf("Expected reported error, got: %v", r.err)
+	}
+	expectIdle(t, s, 0)
+}
+
+func Test_PullWorkerAbandoned(t *testing.T) {
+	m, s := newPullWorker(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startPullWork(ctx, s, 0, "work")
+	id := fetchPullWork(t, m, "work")
+
+	// The sell gives up after the worker has fetched the work
+	cancel()
+	if r := awaitPullWork(t, done); r.err != context.Canceled {
+		t.Errorf("Expected %v, got: %v", context.Canceled, r.err)
+	}
+	if err := m.SubmitResult(context.Background(), "test", id, strings.NewReader("result")); err != ErrJobAbandoned {
+		t.Errorf("Expected %v, got: %v", ErrJobAbandoned, err)
+	}
+	expectIdle(t, s, 0)
+}
+
+func Test_PullWorkerStaleJob(t *testing.T) {
+	m, s := newPullWorker(1)
+	done := startPullWork(context.Background(), s, 0, "work")
+	id := fetchPullWork(t, m, "work")
+
+	// Polling again with all slots pending means that the worker has lost the job
+	if _, work, err := m.FetchWork(context.Background(), "test", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	} else if work != nil {
+		t.Errorf("Expected no work to be available")
+	}
+	if r := awaitPullWork(t, done); r.err == nil {
+		r.result.Close()
+		t.Errorf("Expected stale job to fail")
+	}
+	expectIdle(t, s, 0)
+	if err := m.SubmitResult(context.Background(), "test", id, strings.NewReader("result")); err != ErrNoSuchJob {
+		t.Errorf("Expected %v for stale job, got: %v", ErrNoSuchJob, err)
+	}
+}
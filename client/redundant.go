@@ -0,0 +1,431 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/cafs"
+)
+
+// Type RedundancyPolicy defines how redundant buys deal with results that don't match.
+type RedundancyPolicy struct {
+	// Whether a tie-breaking buy is placed with a third seller when the first two results
+	// differ, or when one of the first two buys fails. Otherwise, all results are rejected.
+	Escalate bool
+}
+
+var ErrResultMismatch = errors.New("Result doesn't match the results of other sellers")
+
+// The number of identical results required for a redundant buy to succeed
+const redundantMatches = 2
+
+// The maximum number of buys placed by a redundant buy, including the tie-breaker
+const redundantMaxBuys = 3
+
+// How long before its transaction times out a member gives up waiting for the other
+// members' results. A transaction timing out in phase UNVERIFIED pays the seller, so the
+// member must reject its result before.
+const crossCheckMargin = time.Minute
+
+// Type RedundantBuy sends the same work to two independent sellers and compares the
+// results before accepting them. Each of its buys is an ordinary BuyActivity, which
+// withholds its acceptance until the results of the other buys are known.
+type RedundantBuy struct {
+	manager  *ActivityManager
+	key      ActivityKey
+	article  bitwrk.ArticleId
+	identity *bitcoin.KeyPair
+	price    *money.Money
+	policy   RedundancyPolicy
+
+	condition *sync.Cond
+	alive     bool
+	members   []*BuyActivity
+	// Result keys reported by members, nil for members that failed
+	outcomes  map[ActivityKey]*cafs.SKey
+	sellers   sellerClaims
+	escalated bool
+	decided   bool
+	winner    *cafs.SKey
+	lastError error
+
+	// Information stored on PerformBuy(...), for starting members
+	ctx        context.Context
+	log        bitwrk.Logger
+	workFile   cafs.File
+	running    sync.WaitGroup
+	resultFile cafs.File
+}
+
+var redundantArticlesMutex sync.Mutex
+var redundantArticles = make(map[bitwrk.ArticleId]bool)
+
+// Function SetRedundantArticle configures whether buys of an article are to be
+// executed redundantly.
+func SetRedundantArticle(article bitwrk.ArticleId, redundant bool) {
+	redundantArticlesMutex.Lock()
+	defer redundantArticlesMutex.Unlock()
+	if redundant {
+		redundantArticles[article] = true
+	} else {
+		delete(redundantArticles, article)
+	}
+}
+
+// Function IsRedundantArticle returns whether buys of an article are to be executed
+// redundantly.
+func IsRedundantArticle(article bitwrk.ArticleId) bool {
+	redundantArticlesMutex.Lock()
+	defer redundantArticlesMutex.Unlock()
+	return redundantArticles[article]
+}
+
+// Creates a redundant buy and the first two of its buys. If price is nil, each buy
+// awaits being published by the user or a mandate.
+func (m *ActivityManager) NewRedundantBuy(article bitwrk.ArticleId, identity *bitcoin.KeyPair, price *money.Money) (*RedundantBuy, error) {
+	result := &RedundantBuy{
+		manager:   m,
+		key:       m.NewKey(),
+		article:   article,
+		identity:  identity,
+		price:     price,
+		policy:    CurrentSettings().Redundancy,
+		condition: sync.NewCond(new(sync.Mutex)),
+		alive:     true,
+		outcomes:  make(map[ActivityKey]*cafs.SKey),
+	}
+	for i := 0; i < redundantMatches; i++ {
+		if _, err := result.newMember(); err != nil {
+			result.Dispose()
+			return nil, err
+		}
+	}
+	m.register(result.key, result, true)
+	return result, nil
+}
+
+// Creates another buy on behalf of the redundant buy.
+func (b *RedundantBuy) newMember() (*BuyActivity, error) {
+	member, err := b.manager.NewBuy(b.article, b.identity, b.price)
+	if err != nil {
+		return nil, err
+	}
+	member.execSync(func() {
+		member.redundancy = b
+		member.sellers = &b.sellers
+	})
+	b.execSync(func() { b.members = append(b.members, member) })
+	return member, nil
+}
+
+// Executes a short function that modifies the redundant buy's internal state.
+// Then broadcasts a signal to condition listeners.
+func (b *RedundantBuy) execSync(f func()) {
+	b.condition.L.Lock()
+	defer b.condition.L.Unlock()
+	f()
+	b.condition.Broadcast()
+}
+
+// Performs all buys of the redundant buy and waits for them to finish.
+// On success, returns a cafs.File to the result data confirmed by at least two sellers.
+func (b *RedundantBuy) PerformBuy(ctx context.Context, log bitwrk.Logger, workFile cafs.File) (cafs.File, error) {
+	log.Printf("Redundant buy started")
+	var members []*BuyActivity
+	b.execSync(func() {
+		b.ctx, b.log, b.workFile = ctx, log, workFile.Duplicate()
+		members = append(members, b.members...)
+	})
+	defer b.execSync(func() {
+		b.alive = false
+		log.Printf("Redundant buy finished")
+	})
+
+	for _, member := range members {
+		b.start(member)
+	}
+	b.running.Wait()
+
+	file, err := b.collectResult()
+	if err != nil {
+		b.execSync(func() { b.lastError = err })
+	}
+	return file, err
+}
+
+// Performs a member buy in the background.
+func (b *RedundantBuy) start(member *BuyActivity) {
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		if _, err := member.PerformBuy(b.ctx, b.log.Newf("Buy #%v", member.GetKey()), b.workFile); err != nil {
+			b.log.Printf("Buy #%v failed: %v", member.GetKey(), err)
+		}
+	}()
+}
+
+// Returns a duplicate of the result confirmed by the majority of sellers.
+func (b *RedundantBuy) collectResult() (cafs.File, error) {
+	var winner *cafs.SKey
+	var members []*BuyActivity
+	b.execSync(func() {
+		winner = b.winner
+		members = append(members, b.members...)
+	})
+	if winner == nil {
+		return nil, ErrResultMismatch
+	}
+	for _, member := range members {
+		var file cafs.File
+		member.execSync(func() {
+			if member.resultFile != nil && member.resultFile.Key() == *winner {
+				file = member.resultFile.Duplicate()
+			}
+		})
+		if file != nil {
+			b.execSync(func() { b.resultFile = file })
+			return file, nil
+		}
+	}
+	return nil, fmt.Errorf("Result %v is no longer available", *winner)
+}
+
+// Reports the member's result and waits until the results of the other members allow
+// a decision. Returns true if the result is confirmed by enough other sellers and may
+// be accepted. If no decision has been reached shortly before the member's transaction
+// times out, the result is rejected.
+func (b *RedundantBuy) crossCheck(ctx context.Context, log bitwrk.Logger, member *BuyActivity, result cafs.File) bool {
+	key := result.Key()
+	b.report(member, &key)
+	log.Printf("Cross-checking result %v", key)
+
+	// Local buys have no transaction of their own and wait without deadline
+	var deadline <-chan time.Time
+	var timeout time.Time
+	member.execSync(func() {
+		if member.tx != nil {
+			timeout = member.tx.Timeout
+		}
+	})
+	if !timeout.IsZero() {
+		timer := time.NewTimer(time.Until(timeout.Add(-crossCheckMargin)))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	expired := false
+
+	exit := make(chan bool)
+	defer close(exit)
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.execSync(func() {})
+		case <-deadline:
+			b.execSync(func() { expired = true })
+		case <-exit:
+		}
+	}()
+
+	var accept bool
+	var confirmations int
+	b.condition.L.Lock()
+	for !b.decided && ctx.Err() == nil && !expired {
+		b.condition.Wait()
+	}
+	if !b.decided && expired {
+		log.Printf("No decision reached before transaction times out")
+	}
+	accept = b.decided && b.winner != nil && *b.winner == key
+	confirmations = b.countOutcomes(key)
+	b.condition.L.Unlock()
+
+	log.Printf("Cross-check of result %v: accepted=%v", key, accept)
+	member.execSync(func() {
+		reason := ErrResultMismatch.Error()
+		member.verdict = VerdictRejected
+		if accept {
+			reason = fmt.Sprintf("Confirmed by %v sellers", confirmations)
+			member.verdict = VerdictAccepted
+		}
+		if member.verdictReason != "" {
+			reason = member.verdictReason + "; " + reason
+		}
+		member.verdictReason = reason
+	})
+	return accept
+}
+
+// Reports that the member has ended without a result, unless it has reported a result
+// already.
+func (b *RedundantBuy) reportFailure(member *BuyActivity) {
+	b.report(member, nil)
+}
+
+// Records the outcome of a member and tries to come to a decision. Places a
+// tie-breaking buy if the policy allows and the results so far are inconclusive.
+func (b *RedundantBuy) report(member *BuyActivity, key *cafs.SKey) {
+	escalate := false
+	b.execSync(func() {
+		if _, ok := b.outcomes[member.GetKey()]; ok {
+			return
+		}
+		b.outcomes[member.GetKey()] = key
+		escalate = b.decide()
+	})
+	if !escalate {
+		return
+	}
+
+	b.log.Printf("Results are inconclusive, placing a tie-breaking buy")
+	if tieBreaker, err := b.newMember(); err != nil {
+		b.log.Printf("Error creating tie-breaking buy: %v", err)
+		b.execSync(func() { b.decided = true })
+	} else {
+		b.start(tieBreaker)
+	}
+}
+
+// Decides on the outcome of the redundant buy, if possible.
+// Returns true if a tie-breaking buy is to be placed.
+// Must be called with the lock held.
+func (b *RedundantBuy) decide() bool {
+	if b.decided {
+		return false
+	}
+
+	counts := make(map[cafs.SKey]int)
+	for _, key := range b.outcomes {
+		if key != nil {
+			counts[*key]++
+		}
+	}
+	for key, count := range counts {
+		if count >= redundantMatches {
+			winner := key
+			b.winner = &winner
+			b.decided = true
+			return false
+		}
+	}
+
+	if len(b.outcomes) < len(b.members) {
+		return false // Still waiting for outcomes
+	}
+	if b.policy.Escalate && !b.escalated && len(b.members) < redundantMaxBuys {
+		b.escalated = true
+		return true
+	}
+	b.decided = true
+	return false
+}
+
+// Implement Activity
+func (b *RedundantBuy) GetKey() ActivityKey {
+	return b.key
+}
+
+func (b *RedundantBuy) GetState() *ActivityState {
+	var members []*BuyActivity
+	var info string
+	var alive bool
+	b.execSync(func() {
+		members = append(members, b.members...)
+		alive = b.alive
+		if b.lastError != nil {
+			info = b.lastError.Error()
+		} else if b.decided && b.winner != nil {
+			info = fmt.Sprintf("Result confirmed by %v of %v sellers", b.countOutcomes(*b.winner), len(b.members))
+		} else {
+			info = fmt.Sprintf("Awaiting %v matching results", redundantMatches)
+		}
+	})
+
+	// The cost of a redundant buy is the sum of the cost of its buys
+	cost := money.Money{Currency: money.BTC}
+	phase := "REDUNDANT"
+	for i, member := range members {
+		state := member.GetState()
+		cost = cost.Add(state.Amount)
+		if i == 0 {
+			phase += " ("
+		} else {
+			phase += ", "
+		}
+		phase += fmt.Sprintf("#%v", member.GetKey())
+	}
+	if len(members) > 0 {
+		phase += ")"
+	}
+
+	return &ActivityState{
+		Type:     bitwrk.Buy.String(),
+		Article:  b.article,
+		Alive:    alive,
+		Accepted: true,
+		Amount:   cost,
+		Info:     info,
+		Phase:    phase,
+	}
+}
+
+// Returns how many members reported the given result.
+// Must be called with the lock held.
+func (b *RedundantBuy) countOutcomes(key cafs.SKey) int {
+	count := 0
+	for _, k := range b.outcomes {
+		if k != nil && *k == key {
+			count++
+		}
+	}
+	return count
+}
+
+// Redundant buys are published through their buys.
+func (b *RedundantBuy) Publish(price money.Money) bool {
+	return false
+}
+
+func (b *RedundantBuy) GetTrade() *Trade {
+	return nil
+}
+
+func (b *RedundantBuy) Dispose() {
+	b.manager.unregister(b.key)
+	var members []*BuyActivity
+	var files []cafs.File
+	b.execSync(func() {
+		members = b.members
+		files = []cafs.File{b.workFile, b.resultFile}
+		b.members, b.workFile, b.resultFile = nil, nil, nil
+	})
+	for _, member := range members {
+		member.Dispose()
+	}
+	for _, f := range files {
+		if f != nil {
+			f.Dispose()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/cafs"
+)
+
+func newTestRedundantBuy(escalate bool) *RedundantBuy {
+	return &RedundantBuy{
+		manager:   newTestActivityManager(),
+		policy:    RedundancyPolicy{Escalate: escalate},
+		condition: sync.NewCond(new(sync.Mutex)),
+		alive:     true,
+		outcomes:  make(map[ActivityKey]*cafs.SKey),
+		log:       bitwrk.Root().New("Redundant buy"),
+	}
+}
+
+func newTestMember(key ActivityKey) *BuyActivity {
+	return &BuyActivity{Trade: Trade{
+		condition: sync.NewCond(new(sync.Mutex)),
+		key:       key,
+		bidType:   bitwrk.Buy,
+		alive:     true,
+	}}
+}
+
+func Test_RedundantDecide(t *testing.T) {
+	a, b, c := &cafs.SKey{1}, &cafs.SKey{2}, &cafs.SKey{3}
+	tests := []struct {
+		name     string
+		escalate bool
+		outcomes []*cafs.SKey // Reported by the members in turn, nil for failures
+		// Index of the outcome after which a tie-breaker is requested, -1 for none
+		tieBreakerAfter int
+		decided         bool
+		winner          *cafs.SKey
+	}{
+		{"matching results", true, []*cafs.SKey{a, a}, -1, true, a},
+		{"awaiting second result", true, []*cafs.SKey{a}, -1, false, nil},
+		{"mismatch with escalation", true, []*cafs.SKey{a, b, b}, 1, true, b},
+		{"mismatch without escalation", false, []*cafs.SKey{a, b}, -1, true, nil},
+		{"failed member and tie-breaker", true, []*cafs.SKey{nil, a, a}, 1, true, a},
+		{"failed member without escalation", false, []*cafs.SKey{a, nil}, -1, true, nil},
+		{"tie-breaker disagrees", true, []*cafs.SKey{a, b, c}, 1, true, nil},
+		{"tie-breaker fails", true, []*cafs.SKey{a, b, nil}, 1, true, nil},
+	}
+	for _, test := range tests {
+		rb := newTestRedundantBuy(test.escalate)
+		for i := 0; i < redundantMatches; i++ {
+			rb.members = append(rb.members, newTestMember(ActivityKey(i+1)))
+		}
+		for i, outcome := range test.outcomes {
+			rb.condition.L.Lock()
+			rb.outcomes[rb.members[i].GetKey()] = outcome
+			tieBreaker := rb.decide()
+			rb.condition.L.Unlock()
+			if tieBreaker != (i == test.tieBreakerAfter) {
+				t.Errorf("%v: outcome #%v: tie-breaker requested: %v", test.name, i, tieBreaker)
+			}
+			if tieBreaker {
+				rb.members = append(rb.members, newTestMember(ActivityKey(len(rb.members)+1)))
+			}
+		}
+		if rb.decided != test.decided {
+			t.Errorf("%v: expected decided=%v, got %v", test.name, test.decided, rb.decided)
+		}
+		if (rb.winner == nil) != (test.winner == nil) || rb.winner != nil && *rb.winner != *test.winner {
+			t.Errorf("%v: expected winner %v, got %v", test.name, test.winner, rb.winner)
+		}
+		// Once decided, further outcomes don't change the decision
+		if rb.decided {
+			rb.outcomes[ActivityKey(100)] = c
+			if rb.decide() {
+				t.Errorf("%v: tie-breaker requested after decision", test.name)
+			}
+		}
+	}
+}
+
+func Test_RedundantCrossCheckDeadline(t *testing.T) {
+	rb := newTestRedundantBuy(true)
+	first, second := newTestMember(1), newTestMember(2)
+	rb.members = []*BuyActivity{first, second}
+	// The transaction times out shortly after the cross-check margin
+	first.tx = &bitwrk.Transaction{Timeout: time.Now().Add(crossCheckMargin + 50*time.Millisecond)}
+
+	storage := newTestActivityManager().GetStorage()
+	result := newTestWork(t, storage, "result")
+	defer result.Dispose()
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- rb.crossCheck(context.Background(), bitwrk.Root().New("Buy #1"), first, result)
+	}()
+	select {
+	case accepted := <-done:
+		if accepted {
+			t.Errorf("Expected result to be rejected without a decision")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cross-check didn't give up at the deadline")
+	}
+	if rb.decided {
+		t.Errorf("Expected no decision with one outcome missing")
+	}
+	if first.verdict != VerdictRejected {
+		t.Errorf("Expected verdict %v, got %#v", VerdictRejected, first.verdict)
+	}
+}
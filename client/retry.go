@@ -0,0 +1,182 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/cafs"
+)
+
+// Type RetryPolicy defines how buys react to a failed remote trade.
+type RetryPolicy struct {
+	// The maximum number of remote trades attempted per buy, including the first one.
+	// Values below 1 are treated as 1.
+	MaxAttempts int
+	// Whether sellers that failed a buy are excluded from its subsequent attempts.
+	// Transactions matched with an excluded seller are abandoned (the buyer's money is
+	// reimbursed when they time out) and a new bid is placed.
+	ExcludeFailingSellers bool
+	// The time to wait before placing a new bid.
+	Delay time.Duration
+}
+
+// Type TradeAttempt records a failed attempt of a buy to trade with a remote seller.
+type TradeAttempt struct {
+	BidId, TxId string
+	Seller      string // Empty if the bid wasn't matched
+	Error       string
+	Started     time.Time
+	Failed      time.Time
+}
+
+var ErrExcludedSeller = errors.New("Matched with an excluded seller")
+
+// Type sellerClaims makes sure that buys working on the same work, such as the buys of
+// a redundant or speculative buy, trade with distinct sellers.
+type sellerClaims struct {
+	mutex   sync.Mutex
+	sellers map[string]ActivityKey
+}
+
+// Reserves a seller for the given buy. Returns false if another buy has claimed the
+// seller before.
+func (c *sellerClaims) claim(key ActivityKey, seller string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.sellers == nil {
+		c.sellers = make(map[string]ActivityKey)
+	}
+	if k, ok := c.sellers[seller]; ok && k != key {
+		return false
+	}
+	c.sellers[seller] = key
+	return true
+}
+
+// Returns whether a failed remote trade may be attempted again. Interrupted buys and
+// rejected or unvalidated results are final.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return err != ErrInterrupted && err != ErrResultRejected && err != ErrResultMismatch && err != ErrNoVerdict
+}
+
+// Returns whether another remote trade may be attempted after the given attempt failed
+// with err. Once the seller has delivered a result, the buy is never retried.
+func (a *BuyActivity) mayRetry(ctx context.Context, err error, attempt int, policy RetryPolicy) bool {
+	return attempt < policy.MaxAttempts && isRetryable(ctx, err) && !a.resultDelivered()
+}
+
+// Returns whether the seller has delivered a result in the current transaction. Unless
+// rejected, the transaction then retires in a way that pays the seller, so placing a new
+// bid would mean paying twice.
+func (a *BuyActivity) resultDelivered() bool {
+	var delivered bool
+	a.execSync(func() {
+		delivered = a.tx != nil && (a.tx.Phase == bitwrk.PhaseUnverified || a.tx.Phase == bitwrk.PhaseFinished)
+	})
+	return delivered
+}
+
+// Sets the retry policy applied to the buy. Must be called before PerformBuy.
+func (a *BuyActivity) SetRetryPolicy(policy RetryPolicy) {
+	a.execSync(func() { a.retryPolicy = policy })
+}
+
+// Performs remote trades until one succeeds or the retry policy forbids another attempt.
+// The same work file is offered on each attempt.
+func (a *BuyActivity) doRemoteBuyWithRetries(ctx context.Context, log bitwrk.Logger) (cafs.File, error) {
+	var policy RetryPolicy
+	a.execSync(func() { policy = a.retryPolicy })
+	excluded := make(map[string]bool)
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		result, err := a.doRemoteBuy(ctx, log.Newf("attempt %v", attempt), excluded)
+		if err == nil {
+			return result, nil
+		}
+
+		record := a.recordAttempt(err, started)
+		log.Printf("Attempt %v failed (bid %v, tx %v, seller %v): %v",
+			attempt, record.BidId, record.TxId, record.Seller, err)
+		if !a.mayRetry(ctx, err, attempt, policy) {
+			if attempt > 1 {
+				return nil, fmt.Errorf("Giving up after %v attempts: %v", attempt, err)
+			}
+			return nil, err
+		}
+
+		if policy.ExcludeFailingSellers && record.Seller != "" {
+			excluded[record.Seller] = true
+		}
+		a.resetRemoteTrade()
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrInterrupted
+		case <-time.After(policy.Delay):
+		}
+		if !a.renewFromMandate(time.Now(), true) {
+			return nil, fmt.Errorf("Giving up after %v attempts, mandate doesn't allow another bid: %v", attempt, err)
+		}
+	}
+}
+
+// Records a failed remote trade in the buy's list of attempts.
+func (a *BuyActivity) recordAttempt(err error, started time.Time) TradeAttempt {
+	var record TradeAttempt
+	a.execSync(func() {
+		record = TradeAttempt{
+			BidId:   a.bidId,
+			TxId:    a.txId,
+			Error:   err.Error(),
+			Started: started,
+			Failed:  time.Now(),
+		}
+		if a.tx != nil {
+			record.Seller = a.tx.Seller
+		}
+		a.attempts = append(a.attempts, record)
+	})
+	return record
+}
+
+// Clears the state of a failed remote trade so that a fresh bid can be placed.
+func (a *BuyActivity) resetRemoteTrade() {
+	var files []cafs.File
+	a.execSync(func() {
+		files = append(files, a.encResultFile, a.resultFile)
+		a.bidId, a.bid = "", nil
+		a.txId, a.txETag, a.tx = "", "", nil
+		a.buyerSecret = nil
+		a.bytesToTransfer, a.bytesTransferred = 0, 0
+		a.encResultFile, a.encResultKey, a.encResultHashSig = nil, nil, ""
+		a.resultFile = nil
+	})
+	for _, f := range files {
+		if f != nil {
+			f.Dispose()
+		}
+	}
+}
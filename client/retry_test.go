@@ -0,0 +1,120 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+)
+
+func Test_IsRetryable(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		ctx       context.Context
+		err       error
+		retryable bool
+	}{
+		{context.Background(), errors.New("Connection refused"), true},
+		{context.Background(), ErrExcludedSeller, true},
+		{context.Background(), ErrInterrupted, false},
+		{context.Background(), ErrResultRejected, false},
+		{context.Background(), ErrResultMismatch, false},
+		{context.Background(), ErrNoVerdict, false},
+		{cancelled, errors.New("Connection refused"), false},
+	}
+	for _, test := range tests {
+		if r := isRetryable(test.ctx, test.err); r != test.retryable {
+			t.Errorf("isRetryable(%v) with context error %v: expected %v, got %v",
+				test.err, test.ctx.Err(), test.retryable, r)
+		}
+	}
+}
+
+func Test_SellerClaimsRace(t *testing.T) {
+	var claims sellerClaims
+	for i := 0; i < 100; i++ {
+		seller := fmt.Sprintf("seller-%v", i)
+		var wins [2]bool
+		var wg sync.WaitGroup
+		start := make(chan bool)
+		for k := range wins {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				<-start
+				wins[k] = claims.claim(ActivityKey(k+1), seller)
+			}(k)
+		}
+		close(start)
+		wg.Wait()
+
+		if wins[0] == wins[1] {
+			t.Fatalf("%v: expected exactly one buy to claim the seller, got %v", seller, wins)
+		}
+		winner := ActivityKey(1)
+		if wins[1] {
+			winner = 2
+		}
+		if !claims.claim(winner, seller) {
+			t.Errorf("%v: expected buy #%v to keep its claim", seller, winner)
+		}
+		if claims.claim(3-winner, seller) {
+			t.Errorf("%v: expected buy #%v to be refused", seller, 3-winner)
+		}
+	}
+}
+
+func Test_NoRetryAfterDelivery(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+	err := errors.New("Connection refused")
+	phases := []struct {
+		phase     bitwrk.TxPhase
+		delivered bool
+	}{
+		{bitwrk.PhaseEstablishing, false},
+		{bitwrk.PhaseTransmitting, false},
+		{bitwrk.PhaseWorking, false},
+		{bitwrk.PhaseUnverified, true},
+		{bitwrk.PhaseFinished, true},
+		{bitwrk.PhaseWorkDisputed, false},
+	}
+	for _, p := range phases {
+		buy := newTestMember(1)
+		buy.tx = &bitwrk.Transaction{Phase: p.phase}
+		if d := buy.resultDelivered(); d != p.delivered {
+			t.Errorf("Phase %v: expected delivered=%v, got %v", p.phase, p.delivered, d)
+		}
+		if r := buy.mayRetry(context.Background(), err, 1, policy); r == p.delivered {
+			t.Errorf("Phase %v: expected retry=%v, got %v", p.phase, !p.delivered, r)
+		}
+	}
+
+	// Without a transaction, only the policy's limit applies
+	buy := newTestMember(1)
+	if !buy.mayRetry(context.Background(), err, 2, policy) {
+		t.Errorf("Expected retry before reaching the maximum number of attempts")
+	}
+	if buy.mayRetry(context.Background(), err, 3, policy) {
+		t.Errorf("Expected no retry after reaching the maximum number of attempts")
+	}
+}
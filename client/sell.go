@@ -88,9 +88,10 @@ func (a *SellActivity) doLocalSell(ctx context.Context, log bitwrk.Logger) error
 
 	st := NewScopedTransport()
 	defer st.Close()
-	if r, err := a.worker.DoWork(reader, NewClient(&st.Transport)); err != nil {
+	if r, err := a.worker.DoWork(ctx, reader, NewClient(&st.Transport)); err != nil {
 		return fmt.Errorf("Worker finished with error: %v", err)
 	} else {
+		defer r.Close()
 		info := fmt.Sprintf("Sell #%v", a.GetKey())
 		temp := a.manager.GetStorage().Create(info)
 		defer temp.Dispose()
@@ -196,6 +197,14 @@ func (a *SellActivity) HandleWork(log bitwrk.Logger, workFile cafs.File, buyerSe
 		return nil, err
 	}
 
+	if err := CheckWorkSize(a.article, workFile.Size()); err != nil {
+		log.Printf("Rejecting work: %v", err)
+		if err := SendTxMessageRejectWork(a.txId, a.identity); err != nil {
+			log.Printf("Rejecting work failed: %v", err)
+		}
+		return nil, err
+	}
+
 	log.Println("Got valid work data. Publishing buyer's secret.")
 	if err := SendTxMessagePublishBuyerSecret(a.txId, a.identity, &buyerSecret); err != nil {
 		return nil, fmt.Errorf("Error publishing buyer's secret: %v", err)
@@ -222,6 +231,14 @@ func (a *SellActivity) HandleReceipt(log bitwrk.Logger, encResultHash, encResult
 	return nil
 }
 
+// Type cancelCloser lets a watchdog cancel a context.
+type cancelCloser context.CancelFunc
+
+func (c cancelCloser) Close() error {
+	c()
+	return nil
+}
+
 func (a *SellActivity) dispatchWork(log bitwrk.Logger, workFile cafs.File) (io.ReadCloser, error) {
 	// Watch transaction state and close connection to worker when transaction expires
 	connChan := make(chan io.Closer)
@@ -231,13 +248,23 @@ func (a *SellActivity) dispatchWork(log bitwrk.Logger, workFile cafs.File) (io.R
 		exitChan <- true
 	}()
 
+	// Ask the buyer for more time if the work takes longer than granted
+	exitProposing := make(chan bool)
+	go a.proposeExtensions(log, exitProposing)
+	defer close(exitProposing)
+
 	reader := workFile.Open()
 	defer reader.Close()
 
+	// Waiting for workers that pull their work is aborted via the context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connChan <- cancelCloser(cancel)
+
 	st := NewScopedTransport()
 	connChan <- st
 	defer st.Close()
-	r, err := a.worker.DoWork(reader, NewClient(&st.Transport))
+	r, err := a.worker.DoWork(ctx, reader, NewClient(&st.Transport))
 	if err == nil {
 		// Defuse connection closing mechanism
 		st.DisownConnections()
@@ -0,0 +1,81 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Type Settings holds the configuration values that may be changed while the client is
+// running. Installed settings are never modified. Instead, changes are made by installing
+// a modified copy using SetSettings, so running trades always see a consistent state.
+type Settings struct {
+	// Maximum number of unmatched bids per article on the server
+	NumUnmatchedBids int
+	// Maximum number of bids not in working state
+	NumTransmittingBids int
+	// Maximum time a result validator may take
+	ValidationTimeout time.Duration
+	// How trades handle extensions of a transaction's timeout
+	Extensions ExtensionPolicy
+	// The retry policy given to new buys
+	Retries RetryPolicy
+	// What happens to the transactions of interrupted buys
+	Aborts AbortPolicy
+	// The redundancy policy given to new redundant buys
+	Redundancy RedundancyPolicy
+	// The lease granted to workers registering through the API if they don't ask for one.
+	// Workers have to renew it by sending heartbeats, by polling for work or by registering
+	// again. Zero means that only workers asking for a lease get one.
+	WorkerLease time.Duration
+}
+
+// The settings currently in effect, of type *Settings
+var currentSettings atomic.Value
+
+func init() {
+	currentSettings.Store(&Settings{
+		NumUnmatchedBids:    1,
+		NumTransmittingBids: 4,
+		ValidationTimeout:   5 * time.Minute,
+		Extensions: ExtensionPolicy{
+			ProposeBefore: 90 * time.Second,
+			ProposeAmount: 10 * time.Minute,
+			MaxGranted:    time.Hour,
+		},
+		Retries: RetryPolicy{
+			MaxAttempts:           3,
+			ExcludeFailingSellers: true,
+			Delay:                 5 * time.Second,
+		},
+		Aborts:     AbortPolicy{AcceptFinished: true},
+		Redundancy: RedundancyPolicy{Escalate: true},
+	})
+}
+
+// Function CurrentSettings returns a copy of the settings currently in effect.
+func CurrentSettings() Settings {
+	return *currentSettings.Load().(*Settings)
+}
+
+// Function SetSettings replaces the settings currently in effect. Trades waiting for
+// tokens re-evaluate their limits.
+func SetSettings(settings Settings) {
+	currentSettings.Store(&settings)
+	GetActivityManager().LimitsChanged()
+}
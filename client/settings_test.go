@@ -0,0 +1,138 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/cafs"
+	"github.com/indyjo/cafs/ram"
+)
+
+func newTestActivityManager() *ActivityManager {
+	return &ActivityManager{
+		logger:     bitwrk.Root().New("ActivityManager"),
+		mutex:      new(sync.Mutex),
+		activities: make(map[ActivityKey]Activity),
+		mandates:   make(map[ActivityKey]*Mandate),
+		nextKey:    1,
+		storage:    ram.NewRamStorage(16 * 1024 * 1024),
+		bidTokens:  make(map[string]int),
+		tokenWake:  make(chan bool),
+		saveChan:   make(chan bool, 1),
+		exhausted:  make(map[string]string),
+	}
+}
+
+// Caches an empty catalog entry for the article so that buys don't contact the server.
+func cacheTestArticle(article bitwrk.ArticleId) {
+	articlesMutex.Lock()
+	defer articlesMutex.Unlock()
+	articles[article] = cachedArticle{&bitwrk.Article{}, time.Now()}
+}
+
+func newTestWork(t *testing.T, storage cafs.FileStorage, data string) cafs.File {
+	temp := storage.Create("Test work")
+	defer temp.Dispose()
+	if _, err := temp.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := temp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return temp.File()
+}
+
+// Returns the number of tokens checked out for the resource.
+func (m *ActivityManager) tokensCheckedOut(key string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.bidTokens[key]
+}
+
+func Test_ReloadSettingsDuringBuy(t *testing.T) {
+	defer SetSettings(CurrentSettings())
+
+	// Without unmatched bids allowed, the buy waits before contacting the server
+	settings := CurrentSettings()
+	settings.NumUnmatchedBids = 0
+	settings.NumTransmittingBids = 0
+	SetSettings(settings)
+
+	m := newTestActivityManager()
+	article := bitwrk.ArticleId("net.bitwrk/test/settings")
+	cacheTestArticle(article)
+	buy, err := m.NewBuy(article, nil, &money.Money{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := newTestWork(t, m.storage, "work")
+	defer work.Dispose()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		result, err := buy.PerformBuy(ctx, bitwrk.Root().New("Buy"), work)
+		if result != nil {
+			result.Dispose()
+		}
+		done <- err
+	}()
+
+	// Reload concurrently with the buy reading its settings
+	for i := 0; i < 100; i++ {
+		settings := CurrentSettings()
+		settings.Retries.Delay = time.Duration(i) * time.Millisecond
+		settings.ValidationTimeout = time.Duration(i) * time.Second
+		SetSettings(settings)
+		m.LimitsChanged()
+	}
+
+	// Raising the limit lets the waiting buy proceed to the next token
+	unmatched := fmt.Sprintf("unmatched-%v-%v", bitwrk.Buy, article)
+	settings = CurrentSettings()
+	settings.NumUnmatchedBids = 1
+	SetSettings(settings)
+	m.LimitsChanged()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.tokensCheckedOut(unmatched) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Buy didn't pick up the raised limit")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected interrupted buy to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Buy didn't return after being cancelled")
+	}
+	if n := m.tokensCheckedOut(unmatched); n != 0 {
+		t.Errorf("Expected token to be returned, %v still checked out", n)
+	}
+}
@@ -0,0 +1,268 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/cafs"
+)
+
+// Type AbortPolicy defines what happens to the transaction of a buy that is interrupted,
+// e.g. because another buy of a speculative buy has won.
+type AbortPolicy struct {
+	// Whether a result the seller has already delivered is accepted, paying the seller
+	// right away. Otherwise, the transaction is left to time out. Transactions that time
+	// out before the seller has delivered reimburse the buyer.
+	AcceptFinished bool
+}
+
+// Abandons the remote trade of an interrupted buy according to the abort policy.
+// Returns ErrInterrupted.
+func (a *BuyActivity) abortTrade(log bitwrk.Logger) error {
+	if a.acceptsOnAbort(CurrentSettings().Aborts) {
+		log.Printf("Buy interrupted, accepting result delivered by seller")
+		go func() {
+			if err := a.finishBuy(log, true); err != nil {
+				log.Printf("Error finishing buy: %v", err)
+			}
+		}()
+	} else {
+		log.Printf("Buy interrupted, leaving transaction to time out")
+	}
+	return ErrInterrupted
+}
+
+// Returns whether an interrupted buy accepts the result delivered in its transaction,
+// according to the given abort policy.
+func (a *BuyActivity) acceptsOnAbort(policy AbortPolicy) bool {
+	var finished bool
+	a.execSync(func() {
+		finished = a.tx != nil && a.tx.State == bitwrk.StateActive && a.tx.Phase == bitwrk.PhaseUnverified
+	})
+	return finished && policy.AcceptFinished
+}
+
+var ErrNoSpeculativeResult = errors.New("None of the speculative buys produced a result")
+
+// Type SpeculativeBuy dispatches the same work to several sellers at once and keeps the
+// first valid result. The remaining buys are interrupted as soon as a result is
+// available and abandon their trades according to the abort policy.
+// Note that the number of unmatched bids per article (see Settings.NumUnmatchedBids) limits how
+// many of the buys can wait for a match at the same time.
+type SpeculativeBuy struct {
+	manager *ActivityManager
+	key     ActivityKey
+	article bitwrk.ArticleId
+
+	condition  *sync.Cond
+	alive      bool
+	members    []*BuyActivity
+	sellers    sellerClaims
+	winner     *BuyActivity
+	lastError  error
+	running    sync.WaitGroup
+	resultFile cafs.File
+}
+
+// Creates a speculative buy consisting of n buys. If price is nil, each buy awaits being
+// published by the user or a mandate.
+func (m *ActivityManager) NewSpeculativeBuy(article bitwrk.ArticleId, identity *bitcoin.KeyPair, price *money.Money, n int) (*SpeculativeBuy, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Invalid number of speculative buys: %v", n)
+	}
+	result := &SpeculativeBuy{
+		manager:   m,
+		key:       m.NewKey(),
+		article:   article,
+		condition: sync.NewCond(new(sync.Mutex)),
+		alive:     true,
+	}
+	for i := 0; i < n; i++ {
+		if member, err := m.NewBuy(article, identity, price); err != nil {
+			result.Dispose()
+			return nil, err
+		} else {
+			member.execSync(func() { member.sellers = &result.sellers })
+			result.members = append(result.members, member)
+		}
+	}
+	m.register(result.key, result, true)
+	return result, nil
+}
+
+// Executes a short function that modifies the speculative buy's internal state.
+// Then broadcasts a signal to condition listeners.
+func (b *SpeculativeBuy) execSync(f func()) {
+	b.condition.L.Lock()
+	defer b.condition.L.Unlock()
+	f()
+	b.condition.Broadcast()
+}
+
+type speculativeResult struct {
+	member *BuyActivity
+	file   cafs.File
+	err    error
+}
+
+// Performs all buys at once and returns the first result. Buys still running at that
+// time are interrupted and finish in the background.
+func (b *SpeculativeBuy) PerformBuy(ctx context.Context, log bitwrk.Logger, workFile cafs.File) (cafs.File, error) {
+	log.Printf("Speculative buy started")
+	defer b.execSync(func() {
+		b.alive = false
+		log.Printf("Speculative buy finished")
+	})
+
+	var members []*BuyActivity
+	b.execSync(func() { members = append(members, b.members...) })
+
+	// The work file must outlive the buys that are still running when we return
+	workFile = workFile.Duplicate()
+	memberCtx, cancel := context.WithCancel(ctx)
+	results := make(chan speculativeResult, len(members))
+	for _, member := range members {
+		member := member
+		b.running.Add(1)
+		go func() {
+			defer b.running.Done()
+			file, err := member.PerformBuy(memberCtx, log.Newf("Buy #%v", member.GetKey()), workFile)
+			results <- speculativeResult{member, file, err}
+		}()
+	}
+	go func() {
+		b.running.Wait()
+		cancel()
+		workFile.Dispose()
+	}()
+
+	var lastErr error = ErrNoSpeculativeResult
+	for range members {
+		r := <-results
+		if r.err != nil {
+			log.Printf("Buy #%v failed: %v", r.member.GetKey(), r.err)
+			if r.err != ErrInterrupted {
+				lastErr = r.err
+			}
+			continue
+		}
+
+		log.Printf("Buy #%v won, interrupting the others", r.member.GetKey())
+		cancel()
+		file := r.file.Duplicate()
+		b.execSync(func() {
+			b.winner = r.member
+			b.resultFile = file
+		})
+		return file, nil
+	}
+
+	err := fmt.Errorf("%v: %v", ErrNoSpeculativeResult, lastErr)
+	if lastErr == ErrNoSpeculativeResult {
+		err = lastErr
+	}
+	b.execSync(func() { b.lastError = err })
+	return nil, err
+}
+
+// Implement Activity
+func (b *SpeculativeBuy) GetKey() ActivityKey {
+	return b.key
+}
+
+func (b *SpeculativeBuy) GetState() *ActivityState {
+	var members []*BuyActivity
+	var winner *BuyActivity
+	var alive bool
+	var info string
+	b.execSync(func() {
+		members = append(members, b.members...)
+		winner = b.winner
+		alive = b.alive
+		if b.lastError != nil {
+			info = b.lastError.Error()
+		}
+	})
+	if winner != nil {
+		info = fmt.Sprintf("Result delivered by #%v", winner.GetKey())
+	}
+
+	// The cost of a speculative buy is the sum of the cost of its matched buys
+	cost := money.Money{Currency: money.BTC}
+	phase := "SPECULATIVE"
+	for i, member := range members {
+		if state := member.GetState(); state.TxId != "" {
+			cost = cost.Add(state.Amount)
+		}
+		if i == 0 {
+			phase += " ("
+		} else {
+			phase += ", "
+		}
+		phase += fmt.Sprintf("#%v", member.GetKey())
+	}
+	if len(members) > 0 {
+		phase += ")"
+	}
+
+	return &ActivityState{
+		Type:     bitwrk.Buy.String(),
+		Article:  b.article,
+		Alive:    alive,
+		Accepted: true,
+		Amount:   cost,
+		Info:     info,
+		Phase:    phase,
+	}
+}
+
+// Speculative buys are published through their buys.
+func (b *SpeculativeBuy) Publish(price money.Money) bool {
+	return false
+}
+
+func (b *SpeculativeBuy) GetTrade() *Trade {
+	return nil
+}
+
+// Disposes the speculative buy. Buys that are still running are disposed of once they
+// have finished.
+func (b *SpeculativeBuy) Dispose() {
+	b.manager.unregister(b.key)
+	var members []*BuyActivity
+	var resultFile cafs.File
+	b.execSync(func() {
+		members, resultFile = b.members, b.resultFile
+		b.members, b.resultFile = nil, nil
+	})
+	if resultFile != nil {
+		resultFile.Dispose()
+	}
+	go func() {
+		b.running.Wait()
+		for _, member := range members {
+			member.Dispose()
+		}
+	}()
+}
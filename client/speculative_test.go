@@ -0,0 +1,166 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/cafs"
+)
+
+// Type fileRefs counts the live handles of a file and how often a handle was disposed of
+// more than once.
+type fileRefs struct {
+	live, disposedTwice int32
+}
+
+// Type trackedFile is a file handle whose references are counted in a fileRefs.
+type trackedFile struct {
+	cafs.File
+	refs     *fileRefs
+	disposed int32
+}
+
+func newTrackedFile(f cafs.File) *trackedFile {
+	return &trackedFile{File: f, refs: &fileRefs{live: 1}}
+}
+
+func (f *trackedFile) Duplicate() cafs.File {
+	atomic.AddInt32(&f.refs.live, 1)
+	return &trackedFile{File: f.File, refs: f.refs}
+}
+
+func (f *trackedFile) Dispose() {
+	if atomic.CompareAndSwapInt32(&f.disposed, 0, 1) {
+		atomic.AddInt32(&f.refs.live, -1)
+	} else {
+		atomic.AddInt32(&f.refs.disposedTwice, 1)
+	}
+}
+
+// Waits until the file has the expected number of live handles.
+func (f *trackedFile) awaitRefs(t *testing.T, what string, expected int32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&f.refs.live) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %v handles of %v, got %v", expected, what, atomic.LoadInt32(&f.refs.live))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&f.refs.disposedTwice); n != 0 {
+		t.Errorf("Handles of %v disposed of twice: %v", what, n)
+	}
+}
+
+func Test_SpeculativeWinnerSurvivesDispose(t *testing.T) {
+	m := newTestActivityManager()
+	article := bitwrk.ArticleId("net.bitwrk/test/speculative")
+	cacheTestArticle(article)
+	b, err := m.NewSpeculativeBuy(article, nil, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each buy is matched with a local sell, only the first of which delivers
+	var sells []*Trade
+	for _, member := range b.members {
+		sell := &Trade{condition: sync.NewCond(new(sync.Mutex)), bidType: bitwrk.Sell, alive: true}
+		sells = append(sells, sell)
+		member.execSync(func() {
+			member.localMatch = sell
+			member.awaitingClearance = false
+		})
+	}
+	loser := b.members[1]
+
+	work := newTrackedFile(newTestWork(t, m.storage, "work"))
+	result := newTrackedFile(newTestWork(t, m.storage, "result"))
+	done := make(chan cafs.File, 1)
+	go func() {
+		file, err := b.PerformBuy(context.Background(), bitwrk.Root().New("Speculative buy"), work)
+		if err != nil {
+			t.Errorf("Speculative buy failed: %v", err)
+		}
+		done <- file
+	}()
+	sells[0].execSync(func() { sells[0].resultFile = result })
+
+	var file cafs.File
+	select {
+	case file = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Speculative buy didn't return a result")
+	}
+	if file == nil {
+		t.FailNow()
+	}
+
+	// The caller keeps a reference to the result, like jobs do
+	kept := file.Duplicate()
+	defer kept.Dispose()
+	b.Dispose()
+	b.running.Wait()
+
+	var loserErr error
+	loser.execSync(func() { loserErr = loser.lastError })
+	if loserErr == nil {
+		t.Errorf("Expected the losing buy to be interrupted")
+	}
+
+	// Only the test's handles remain: the sell's result and the kept duplicate
+	result.awaitRefs(t, "result", 2)
+	work.awaitRefs(t, "work", 1)
+	if data, err := ioutil.ReadAll(kept.Open()); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "result" {
+		t.Errorf("Unexpected result after disposing of the speculative buy: %#v", string(data))
+	}
+}
+
+func Test_AcceptsOnAbort(t *testing.T) {
+	tests := []struct {
+		state  bitwrk.TxState
+		phase  bitwrk.TxPhase
+		policy AbortPolicy
+		accept bool
+	}{
+		{bitwrk.StateActive, bitwrk.PhaseUnverified, AbortPolicy{AcceptFinished: true}, true},
+		{bitwrk.StateActive, bitwrk.PhaseUnverified, AbortPolicy{AcceptFinished: false}, false},
+		{bitwrk.StateActive, bitwrk.PhaseWorking, AbortPolicy{AcceptFinished: true}, false},
+		{bitwrk.StateActive, bitwrk.PhaseTransmitting, AbortPolicy{AcceptFinished: true}, false},
+		{bitwrk.StateRetired, bitwrk.PhaseUnverified, AbortPolicy{AcceptFinished: true}, false},
+	}
+	for _, test := range tests {
+		buy := newTestMember(1)
+		buy.tx = &bitwrk.Transaction{State: test.state, Phase: test.phase}
+		if accept := buy.acceptsOnAbort(test.policy); accept != test.accept {
+			t.Errorf("%v/%v with %+v: expected accept=%v, got %v",
+				test.state, test.phase, test.policy, test.accept, accept)
+		}
+	}
+
+	// A buy interrupted before being matched has nothing to accept
+	if newTestMember(1).acceptsOnAbort(AbortPolicy{AcceptFinished: true}) {
+		t.Errorf("Expected buy without transaction not to accept anything")
+	}
+}
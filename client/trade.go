@@ -53,6 +53,12 @@ type Trade struct {
 	identity *bitcoin.KeyPair
 	price    money.Money
 
+	// The mandate that published the trade, when it did so and the amount it has
+	// reserved (the latter for buys only)
+	mandate         *Mandate
+	publishedAt     time.Time
+	mandateReserved money.Money
+
 	// Remote bid information
 	bidId string
 	bid   *bitwrk.Bid
@@ -72,20 +78,20 @@ type Trade struct {
 	encResultHashSig string
 
 	resultFile cafs.File
-}
 
-// Configuration value for the maximum number of unmatched bids to allow at a time
-var NumUnmatchedBids = 1
+	// Outcome of result validation (buys only)
+	verdict, verdictReason string
 
-// Configuration value for the maximum number of bids not in working state
-var NumTransmittingBids = 4
+	// Failed attempts to trade with a remote seller (buys only)
+	attempts []TradeAttempt
+}
 
 // Goes through the process of creating a bid and waiting for a transaction.
 // If this is a buy, leaves the Trade with the transmission token checked out.
 func (t *Trade) beginRemoteTrade(ctx context.Context, log bitwrk.Logger) error {
 	// Prevent too many unmatched bids on server
 	key := fmt.Sprintf("unmatched-%v-%v", t.bidType, t.article)
-	if err := t.manager.checkoutToken(ctx, key, NumUnmatchedBids); err != nil {
+	if err := t.manager.checkoutToken(ctx, key, func(s Settings) int { return s.NumUnmatchedBids }); err != nil {
 		return err
 	}
 	defer t.manager.returnToken(key)
@@ -101,7 +107,7 @@ func (t *Trade) beginRemoteTrade(ctx context.Context, log bitwrk.Logger) error {
 	}
 	log.Printf("Got bid id: %v", t.bidId)
 
-	if err := t.awaitTransaction(log); err != nil {
+	if err := t.awaitTransaction(ctx, log); err != nil {
 		return fmt.Errorf("Error awaiting transaction: %v", err)
 	}
 	log.Printf("Got transaction id: %v", t.txId)
@@ -113,6 +119,7 @@ func (t *Trade) beginRemoteTrade(ctx context.Context, log bitwrk.Logger) error {
 			t.tx = tx
 			t.txETag = etag
 		})
+		t.mandateMatched(tx, time.Now())
 	}
 
 	return nil
@@ -173,7 +180,9 @@ func (t *Trade) awaitBid() error {
 	return nil
 }
 
-func (t *Trade) awaitTransaction(log bitwrk.Logger) error {
+// Polls the bid until it is matched. Returns ErrInterrupted if the context is done
+// first, leaving the bid to be matched or to expire on the server.
+func (t *Trade) awaitTransaction(ctx context.Context, log bitwrk.Logger) error {
 	lastETag := ""
 	for count := 1; ; count++ {
 		if bid, etag, err := protocol.FetchBid(t.bidId, lastETag); err != nil {
@@ -186,12 +195,27 @@ func (t *Trade) awaitTransaction(log bitwrk.Logger) error {
 				t.txId = *t.bid.Transaction
 				break
 			} else if t.bid.State == bitwrk.Expired {
-				return ErrBidExpired
+				// Bids can't be withdrawn, so escalating prices take effect when
+				// an unmatched bid has expired and is placed again.
+				if !t.escalatedByMandate() || !t.renewFromMandate(time.Now(), false) {
+					return ErrBidExpired
+				}
+				if err := t.awaitBid(); err != nil {
+					return err
+				}
+				log.Printf("Bid expired unmatched, placed new bid %v at %v", t.bidId, t.price)
+				lastETag = ""
+				count = 0
+				continue
 			}
 		}
 
 		// Sleep for gradually longer durations
-		time.Sleep(time.Duration(count) * 500 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return ErrInterrupted
+		case <-time.After(time.Duration(count) * 500 * time.Millisecond):
+		}
 	}
 	return nil
 }
@@ -258,22 +282,24 @@ func (t *Trade) interruptibleWaitWhile(ctx context.Context, f func() bool) error
 		exit <- true
 	}()
 
-	// Listen for interrupt and exit signal in parallel goroutine
+	// Listen for interrupt and exit signal in parallel goroutine. The flag is only
+	// accessed with the lock held.
 	interrupted := false
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				interrupted = true
-				t.condition.Broadcast()
-			case <-exit:
-				return
-			}
+		select {
+		case <-ctx.Done():
+			t.execSync(func() { interrupted = true })
+			<-exit
+		case <-exit:
 		}
 	}()
 
-	t.waitWhile(func() bool { return !interrupted && f() })
-	if interrupted {
+	var stopped bool
+	t.waitWhile(func() bool {
+		stopped = interrupted
+		return !interrupted && f()
+	})
+	if stopped {
 		return ErrInterrupted
 	}
 	return nil
@@ -426,6 +452,11 @@ func (t *Trade) GetState() *ActivityState {
 		Info:     info,
 		Phase:    phase,
 
+		Verdict:       t.verdict,
+		VerdictReason: t.verdictReason,
+
+		Attempts: append([]TradeAttempt(nil), t.attempts...),
+
 		BytesToTransfer:  t.bytesToTransfer,
 		BytesTransferred: t.bytesTransferred,
 	}
@@ -477,14 +508,17 @@ func (t *Trade) Dispose() {
 
 func (t *Trade) awaitTransmissionToken(ctx context.Context) error {
 	var wasTransmitting bool
-	t.execSync(func() {
-		wasTransmitting = t.transmitting
-		t.transmitting = true
-	})
+	t.execSync(func() { wasTransmitting = t.transmitting })
 	if wasTransmitting {
 		return errors.New("Was already transmitting")
 	}
-	return t.manager.checkoutToken(ctx, "transmission", NumTransmittingBids)
+	// Only mark the token as held once it has been checked out, so that an
+	// interrupted trade doesn't return a token it never had.
+	if err := t.manager.checkoutToken(ctx, "transmission", func(s Settings) int { return s.NumTransmittingBids }); err != nil {
+		return err
+	}
+	t.execSync(func() { t.transmitting = true })
+	return nil
 }
 
 func (t *Trade) returnTransmissionToken() {
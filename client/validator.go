@@ -0,0 +1,165 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/cafs"
+)
+
+// Verdicts recorded in ActivityState.Verdict
+const (
+	VerdictAccepted = "ACCEPTED"
+	VerdictRejected = "REJECTED"
+)
+
+// Interface ResultValidator decides whether the decrypted result of a buy is acceptable.
+// A rejected result is not paid for, but disputed with the seller.
+type ResultValidator interface {
+	// Returns true if the result is accepted, false if it is rejected, together with a
+	// human-readable reason. An error means that no verdict could be reached.
+	Validate(ctx context.Context, article bitwrk.ArticleId, result cafs.File) (accept bool, reason string, err error)
+}
+
+// Type CommandValidator runs a local command which receives the result on stdin.
+// Exit status 0 means the result is accepted, any other exit status means it is rejected.
+// The command's output is used as the reason.
+type CommandValidator struct {
+	Path string
+	Args []string
+}
+
+func (v *CommandValidator) Validate(ctx context.Context, article bitwrk.ArticleId, result cafs.File) (bool, string, error) {
+	cmd := exec.CommandContext(ctx, v.Path, v.Args...)
+	cmd.Env = append(os.Environ(), "BITWRK_ARTICLE="+string(article))
+	reader := result.Open()
+	defer reader.Close()
+	cmd.Stdin = reader
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	reason := strings.TrimSpace(output.String())
+	if len(reason) > 256 {
+		reason = reason[:256] + "..."
+	}
+	if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+		if reason == "" {
+			reason = err.Error()
+		}
+		return false, reason, nil
+	} else if err != nil {
+		return false, "", err
+	}
+	return true, reason, nil
+}
+
+func (v *CommandValidator) String() string {
+	return strings.Join(append([]string{v.Path}, v.Args...), " ")
+}
+
+// Type HTTPValidator posts the result to a URL. A response with status 200 (OK) means
+// the result is accepted, status 406 (Not Acceptable) or 422 (Unprocessable Entity) means
+// it is rejected. The response body is used as the reason.
+type HTTPValidator struct {
+	URL string
+}
+
+func (v *HTTPValidator) Validate(ctx context.Context, article bitwrk.ArticleId, result cafs.File) (bool, string, error) {
+	reader := result.Open()
+	defer reader.Close()
+	req, err := http.NewRequest("POST", v.URL, reader)
+	if err != nil {
+		return false, "", err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = result.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-BitWrk-Article", string(article))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	reason := strings.TrimSpace(string(body))
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, reason, nil
+	case http.StatusNotAcceptable, http.StatusUnprocessableEntity:
+		if reason == "" {
+			reason = resp.Status
+		}
+		return false, reason, nil
+	}
+	return false, "", fmt.Errorf("Validator returned unexpected status: %v", resp.Status)
+}
+
+func (v *HTTPValidator) String() string {
+	return v.URL
+}
+
+// Function ParseResultValidator creates a validator from a textual specification.
+// URLs starting with "http://" or "https://" create an HTTPValidator, everything else
+// is interpreted as a command line.
+func ParseResultValidator(spec string) (ResultValidator, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &HTTPValidator{URL: spec}, nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Empty validator specification")
+	}
+	return &CommandValidator{Path: fields[0], Args: fields[1:]}, nil
+}
+
+var validatorsMutex sync.Mutex
+var validators = make(map[bitwrk.ArticleId]ResultValidator)
+
+// Sets the validator to apply to results of buys of the given article. A nil validator
+// removes any validator previously set, meaning that all results are accepted.
+func SetResultValidator(article bitwrk.ArticleId, validator ResultValidator) {
+	validatorsMutex.Lock()
+	defer validatorsMutex.Unlock()
+	if validator == nil {
+		delete(validators, article)
+	} else {
+		validators[article] = validator
+	}
+}
+
+// Returns the validator configured for the given article, or nil.
+func GetResultValidator(article bitwrk.ArticleId) ResultValidator {
+	validatorsMutex.Lock()
+	defer validatorsMutex.Unlock()
+	return validators[article]
+}
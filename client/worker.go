@@ -18,6 +18,7 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -43,27 +44,73 @@ type WorkerState struct {
 	cond         *sync.Cond
 	LastError    string // set after each call to DoWork
 	Info         WorkerInfo
-	Idle         bool // set to false when a job is started, true when worker reports back
+	Slots        []WorkerSlot // one per job the worker can process concurrently
+	IdleSlots    int          // number of slots available for new sells, filled in by ListWorkers
 	Unregistered bool
 	Blockers     int              // count of currently blocking circumstances
+	LastSeen     time.Time        // last time the worker showed signs of life
+	Lease        time.Duration    // the worker is unregistered if not seen for this long; zero means never
+	Health       string           // one of the Health... constants, filled in by ListWorkers
 	identity     *bitcoin.KeyPair // BitWrk identity this worker is associated with
+	pull         *pullQueue       // Work waiting to be fetched, for workers using WorkerMethodPull
+	leaseTimer   *time.Timer      // fires when the lease may have expired
+	gone         chan struct{}    // closed when the worker is unregistered
 }
 
+// Values of WorkerState.Health
+const (
+	HealthOK      = "ok"      // seen recently, last sell didn't fail
+	HealthLate    = "late"    // more than half of the lease has passed without sign of life
+	HealthFailing = "failing" // the last attempt to sell failed
+)
+
+var ErrNoSuchWorker = errors.New("No such worker")
+
 type WorkerInfo struct {
-	Id      string
-	Article bitwrk.ArticleId
-	Method  string
-	PushURL string
+	Id       string
+	Article  bitwrk.ArticleId
+	Method   string // WorkerMethodPush, WorkerMethodPull or WorkerMethodExec
+	PushURL  string // Only used with WorkerMethodPush
+	Capacity int    // Number of jobs the worker processes concurrently. Zero means one.
+
+	// Only used with WorkerMethodExec
+	Command            string // Command line, split at white space
+	MaxCPUSeconds      int    // Limit of CPU time per job. Zero means unlimited.
+	MaxMemoryMegabytes int    // Limit of virtual memory per job. Zero means unlimited.
 }
 
+// Type WorkerSlot describes what one of a worker's slots is doing. Each slot is occupied
+// by at most one sell at a time.
+type WorkerSlot struct {
+	Sell      ActivityKey // The sell occupying the slot, or 0 if there is none
+	Busy      bool        // set to true when work is dispatched, false when the worker reports back
+	BusySince time.Time   // when work was dispatched
+}
+
+// Protocols for passing work to workers
+const (
+	// The client POSTs work to the worker's PushURL and receives the result as response.
+	WorkerMethodPush = "http-push"
+	// The worker long-polls the client for work and sends back the result (or an error)
+	// in a separate request. Workers need no listening port.
+	WorkerMethodPull = "http-pull"
+	// The client runs a command per job, passing the work on stdin and reading the result
+	// from stdout. Only available to workers from the configuration file.
+	WorkerMethodExec = "exec"
+)
+
 // The interface given to ActivityManager.NewSell() for controlling a worker without knowing
 // About the exact cient<->worker protocol.
 type Worker interface {
 	// Returns the current state of a worker
 	GetWorkerState() WorkerState
+	// Returns the information the worker registered with. Unlike GetWorkerState, this
+	// doesn't lock the worker's state and may be called while it is held.
+	GetWorkerInfo() WorkerInfo
 	// Makes the worker perform some work. Returns an io.ReadCloser containing the result,
 	// or an error if anything went wrong. The caller is responsible for closing the result.
-	DoWork(workReader io.Reader, client *http.Client) (io.ReadCloser, error)
+	// Cancelling the context aborts waiting for the result.
+	DoWork(ctx context.Context, workReader io.Reader, client *http.Client) (io.ReadCloser, error)
 }
 
 func NewWorkerManager(a *ActivityManager, r *receiveman.ReceiveManager, localOnly bool) *WorkerManager {
@@ -80,27 +127,64 @@ func (m *WorkerManager) ListWorkers() (result []WorkerState) {
 	defer m.mutex.Unlock()
 	result = make([]WorkerState, 0, len(m.workers))
 	for _, workerState := range m.workers {
-		result = append(result, *workerState)
+		result = append(result, workerState.snapshot())
 	}
 	return
 }
 
-func (m *WorkerManager) RegisterWorker(info WorkerInfo, identity *bitcoin.KeyPair) {
+// Returns a copy of the worker's state, including its slots.
+func (s *WorkerState) snapshot() WorkerState {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	result := *s
+	result.Slots = append([]WorkerSlot(nil), s.Slots...)
+	result.IdleSlots = 0
+	for _, slot := range s.Slots {
+		if slot.Sell == 0 && !slot.Busy {
+			result.IdleSlots++
+		}
+	}
+	if s.Lease > 0 && time.Since(s.LastSeen) > s.Lease/2 {
+		result.Health = HealthLate
+	} else if s.LastError != "" {
+		result.Health = HealthFailing
+	} else {
+		result.Health = HealthOK
+	}
+	return result
+}
+
+// Registers a worker, or renews its lease if it is registered already. A zero lease means
+// that the worker stays registered until it is explicitly unregistered.
+func (m *WorkerManager) RegisterWorker(info WorkerInfo, identity *bitcoin.KeyPair, lease time.Duration) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	log := bitwrk.Root().Newf("Worker %#v", info.Id)
 	if s, ok := m.workers[info.Id]; ok {
 		log.Printf("Reported idle: %v", info)
-		s.setIdle(true)
+		s.touch()
+		m.setLease(s, lease)
+		if s.Info.Method == WorkerMethodPush {
+			s.reportIdle()
+		}
 	} else {
 		log.Printf("Registered: %v", info)
+		if info.Capacity < 1 {
+			info.Capacity = 1
+		}
 		s = &WorkerState{
 			m:        m,
 			cond:     sync.NewCond(new(sync.Mutex)),
 			Info:     info,
-			Idle:     true,
+			Slots:    make([]WorkerSlot, info.Capacity),
+			LastSeen: time.Now(),
 			identity: identity,
+			gone:     make(chan struct{}),
+		}
+		if info.Method == WorkerMethodPull {
+			s.pull = newPullQueue()
 		}
+		m.setLease(s, lease)
 		m.workers[info.Id] = s
 		go s.offer(log, m.localOnly)
 	}
@@ -110,15 +194,84 @@ func (m *WorkerManager) UnregisterWorker(id string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	if s, ok := m.workers[id]; ok {
-		delete(m.workers, id)
-		s.cond.L.Lock()
-		defer s.cond.L.Unlock()
-		s.Unregistered = true
-		s.cond.Broadcast()
+		m.unregister(s)
 		bitwrk.Root().Newf("Worker %#v", id).Printf("Unregistered: %v", s.Info)
 	}
 }
 
+// Removes a worker and makes its sells stop. Assumes that the manager's mutex is held.
+func (m *WorkerManager) unregister(s *WorkerState) {
+	delete(m.workers, s.Info.Id)
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	s.Unregistered = true
+	s.cond.Broadcast()
+	close(s.gone)
+	if s.pull != nil {
+		s.pull.close()
+	}
+	if s.leaseTimer != nil {
+		s.leaseTimer.Stop()
+	}
+}
+
+// Sets the lease of a worker that has just shown signs of life, (re-)arming or stopping
+// the lease timer. Assumes that the manager's mutex is held.
+func (m *WorkerManager) setLease(s *WorkerState, lease time.Duration) {
+	s.cond.L.Lock()
+	s.Lease = lease
+	s.cond.L.Unlock()
+	if lease <= 0 {
+		if s.leaseTimer != nil {
+			s.leaseTimer.Stop()
+			s.leaseTimer = nil
+		}
+	} else if s.leaseTimer == nil {
+		s.leaseTimer = time.AfterFunc(lease, func() { m.checkLease(s) })
+	} else {
+		s.leaseTimer.Reset(lease)
+	}
+}
+
+// Renews the lease of a worker. Returns ErrNoSuchWorker if the worker isn't registered
+// (anymore), in which case it should register again.
+func (m *WorkerManager) Heartbeat(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s, ok := m.workers[id]; !ok {
+		return ErrNoSuchWorker
+	} else {
+		s.touch()
+		return nil
+	}
+}
+
+// Called by the lease timer. Unregisters the worker if its lease has expired, or
+// re-arms the timer otherwise.
+func (m *WorkerManager) checkLease(s *WorkerState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.workers[s.Info.Id] != s || s.leaseTimer == nil {
+		return
+	}
+	s.cond.L.Lock()
+	remaining := s.Lease - time.Since(s.LastSeen)
+	s.cond.L.Unlock()
+	if remaining > 0 {
+		s.leaseTimer.Reset(remaining)
+		return
+	}
+	m.unregister(s)
+	bitwrk.Root().Newf("Worker %#v", s.Info.Id).Printf("Lease of %v expired, unregistered: %v", s.Lease, s.Info)
+}
+
+// Records that the worker has shown signs of life.
+func (s *WorkerState) touch() {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	s.LastSeen = time.Now()
+}
+
 func (s *WorkerState) offer(log bitwrk.Logger, localOnly bool) {
 	defer log.Printf("Stopped offering")
 	s.cond.L.Lock()
@@ -133,33 +286,50 @@ func (s *WorkerState) offer(log bitwrk.Logger, localOnly bool) {
 			cancel()
 			break
 		}
-		if s.Blockers == 0 {
+		// Start a sell for every free slot
+		for s.Blockers == 0 {
+			slot := s.freeSlot()
+			if slot < 0 {
+				break
+			}
 			s.LastError = ""
-			if sell, err := s.m.activityManager.NewSell(s, s.identity, localOnly); err != nil {
+			if sell, err := s.m.activityManager.NewSell(slotWorker{s, slot}, s.identity, localOnly); err != nil {
 				s.LastError = fmt.Sprintf("Error creating sell: %v", err)
 				log.Println(s.LastError)
 				s.blockFor(20 * time.Second)
 			} else {
-				s.Blockers++
-				go s.executeSell(ctx, log, sell)
+				s.Slots[slot].Sell = sell.GetKey()
+				go s.executeSell(ctx, log, sell, slot)
 			}
 		}
 		s.cond.Wait()
 	}
 }
 
-func (s *WorkerState) executeSell(ctx context.Context, log bitwrk.Logger, sell *SellActivity) {
+// Returns the index of a slot that is neither occupied by a sell nor busy working, or -1.
+// Assumes that the mutex is held at the time of the call.
+func (s *WorkerState) freeSlot() int {
+	for i, slot := range s.Slots {
+		if slot.Sell == 0 && !slot.Busy {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *WorkerState) executeSell(ctx context.Context, log bitwrk.Logger, sell *SellActivity, slot int) {
 	defer func() {
 		s.cond.L.Lock()
-		s.Blockers--
+		s.Slots[slot].Sell = 0
 		s.cond.Broadcast()
 		s.cond.L.Unlock()
 	}()
 	defer sell.Dispose()
 	if err := sell.PerformSell(ctx, log.Newf("Sell #%v", sell.GetKey()), s.m.receiveManager); err != nil {
-		s.LastError = fmt.Sprintf("Error performing sell (delaying next sell by 20s): %v", err)
-		log.Println(s.LastError)
+		msg := fmt.Sprintf("Error performing sell (delaying next sell by 20s): %v", err)
+		log.Println(msg)
 		s.cond.L.Lock()
+		s.LastError = msg
 		s.blockFor(20 * time.Second)
 		s.cond.L.Unlock()
 	} else {
@@ -181,42 +351,132 @@ func (s *WorkerState) blockFor(d time.Duration) {
 	}()
 }
 
-// As long as a worker is marked as busy (not idle), no attempt is made to sell with it.
-func (s *WorkerState) setIdle(idle bool) {
+// As long as a slot is marked as busy, no attempt is made to sell with it.
+func (s *WorkerState) setBusy(slot int, busy bool) {
 	s.cond.L.Lock()
-	s.cond.L.Unlock()
-	if s.Idle != idle {
-		s.Idle = idle
-		if idle {
-			s.Blockers--
+	defer s.cond.L.Unlock()
+	if s.Slots[slot].Busy != busy {
+		s.Slots[slot].Busy = busy
+		if busy {
+			s.Slots[slot].BusySince = time.Now()
 		} else {
-			s.Blockers++
+			s.Slots[slot].BusySince = time.Time{}
 		}
 		s.cond.Broadcast()
 	}
 }
 
-func (s *WorkerState) GetWorkerState() WorkerState {
-	return *s
+// Called when a worker reports back without telling which job it has finished.
+// Frees the slot that has been busy the longest.
+func (s *WorkerState) reportIdle() {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	oldest := -1
+	for i, slot := range s.Slots {
+		if slot.Busy && (oldest < 0 || slot.BusySince.Before(s.Slots[oldest].BusySince)) {
+			oldest = i
+		}
+	}
+	if oldest >= 0 {
+		s.Slots[oldest].Busy = false
+		s.Slots[oldest].BusySince = time.Time{}
+		s.cond.Broadcast()
+	}
+}
+
+// Type slotWorker is the Worker given to a sell. It dispatches work to the slot
+// occupied by the sell.
+type slotWorker struct {
+	s    *WorkerState
+	slot int
+}
+
+func (w slotWorker) GetWorkerState() WorkerState {
+	return w.s.snapshot()
+}
+
+func (w slotWorker) GetWorkerInfo() WorkerInfo {
+	// Info is never modified after registration
+	return w.s.Info
+}
+
+func (w slotWorker) DoWork(ctx context.Context, workReader io.Reader, client *http.Client) (io.ReadCloser, error) {
+	return w.s.doWork(ctx, w.slot, workReader, client)
+}
+
+func (s *WorkerState) doWork(ctx context.Context, slot int, workReader io.Reader, client *http.Client) (io.ReadCloser, error) {
+	// Don't hand work to workers that are gone, e.g. because their lease expired.
+	s.cond.L.Lock()
+	gone := s.Unregistered
+	s.cond.L.Unlock()
+	if gone {
+		return nil, ErrNoSuchWorker
+	}
+
+	// Mark slot as busy until the worker reports back.
+	s.setBusy(slot, true)
+
+	// Abort dispatching the work and receiving the result when the worker is unregistered,
+	// e.g. because its lease expired. The result keeps the context alive until closed.
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.gone:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var result io.ReadCloser
+	var err error
+	if s.pull != nil {
+		result, err = s.doPullWork(ctx, slot, workReader)
+	} else if s.Info.Method == WorkerMethodExec {
+		result, err = s.doExecWork(ctx, slot, workReader)
+	} else {
+		result, err = s.doPushWork(ctx, slot, workReader, client)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return cancelOnClose{result, cancel}, nil
+}
+
+// Type cancelOnClose cancels a context when the wrapped result is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
 }
 
-func (s *WorkerState) DoWork(workReader io.Reader, client *http.Client) (io.ReadCloser, error) {
-	// Mark worker as busy until it reports back.
-	s.setIdle(false)
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
 
+// Posts work to a worker using WorkerMethodPush and returns the response body.
+func (s *WorkerState) doPushWork(ctx context.Context, slot int, workReader io.Reader, client *http.Client) (io.ReadCloser, error) {
 	// Do ectual HTTP request
-	resp, err := client.Post(s.Info.PushURL, "application/octet-stream", workReader)
+	req, err := http.NewRequest("POST", s.Info.PushURL, workReader)
+	if err != nil {
+		s.setBusy(slot, false)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		// There is no guarantee that the worker will report back, so we need to assume it is idle
-		s.setIdle(true)
+		s.setBusy(slot, false)
 		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusInternalServerError {
-		// TODO: Need a way for the worker to signal it will report back after an error
-		s.setIdle(true)
+		// Push workers report back by registering again after an internal error. Workers
+		// wishing to report errors explicitly use WorkerMethodPull.
+		s.setBusy(slot, false)
 	}
 
+	s.touch()
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
 		return nil, fmt.Errorf("Worker returned status: %v", resp.Status)
@@ -0,0 +1,60 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package workersdk_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/indyjo/bitwrk/client/workersdk"
+)
+
+// Function echo is a handler returning the work as result.
+func echo(ctx context.Context, work io.Reader) (io.ReadCloser, error) {
+	if data, err := ioutil.ReadAll(work); err != nil {
+		return nil, err
+	} else {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// An echo worker selling article "foobar" through the BitWrk client running on the same host.
+// It stops on Ctrl+C.
+func ExampleWorker() {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
+
+	worker := workersdk.Worker{
+		Id:      "echo-1",
+		Article: "foobar",
+		Handler: workersdk.HandlerFunc(echo),
+		Token:   os.Getenv("BITWRK_TOKEN"),
+	}
+	if err := worker.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,272 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package workersdk helps writing BitWrk workers in Go. A worker registers with a running
+// BitWrk client, which pushes work to it via HTTP (method "http-push"). The worker keeps
+// its registration alive by sending heartbeats and unregisters when it shuts down.
+package workersdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interface Handler performs the actual work. The result is sent back to the BitWrk client
+// and closed afterwards. An error makes the BitWrk client reject the work.
+type Handler interface {
+	Handle(ctx context.Context, work io.Reader) (io.ReadCloser, error)
+}
+
+// Type HandlerFunc lets ordinary functions be used as handlers.
+type HandlerFunc func(ctx context.Context, work io.Reader) (io.ReadCloser, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, work io.Reader) (io.ReadCloser, error) {
+	return f(ctx, work)
+}
+
+// The URL of a BitWrk client running on the same host with default settings.
+const DefaultClientURL = "http://127.0.0.1:8081/"
+
+// The interval between heartbeats if none is given and the worker has no lease, in which
+// case heartbeats only tell whether the BitWrk client has forgotten about the worker.
+const DefaultHeartbeatInterval = 30 * time.Second
+
+// Workers with a lease send this many heartbeats per lease if no interval is given, so
+// that a single lost heartbeat doesn't cost them the lease.
+const heartbeatsPerLease = 3
+
+// Type Worker describes a worker and how to reach the BitWrk client.
+type Worker struct {
+	Id       string  // ID the worker registers under
+	Article  string  // The article the worker sells
+	Handler  Handler // Performs the work
+	Capacity int     // Number of jobs processed concurrently. Zero means one.
+
+	ClientURL string // URL of the BitWrk client's internal port. Defaults to DefaultClientURL.
+	Token     string // API token with scope "worker", needed if the BitWrk client runs on another host
+
+	// Address to listen for work on. Defaults to "127.0.0.1:0", i.e. a random port on the
+	// loopback interface.
+	ListenAddr string
+	// URL the BitWrk client pushes work to. Defaults to the listener's address, which
+	// only works if it is a specific (not unspecified) IP address.
+	PushURL string
+
+	Lease             time.Duration // Lease to ask for. Zero means the BitWrk client's default, usually none.
+	HeartbeatInterval time.Duration // Must be shorter than Lease. Defaults to a third of Lease, or DefaultHeartbeatInterval.
+
+	Client *http.Client // Used for contacting the BitWrk client. Defaults to http.DefaultClient.
+	Log    *log.Logger  // Defaults to the standard logger
+}
+
+// Registers the worker, serves work until the context is cancelled and unregisters the
+// worker again. Returns nil after a regular shutdown.
+func (w *Worker) Run(ctx context.Context) error {
+	if w.Id == "" || w.Article == "" || w.Handler == nil {
+		return errors.New("Worker needs an Id, an Article and a Handler")
+	}
+	interval, err := w.heartbeatInterval()
+	if err != nil {
+		return err
+	}
+
+	listenAddr := w.ListenAddr
+	if listenAddr == "" {
+		listenAddr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	pushURL := w.PushURL
+	if pushURL == "" {
+		if addr, ok := listener.Addr().(*net.TCPAddr); !ok || addr.IP.IsUnspecified() {
+			return fmt.Errorf("Worker listens on %v and needs a PushURL", listener.Addr())
+		} else {
+			pushURL = "http://" + addr.String() + "/work"
+		}
+	}
+
+	if err := w.register(pushURL); err != nil {
+		return fmt.Errorf("Error registering worker: %v", err)
+	}
+	w.logf("Registered worker %#v for article %#v, receiving work on %v", w.Id, w.Article, pushURL)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/work", func(rw http.ResponseWriter, r *http.Request) {
+		w.serveWork(pushURL, rw, r)
+	})
+	server := &http.Server{Handler: mux}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.heartbeat(pushURL)
+		case err := <-served:
+			w.unregister()
+			return err
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := server.Shutdown(shutdownCtx)
+			cancel()
+			if err := w.unregister(); err != nil {
+				w.logf("Error unregistering worker: %v", err)
+			}
+			return err
+		}
+	}
+}
+
+// Returns the interval between heartbeats, which must be shorter than the lease.
+func (w *Worker) heartbeatInterval() (time.Duration, error) {
+	interval := w.HeartbeatInterval
+	if interval <= 0 && w.Lease > 0 {
+		interval = w.Lease / heartbeatsPerLease
+	} else if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	if w.Lease > 0 && interval >= w.Lease {
+		return 0, fmt.Errorf("Heartbeat interval %v must be shorter than lease %v", interval, w.Lease)
+	}
+	return interval, nil
+}
+
+// Handles work pushed by the BitWrk client. Afterwards, the worker registers again to tell
+// the BitWrk client that it is idle.
+func (w *Worker) serveWork(pushURL string, rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	defer func() {
+		if err := w.register(pushURL); err != nil {
+			w.logf("Error reporting idle: %v", err)
+		}
+	}()
+
+	result, err := w.Handler.Handle(r.Context(), r.Body)
+	if err != nil {
+		w.logf("Error handling work: %v", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer result.Close()
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(rw, result); err != nil {
+		w.logf("Error sending result: %v", err)
+		return
+	}
+	if f, ok := rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *Worker) register(pushURL string) error {
+	capacity := w.Capacity
+	if capacity < 1 {
+		capacity = 1
+	}
+	params := url.Values{
+		"id":       {w.Id},
+		"article":  {w.Article},
+		"method":   {"http-push"},
+		"pushurl":  {pushURL},
+		"capacity": {strconv.Itoa(capacity)},
+	}
+	if w.Lease > 0 {
+		params.Set("lease", w.Lease.String())
+	}
+	_, err := w.post("registerworker", params)
+	return err
+}
+
+// Renews the worker's lease, registering again if the BitWrk client has forgotten about it.
+func (w *Worker) heartbeat(pushURL string) {
+	if status, err := w.post("worker/heartbeat", url.Values{"id": {w.Id}}); status == http.StatusNotFound {
+		w.logf("Worker was unregistered by BitWrk client. Registering again.")
+		if err := w.register(pushURL); err != nil {
+			w.logf("Error registering worker: %v", err)
+		}
+	} else if err != nil {
+		w.logf("Error sending heartbeat: %v", err)
+	}
+}
+
+func (w *Worker) unregister() error {
+	_, err := w.post("unregisterworker", url.Values{"id": {w.Id}})
+	return err
+}
+
+// Posts a form to the BitWrk client. Returns the response's status code and an error
+// if the request failed or returned another status than "200 OK".
+func (w *Worker) post(path string, params url.Values) (int, error) {
+	clientURL := w.ClientURL
+	if clientURL == "" {
+		clientURL = DefaultClientURL
+	}
+	if !strings.HasSuffix(clientURL, "/") {
+		clientURL += "/"
+	}
+	req, err := http.NewRequest("POST", clientURL+path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("BitWrk client returned status: %v", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (w *Worker) logf(format string, args ...interface{}) {
+	if w.Log != nil {
+		w.Log.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
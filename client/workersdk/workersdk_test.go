@@ -0,0 +1,216 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package workersdk_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/client/workersdk"
+)
+
+// Type fakeClient records the requests a worker sends to the BitWrk client.
+type fakeClient struct {
+	mutex     sync.Mutex
+	requests  []string
+	pushURL   string
+	forgotten bool      // answer heartbeats with "404 Not Found"
+	changed   chan bool // notified on each request
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{changed: make(chan bool, 100)}
+}
+
+func (c *fakeClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer func() {
+		c.mutex.Unlock()
+		select {
+		case c.changed <- true:
+		default:
+		}
+	}()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	c.requests = append(c.requests, r.URL.Path)
+	switch r.URL.Path {
+	case "/registerworker":
+		if r.FormValue("id") != "echo-1" || r.FormValue("article") != "foobar" ||
+			r.FormValue("method") != "http-push" || r.FormValue("capacity") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.pushURL = r.FormValue("pushurl")
+		c.forgotten = false
+	case "/worker/heartbeat":
+		if c.forgotten {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case "/unregisterworker":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Waits until the fake client has received a request to the given path n times.
+func (c *fakeClient) await(t *testing.T, path string, n int) {
+	timeout := time.After(5 * time.Second)
+	for {
+		c.mutex.Lock()
+		count := 0
+		for _, p := range c.requests {
+			if p == path {
+				count++
+			}
+		}
+		c.mutex.Unlock()
+		if count >= n {
+			return
+		}
+		select {
+		case <-c.changed:
+		case <-timeout:
+			t.Fatalf("Timeout waiting for %v request #%v", path, n)
+		}
+	}
+}
+
+func startWorker(t *testing.T, handler workersdk.Handler) (*fakeClient, context.CancelFunc, <-chan error) {
+	return runWorker(t, workersdk.Worker{
+		Id:                "echo-1",
+		Article:           "foobar",
+		Handler:           handler,
+		Token:             "secret",
+		HeartbeatInterval: 20 * time.Millisecond,
+	})
+}
+
+// Runs the worker against a fake client and waits for it to register.
+func runWorker(t *testing.T, worker workersdk.Worker) (*fakeClient, context.CancelFunc, <-chan error) {
+	client := newFakeClient()
+	server := httptest.NewServer(client)
+	worker.ClientURL = server.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Run(ctx)
+		server.Close()
+	}()
+	client.await(t, "/registerworker", 1)
+	return client, cancel, done
+}
+
+func Test_EchoWorker(t *testing.T) {
+	client, cancel, done := startWorker(t, workersdk.HandlerFunc(echo))
+
+	resp, err := http.Post(client.pushURL, "application/octet-stream", strings.NewReader("Hello, BitWrk!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(result) != "Hello, BitWrk!" {
+		t.Errorf("Unexpected result: %v %#v", resp.Status, string(result))
+	}
+
+	// The worker reports idle by registering again
+	client.await(t, "/registerworker", 2)
+	client.await(t, "/worker/heartbeat", 1)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	client.await(t, "/unregisterworker", 1)
+}
+
+func Test_FailingWorker(t *testing.T) {
+	client, cancel, done := startWorker(t, workersdk.HandlerFunc(
+		func(ctx context.Context, work io.Reader) (io.ReadCloser, error) {
+			return nil, errors.New("failed")
+		}))
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	resp, err := http.Post(client.pushURL, "application/octet-stream", strings.NewReader("work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Unexpected status: %v", resp.Status)
+	}
+	client.await(t, "/registerworker", 2)
+}
+
+func Test_ReregisterWhenForgotten(t *testing.T) {
+	client, cancel, done := startWorker(t, workersdk.HandlerFunc(echo))
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client.mutex.Lock()
+	client.forgotten = true
+	client.mutex.Unlock()
+	client.await(t, "/registerworker", 2)
+}
+
+func Test_HeartbeatsFollowLease(t *testing.T) {
+	client, cancel, done := runWorker(t, workersdk.Worker{
+		Id:      "echo-1",
+		Article: "foobar",
+		Handler: workersdk.HandlerFunc(echo),
+		Token:   "secret",
+		Lease:   150 * time.Millisecond,
+	})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	client.await(t, "/worker/heartbeat", 3)
+}
+
+func Test_HeartbeatIntervalExceedsLease(t *testing.T) {
+	worker := workersdk.Worker{
+		Id:                "echo-1",
+		Article:           "foobar",
+		Handler:           workersdk.HandlerFunc(echo),
+		ClientURL:         "http://127.0.0.1:1/",
+		Lease:             time.Minute,
+		HeartbeatInterval: time.Minute,
+	}
+	if err := worker.Run(context.Background()); err == nil {
+		t.Errorf("Expected heartbeat interval not shorter than lease to be rejected")
+	}
+}
@@ -367,9 +367,10 @@ const (
 	AccountMovementTransaction
 	AccountMovementTransactionFinish
 	AccountMovementTransactionReimburse
+	AccountMovementPayOutRequest
 
 	accountMovementTypeFirst = AccountMovementInvalid
-	accountMovementTypeLast  = AccountMovementTransactionReimburse
+	accountMovementTypeLast  = AccountMovementPayOutRequest
 )
 
 func (t AccountMovementType) String() string {
@@ -390,6 +391,8 @@ func (t AccountMovementType) String() string {
 		return "TRANSACTION_FINISH"
 	case AccountMovementTransactionReimburse:
 		return "TRANSACTION_REIMBURSE"
+	case AccountMovementPayOutRequest:
+		return "WITHDRAWAL_REQUEST"
 	}
 	return fmt.Sprintf("<Invalid Account Movement Type: %v>", int8(t))
 }
@@ -530,7 +533,17 @@ func (m *AccountMovement) Validate() (err error) {
 	case AccountMovementPayIn:
 		err = m.checkCashFlowDirection(2, 0, 0, -2)
 	case AccountMovementPayOut:
-		err = m.checkCashFlowDirection(-2, 0, 0, 2)
+		// Money leaves either directly (negative injections) or after having been
+		// blocked by a withdrawal request.
+		if m.BlockedDelta.Amount != 0 {
+			err = m.checkCashFlowDirection(0, -2, 0, 2)
+		} else {
+			err = m.checkCashFlowDirection(-2, 0, 0, 2)
+		}
+	case AccountMovementPayOutRequest:
+		err = m.checkCashFlowDirection(-2, 2, 0, 0)
+	case AccountMovementPayOutReimburse:
+		err = m.checkCashFlowDirection(2, -2, 0, 0)
 	default:
 		err = fmt.Errorf("Invalid account movement type %v", m.Type)
 	}
@@ -110,4 +110,5 @@ func Test_AccountMovementType_JSON(t *testing.T) {
 	test(AccountMovementTransaction, "TRANSACTION")
 	test(AccountMovementTransactionFinish, "TRANSACTION_FINISH")
 	test(AccountMovementTransactionReimburse, "TRANSACTION_REIMBURSE")
+	test(AccountMovementPayOutRequest, "WITHDRAWAL_REQUEST")
 }
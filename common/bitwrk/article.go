@@ -0,0 +1,273 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+)
+
+// Suffix of article IDs that may only be sold by trusted sellers.
+const TrustedSuffix = "~trusted"
+
+// Type PhaseTimeouts specifies how much time a transaction is granted for each of its
+// phases. A zero value means that the corresponding default is used.
+type PhaseTimeouts struct {
+	Establishing time.Duration // Time from matching until both parties have established
+	Transmitting time.Duration // Time granted for transmitting the work data
+	Working      time.Duration // Time granted for computing and transmitting the result
+	Unverified   time.Duration // Time granted to the buyer for verifying the result
+}
+
+// The timeouts applied to transactions of articles without their own settings.
+var DefaultPhaseTimeouts = PhaseTimeouts{
+	Establishing: 60 * time.Second,
+	Transmitting: 2 * time.Minute,
+	Working:      5 * time.Minute,
+	Unverified:   15 * time.Minute,
+}
+
+// Returns the time granted on arrival at the given phase, falling back to the default
+// if no specific value has been set.
+func (t PhaseTimeouts) For(phase TxPhase) time.Duration {
+	var d, def time.Duration
+	switch phase {
+	case PhaseEstablishing:
+		d, def = t.Establishing, DefaultPhaseTimeouts.Establishing
+	case PhaseTransmitting:
+		d, def = t.Transmitting, DefaultPhaseTimeouts.Transmitting
+	case PhaseWorking:
+		d, def = t.Working, DefaultPhaseTimeouts.Working
+	case PhaseUnverified:
+		d, def = t.Unverified, DefaultPhaseTimeouts.Unverified
+	}
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// Formats the timeouts as a comma-separated list of durations, in order of the phases.
+// Unset values are left empty.
+func (t PhaseTimeouts) String() string {
+	parts := make([]string, 4)
+	for i, d := range []time.Duration{t.Establishing, t.Transmitting, t.Working, t.Unverified} {
+		if d != 0 {
+			parts[i] = d.String()
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parses the format produced by PhaseTimeouts.String(). An empty string means that
+// all defaults are used.
+func ParsePhaseTimeouts(s string) (PhaseTimeouts, error) {
+	var result PhaseTimeouts
+	if s == "" {
+		return result, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return result, fmt.Errorf("Expected 4 comma-separated timeouts, got %v", len(parts))
+	}
+	values := make([]time.Duration, 4)
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(part); err != nil {
+			return result, err
+		} else if d <= 0 {
+			return result, fmt.Errorf("Timeout must be positive: %v", d)
+		} else {
+			values[i] = d
+		}
+	}
+	result.Establishing, result.Transmitting, result.Working, result.Unverified =
+		values[0], values[1], values[2], values[3]
+	return result, nil
+}
+
+// Type Article is an entry of the server's article catalog. It specifies whether an
+// article is traded and which rules apply to its bids and transactions.
+// Catalog entries are signed by the server's catalog account.
+type Article struct {
+	Id          ArticleId
+	Description string
+	Enabled     bool          // Whether bids on this article are accepted
+	Timeouts    PhaseTimeouts // Per-phase timeouts of transactions
+	// Maximum size of work data in bytes, enforced by clients. Zero means unlimited.
+	MaxWorkSize int64
+	// Fee ratio applied to new bids. A zero denominator means that the server default is used.
+	FeeRatioNumerator, FeeRatioDenominator int64
+	Document, Signature                    string    // For verifying authenticity
+	LastModified                           time.Time // When the entry was created or last modified
+}
+
+// Returns the article's fee ratio in the "numerator/denominator" notation, or an empty
+// string if the server default is used.
+func (a *Article) FeeRatio() string {
+	if a.FeeRatioDenominator == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v/%v", a.FeeRatioNumerator, a.FeeRatioDenominator)
+}
+
+// Returns the article's maximum work size as a string, or an empty string if unlimited.
+func (a *Article) MaxWorkSizeString() string {
+	if a.MaxWorkSize == 0 {
+		return ""
+	}
+	return strconv.FormatInt(a.MaxWorkSize, 10)
+}
+
+func (a *Article) String() string {
+	return fmt.Sprintf("%v [enabled:%v timeouts:%v maxworksize:%v feeratio:%v]",
+		a.Id, a.Enabled, a.Timeouts, a.MaxWorkSize, a.FeeRatio())
+}
+
+// Function ArticleBase strips the "~trusted" suffix from an article ID, if present.
+func ArticleBase(id ArticleId) (base ArticleId, trusted bool) {
+	if strings.HasSuffix(string(id), TrustedSuffix) {
+		return id[:len(id)-len(TrustedSuffix)], true
+	}
+	return id, false
+}
+
+// Builds the document which is checked against an article's signature.
+// It is built like a URL query, with parameters ordered strictly alphabetically.
+func articleDocument(id, description, enabled, feeratio, maxworksize, nonce, timeouts string) string {
+	return fmt.Sprintf(
+		"article=%s&description=%s&enabled=%s&feeratio=%s&maxworksize=%s&nonce=%s&timeouts=%s",
+		normalize(id),
+		normalize(description),
+		normalize(enabled),
+		normalize(feeratio),
+		normalize(maxworksize),
+		normalize(nonce),
+		normalize(timeouts))
+}
+
+var errInvalidArticleId = errors.New("Invalid article id")
+
+// Function ParseArticle creates a catalog entry from the fields of a signed document.
+// Empty timeouts, maxworksize and feeratio mean that the respective defaults are used.
+func ParseArticle(id, description, enabled, timeouts, maxworksize, feeratio, nonce, signature string) (*Article, error) {
+	if enabled != "true" && enabled != "false" {
+		return nil, errNoBoolean
+	}
+	if id == "" || strings.HasSuffix(id, TrustedSuffix) {
+		return nil, errInvalidArticleId
+	}
+
+	result := Article{
+		Id:           ArticleId(id),
+		Description:  description,
+		Enabled:      enabled == "true",
+		Document:     articleDocument(id, description, enabled, feeratio, maxworksize, nonce, timeouts),
+		Signature:    signature,
+		LastModified: time.Now(),
+	}
+
+	if t, err := ParsePhaseTimeouts(timeouts); err != nil {
+		return nil, err
+	} else {
+		result.Timeouts = t
+	}
+
+	if maxworksize != "" {
+		if n, err := strconv.ParseInt(maxworksize, 10, 64); err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, fmt.Errorf("Invalid maximum work size: %v", n)
+		} else {
+			result.MaxWorkSize = n
+		}
+	}
+
+	if feeratio != "" {
+		var num, den int64
+		if n, err := fmt.Sscanf(feeratio, "%d/%d", &num, &den); err != nil || n != 2 {
+			return nil, fmt.Errorf("Invalid fee ratio: %#v", feeratio)
+		} else if num < 0 || den <= 0 || num > den {
+			return nil, fmt.Errorf("Fee ratio out of range: %#v", feeratio)
+		}
+		result.FeeRatioNumerator, result.FeeRatioDenominator = num, den
+	}
+
+	return &result, nil
+}
+
+// Verifies that the article's document has been signed by the given account.
+func (a *Article) Verify(signer string) error {
+	if err := bitcoin.VerifySignatureBase64(a.Document, signer, a.Signature); err != nil {
+		return fmt.Errorf("Could not validate signature: %v", err)
+	}
+	return nil
+}
+
+// Signs the article using the given key and random number sources.
+func (a *Article) SignWith(key *bitcoin.KeyPair, rand io.Reader, nonce string) error {
+	doc := articleDocument(string(a.Id), a.Description, strconv.FormatBool(a.Enabled),
+		a.FeeRatio(), a.MaxWorkSizeString(), nonce, a.Timeouts.String())
+	if sig, err := key.SignMessage(doc, rand); err != nil {
+		return err
+	} else {
+		a.Document = doc
+		a.Signature = sig
+		return nil
+	}
+}
+
+var blenderRegexp = regexp.MustCompile(`^(net\.bitwrk/blender/0/2\.(69|7[0-9]|8[0-2])/(512M|2G|8G|32G))$`)
+
+// Function BuiltinArticle returns the catalog entry used for articles that have no entry
+// in the server's catalog, or nil if the article is not traded by default.
+func BuiltinArticle(id ArticleId) *Article {
+	switch id {
+	case "fnord", "snafu", "foobar", "net.bitwrk/gorays/0":
+	default:
+		if !blenderRegexp.MatchString(string(id)) {
+			return nil
+		}
+	}
+	return &Article{Id: id, Enabled: true}
+}
+
+// Function LookupArticle returns the catalog entry applying to an article ID, ignoring
+// any "~trusted" suffix. Articles without an entry of their own fall back to the
+// built-in defaults. Returns ErrNoSuchObject if neither exists.
+func LookupArticle(dao AccountingDao, id ArticleId) (*Article, error) {
+	base, _ := ArticleBase(id)
+	if article, err := dao.GetArticle(base); err == ErrNoSuchObject {
+		if article = BuiltinArticle(base); article == nil {
+			return nil, ErrNoSuchObject
+		}
+		return article, nil
+	} else if err != nil {
+		return nil, err
+	} else {
+		return article, nil
+	}
+}
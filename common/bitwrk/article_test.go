@@ -0,0 +1,103 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseTimeouts(t *testing.T) {
+	if timeouts, err := ParsePhaseTimeouts(",,30m,"); err != nil {
+		t.Fatal(err)
+	} else if timeouts.For(PhaseWorking) != 30*time.Minute {
+		t.Errorf("Expected 30m for WORKING, got %v", timeouts.For(PhaseWorking))
+	} else if timeouts.For(PhaseTransmitting) != DefaultPhaseTimeouts.Transmitting {
+		t.Errorf("Expected default for TRANSMITTING, got %v", timeouts.For(PhaseTransmitting))
+	} else if s := timeouts.String(); s != ",,30m0s," {
+		t.Errorf("Unexpected string representation: %#v", s)
+	}
+
+	for _, s := range []string{"1m", "1m,2m,3m,4m,5m", "1m,,,-1m", "1m,x,,"} {
+		if _, err := ParsePhaseTimeouts(s); err == nil {
+			t.Errorf("Expected error parsing %#v", s)
+		}
+	}
+}
+
+func TestParseArticle(t *testing.T) {
+	a, err := ParseArticle("net.bitwrk/test/0", "A test article", "true", "30s,1m,1h,10m", "1048576", "1/50", "123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Timeouts.Working != time.Hour || a.MaxWorkSize != 1048576 || a.FeeRatio() != "1/50" {
+		t.Errorf("Unexpected article: %v", a)
+	}
+	expected := "article=net.bitwrk%2Ftest%2F0&description=Atestarticle&enabled=true&feeratio=1%2F50" +
+		"&maxworksize=1048576&nonce=123&timeouts=30s%2C1m%2C1h%2C10m"
+	if a.Document != expected {
+		t.Errorf("Unexpected document:\n%v\nExpected:\n%v", a.Document, expected)
+	}
+
+	for _, args := range [][]string{
+		{"foo~trusted", "true", "", "", ""},
+		{"foo", "yes", "", "", ""},
+		{"foo", "true", "1m", "", ""},
+		{"foo", "true", "", "-1", ""},
+		{"foo", "true", "", "", "2/1"},
+		{"foo", "true", "", "", "1/0"},
+	} {
+		if _, err := ParseArticle(args[0], "", args[1], args[2], args[3], args[4], "", ""); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+}
+
+func TestLookupArticle(t *testing.T) {
+	dao := newMemDao()
+	if a, err := LookupArticle(dao, "net.bitwrk/blender/0/2.79/2G~trusted"); err != nil {
+		t.Errorf("Expected built-in article, got: %v", err)
+	} else if a.Id != "net.bitwrk/blender/0/2.79/2G" || !a.Enabled {
+		t.Errorf("Unexpected built-in article: %v", a)
+	}
+	if _, err := LookupArticle(dao, "net.bitwrk/blender/0/2.60/2G"); err != ErrNoSuchObject {
+		t.Errorf("Expected ErrNoSuchObject, got: %v", err)
+	}
+}
+
+func TestNewTransactionTimeouts(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	sell := &Bid{Type: Sell, Article: "foo", State: Placed, Expires: now.Add(time.Minute)}
+	buy := &Bid{Type: Buy, Article: "foo", State: InQueue, Expires: now.Add(time.Minute)}
+	tx, err := NewTransaction(now, PhaseTimeouts{Establishing: 10 * time.Second, Working: time.Hour}, "b", "s", buy, sell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Timeout.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("Unexpected initial timeout: %v", tx.Timeout)
+	}
+	timeout := tx.Timeout
+	phaseArrivalFuncs[PhaseTransmitting](tx, now)
+	if !tx.Timeout.Equal(timeout.Add(DefaultPhaseTimeouts.Transmitting)) {
+		t.Errorf("Expected default time to be granted for TRANSMITTING, got timeout %v", tx.Timeout)
+	}
+	timeout = tx.Timeout
+	phaseArrivalFuncs[PhaseWorking](tx, now)
+	if !tx.Timeout.Equal(timeout.Add(time.Hour)) {
+		t.Errorf("Expected one hour to be granted for WORKING, got timeout %v", tx.Timeout)
+	}
+}
@@ -41,8 +41,14 @@ type AccountingDao interface {
 	GetDeposit(uid string) (Deposit, error)
 	SaveDeposit(uid string, deposit *Deposit) error
 
+	GetWithdrawal(uid string) (Withdrawal, error)
+	SaveWithdrawal(uid string, withdrawal *Withdrawal) error
+
 	GetRelation(source, target string, reltype RelationType) (*Relation, error)
 	SaveRelation(relation *Relation) error
+
+	GetArticle(id ArticleId) (*Article, error)
+	SaveArticle(article *Article) error
 }
 
 type CachedAccountingDao interface {
@@ -65,13 +71,15 @@ type cachedAccountingDao struct {
 	// Whether the underlying DAO has transactional properties
 	transactional bool
 	// Cache all objects read _and_ written since the creation of the cached DAO.
-	accounts  map[string]ParticipantAccount
-	deposits  map[string]Deposit
-	movements map[string]AccountMovement
+	accounts    map[string]ParticipantAccount
+	deposits    map[string]Deposit
+	withdrawals map[string]Withdrawal
+	movements   map[string]AccountMovement
 	// Store which objects have changed and must be written back to the delegate.
-	savedAccounts  map[string]bool
-	savedDeposits  map[string]bool
-	savedMovements map[string]bool
+	savedAccounts    map[string]bool
+	savedDeposits    map[string]bool
+	savedWithdrawals map[string]bool
+	savedMovements   map[string]bool
 }
 
 // Creates a new cached accounting DAO. This DAO provides the following benefits:
@@ -89,9 +97,11 @@ func NewCachedAccountingDao(delegate AccountingDao, transactional bool) CachedAc
 	result.transactional = transactional
 	result.accounts = make(map[string]ParticipantAccount)
 	result.deposits = make(map[string]Deposit)
+	result.withdrawals = make(map[string]Withdrawal)
 	result.movements = make(map[string]AccountMovement)
 	result.savedAccounts = make(map[string]bool)
 	result.savedDeposits = make(map[string]bool)
+	result.savedWithdrawals = make(map[string]bool)
 	result.savedMovements = make(map[string]bool)
 	return result
 }
@@ -177,6 +187,25 @@ func (c *cachedAccountingDao) SaveDeposit(uid string, deposit *Deposit) error {
 	return nil
 }
 
+func (c *cachedAccountingDao) GetWithdrawal(uid string) (Withdrawal, error) {
+	if withdrawal, ok := c.withdrawals[uid]; ok {
+		return withdrawal, nil
+	}
+
+	if withdrawal, err := c.delegate.GetWithdrawal(uid); err != nil {
+		return Withdrawal{}, err
+	} else {
+		c.withdrawals[uid] = withdrawal
+		return withdrawal, nil
+	}
+}
+
+func (c *cachedAccountingDao) SaveWithdrawal(uid string, withdrawal *Withdrawal) error {
+	c.withdrawals[uid] = *withdrawal
+	c.savedWithdrawals[uid] = true
+	return nil
+}
+
 func (c *cachedAccountingDao) GetRelation(source, target string, reltype RelationType) (*Relation, error) {
 	return c.delegate.GetRelation(source, target, reltype)
 }
@@ -185,6 +214,14 @@ func (c *cachedAccountingDao) SaveRelation(relation *Relation) error {
 	return c.delegate.SaveRelation(relation)
 }
 
+func (c *cachedAccountingDao) GetArticle(id ArticleId) (*Article, error) {
+	return c.delegate.GetArticle(id)
+}
+
+func (c *cachedAccountingDao) SaveArticle(article *Article) error {
+	return c.delegate.SaveArticle(article)
+}
+
 func (c *cachedAccountingDao) Flush() error {
 	if !c.transactional {
 		return ErrNotTransactional
@@ -204,6 +241,13 @@ func (c *cachedAccountingDao) Flush() error {
 		}
 		delete(c.savedDeposits, k)
 	}
+	for k := range c.savedWithdrawals {
+		withdrawal := c.withdrawals[k]
+		if err := c.delegate.SaveWithdrawal(k, &withdrawal); err != nil {
+			return err
+		}
+		delete(c.savedWithdrawals, k)
+	}
 	for k := range c.savedMovements {
 		movement := c.movements[k]
 		if err := c.delegate.SaveMovement(&movement); err != nil {
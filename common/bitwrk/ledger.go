@@ -0,0 +1,128 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"fmt"
+
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Type LedgerDiscrepancy describes a problem found while verifying an account's ledger.
+type LedgerDiscrepancy struct {
+	MovementKey string // The offending account movement, or empty if the account itself is affected
+	Problem     string
+}
+
+func (d LedgerDiscrepancy) String() string {
+	if d.MovementKey == "" {
+		return d.Problem
+	}
+	return fmt.Sprintf("%v: %v", d.MovementKey, d.Problem)
+}
+
+// Type LedgerReport is the result of verifying a participant's account against the chain
+// of account movements leading up to it.
+type LedgerReport struct {
+	Participant   string
+	Movements     int         // Number of account movements visited
+	Available     money.Money // Available balance recomputed from the chain
+	Blocked       money.Money // Blocked balance recomputed from the chain
+	Discrepancies []LedgerDiscrepancy
+}
+
+// Returns true if no discrepancies have been found.
+func (r *LedgerReport) Ok() bool {
+	return len(r.Discrepancies) == 0
+}
+
+func (r *LedgerReport) addf(key string, format string, args ...interface{}) {
+	r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{key, fmt.Sprintf(format, args...)})
+}
+
+// Function VerifyLedger walks the chain of account movements of an account, beginning at
+// its last movement and following the predecessor keys. It replays the available and
+// blocked deltas and checks that every movement is sound, i.e. that its deltas, fee and
+// world amounts sum up to zero. The recomputed balances must equal the account's balances.
+// Inconsistencies are reported as discrepancies. An error is returned only if movements
+// couldn't be retrieved.
+func VerifyLedger(account *ParticipantAccount, getMovement func(key string) (AccountMovement, error)) (*LedgerReport, error) {
+	participant := account.Participant
+	report := &LedgerReport{Participant: participant}
+	var available, blocked int64
+
+	visited := make(map[string]bool)
+	key := account.LastMovementKey
+	for key != nil {
+		if visited[*key] {
+			report.addf(*key, "Chain contains a cycle")
+			break
+		}
+		visited[*key] = true
+
+		m, err := getMovement(*key)
+		if err == ErrNoSuchObject {
+			report.addf(*key, "Account movement is missing")
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		report.Movements++
+
+		if err := m.Validate(); err != nil {
+			report.addf(*key, "Account movement doesn't validate: %v", err)
+		}
+
+		isAvailable := m.AvailableAccount == participant
+		isBlocked := m.BlockedAccount == participant
+		if isAvailable {
+			if m.AvailableDelta.Amount != 0 && m.AvailableDelta.Currency != account.Currency {
+				report.addf(*key, "Available delta %v doesn't match account currency %v", m.AvailableDelta, account.Currency)
+			}
+			available += m.AvailableDelta.Amount
+		}
+		if isBlocked {
+			if m.BlockedDelta.Amount != 0 && m.BlockedDelta.Currency != account.Currency {
+				report.addf(*key, "Blocked delta %v doesn't match account currency %v", m.BlockedDelta, account.Currency)
+			}
+			blocked += m.BlockedDelta.Amount
+		}
+
+		// If both parts of the movement refer to this account, the blocked predecessor
+		// key is redundant (and, depending on the data store, not even stored).
+		if isAvailable {
+			key = m.AvailablePredecessorKey
+		} else if isBlocked {
+			key = m.BlockedPredecessorKey
+		} else {
+			report.addf(*key, "Account movement doesn't refer to %v", participant)
+			break
+		}
+	}
+
+	report.Available = money.Money{Amount: available, Currency: account.Currency}
+	report.Blocked = money.Money{Amount: blocked, Currency: account.Currency}
+	if available != account.AvailableAmount {
+		report.addf("", "Available balance is %v, but movements sum up to %v",
+			money.Money{Amount: account.AvailableAmount, Currency: account.Currency}, report.Available)
+	}
+	if blocked != account.BlockedAmount {
+		report.addf("", "Blocked balance is %v, but movements sum up to %v",
+			money.Money{Amount: account.BlockedAmount, Currency: account.Currency}, report.Blocked)
+	}
+	return report, nil
+}
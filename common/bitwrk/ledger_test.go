@@ -0,0 +1,65 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+func TestVerifyLedger(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	dao := newMemDao()
+	deposit := Deposit{Type: DEPOSIT_TYPE_BITCOIN, Amount: money.MustParse("uBTC 1000"), Account: "alice", Created: now}
+	if err := deposit.Place("deposit-1", dao); err != nil {
+		t.Fatalf("Placing deposit failed: %v", err)
+	}
+	req := WithdrawalRequest{Participant: "alice", Amount: "uBTC 300", Address: "somewhere", Uid: "withdrawal-1"}
+	if w, err := NewWithdrawal(&req, now); err != nil {
+		t.Fatal(err)
+	} else if err := w.Place(req.Uid, dao); err != nil {
+		t.Fatalf("Placing withdrawal failed: %v", err)
+	}
+
+	account := dao.accounts["alice"]
+	if report, err := VerifyLedger(&account, dao.GetMovement); err != nil {
+		t.Fatal(err)
+	} else if !report.Ok() || report.Movements != 2 {
+		t.Errorf("Unexpected report: %#v", report)
+	} else if report.Available != money.MustParse("uBTC 700") || report.Blocked != money.MustParse("uBTC 300") {
+		t.Errorf("Unexpected balances: %v / %v", report.Available, report.Blocked)
+	}
+
+	// Tampering with the balance must be detected
+	account.AvailableAmount++
+	if report, err := VerifyLedger(&account, dao.GetMovement); err != nil {
+		t.Fatal(err)
+	} else if len(report.Discrepancies) != 1 || report.Discrepancies[0].MovementKey != "" {
+		t.Errorf("Expected one discrepancy concerning the account, got %v", report.Discrepancies)
+	}
+	account.AvailableAmount--
+
+	// So must a missing movement
+	delete(dao.movements, *account.LastMovementKey)
+	if report, err := VerifyLedger(&account, dao.GetMovement); err != nil {
+		t.Fatal(err)
+	} else if report.Ok() {
+		t.Errorf("Expected missing movement to be reported")
+	}
+}
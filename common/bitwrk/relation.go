@@ -31,6 +31,7 @@ type RelationType int
 const (
 	RELATION_TYPE_TRUSTS   RelationType = 1
 	RELATION_TYPE_WORKSFOR RelationType = 2
+	RELATION_TYPE_ARBITER  RelationType = 3 // Source appoints target as arbiter for disputed results
 )
 
 // Type relation describes a relationship between two participants.
@@ -51,6 +52,8 @@ func ParseRelationType(str string) (RelationType, error) {
 		return RELATION_TYPE_TRUSTS, nil
 	} else if str == "worksfor" {
 		return RELATION_TYPE_WORKSFOR, nil
+	} else if str == "arbiter" {
+		return RELATION_TYPE_ARBITER, nil
 	}
 	return 0, errNoSuchRelationType
 }
@@ -60,6 +63,8 @@ func (t RelationType) String() string {
 		return "trusts"
 	} else if t == RELATION_TYPE_WORKSFOR {
 		return "worksfor"
+	} else if t == RELATION_TYPE_ARBITER {
+		return "arbiter"
 	}
 	return fmt.Sprintf("<invalid relation type: %d>", int(t))
 }
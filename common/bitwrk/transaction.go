@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/indyjo/bitwrk/common/bitcoin"
@@ -114,6 +115,7 @@ const (
 	FromBuyer Origin = iota
 	FromSeller
 	FromUnknown
+	FromArbiter
 )
 
 func (o Origin) String() string {
@@ -124,6 +126,8 @@ func (o Origin) String() string {
 		return "Seller"
 	case FromUnknown:
 		return "Unknown"
+	case FromArbiter:
+		return "Arbiter"
 	}
 	return "From Invalid"
 }
@@ -151,6 +155,7 @@ const (
 	PhaseFinished
 	PhaseWorkDisputed
 	PhaseResultDisputed
+	PhaseArbitrated
 )
 
 type TxState int8
@@ -180,6 +185,8 @@ func (phase TxPhase) String() string {
 		return "WORK_DISPUTED"
 	case PhaseResultDisputed:
 		return "RESULT_DISPUTED"
+	case PhaseArbitrated:
+		return "ARBITRATED"
 	}
 	return fmt.Sprintf("<Unknown TxPhase %v>", int8(phase))
 }
@@ -204,6 +211,8 @@ func (phase *TxPhase) Parse(s string) error {
 		*phase = PhaseWorkDisputed
 	case "RESULT_DISPUTED":
 		*phase = PhaseResultDisputed
+	case "ARBITRATED":
+		*phase = PhaseArbitrated
 	default:
 		return fmt.Errorf("Invalid phase %#v", s)
 	}
@@ -274,6 +283,26 @@ type Transaction struct {
 	//   --> FINISHED
 	// or rejecting it
 	//   --> RESULT_DISPUTED
+
+	// A disputed result is judged by an arbiter appointed by the market operator.
+	// All information needed for that (WorkHash, EncryptedResultReceipt and
+	// ResultDecryptionKey) is part of the transaction. The arbiter signs a ruling
+	// stating which percentage of the price the seller receives. The rest is
+	// reimbursed to the buyer.
+	//   --> ARBITRATED
+	// If no ruling is made before the transaction times out, the buyer is reimbursed.
+	Arbiter     *string
+	SellerShare *int
+
+	// Time granted for each phase, taken from the article catalog when the transaction
+	// is created.
+	Timeouts PhaseTimeouts
+
+	// While WORKING, a seller who needs more time may propose extending the timeout.
+	// The extension takes effect when the buyer countersigns it by sending the same value.
+	ProposedExtension time.Duration
+	// Total time by which the timeout has been extended so far
+	Extension time.Duration
 }
 
 type messageHandlerFunc func(*Transaction, map[string]string) error
@@ -326,12 +355,18 @@ var phaseTransitionRules = []phaseTransitionRule{
 			{PhaseSellerEstablished, PhaseWorkDisputed},
 			{PhaseTransmitting, PhaseWorkDisputed},
 			{PhaseWorking, PhaseWorkDisputed}}},
+	{makeMessageType(FromSeller, "extendtimeout").with(handleProposeExtension),
+		[]phaseTransition{
+			{PhaseWorking, PhaseWorking}}},
+	{makeMessageType(FromBuyer, "extendtimeout").with(handleGrantExtension),
+		[]phaseTransition{
+			{PhaseWorking, PhaseWorking}}},
 	{makeMessageType(FromSeller, "encresulthash", "encresulthashsig", "encresultkey").with(handleTransmitFinished),
 		[]phaseTransition{
 			{PhaseWorking, PhaseUnverified}}},
 	{makeMessageType(FromBuyer, "rejectresult"),
 		[]phaseTransition{
-			{PhaseUnverified, PhaseWorkDisputed}}},
+			{PhaseUnverified, PhaseResultDisputed}}},
 	{makeMessageType(FromBuyer, "acceptresult"),
 		[]phaseTransition{
 			{PhaseEstablishing, PhaseFinished},
@@ -339,7 +374,11 @@ var phaseTransitionRules = []phaseTransitionRule{
 			{PhaseSellerEstablished, PhaseFinished},
 			{PhaseTransmitting, PhaseFinished},
 			{PhaseWorking, PhaseFinished},
-			{PhaseUnverified, PhaseFinished}}},
+			{PhaseUnverified, PhaseFinished},
+			{PhaseResultDisputed, PhaseFinished}}},
+	{makeMessageType(FromArbiter, "sellershare").with(handleRuling),
+		[]phaseTransition{
+			{PhaseResultDisputed, PhaseArbitrated}}},
 }
 
 // Function that is executed on a transaction upon arrival at a specific phase.
@@ -347,10 +386,17 @@ var phaseTransitionRules = []phaseTransitionRule{
 // It may not fail.
 type phaseArrivalFunc func(tx *Transaction, now time.Time)
 
-// Returns an arrival function that grants a specific amount of time
-func grantTime(t time.Duration) phaseArrivalFunc {
+// Returns an arrival function that grants the amount of time configured for the phase
+func grantTime(phase TxPhase) phaseArrivalFunc {
 	return func(tx *Transaction, _ time.Time) {
-		tx.Timeout = tx.Timeout.Add(t)
+		tx.Timeout = tx.Timeout.Add(tx.Timeouts.For(phase))
+	}
+}
+
+// Returns an arrival function that sets the timeout relative to the time of arrival
+func timeoutAfter(t time.Duration) phaseArrivalFunc {
+	return func(tx *Transaction, now time.Time) {
+		tx.Timeout = now.Add(t)
 	}
 }
 
@@ -358,16 +404,25 @@ func retireNow(tx *Transaction, now time.Time) {
 	tx.Timeout = now
 }
 
+// How long an arbiter has for ruling on a disputed result
+const ArbitrationPeriod = 72 * time.Hour
+
+// Upper bound of the total time by which buyer and seller may extend a transaction's timeout
+const MaxTimeoutExtension = 24 * time.Hour
+
 // What to do on arrival at specific transaction phases
 var phaseArrivalFuncs = map[TxPhase]phaseArrivalFunc{
-	PhaseTransmitting:   grantTime(2 * time.Minute),
-	PhaseWorking:        grantTime(5 * time.Minute),
-	PhaseUnverified:     grantTime(15 * time.Minute),
+	PhaseTransmitting:   grantTime(PhaseTransmitting),
+	PhaseWorking:        grantTime(PhaseWorking),
+	PhaseUnverified:     grantTime(PhaseUnverified),
 	PhaseFinished:       retireNow,
 	PhaseWorkDisputed:   retireNow,
-	PhaseResultDisputed: retireNow,
+	PhaseResultDisputed: timeoutAfter(ArbitrationPeriod),
+	PhaseArbitrated:     retireNow,
 }
 
+// Messages from arbiters are accepted from any address except the buyer's or the seller's.
+// Checking that the sender has actually been appointed as arbiter is the caller's duty.
 func (tx *Transaction) findMatchingRule(address string, arguments map[string]string) *phaseTransitionRule {
 rules:
 	for _, rule := range phaseTransitionRules {
@@ -377,6 +432,9 @@ rules:
 		if rule.messageType.from == FromSeller && address != tx.Seller {
 			continue
 		}
+		if rule.messageType.from == FromArbiter && (address == tx.Buyer || address == tx.Seller) {
+			continue
+		}
 		if len(arguments) != len(rule.messageType.arguments) {
 			continue
 		}
@@ -407,6 +465,9 @@ func (tx *Transaction) Identify(address string) (from Origin) {
 		from = FromSeller
 	default:
 		from = FromUnknown
+		if tx.Arbiter != nil && address == *tx.Arbiter {
+			from = FromArbiter
+		}
 	}
 	return
 }
@@ -447,6 +508,9 @@ func (tx *Transaction) SendMessage(now time.Time, address string, arguments map[
 		return
 	}
 
+	if rule.messageType.from == FromArbiter {
+		tx.Arbiter = &address
+	}
 	tx.Phase = transition.postPhase
 	tx.Revision += 1
 	result.Accepted = true
@@ -548,6 +612,62 @@ func handleBuyerSecret(tx *Transaction, arguments map[string]string) error {
 	return nil
 }
 
+func handleRuling(tx *Transaction, arguments map[string]string) error {
+	share, err := strconv.Atoi(arguments["sellershare"])
+	if err != nil {
+		return fmt.Errorf("Invalid seller share: %v", err)
+	}
+	if share < 0 || share > 100 {
+		return fmt.Errorf("Seller share must be between 0 and 100 percent, but is %v", share)
+	}
+	tx.SellerShare = &share
+	return nil
+}
+
+// Parses a proposed or granted extension and checks it against the upper bound.
+func parseExtension(tx *Transaction, s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid extension: %v", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("Extension must be positive, but is %v", d)
+	}
+	if tx.Extension+d > MaxTimeoutExtension {
+		return 0, fmt.Errorf("Extension by %v would exceed the maximum of %v (already extended by %v)",
+			d, MaxTimeoutExtension, tx.Extension)
+	}
+	return d, nil
+}
+
+func handleProposeExtension(tx *Transaction, arguments map[string]string) error {
+	d, err := parseExtension(tx, arguments["extendtimeout"])
+	if err != nil {
+		return err
+	}
+	tx.ProposedExtension = d
+	return nil
+}
+
+var errNoExtensionProposed = fmt.Errorf("No extension has been proposed")
+
+func handleGrantExtension(tx *Transaction, arguments map[string]string) error {
+	d, err := parseExtension(tx, arguments["extendtimeout"])
+	if err != nil {
+		return err
+	}
+	if tx.ProposedExtension == 0 {
+		return errNoExtensionProposed
+	}
+	if d != tx.ProposedExtension {
+		return fmt.Errorf("Extension by %v doesn't match the proposed extension by %v", d, tx.ProposedExtension)
+	}
+	tx.Timeout = tx.Timeout.Add(d)
+	tx.Extension += d
+	tx.ProposedExtension = 0
+	return nil
+}
+
 func handleTransmitFinished(tx *Transaction, arguments map[string]string) error {
 	receipt := &Treceipt{
 		Hash:          *mustParseHash(arguments["encresulthash"]),
@@ -562,8 +682,9 @@ func handleTransmitFinished(tx *Transaction, arguments map[string]string) error
 // Given two matching bids, an older one and a newer one, returns a new Transaction object.
 // Also checks that none of the bids has expired and that they're in the correct state (placed, in_queue).
 // The resulting transaction's price is defined by the elder bid, as is the fee.
+// The transaction is granted time according to the given timeouts.
 // In case of success, both bids are modified in order to reflect their new matched state.
-func NewTransaction(now time.Time, newKey, oldKey string, newBid, oldBid *Bid) (*Transaction, error) {
+func NewTransaction(now time.Time, timeouts PhaseTimeouts, newKey, oldKey string, newBid, oldBid *Bid) (*Transaction, error) {
 	// sanity checks
 	if oldBid.Type == newBid.Type || oldBid.Price.Currency != newBid.Price.Currency || oldBid.Article != newBid.Article {
 		return nil, fmt.Errorf("Non-matching bids: \n\t%v\n\t%v", newBid, oldBid)
@@ -582,11 +703,12 @@ func NewTransaction(now time.Time, newKey, oldKey string, newBid, oldBid *Bid) (
 	}
 
 	tx := &Transaction{
-		Price:   oldBid.Price,
-		Article: oldBid.Article,
-		Matched: now,
-		Timeout: now.Add(60 * time.Second),
-		State:   StateActive,
+		Price:    oldBid.Price,
+		Article:  oldBid.Article,
+		Matched:  now,
+		Timeout:  now.Add(timeouts.For(PhaseEstablishing)),
+		State:    StateActive,
+		Timeouts: timeouts,
 	}
 
 	var buyBid, sellBid *Bid
@@ -645,6 +767,8 @@ var ErrAlreadyRetired = fmt.Errorf("Thid transaction has already been retired")
 // Retires the transaction and performs the necessary accounting steps:
 // - If the transaction retires in UNVERIFIED or FINISHED state, the buyer's blocked
 //   money is transferred to the seller
+// - If the transaction retires in ARBITRATED state, the price is split between seller
+//   and buyer according to the arbiter's ruling
 // - Otherwise, the blocked money is reimbursed
 // Returns ErrTooYoung if the transaction is too young for retirement.
 // Returns ErrAlreadyRetired if the transaction has already been retired.
@@ -671,6 +795,8 @@ func (tx *Transaction) Retire(dao AccountingDao, txId string, now time.Time) err
 			tx.Price, tx.Price.Add(tx.Fee).Neg(),
 			tx.Fee, zero,
 			nil, &txId, nil, nil)
+	} else if tx.Phase == PhaseArbitrated && tx.SellerShare != nil {
+		err = tx.retireArbitrated(dao, txId, now)
 	} else {
 		// Reimburse buyer's money
 		err = PlaceAccountMovement(dao, now, AccountMovementTransactionReimburse,
@@ -688,6 +814,39 @@ func (tx *Transaction) Retire(dao AccountingDao, txId string, now time.Time) err
 	return nil
 }
 
+// Splits the buyer's blocked money according to the arbiter's ruling. The fee is
+// collected unless the seller receives nothing at all.
+func (tx *Transaction) retireArbitrated(dao AccountingDao, txId string, now time.Time) error {
+	zero := money.Money{Currency: tx.Price.Currency, Amount: 0}
+	sellerAmount := money.Money{Currency: tx.Price.Currency, Amount: tx.Price.Amount * int64(*tx.SellerShare) / 100}
+	buyerAmount := tx.Price.Sub(sellerAmount)
+
+	if sellerAmount.Amount > 0 {
+		// Transfer seller's share of buyer's money to seller, sack fee
+		if err := PlaceAccountMovement(dao, now, AccountMovementTransactionFinish,
+			tx.Seller, tx.Buyer,
+			sellerAmount, sellerAmount.Add(tx.Fee).Neg(),
+			tx.Fee, zero,
+			nil, &txId, nil, nil); err != nil {
+			return err
+		}
+	} else {
+		buyerAmount = buyerAmount.Add(tx.Fee)
+	}
+
+	if buyerAmount.Amount > 0 {
+		// Reimburse the rest of buyer's money
+		if err := PlaceAccountMovement(dao, now, AccountMovementTransactionReimburse,
+			tx.Buyer, tx.Buyer,
+			buyerAmount, buyerAmount.Neg(),
+			zero, zero,
+			nil, &txId, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Function MatchKey returns a key that identifies bids which may possibly match.
 // Currently includes articke ID and currency.
 func (tx *Transaction) MatchKey() string {
@@ -0,0 +1,224 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2014  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Minimal in-memory AccountingDao, sufficient for booking account movements.
+type memDao struct {
+	accounts    map[string]ParticipantAccount
+	movements   map[string]AccountMovement
+	withdrawals map[string]Withdrawal
+	nextKey     int
+}
+
+func newMemDao() *memDao {
+	return &memDao{
+		accounts:    make(map[string]ParticipantAccount),
+		movements:   make(map[string]AccountMovement),
+		withdrawals: make(map[string]Withdrawal),
+	}
+}
+
+func (d *memDao) GetAccount(participant string) (ParticipantAccount, error) {
+	if a, ok := d.accounts[participant]; ok {
+		return a, nil
+	}
+	return ParticipantAccount{Participant: participant, Currency: money.BTC}, nil
+}
+
+func (d *memDao) SaveAccount(a *ParticipantAccount) error {
+	d.accounts[a.Participant] = *a
+	return nil
+}
+
+func (d *memDao) GetMovement(key string) (AccountMovement, error) {
+	if m, ok := d.movements[key]; ok {
+		return m, nil
+	}
+	return AccountMovement{}, ErrNoSuchObject
+}
+
+func (d *memDao) SaveMovement(m *AccountMovement) error {
+	d.movements[*m.Key] = *m
+	return nil
+}
+
+func (d *memDao) NewAccountMovementKey(participant string) (string, error) {
+	d.nextKey++
+	return fmt.Sprint(d.nextKey), nil
+}
+
+func (d *memDao) GetDeposit(uid string) (Deposit, error) {
+	return Deposit{}, ErrNoSuchObject
+}
+
+func (d *memDao) SaveDeposit(uid string, deposit *Deposit) error {
+	return nil
+}
+
+func (d *memDao) GetWithdrawal(uid string) (Withdrawal, error) {
+	if w, ok := d.withdrawals[uid]; ok {
+		return w, nil
+	}
+	return Withdrawal{}, ErrNoSuchObject
+}
+
+func (d *memDao) SaveWithdrawal(uid string, withdrawal *Withdrawal) error {
+	d.withdrawals[uid] = *withdrawal
+	return nil
+}
+
+func (d *memDao) GetRelation(source, target string, reltype RelationType) (*Relation, error) {
+	return nil, ErrNoSuchObject
+}
+
+func (d *memDao) SaveRelation(relation *Relation) error {
+	return nil
+}
+
+func (d *memDao) GetArticle(id ArticleId) (*Article, error) {
+	return nil, ErrNoSuchObject
+}
+
+func (d *memDao) SaveArticle(article *Article) error {
+	return nil
+}
+
+func newDisputedTx(now time.Time) *Transaction {
+	return &Transaction{
+		Buyer:   "buyer",
+		Seller:  "seller",
+		Price:   money.MustParse("uBTC 1000"),
+		Fee:     money.MustParse("uBTC 10"),
+		State:   StateActive,
+		Phase:   PhaseUnverified,
+		Timeout: now.Add(time.Minute),
+	}
+}
+
+func TestArbitration(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		share                string
+		seller, buyer, fee   string
+		expectRulingAccepted bool
+		expectedPhase        TxPhase
+	}{
+		{"100", "uBTC 1000", "uBTC 0", "uBTC 10", true, PhaseArbitrated},
+		{"0", "uBTC 0", "uBTC 1010", "uBTC 0", true, PhaseArbitrated},
+		{"30", "uBTC 300", "uBTC 700", "uBTC 10", true, PhaseArbitrated},
+		{"101", "uBTC 0", "uBTC 1010", "uBTC 0", false, PhaseResultDisputed},
+	} {
+		tx := newDisputedTx(now)
+		if m := tx.SendMessage(now, "buyer", map[string]string{"rejectresult": "on"}); !m.Accepted {
+			t.Fatalf("Rejecting result failed: %v", m.RejectMessage)
+		}
+		if tx.Phase != PhaseResultDisputed || !tx.Timeout.Equal(now.Add(ArbitrationPeriod)) {
+			t.Fatalf("Unexpected phase/timeout after rejecting result: %v/%v", tx.Phase, tx.Timeout)
+		}
+		if m := tx.SendMessage(now, "seller", map[string]string{"sellershare": test.share}); m.Accepted {
+			t.Errorf("Seller must not be able to rule")
+		}
+
+		m := tx.SendMessage(now, "arbiter", map[string]string{"sellershare": test.share})
+		if m.Accepted != test.expectRulingAccepted {
+			t.Errorf("Share %v: expected accepted=%v, got %v (%v)", test.share, test.expectRulingAccepted, m.Accepted, m.RejectMessage)
+		}
+		if tx.Phase != test.expectedPhase {
+			t.Errorf("Share %v: expected phase %v, got %v", test.share, test.expectedPhase, tx.Phase)
+		}
+
+		// Buyer has the full price and fee blocked
+		dao := newMemDao()
+		dao.accounts["buyer"] = ParticipantAccount{Participant: "buyer", Currency: money.BTC, BlockedAmount: money.MustParse("uBTC 1010").Amount}
+
+		if err := tx.Retire(dao, "tx", tx.Timeout); err != nil {
+			t.Fatalf("Share %v: error retiring: %v", test.share, err)
+		}
+
+		var fee int64
+		for _, m := range dao.movements {
+			fee += m.Fee.Amount
+		}
+		seller, _ := dao.GetAccount("seller")
+		buyer, _ := dao.GetAccount("buyer")
+		if seller.AvailableAmount != money.MustParse(test.seller).Amount {
+			t.Errorf("Share %v: seller expected to receive %v, got %v", test.share, test.seller, seller.GetAvailable().GetBalance())
+		}
+		if buyer.AvailableAmount != money.MustParse(test.buyer).Amount {
+			t.Errorf("Share %v: buyer expected to receive %v, got %v", test.share, test.buyer, buyer.GetAvailable().GetBalance())
+		}
+		if buyer.BlockedAmount != 0 {
+			t.Errorf("Share %v: buyer still has %v blocked", test.share, buyer.GetBlocked().GetBalance())
+		}
+		if fee != money.MustParse(test.fee).Amount {
+			t.Errorf("Share %v: expected fee %v, got %v", test.share, test.fee, fee)
+		}
+	}
+}
+
+func TestExtendTimeout(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	tx := newDisputedTx(now)
+	tx.Phase = PhaseWorking
+	timeout := tx.Timeout
+
+	send := func(from, extension string, expectAccepted bool) {
+		t.Helper()
+		m := tx.SendMessage(now, from, map[string]string{"extendtimeout": extension})
+		if m.Accepted != expectAccepted {
+			t.Fatalf("%v sending %v: expected accepted=%v, got %v (%v)", from, extension, expectAccepted, m.Accepted, m.RejectMessage)
+		}
+	}
+
+	// Buyer can't grant what hasn't been proposed
+	send("buyer", "10m", false)
+	send("seller", "10m", true)
+	if !tx.Timeout.Equal(timeout) || tx.Phase != PhaseWorking {
+		t.Fatalf("Proposal must not change timeout or phase: %v/%v", tx.Timeout, tx.Phase)
+	}
+	// Buyer must countersign exactly the proposed value
+	send("buyer", "20m", false)
+	send("buyer", "10m", true)
+	if !tx.Timeout.Equal(timeout.Add(10*time.Minute)) || tx.Extension != 10*time.Minute || tx.ProposedExtension != 0 {
+		t.Fatalf("Unexpected state after extension: timeout=%v extension=%v proposed=%v", tx.Timeout, tx.Extension, tx.ProposedExtension)
+	}
+	// A granted proposal can't be granted twice
+	send("buyer", "10m", false)
+
+	// The total extension is bounded
+	send("seller", (MaxTimeoutExtension - 5*time.Minute).String(), false)
+	send("seller", "-1m", false)
+	send("seller", (MaxTimeoutExtension - 10*time.Minute).String(), true)
+	send("buyer", (MaxTimeoutExtension - 10*time.Minute).String(), true)
+	if tx.Extension != MaxTimeoutExtension {
+		t.Errorf("Expected total extension of %v, got %v", MaxTimeoutExtension, tx.Extension)
+	}
+	send("seller", "1s", false)
+
+	// Extensions are only possible while WORKING
+	tx.Phase = PhaseUnverified
+	tx.Extension = 0
+	send("seller", "10m", false)
+}
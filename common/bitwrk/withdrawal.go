@@ -0,0 +1,341 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Message sent by a participant who wishes to have money paid out of their account.
+// The UID is chosen by the participant and makes placing the request idempotent.
+// When URL-encoding, fields names are converted to lower-case and ordered alphabetically.
+type WithdrawalRequest struct {
+	Nonce       string // A nonce requested from the BitWrk service
+	Participant string // The account owner
+	Amount      string // The amount to withdraw, e.g. "mBTC 12.5"
+	Address     string // The monetary address to send the money to
+	Uid         string // Unique ID of the withdrawal
+	Signer      string // The participant who signed the message, must be the account owner
+	Signature   string // Signature over the URL-encoded message (except the "Signature" field)
+}
+
+// Reads fields from an url.Values object. Does not perform any checking
+func (r *WithdrawalRequest) FromValues(values url.Values) {
+	r.Nonce = values.Get("nonce")
+	r.Participant = values.Get("participant")
+	r.Amount = values.Get("amount")
+	r.Address = values.Get("address")
+	r.Uid = values.Get("uid")
+	r.Signer = values.Get("signer")
+	r.Signature = values.Get("signature")
+}
+
+// Places fields in an url.Values object.
+func (r *WithdrawalRequest) ToValues(values url.Values) {
+	values.Set("nonce", r.Nonce)
+	values.Set("participant", r.Participant)
+	values.Set("amount", r.Amount)
+	values.Set("address", r.Address)
+	values.Set("uid", r.Uid)
+	values.Set("signer", r.Signer)
+	values.Set("signature", r.Signature)
+}
+
+// Returns the URL-encoded part of the request that is signed.
+// The "+" sign is encoded as "%20" to resolve an ambiguity with
+// javascript's encodeURIComponent.
+func (r *WithdrawalRequest) document() string {
+	values := url.Values{}
+	r.ToValues(values)
+	values.Del("signature")
+	return strings.Replace(values.Encode(), "+", "%20", -1)
+}
+
+// Signs the request using the specified key pair. Fields "Signer" and "Signature"
+// are modified.
+func (r *WithdrawalRequest) SignWith(key *bitcoin.KeyPair, rand io.Reader) error {
+	r.Signer = key.GetAddress()
+	if s, err := key.SignMessage(r.document(), rand); err != nil {
+		return err
+	} else {
+		r.Signature = s
+		return nil
+	}
+}
+
+// Verifies authenticity (or if fed with r.Signer, only integrity) of the request.
+func (r *WithdrawalRequest) VerifyWith(signer string) error {
+	return bitcoin.VerifySignatureBase64(r.document(), signer, r.Signature)
+}
+
+// Possible outcomes of a withdrawal settlement
+const (
+	WithdrawalConfirmed = "confirmed"
+	WithdrawalRejected  = "rejected"
+)
+
+// Message sent by the payment processor after a withdrawal has been handled. Confirming
+// a withdrawal means that the money has been sent, rejecting means that it is given back
+// to the participant.
+// When URL-encoding, fields names are converted to lower-case and ordered alphabetically.
+type WithdrawalSettlement struct {
+	Nonce     string // A nonce requested from the BitWrk service
+	Uid       string // Unique ID of the withdrawal
+	Outcome   string // Either "confirmed" or "rejected"
+	Reference string // An external reference, e.g. the ID of the monetary transaction
+	Signer    string // The payment processor who signed this message
+	Signature string // Signature over the URL-encoded message (except the "Signature" field)
+}
+
+// Reads fields from an url.Values object. Does not perform any checking
+func (s *WithdrawalSettlement) FromValues(values url.Values) {
+	s.Nonce = values.Get("nonce")
+	s.Uid = values.Get("uid")
+	s.Outcome = values.Get("outcome")
+	s.Reference = values.Get("reference")
+	s.Signer = values.Get("signer")
+	s.Signature = values.Get("signature")
+}
+
+// Places fields in an url.Values object.
+func (s *WithdrawalSettlement) ToValues(values url.Values) {
+	values.Set("nonce", s.Nonce)
+	values.Set("uid", s.Uid)
+	values.Set("outcome", s.Outcome)
+	values.Set("reference", s.Reference)
+	values.Set("signer", s.Signer)
+	values.Set("signature", s.Signature)
+}
+
+// Returns the URL-encoded part of the message that is signed.
+// The "+" sign is encoded as "%20" to resolve an ambiguity with
+// javascript's encodeURIComponent.
+func (s *WithdrawalSettlement) document() string {
+	values := url.Values{}
+	s.ToValues(values)
+	values.Del("signature")
+	return strings.Replace(values.Encode(), "+", "%20", -1)
+}
+
+// Signs the message using the specified key pair. Fields "Signer" and "Signature"
+// are modified.
+func (s *WithdrawalSettlement) SignWith(key *bitcoin.KeyPair, rand io.Reader) error {
+	s.Signer = key.GetAddress()
+	if sig, err := key.SignMessage(s.document(), rand); err != nil {
+		return err
+	} else {
+		s.Signature = sig
+		return nil
+	}
+}
+
+// Verifies authenticity (or if fed with s.Signer, only integrity) of the message.
+func (s *WithdrawalSettlement) VerifyWith(signer string) error {
+	return bitcoin.VerifySignatureBase64(s.document(), signer, s.Signature)
+}
+
+type WithdrawalState int8
+
+const (
+	WithdrawalStateRequested WithdrawalState = iota // Money is blocked, waiting for the payment processor
+	WithdrawalStateConfirmed                        // Money has left the system
+	WithdrawalStateRejected                         // Money has been given back to the participant
+)
+
+func (s WithdrawalState) String() string {
+	switch s {
+	case WithdrawalStateRequested:
+		return "REQUESTED"
+	case WithdrawalStateConfirmed:
+		return "CONFIRMED"
+	case WithdrawalStateRejected:
+		return "REJECTED"
+	}
+	return fmt.Sprintf("<Invalid Withdrawal State: %v>", int8(s))
+}
+
+func (s WithdrawalState) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + s.String() + "\""), nil
+}
+
+func (s *WithdrawalState) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	for state := WithdrawalStateRequested; state <= WithdrawalStateRejected; state++ {
+		if str == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid input: %#v", str)
+}
+
+// Type Withdrawal tracks money being paid out of a participant's account. While requested,
+// the amount is held in the blocked part of the account.
+type Withdrawal struct {
+	Account   string
+	Amount    money.Money
+	Address   string // The monetary address to send the money to
+	State     WithdrawalState
+	Reference string // An external reference given on settlement
+	Created   time.Time
+	Settled   time.Time
+	// Document containing URL-encoded WithdrawalRequest
+	Request string
+	// Document containing URL-encoded WithdrawalSettlement
+	Settlement string
+}
+
+// Checks that a withdrawal UID or reference only contains characters A-Z, a-z, 0-9 and '-'.
+func checkWithdrawalString(what, s string) error {
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return fmt.Errorf("Withdrawal %v contains illegal character (only A-Z, a-z, 0-9 and '-')", what)
+		}
+	}
+	return nil
+}
+
+// Function NewWithdrawal creates a withdrawal from a request after checking its fields.
+// The request's signature is not checked.
+func NewWithdrawal(r *WithdrawalRequest, now time.Time) (*Withdrawal, error) {
+	var amount money.Money
+	if err := amount.Parse(r.Amount); err != nil {
+		return nil, err
+	}
+	if amount.Amount <= 0 {
+		return nil, fmt.Errorf("Non-positive withdrawal amount not allowed: %s", r.Amount)
+	}
+	if len(r.Uid) < 8 || len(r.Uid) > 64 {
+		return nil, fmt.Errorf("Withdrawal UID length must be >= 8 and <= 64")
+	}
+	if err := checkWithdrawalString("UID", r.Uid); err != nil {
+		return nil, err
+	}
+	if r.Address == "" {
+		return nil, fmt.Errorf("Withdrawal address must not be empty")
+	}
+
+	v := url.Values{}
+	r.ToValues(v)
+	return &Withdrawal{
+		Account: r.Participant,
+		Amount:  amount,
+		Address: r.Address,
+		State:   WithdrawalStateRequested,
+		Created: now,
+		Request: v.Encode(),
+	}, nil
+}
+
+// Places the withdrawal under the given UID, blocking the requested amount in the
+// participant's account. Placing an identical withdrawal twice has no effect.
+func (w *Withdrawal) Place(uid string, dao AccountingDao) error {
+	if previous, err := dao.GetWithdrawal(uid); err == ErrNoSuchObject {
+		// This is the expected case, handled below.
+	} else if err != nil {
+		return err
+	} else if previous.Account == w.Account && previous.Amount == w.Amount && previous.Address == w.Address {
+		return nil
+	} else {
+		return fmt.Errorf("A different withdrawal exists already with uid %v.", uid)
+	}
+
+	zero := money.Money{Currency: w.Amount.Currency, Amount: 0}
+	err := PlaceAccountMovement(dao, w.Created, AccountMovementPayOutRequest,
+		w.Account, w.Account,
+		w.Amount.Neg(), w.Amount,
+		zero, zero,
+		nil, nil, nil, &uid)
+	if err != nil {
+		return err
+	}
+
+	return dao.SaveWithdrawal(uid, w)
+}
+
+// Function SettleWithdrawal books a requested withdrawal according to the settlement's
+// outcome: Confirmed withdrawals leave the system, rejected ones are reimbursed to the
+// participant. Settling a withdrawal twice with the same outcome has no effect.
+// The settlement's signature is not checked.
+func SettleWithdrawal(dao AccountingDao, s *WithdrawalSettlement, now time.Time) (*Withdrawal, error) {
+	var state WithdrawalState
+	var mType AccountMovementType
+	switch s.Outcome {
+	case WithdrawalConfirmed:
+		state, mType = WithdrawalStateConfirmed, AccountMovementPayOut
+	case WithdrawalRejected:
+		state, mType = WithdrawalStateRejected, AccountMovementPayOutReimburse
+	default:
+		return nil, fmt.Errorf("Invalid withdrawal outcome: %#v", s.Outcome)
+	}
+	if len(s.Reference) > 64 {
+		return nil, fmt.Errorf("Withdrawal reference length must be <= 64")
+	}
+	if err := checkWithdrawalString("reference", s.Reference); err != nil {
+		return nil, err
+	}
+
+	w, err := dao.GetWithdrawal(s.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if w.State == state && w.Reference == s.Reference {
+		return &w, nil
+	} else if w.State != WithdrawalStateRequested {
+		return nil, fmt.Errorf("Withdrawal %v has been settled already: %v", s.Uid, w.State)
+	}
+
+	zero := money.Money{Currency: w.Amount.Currency, Amount: 0}
+	if state == WithdrawalStateConfirmed {
+		err = PlaceAccountMovement(dao, now, mType,
+			w.Account, w.Account,
+			zero, w.Amount.Neg(),
+			zero, w.Amount,
+			nil, nil, nil, &s.Uid)
+	} else {
+		err = PlaceAccountMovement(dao, now, mType,
+			w.Account, w.Account,
+			w.Amount, w.Amount.Neg(),
+			zero, zero,
+			nil, nil, nil, &s.Uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	s.ToValues(v)
+	w.State = state
+	w.Reference = s.Reference
+	w.Settled = now
+	w.Settlement = v.Encode()
+	if err := dao.SaveWithdrawal(s.Uid, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
@@ -0,0 +1,86 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package bitwrk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+func TestWithdrawal(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		outcome            string
+		available, blocked string
+		expectedState      WithdrawalState
+	}{
+		{WithdrawalConfirmed, "uBTC 600", "uBTC 0", WithdrawalStateConfirmed},
+		{WithdrawalRejected, "uBTC 1000", "uBTC 0", WithdrawalStateRejected},
+	} {
+		dao := newMemDao()
+		dao.accounts["alice"] = ParticipantAccount{Participant: "alice", Currency: money.BTC, AvailableAmount: 100000}
+
+		req := WithdrawalRequest{Participant: "alice", Amount: "uBTC 400", Address: "somewhere", Uid: "withdrawal-1"}
+		w, err := NewWithdrawal(&req, now)
+		if err != nil {
+			t.Fatalf("NewWithdrawal failed: %v", err)
+		}
+		if err := w.Place(req.Uid, dao); err != nil {
+			t.Fatalf("Place failed: %v", err)
+		}
+		// Placing the same withdrawal again must be idempotent
+		if err := w.Place(req.Uid, dao); err != nil {
+			t.Fatalf("Placing again failed: %v", err)
+		}
+		if a := dao.accounts["alice"]; a.AvailableAmount != 60000 || a.BlockedAmount != 40000 {
+			t.Fatalf("Unexpected balances after request: %v / %v", a.AvailableAmount, a.BlockedAmount)
+		}
+
+		s := WithdrawalSettlement{Uid: req.Uid, Outcome: test.outcome, Reference: "ref-1"}
+		if w, err := SettleWithdrawal(dao, &s, now.Add(time.Hour)); err != nil {
+			t.Fatalf("SettleWithdrawal(%v) failed: %v", test.outcome, err)
+		} else if w.State != test.expectedState {
+			t.Errorf("Unexpected state: %v", w.State)
+		}
+		a := dao.accounts["alice"]
+		if a.AvailableAmount != money.MustParse(test.available).Amount || a.BlockedAmount != money.MustParse(test.blocked).Amount {
+			t.Errorf("Unexpected balances after %v: %v / %v", test.outcome, a.AvailableAmount, a.BlockedAmount)
+		}
+		if len(dao.movements) != 2 {
+			t.Errorf("Expected 2 account movements, got %v", len(dao.movements))
+		}
+
+		// Settling differently afterwards must fail
+		s.Outcome, s.Reference = WithdrawalConfirmed, "ref-2"
+		if _, err := SettleWithdrawal(dao, &s, now.Add(time.Hour)); err == nil {
+			t.Errorf("Expected second settlement to fail")
+		}
+	}
+}
+
+func TestWithdrawalExceedingBalance(t *testing.T) {
+	dao := newMemDao()
+	dao.accounts["bob"] = ParticipantAccount{Participant: "bob", Currency: money.BTC, AvailableAmount: 100}
+	req := WithdrawalRequest{Participant: "bob", Amount: "satoshi 101", Address: "somewhere", Uid: "withdrawal-2"}
+	if w, err := NewWithdrawal(&req, time.Now()); err != nil {
+		t.Fatalf("NewWithdrawal failed: %v", err)
+	} else if err := w.Place(req.Uid, dao); err == nil {
+		t.Errorf("Expected withdrawal exceeding balance to fail")
+	}
+}
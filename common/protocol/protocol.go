@@ -28,12 +28,14 @@ import (
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/indyjo/bitwrk/common/bitcoin"
 	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
 )
 
 var defaultClient = NewClient(&http.Transport{
@@ -174,6 +176,135 @@ func FetchBid(bidId, etag string) (*bitwrk.Bid, string, error) {
 	return nil, "", fmt.Errorf("Error fetching bid: %v", response.Status)
 }
 
+// Fetches a participant's account, including its balances and the key of its last
+// account movement.
+func FetchAccount(participant string) (*bitwrk.ParticipantAccount, error) {
+	var response *http.Response
+	if r, err := getJsonFromServer("account/"+participant, ""); err != nil {
+		return nil, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching account: %v", response.Status)
+	}
+
+	var result bitwrk.ParticipantAccount
+	if err := json.NewDecoder(response.Body).Decode(json.Unmarshaler(&result)); err != nil {
+		return nil, fmt.Errorf("Error decoding account JSON: %v", err)
+	}
+	return &result, nil
+}
+
+// Fetches a ledger entry. Returns bitwrk.ErrNoSuchObject if it doesn't exist.
+func FetchAccountMovement(key string) (bitwrk.AccountMovement, error) {
+	var response *http.Response
+	if r, err := getJsonFromServer("ledger/"+key, ""); err != nil {
+		return bitwrk.AccountMovement{}, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return bitwrk.AccountMovement{}, bitwrk.ErrNoSuchObject
+	} else if response.StatusCode != http.StatusOK {
+		return bitwrk.AccountMovement{}, fmt.Errorf("Error fetching ledger entry: %v", response.Status)
+	}
+
+	var result bitwrk.AccountMovement
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return bitwrk.AccountMovement{}, fmt.Errorf("Error decoding ledger entry JSON: %v", err)
+	}
+	return result, nil
+}
+
+// Fetches the catalog entry applying to an article. Returns bitwrk.ErrNoSuchObject if the
+// article isn't traded on the server.
+func FetchArticle(article bitwrk.ArticleId) (*bitwrk.Article, error) {
+	var response *http.Response
+	if r, err := getJsonFromServer("article/"+string(article), ""); err != nil {
+		return nil, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode == http.StatusOK {
+		var result bitwrk.Article
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("Error decoding article JSON: %v", err)
+		}
+		return &result, nil
+	} else if response.StatusCode == http.StatusNotFound {
+		return nil, bitwrk.ErrNoSuchObject
+	}
+
+	return nil, fmt.Errorf("Error fetching article: %v", response.Status)
+}
+
+// Fetches all entries of the server's article catalog.
+func FetchArticles() ([]bitwrk.Article, error) {
+	var response *http.Response
+	if r, err := getJsonFromServer("query/articles", ""); err != nil {
+		return nil, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching articles: %v", response.Status)
+	}
+
+	var result []bitwrk.Article
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Error decoding articles JSON: %v", err)
+	}
+	return result, nil
+}
+
+// Type PriceSlot contains statistics on the prices of an article's transactions within
+// a time slot, as returned by the server's "query/prices" endpoint.
+type PriceSlot struct {
+	Begin time.Time   `json:"begin"`
+	End   time.Time   `json:"end"`
+	Sum   money.Money `json:"sum"`
+	Min   money.Money `json:"min"`
+	Max   money.Money `json:"max"`
+	Count int         `json:"count"`
+}
+
+// Fetches price statistics for an article over the given period (e.g. "1h") in slots of
+// the given resolution (e.g. "3m"). Slots without transactions are omitted.
+func FetchPrices(article bitwrk.ArticleId, period, resolution string) ([]PriceSlot, error) {
+	query := url.Values{}
+	query.Set("article", string(article))
+	query.Set("period", period)
+	query.Set("resolution", resolution)
+	query.Set("unit", "BTC")
+
+	var response *http.Response
+	if r, err := getJsonFromServer("query/prices?"+query.Encode(), ""); err != nil {
+		return nil, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching prices: %v", response.Status)
+	}
+
+	var result []PriceSlot
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Error decoding prices JSON: %v", err)
+	}
+	return result, nil
+}
+
 func FetchTx(txId, etag string) (*bitwrk.Transaction, string, error) {
 	var response *http.Response
 	if r, err := getJsonFromServer("tx/"+txId, etag); err != nil {
@@ -197,6 +328,50 @@ func FetchTx(txId, etag string) (*bitwrk.Transaction, string, error) {
 	return nil, "", fmt.Errorf("Error fetching transaction: %v", response.Status)
 }
 
+// Fetches the messages received by a transaction, in order of arrival.
+func FetchTxMessages(txId string) ([]bitwrk.Tmessage, error) {
+	var response *http.Response
+	if r, err := getJsonFromServer("tx/"+txId+"/messages", ""); err != nil {
+		return nil, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching transaction messages: %v", response.Status)
+	}
+
+	var result []bitwrk.Tmessage
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Error decoding transaction messages JSON: %v", err)
+	}
+	return result, nil
+}
+
+// Fetches a deposit by its UID. Returns bitwrk.ErrNoSuchObject if it doesn't exist.
+func FetchDeposit(uid string) (*bitwrk.Deposit, error) {
+	var response *http.Response
+	if r, err := getJsonFromServer("deposit/"+uid, ""); err != nil {
+		return nil, err
+	} else {
+		response = r
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, bitwrk.ErrNoSuchObject
+	} else if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching deposit: %v", response.Status)
+	}
+
+	var result bitwrk.Deposit
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Error decoding deposit JSON: %v", err)
+	}
+	return &result, nil
+}
+
 func postFormToServer(relpath, query string) (*http.Response, error) {
 	req, err := newServerRequest("POST", relpath, strings.NewReader(query))
 	if err != nil {
@@ -341,6 +516,14 @@ func SendTxMessageTransmitFinished(txId string, identity *bitcoin.KeyPair, encRe
 	return SendTxMessage(txId, identity, arguments)
 }
 
+// Sent by the seller to propose extending the transaction's timeout, and by the buyer
+// to grant the proposed extension.
+func SendTxMessageExtendTimeout(txId string, identity *bitcoin.KeyPair, extension time.Duration) error {
+	arguments := make(map[string]string)
+	arguments["extendtimeout"] = extension.String()
+	return SendTxMessage(txId, identity, arguments)
+}
+
 func SendTxMessageRejectWork(txId string, identity *bitcoin.KeyPair) error {
 	arguments := make(map[string]string)
 	arguments["rejectwork"] = "on"
@@ -353,6 +536,18 @@ func SendTxMessageAcceptResult(txId string, identity *bitcoin.KeyPair) error {
 	return SendTxMessage(txId, identity, arguments)
 }
 
+func SendTxMessageRejectResult(txId string, identity *bitcoin.KeyPair) error {
+	arguments := make(map[string]string)
+	arguments["rejectresult"] = "on"
+	return SendTxMessage(txId, identity, arguments)
+}
+
+func SendTxMessageRuling(txId string, identity *bitcoin.KeyPair, sellerShare int) error {
+	arguments := make(map[string]string)
+	arguments["sellershare"] = strconv.Itoa(sellerShare)
+	return SendTxMessage(txId, identity, arguments)
+}
+
 func normalize(s string) string {
 	return url.QueryEscape(strings.Replace(s, " ", "", -1))
 }
@@ -371,13 +566,37 @@ func SendDepositAddressMessage(msg *bitwrk.DepositAddressMessage) error {
 	return postFormToServerExpectRedirect("account/"+msg.Participant, query)
 }
 
+// Asks the server to pay out money from the requesting participant's account.
+// The amount is blocked until the withdrawal has been settled.
+func SendWithdrawalRequest(req *bitwrk.WithdrawalRequest) error {
+	values := url.Values{}
+	req.ToValues(values)
+	return postFormToServerExpectRedirect("withdrawal", values.Encode())
+}
+
+// Confirms or rejects a pending withdrawal. Must be signed by the payment processor.
+func SendWithdrawalSettlement(s *bitwrk.WithdrawalSettlement) error {
+	values := url.Values{}
+	s.ToValues(values)
+	return postFormToServerExpectRedirect("withdrawal/"+s.Uid, values.Encode())
+}
+
 func SendDeposit(deposit *bitwrk.Deposit) error {
 	msg := fmt.Sprintf("%v&signature=%v", deposit.Document, url.QueryEscape(deposit.Signature))
 	return postFormToServerExpectRedirect("deposit", msg)
 }
 
 func GetParticipantsWithDepositAddressRequest(limit int) ([]string, error) {
-	if resp, err := getFromServer(fmt.Sprintf("query/accounts?requestdepositaddress=yes&limit=%v", limit)); err != nil {
+	return getParticipants(fmt.Sprintf("query/accounts?requestdepositaddress=yes&limit=%v", limit), limit)
+}
+
+// Returns the IDs of up to limit participants having an account on the server.
+func GetParticipants(limit int) ([]string, error) {
+	return getParticipants(fmt.Sprintf("query/accounts?limit=%v", limit), limit)
+}
+
+func getParticipants(relpath string, limit int) ([]string, error) {
+	if resp, err := getFromServer(relpath); err != nil {
 		return nil, fmt.Errorf("Error GETting from server: %v", err)
 	} else if resp == nil {
 		return nil, fmt.Errorf("No response from server")
@@ -398,6 +617,20 @@ func GetParticipantsWithDepositAddressRequest(limit int) ([]string, error) {
 	}
 }
 
+// Sends a catalog entry, which must have been signed using the given nonce.
+func SendArticle(article *bitwrk.Article, nonce string) error {
+	values := url.Values{}
+	values.Set("article", string(article.Id))
+	values.Set("description", article.Description)
+	values.Set("enabled", strconv.FormatBool(article.Enabled))
+	values.Set("feeratio", article.FeeRatio())
+	values.Set("maxworksize", article.MaxWorkSizeString())
+	values.Set("nonce", nonce)
+	values.Set("timeouts", article.Timeouts.String())
+	values.Set("signature", article.Signature)
+	return postFormToServerExpectRedirect("article", values.Encode())
+}
+
 func SendRelation(relation *bitwrk.Relation) error {
 	msg := fmt.Sprintf("%v&signature=%v", relation.Document, url.QueryEscape(relation.Signature))
 	return postFormToServerExpectRedirect("rel", msg)
@@ -39,6 +39,8 @@ function setActivities(node, activitiesjson, serverUrl) {
 					item.removeChild(item.lastChild);
 				}
 			} else if (info.Amount !== info2.Amount || info.Info !== info2.Info
+					|| info.Verdict !== info2.Verdict
+					|| attemptCount(info) !== attemptCount(info2)
 					|| info.Phase !== info2.Phase
 					|| info.BytesTotal !== info2.BytesTotal
 					|| info.BytesToTransfer !== info2.BytesToTransfer
@@ -127,8 +129,22 @@ function setActivities(node, activitiesjson, serverUrl) {
 				+ ((phaseHtml === '') ? '' : '(' + phaseHtml + ')');
 		item.childNodes[childIdx++].data = phaseHtml;
 
-		item.childNodes[childIdx++].textContent = info.Info === undefined ? ""
-				: info.Info;
+		var infoText = info.Info === undefined ? "" : info.Info;
+		if (info.Verdict) {
+			// Result of a buy has been validated
+			infoText = 'Result ' + info.Verdict
+					+ (info.VerdictReason ? ': ' + info.VerdictReason : '')
+					+ (infoText === '' ? '' : ' - ' + infoText);
+		}
+		var attempts = attemptCount(info);
+		if (attempts > 1 || (attempts > 0 && info.Alive)) {
+			// Buy is being retried after failures
+			var lastAttempt = info.Attempts[attempts - 1];
+			infoText = (infoText === '' ? '' : infoText + ' - ') + attempts
+					+ ' failed attempt' + (attempts > 1 ? 's' : '')
+					+ ', last: ' + lastAttempt.Error;
+		}
+		item.childNodes[childIdx++].textContent = infoText;
 	}
 
 	// delete removed nodes
@@ -139,6 +155,10 @@ function setActivities(node, activitiesjson, serverUrl) {
 	}
 }
 
+function attemptCount(info) {
+	return info.Attempts ? info.Attempts.length : 0;
+}
+
 function updateActivities(serverUrl) {
 	var xhr = new XMLHttpRequest();
 	xhr.onreadystatechange = function() {
@@ -4,6 +4,21 @@ function showMandateDialog(type, articleId) {
 	dlg.find("#perm-articleid-span").text(articleId);
 	dlg.find("input[name='type']").val(type);
 	dlg.find("input[name='articleid']").val(articleId);
+	dlg.find(".buyonly").toggle(type === "BUY");
+
+	// Only show the fields of the selected pricing strategy
+	var pricingSelect = dlg.find("select[name='pricing']");
+	var showPricing = function() {
+		var pricing = pricingSelect.val();
+		dlg.find(".pricing-market").toggle(pricing === "market");
+		dlg.find(".pricing-escalate").toggle(pricing === "escalate");
+		if (pricing === "market" || pricing === "escalate") {
+			dlg.find(".pricing-" + pricing).show();
+		}
+	};
+	pricingSelect.off('change');
+	pricingSelect.on('change', showPricing);
+	showPricing();
 
 	// Initialize previous values from stored cookies
 	var tag = type + "-" + articleId;
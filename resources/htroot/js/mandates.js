@@ -25,7 +25,10 @@ function setMandates(node, mandatesjson) {
             // Item existed already. Check for equality.
             needsCreate = false;
             var info2 = item.Info;
-            if (info.TradesLeft === info2.TradesLeft) {
+            if (info.TradesLeft === info2.TradesLeft
+                    && info.CurrentPrice === info2.CurrentPrice
+                    && info.BudgetLeft === info2.BudgetLeft
+                    && info.DailyCapLeft === info2.DailyCapLeft) {
             	needsUpdate = false
             }
         }
@@ -44,6 +47,12 @@ function setMandates(node, mandatesjson) {
 			if (info.UseUntil) {
 				html += '<div class="validuntil"></div>';
 			}
+			if (info.UseBudget) {
+				html += '<div class="budgetleft"></div>';
+			}
+			if (info.UseDailyCap) {
+				html += '<div class="dailycapleft"></div>';
+			}
 			
 			item.innerHTML = html;
             node.appendChild(item);
@@ -59,7 +68,8 @@ function setMandates(node, mandatesjson) {
 					revokeMandateAsync(key);
 				};
 			}(key);
-			item.childNodes[childIdx++].textContent = info.Price;
+			item.childNodes[childIdx++].textContent = info.Pricing === "fixed" ?
+				info.Price : info.CurrentPrice + " (" + info.Pricing + ")";
 			item.childNodes[childIdx++].textContent = info.Article;
 			if (info.UseTradesLeft) {
 				item.childNodes[childIdx++].textContent = "Trades left: " + info.TradesLeft;
@@ -69,6 +79,12 @@ function setMandates(node, mandatesjson) {
 				item.childNodes[childIdx++].textContent = "Minutes left: " +
 					Math.max(0, Math.ceil(millis / 60000));
 			}
+			if (info.UseBudget) {
+				item.childNodes[childIdx++].textContent = "Budget left: " + info.BudgetLeft;
+			}
+			if (info.UseDailyCap) {
+				item.childNodes[childIdx++].textContent = "Left today: " + info.DailyCapLeft;
+			}
 			item.Info = info;
         }
     }
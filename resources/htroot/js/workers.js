@@ -29,7 +29,9 @@ function setWorkers(node, workersjson) {
             	&& info.Method === info2.Method
             	&& info.PushURL === info2.PushURL
             	&& worker.LastError === item.LastError
-            	&& worker.Idle === item.Idle) {
+            	&& JSON.stringify(worker.Slots) === item.Slots
+            	&& worker.Health === item.Health
+            	&& worker.LastSeen === item.LastSeen) {
             	needsUpdate = false
             }
         }
@@ -42,6 +44,7 @@ function setWorkers(node, workersjson) {
 				'<div class="key"></div>' +
 				'<input type="button" class="closebtn btn btn-default btn-xs" value="Stop"></input>' +
 				'<div class="status"></div>' +
+				'<div class="health"></div>' +
 				'<div class="article"></div>' +
 				'<div class="lasterror"></div>';
             node.appendChild(item);
@@ -57,11 +60,15 @@ function setWorkers(node, workersjson) {
 					unregisterWorkerAsync(key);
 				};
 			}(key);
-			item.childNodes[childIdx++].textContent = worker.Idle ? "Idle" : "Work dispatched";
+			item.childNodes[childIdx++].textContent = slotStates(worker.Slots);
+			item.childNodes[childIdx++].textContent =
+				"Health: " + worker.Health + ", last seen " + new Date(worker.LastSeen).toLocaleTimeString();
 			item.childNodes[childIdx++].textContent = info.Article;
 			item.childNodes[childIdx++].textContent = worker.LastError;
 			item.Info = info;
-			item.Idle = worker.Idle
+			item.Slots = JSON.stringify(worker.Slots)
+			item.Health = worker.Health
+			item.LastSeen = worker.LastSeen
 			item.LastError = worker.LastError
         }
     }
@@ -74,6 +81,21 @@ function setWorkers(node, workersjson) {
     }
 }
 
+// Describes what each of a worker's slots is doing
+function slotStates(slots) {
+    var states = [];
+    for (var i=0; i<slots.length; i++) {
+        if (slots[i].Busy) {
+            states.push("Work dispatched");
+        } else if (slots[i].Sell) {
+            states.push("Selling");
+        } else {
+            states.push("Idle");
+        }
+    }
+    return states.join(", ");
+}
+
 function unregisterWorkerAsync(key) {
     var xhr = new XMLHttpRequest();
     xhr.onreadystatechange = function() {
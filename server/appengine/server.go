@@ -2,9 +2,12 @@ package main
 
 import (
 	_ "github.com/indyjo/bitwrk/server"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/gae"
 	"google.golang.org/appengine"
 )
 
 func main() {
+	db.SetBackend(gae.NewBackend())
 	appengine.Main()
 }
@@ -0,0 +1,147 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package article deals with the management of the article catalog.
+package article
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/server/config"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
+	nonce2 "github.com/indyjo/bitwrk/server/nonce"
+)
+
+const createHtml = `
+<!doctype html>
+<html>
+<head><title>Enter Article</title></head>
+<script src="/js/getnonce.js" ></script>
+<script src="/js/createarticle.js" ></script>
+<body onload="getnonce()">
+<form action="/article" method="post">
+<input id="article" type="text" name="article" size="64" placeholder="net.bitwrk/example/0" onchange="update()" /> &larr; The article ID (without "~trusted").<br />
+<input id="description" type="text" name="description" size="64" onchange="update()" /> &larr; A human-readable description.<br />
+<select id="enabled" name="enabled" onchange="update()">
+<option value="true" selected>enabled</option>
+<option value="false">disabled</option>
+</select> &larr; Choose whether the article is traded.<br />
+<input id="timeouts" type="text" name="timeouts" size="64" placeholder="60s,2m,5m,15m" onchange="update()" /> &larr; Timeouts for phases ESTABLISHING, TRANSMITTING, WORKING and UNVERIFIED (empty for defaults).<br />
+<input id="maxworksize" type="text" name="maxworksize" size="64" onchange="update()" /> &larr; Maximum size of work data in bytes (empty for unlimited).<br />
+<input id="feeratio" type="text" name="feeratio" size="64" placeholder="3/100" onchange="update()" /> &larr; Fee ratio (empty for server default).<br />
+<input id="nonce" type="hidden" name="nonce" onchange="update()"/> <br/>
+<input type="text" name="signature" size="64" placeholder="Signature of query parameters" />
+<input type="submit" />
+</form>
+<br />
+Sign this text using the catalog account to confirm:<br />
+<input id="query" type="text" size="180" onclick="select()" readonly/>
+</body>
+</html>
+`
+
+var createTemplate = template.Must(template.New("articleCreate").Parse(createHtml))
+
+// Handler function for /article
+func HandleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		if err := createTemplate.Execute(w, nil); err != nil {
+			http.Error(w, "Error executing template: "+err.Error(), http.StatusInternalServerError)
+		}
+	} else if r.Method == "POST" {
+		c := db.NewContext(r)
+
+		id := r.FormValue("article")
+		description := r.FormValue("description")
+		enabled := r.FormValue("enabled")
+		timeouts := r.FormValue("timeouts")
+		maxworksize := r.FormValue("maxworksize")
+		feeratio := r.FormValue("feeratio")
+		nonce := r.FormValue("nonce")
+		signature := r.FormValue("signature")
+
+		if err := createArticle(c, id, description, enabled, timeouts, maxworksize, feeratio, nonce, signature); err != nil {
+			http.Error(w, "Error creating article: "+err.Error(), http.StatusInternalServerError)
+		} else {
+			http.Redirect(w, r, "/article/"+url.PathEscape(id), http.StatusFound)
+		}
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func createArticle(c context.Context, id, description, enabled, timeouts, maxworksize, feeratio, nonce, signature string) (err error) {
+	// Important: checking (and invalidating) the nonce must be the first thing we do!
+	err = nonce2.CheckNonce(c, nonce)
+	if config.CfgRequireValidNonce && err != nil {
+		return fmt.Errorf("Error in checkNonce: %v", err)
+	}
+
+	article, err := bitwrk.ParseArticle(id, description, enabled, timeouts, maxworksize, feeratio, nonce, signature)
+	if err != nil {
+		return
+	}
+
+	if config.CfgRequireValidSignature {
+		if err := article.Verify(config.CfgArticleCatalogAccount); err != nil {
+			return err
+		}
+	}
+
+	log.Infof(c, "Saving article: %v", article)
+
+	// No need to run in transaction, there is only one write operation and no read
+	dao := db.NewAccountingDao(c, false)
+	return dao.SaveArticle(article)
+}
+
+// Handler function for /article/<id>. Returns the catalog entry applying to the article,
+// which is a built-in default if the catalog doesn't contain an entry of its own.
+func HandleRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := bitwrk.ArticleId(r.URL.Path[len("/article/"):])
+	c := db.NewContext(r)
+	dao := db.NewAccountingDao(c, false)
+
+	var article *bitwrk.Article
+	if a, err := bitwrk.LookupArticle(dao, id); err == bitwrk.ErrNoSuchObject {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else {
+		article = a
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(article); err != nil {
+		log.Errorf(c, "Error encoding JSON: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
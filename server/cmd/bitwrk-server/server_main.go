@@ -0,0 +1,116 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Command bitwrk-server runs a self-hosted BitWrk market. It serves the same handlers
+// as the App Engine server, but stores all data in a single local database file.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/indyjo/bitwrk/server"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/embedded"
+)
+
+var ListenAddress string
+var DatabaseFile string
+var AdminPassword string
+var StaticDir string
+
+func main() {
+	flags := flag.NewFlagSet("bitwrk-server", flag.ExitOnError)
+	flags.StringVar(&ListenAddress, "addr", ":8080", "Network address to listen on")
+	flags.StringVar(&DatabaseFile, "db", "bitwrk.db", "Database file to store all market data in")
+	flags.StringVar(&AdminPassword, "admin-password", os.Getenv("BITWRK_ADMIN_PASSWORD"),
+		"Password for logging in with admin privileges (default: $BITWRK_ADMIN_PASSWORD)")
+	flags.StringVar(&StaticDir, "staticdir", "static", "Directory containing static files (JavaScript, favicon)")
+	err := flags.Parse(os.Args[1:])
+	if err == flag.ErrHelp {
+		flags.Usage()
+	} else if err != nil {
+		log.Fatalf("Error parsing command line: %v", err)
+	}
+
+	backend, err := embedded.Open(DatabaseFile, embedded.Options{AdminPassword: AdminPassword})
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	db.SetBackend(backend)
+	log.Printf("Database file: %v", DatabaseFile)
+	if AdminPassword == "" {
+		log.Printf("No admin password given. Admin login is disabled.")
+	}
+
+	http.HandleFunc("/_ah/login", backend.HandleLogin)
+	http.HandleFunc("/_ah/logout", backend.HandleLogout)
+
+	// Static files, as configured in app.yaml
+	http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(http.Dir(filepath.Join(StaticDir, "js")))))
+	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(StaticDir, "favicon.ico"))
+	})
+
+	// Queued tasks are dispatched to the mux directly, bypassing the protection below.
+	backend.Start(http.DefaultServeMux)
+
+	server := &http.Server{
+		Addr:    ListenAddress,
+		Handler: protectQueue(http.DefaultServeMux),
+	}
+
+	exit := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %v", ListenAddress)
+		exit <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-exit:
+		log.Printf("Server exited: %v", err)
+	case sig := <-signals:
+		log.Printf("Received %v. Shutting down.", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = server.Shutdown(ctx)
+		cancel()
+	}
+
+	if err := backend.Close(); err != nil {
+		log.Fatalf("Error closing database: %v", err)
+	}
+}
+
+// Function protectQueue rejects all external requests to the task queue handlers.
+func protectQueue(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/_ah/queue/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -22,9 +22,12 @@ const CfgRequireValidNonce = true
 const CfgRequireValidSignature = true
 const CfgRequireValidWorkerURL = true
 
-// Account ID that is trusted when receiving a deposit
+// Account ID that is trusted when receiving a deposit or settling a withdrawal
 const CfgTrustedAccount = "1TrsjuCvBch1D9h6nRkadGKakv9KyaiP6"
 
+// Smallest amount that may be withdrawn at once
+const CfgMinimumWithdrawal = "mBTC 1"
+
 // The following constants refer to the privilege of being able to sell articles with a
 // "~trusted" suffix. This privilege is embodied by a certain account ID having a "trusts"
 // relation to the seller.
@@ -33,3 +36,9 @@ const CfgTrustedAccount = "1TrsjuCvBch1D9h6nRkadGKakv9KyaiP6"
 const CfgRequireTrustsRelation = true
 // Account which needs to have "trusts" relation to seller wishing to sell on ~trusted article ID.
 const CfgTrustsRelationAccount = "1C1oudoQRdNh6mKr6VaTg2DPveVq97VAyT"
+
+// Account which signs entries of the article catalog.
+const CfgArticleCatalogAccount = "1C1oudoQRdNh6mKr6VaTg2DPveVq97VAyT"
+
+// Account which appoints arbiters for disputed results by having an "arbiter" relation to them.
+const CfgArbitrationAccount = "1C1oudoQRdNh6mKr6VaTg2DPveVq97VAyT"
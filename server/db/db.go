@@ -0,0 +1,261 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package db abstracts the storage, queueing, caching and user services the BitWrk server
+// relies on. Handlers talk to the package-level functions, which delegate to the Backend
+// installed using SetBackend.
+package db
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+var ErrTransactionTooYoung = errors.New("Transaction is too young to be retired")
+var ErrTransactionAlreadyRetired = errors.New("Transaction has already been retired")
+
+// Returned by CacheGet if no (unexpired) item exists for the requested key.
+var ErrCacheMiss = errors.New("Cache miss")
+
+// Returned by ConsumeNonce if the nonce doesn't exist or has been consumed already.
+var ErrNoSuchNonce = errors.New("No such nonce")
+
+type TxFunc func(key string, tx bitwrk.Transaction)
+
+// Type Nonce holds the information stored with each nonce handed out by the server.
+type Nonce struct {
+	Created, Expires      time.Time
+	UserAgent, RemoteAddr string
+}
+
+// Type User describes a logged-in user.
+type User struct {
+	Email string
+	Admin bool
+}
+
+func (u *User) String() string {
+	return u.Email
+}
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelCritical
+)
+
+// Interface Backend is implemented by every environment the BitWrk server can run on.
+// Keys of bids, transactions and account movements are opaque strings whose format is
+// defined by the backend.
+type Backend interface {
+	// Returns a context for serving the given request. All other functions expect to be
+	// passed a context derived from it.
+	NewContext(r *http.Request) context.Context
+
+	// Runs f atomically. Either all of the changes performed by f are applied, or none.
+	// The context passed to f must be used for all operations inside the transaction.
+	RunInTransaction(c context.Context, f func(c context.Context) error) error
+
+	// Returns an accounting DAO. Transactional DAOs may only be used inside RunInTransaction.
+	NewAccountingDao(c context.Context, transactional bool) bitwrk.CachedAccountingDao
+
+	GetBid(c context.Context, bidId string) (*bitwrk.Bid, error)
+	EnqueueBid(c context.Context, bid *bitwrk.Bid) (string, error)
+	TriggerBatchProcessing(c context.Context, matchKey string) error
+	PlaceBid(c context.Context, bidId string) error
+	RetireBid(c context.Context, bidId string) error
+	MatchBids(c context.Context, matched time.Time, newBidId, oldBidId string) error
+
+	GetTransaction(c context.Context, txId string) (*bitwrk.Transaction, error)
+	GetTransactionMessages(c context.Context, txId string) ([]bitwrk.Tmessage, error)
+	UpdateTransaction(c context.Context, txId string, now time.Time, address string,
+		values map[string]string, document, signature string) error
+	RetireTransaction(c context.Context, txId string) error
+
+	QueryAccountKeys(c context.Context, limit int, requestdepositaddress bool, handler func(string)) error
+	QueryTransactions(c context.Context, limit int, article bitwrk.ArticleId, currency money.Currency,
+		begin, end time.Time, handler TxFunc) error
+	QueryAccountMovements(c context.Context, begin time.Time, limit int) ([]bitwrk.AccountMovement, error)
+	// Invokes handler for every entry of the article catalog, ordered by article ID.
+	QueryArticles(c context.Context, handler func(*bitwrk.Article)) error
+
+	// Stores a nonce for later consumption.
+	PutNonce(c context.Context, nonce string, info *Nonce) error
+	// Atomically fetches and deletes a nonce. Returns ErrNoSuchNonce if not found.
+	ConsumeNonce(c context.Context, nonce string) (*Nonce, error)
+	// Deletes (some of the) nonces that have expired at the given time.
+	DeleteExpiredNonces(c context.Context, now time.Time) error
+
+	CacheGet(c context.Context, key string) ([]byte, error)
+	CacheAdd(c context.Context, key string, value []byte, expiration time.Duration) error
+
+	// Returns the currently logged-in user, or nil.
+	CurrentUser(c context.Context) *User
+	LoginURL(c context.Context, dest string) (string, error)
+	LogoutURL(c context.Context, dest string) (string, error)
+
+	Logf(c context.Context, level LogLevel, format string, args ...interface{})
+}
+
+var backend Backend
+
+// Function SetBackend installs the backend used by all functions of this package.
+// Must be called before serving the first request.
+func SetBackend(b Backend) {
+	backend = b
+}
+
+func get() Backend {
+	if backend == nil {
+		panic("No database backend configured")
+	}
+	return backend
+}
+
+func NewContext(r *http.Request) context.Context {
+	return get().NewContext(r)
+}
+
+func RunInTransaction(c context.Context, f func(c context.Context) error) error {
+	return get().RunInTransaction(c, f)
+}
+
+func NewAccountingDao(c context.Context, transactional bool) bitwrk.CachedAccountingDao {
+	return get().NewAccountingDao(c, transactional)
+}
+
+func GetBid(c context.Context, bidId string) (*bitwrk.Bid, error) {
+	return get().GetBid(c, bidId)
+}
+
+// Enqueues a bid while keeping accounts in balance. Returns the new bid's key.
+func EnqueueBid(c context.Context, bid *bitwrk.Bid) (string, error) {
+	return get().EnqueueBid(c, bid)
+}
+
+// Matches bids enqueued for the given article/currency combination.
+func TriggerBatchProcessing(c context.Context, matchKey string) error {
+	return get().TriggerBatchProcessing(c, matchKey)
+}
+
+// Marks a bid as placed. This is purely informational for the user.
+func PlaceBid(c context.Context, bidId string) error {
+	return get().PlaceBid(c, bidId)
+}
+
+// Retires a bid. This will reimburse the bid's price and fee to the buyer.
+func RetireBid(c context.Context, bidId string) error {
+	return get().RetireBid(c, bidId)
+}
+
+// Given IDs of two bids, matches both in a transaction.
+func MatchBids(c context.Context, matched time.Time, newBidId, oldBidId string) error {
+	return get().MatchBids(c, matched, newBidId, oldBidId)
+}
+
+func GetTransaction(c context.Context, txId string) (*bitwrk.Transaction, error) {
+	return get().GetTransaction(c, txId)
+}
+
+func GetTransactionMessages(c context.Context, txId string) ([]bitwrk.Tmessage, error) {
+	return get().GetTransactionMessages(c, txId)
+}
+
+// Sends a message (defined by its argument values) to the transaction and performs
+// the corresponding changes atomically.
+func UpdateTransaction(c context.Context, txId string, now time.Time, address string,
+	values map[string]string, document, signature string) error {
+	return get().UpdateTransaction(c, txId, now, address, values, document, signature)
+}
+
+// Retires a transaction, causing either the seller to be paid or the buyer to be reimbursed.
+// Returns ErrTransactionTooYoung or ErrTransactionAlreadyRetired if the transaction can't be
+// retired at the time of the call.
+func RetireTransaction(c context.Context, txId string) error {
+	return get().RetireTransaction(c, txId)
+}
+
+func QueryAccountKeys(c context.Context, limit int, requestdepositaddress bool, handler func(string)) error {
+	return get().QueryAccountKeys(c, limit, requestdepositaddress, handler)
+}
+
+// Queries transactions matching the given constraints. Invokes handler func for every transaction found.
+func QueryTransactions(c context.Context, limit int, article bitwrk.ArticleId, currency money.Currency,
+	begin, end time.Time, handler TxFunc) error {
+	return get().QueryTransactions(c, limit, article, currency, begin, end, handler)
+}
+
+// Queries account movements (ledger entries) in ascending timestamp order, beginning at a specific point in time.
+func QueryAccountMovements(c context.Context, begin time.Time, limit int) ([]bitwrk.AccountMovement, error) {
+	return get().QueryAccountMovements(c, begin, limit)
+}
+
+func QueryArticles(c context.Context, handler func(*bitwrk.Article)) error {
+	return get().QueryArticles(c, handler)
+}
+
+func PutNonce(c context.Context, nonce string, info *Nonce) error {
+	return get().PutNonce(c, nonce, info)
+}
+
+func ConsumeNonce(c context.Context, nonce string) (*Nonce, error) {
+	return get().ConsumeNonce(c, nonce)
+}
+
+func DeleteExpiredNonces(c context.Context, now time.Time) error {
+	return get().DeleteExpiredNonces(c, now)
+}
+
+func CacheGet(c context.Context, key string) ([]byte, error) {
+	return get().CacheGet(c, key)
+}
+
+// Adds an item to the cache, unless an item exists for the key already.
+// A zero expiration means that the item doesn't expire.
+func CacheAdd(c context.Context, key string, value []byte, expiration time.Duration) error {
+	return get().CacheAdd(c, key, value, expiration)
+}
+
+func CurrentUser(c context.Context) *User {
+	return get().CurrentUser(c)
+}
+
+// Returns true if the current user is logged in and has admin privileges.
+func IsAdmin(c context.Context) bool {
+	u := CurrentUser(c)
+	return u != nil && u.Admin
+}
+
+func LoginURL(c context.Context, dest string) (string, error) {
+	return get().LoginURL(c, dest)
+}
+
+func LogoutURL(c context.Context, dest string) (string, error) {
+	return get().LogoutURL(c, dest)
+}
+
+func Logf(c context.Context, level LogLevel, format string, args ...interface{}) {
+	get().Logf(c, level, format, args...)
+}
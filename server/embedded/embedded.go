@@ -0,0 +1,227 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package embedded implements a db.Backend which keeps all data in a single local file
+// and executes queued tasks in-process. It allows running a BitWrk server without
+// Google App Engine, e.g. for private markets and integration tests.
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/matching"
+)
+
+type contextKey int
+
+const (
+	txnKey contextKey = iota
+	requestKey
+)
+
+// Type Options configures an embedded backend.
+type Options struct {
+	// Users logging in with this password (using HTTP basic authentication) are
+	// granted admin privileges. Logging in is impossible if empty.
+	AdminPassword string
+	// The clock used for matching bids. Defaults to the system clock.
+	Clock matching.Clock
+}
+
+// Type Backend is the embedded implementation of db.Backend.
+type Backend struct {
+	store   *store
+	options Options
+	queue   *taskQueue
+	clock   matching.Clock
+
+	cacheMutex sync.Mutex
+	cache      map[string]cacheItem
+}
+
+type cacheItem struct {
+	value   []byte
+	expires time.Time
+}
+
+// Make sure db.Backend is implemented
+var _ db.Backend = &Backend{}
+
+// Function Open opens (or creates) the database file with the given name.
+// Queued tasks are not executed before Start is called.
+func Open(filename string, options Options) (*Backend, error) {
+	s, err := openStore(filename)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backend{
+		store:   s,
+		options: options,
+		clock:   options.Clock,
+		cache:   make(map[string]cacheItem),
+	}
+	if b.clock == nil {
+		b.clock = matching.SystemClock
+	}
+	b.queue = newTaskQueue(b)
+	return b, nil
+}
+
+// Starts executing queued tasks by dispatching them to the given handler, which
+// is expected to serve the "/_ah/queue/" paths.
+func (b *Backend) Start(handler http.Handler) {
+	b.queue.start(handler)
+}
+
+// Stops executing queued tasks and closes the database file.
+func (b *Backend) Close() error {
+	b.queue.stop()
+	return b.store.close()
+}
+
+func (b *Backend) NewContext(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), requestKey, r)
+}
+
+func txnFrom(c context.Context) *txn {
+	if t, ok := c.Value(txnKey).(*txn); ok {
+		return t
+	}
+	return nil
+}
+
+// Runs f inside the context's transaction. If there is none, a new transaction is
+// created for the duration of f.
+func (b *Backend) do(c context.Context, f func(t *txn) error) error {
+	if t := txnFrom(c); t != nil {
+		return f(t)
+	}
+	return b.store.update(f)
+}
+
+func (b *Backend) RunInTransaction(c context.Context, f func(c context.Context) error) error {
+	return b.do(c, func(t *txn) error {
+		return f(context.WithValue(c, txnKey, t))
+	})
+}
+
+func (b *Backend) CacheGet(c context.Context, key string) ([]byte, error) {
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
+	if item, ok := b.cache[key]; !ok {
+		return nil, db.ErrCacheMiss
+	} else if !item.expires.IsZero() && !item.expires.After(time.Now()) {
+		delete(b.cache, key)
+		return nil, db.ErrCacheMiss
+	} else {
+		return item.value, nil
+	}
+}
+
+func (b *Backend) CacheAdd(c context.Context, key string, value []byte, expiration time.Duration) error {
+	b.cacheMutex.Lock()
+	defer b.cacheMutex.Unlock()
+	now := time.Now()
+	if len(b.cache) >= 10000 {
+		// Sweep expired items
+		for k, item := range b.cache {
+			if !item.expires.IsZero() && !item.expires.After(now) {
+				delete(b.cache, k)
+			}
+		}
+	}
+	if item, ok := b.cache[key]; ok && (item.expires.IsZero() || item.expires.After(now)) {
+		return nil
+	}
+	item := cacheItem{value: value}
+	if expiration > 0 {
+		item.expires = now.Add(expiration)
+	}
+	b.cache[key] = item
+	return nil
+}
+
+// Users authenticate via HTTP basic authentication. Any user name is accepted
+// as long as the password matches the configured admin password.
+func (b *Backend) CurrentUser(c context.Context) *db.User {
+	r, ok := c.Value(requestKey).(*http.Request)
+	if !ok || b.options.AdminPassword == "" {
+		return nil
+	}
+	if name, password, ok := r.BasicAuth(); ok && password == b.options.AdminPassword {
+		return &db.User{Email: name, Admin: true}
+	}
+	return nil
+}
+
+func (b *Backend) LoginURL(c context.Context, dest string) (string, error) {
+	return "/_ah/login?continue=" + url.QueryEscape(dest), nil
+}
+
+func (b *Backend) LogoutURL(c context.Context, dest string) (string, error) {
+	return "/_ah/logout?continue=" + url.QueryEscape(dest), nil
+}
+
+// Handler function for the URL returned by LoginURL. Asks for credentials until
+// the user is logged in, then redirects to the requested destination.
+func (b *Backend) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if b.CurrentUser(b.NewContext(r)) == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="BitWrk"`)
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, continueURL(r), http.StatusFound)
+}
+
+// Handler function for the URL returned by LogoutURL. Browsers forget their
+// basic authentication credentials after receiving a 401 status.
+func (b *Backend) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprintf(w, "Logged out. <a href=\"%v\">Continue</a>", continueURL(r))
+}
+
+// Returns the redirection target of a login or logout request, restricted to local paths.
+func continueURL(r *http.Request) string {
+	if u, err := url.Parse(r.FormValue("continue")); err != nil || u.Host != "" || u.Scheme != "" {
+		return "/"
+	} else if u.Path == "" {
+		return "/"
+	} else {
+		return u.RequestURI()
+	}
+}
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"}
+
+func (b *Backend) Logf(c context.Context, level db.LogLevel, format string, args ...interface{}) {
+	name := "CRITICAL"
+	if int(level) < len(levelNames) {
+		name = levelNames[level]
+	}
+	if r, ok := c.Value(requestKey).(*http.Request); ok {
+		log.Printf("%v [%v] %v", name, r.URL.Path, fmt.Sprintf(format, args...))
+	} else {
+		log.Printf("%v %v", name, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,150 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	. "github.com/indyjo/bitwrk/common/bitwrk"
+)
+
+func accountKey(participant string) string {
+	return "Account/" + participant
+}
+
+func movementKey(key string) string {
+	return "AccountMovement/" + key
+}
+
+func depositKey(uid string) string {
+	return "Deposit/" + uid
+}
+
+func withdrawalKey(uid string) string {
+	return "Withdrawal/" + uid
+}
+
+func relationKey(source, target string, reltype RelationType) string {
+	return "Relation/" + source + "/" + reltype.String() + "/" + target
+}
+
+func articleKey(id ArticleId) string {
+	return "Article/" + string(id)
+}
+
+type embeddedAccountingDao struct {
+	b *Backend
+	c context.Context
+}
+
+// Make sure AccountingDao is implemented
+var _ AccountingDao = &embeddedAccountingDao{}
+
+// Reads an entity, translating a missing entity into ErrNoSuchObject.
+func (dao *embeddedAccountingDao) get(key string, v interface{}) error {
+	return dao.b.do(dao.c, func(t *txn) error {
+		if err := t.get(key, v); err == errNoSuchEntity {
+			return ErrNoSuchObject
+		} else {
+			return err
+		}
+	})
+}
+
+func (dao *embeddedAccountingDao) put(key string, v interface{}) error {
+	return dao.b.do(dao.c, func(t *txn) error {
+		return t.put(key, v)
+	})
+}
+
+func (dao *embeddedAccountingDao) GetAccount(participant string) (account ParticipantAccount, err error) {
+	err = dao.get(accountKey(participant), &account)
+	return
+}
+
+func (dao *embeddedAccountingDao) SaveAccount(account *ParticipantAccount) error {
+	if account == nil || account.Participant == "" {
+		panic(fmt.Errorf("Can't save account: %v", account))
+	}
+	return dao.put(accountKey(account.Participant), account)
+}
+
+func (dao *embeddedAccountingDao) GetMovement(key string) (movement AccountMovement, err error) {
+	err = dao.get(movementKey(key), &movement)
+	return
+}
+
+func (dao *embeddedAccountingDao) SaveMovement(movement *AccountMovement) error {
+	// don't check for nil here -> programmer's error
+	return dao.put(movementKey(*movement.Key), movement)
+}
+
+func (dao *embeddedAccountingDao) NewAccountMovementKey(participant string) (key string, err error) {
+	err = dao.b.do(dao.c, func(t *txn) error {
+		key = strconv.FormatInt(t.newId(), 10)
+		return nil
+	})
+	return
+}
+
+func (dao *embeddedAccountingDao) GetDeposit(uid string) (deposit Deposit, err error) {
+	err = dao.get(depositKey(uid), &deposit)
+	return
+}
+
+func (dao *embeddedAccountingDao) SaveDeposit(uid string, deposit *Deposit) error {
+	return dao.put(depositKey(uid), deposit)
+}
+
+func (dao *embeddedAccountingDao) GetWithdrawal(uid string) (withdrawal Withdrawal, err error) {
+	err = dao.get(withdrawalKey(uid), &withdrawal)
+	return
+}
+
+func (dao *embeddedAccountingDao) SaveWithdrawal(uid string, withdrawal *Withdrawal) error {
+	return dao.put(withdrawalKey(uid), withdrawal)
+}
+
+func (dao *embeddedAccountingDao) GetRelation(source, target string, reltype RelationType) (*Relation, error) {
+	var relation Relation
+	if err := dao.get(relationKey(source, target, reltype), &relation); err != nil {
+		return nil, err
+	}
+	return &relation, nil
+}
+
+func (dao *embeddedAccountingDao) SaveRelation(relation *Relation) error {
+	return dao.put(relationKey(relation.Source, relation.Target, relation.Type), relation)
+}
+
+func (dao *embeddedAccountingDao) GetArticle(id ArticleId) (*Article, error) {
+	var article Article
+	if err := dao.get(articleKey(id), &article); err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+func (dao *embeddedAccountingDao) SaveArticle(article *Article) error {
+	return dao.put(articleKey(article.Id), article)
+}
+
+func (b *Backend) NewAccountingDao(c context.Context, transactional bool) CachedAccountingDao {
+	return NewCachedAccountingDao(&embeddedAccountingDao{b, c}, transactional)
+}
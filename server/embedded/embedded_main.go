@@ -0,0 +1,473 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/server/db"
+)
+
+// Bids, transactions and account movements are identified by decimal numbers.
+func checkId(id string) error {
+	if _, err := strconv.ParseUint(id, 10, 63); err != nil {
+		return fmt.Errorf("Invalid key: %#v", id)
+	}
+	return nil
+}
+
+func bidKey(bidId string) string {
+	return "Bid/" + bidId
+}
+
+func txKey(txId string) string {
+	return "Tx/" + txId
+}
+
+func messagePrefix(txId string) string {
+	return "Tmessage/" + txId + "/"
+}
+
+func nonceKey(nonce string) string {
+	return "Nonce/" + nonce
+}
+
+func (b *Backend) getBid(t *txn, bidId string) (*Bid, error) {
+	if err := checkId(bidId); err != nil {
+		return nil, err
+	}
+	bid := new(Bid)
+	if err := t.get(bidKey(bidId), bid); err != nil {
+		return nil, err
+	}
+	return bid, nil
+}
+
+func (b *Backend) getTransaction(t *txn, txId string) (*Transaction, error) {
+	if err := checkId(txId); err != nil {
+		return nil, err
+	}
+	tx := new(Transaction)
+	if err := t.get(txKey(txId), tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (b *Backend) GetBid(c context.Context, bidId string) (bid *Bid, err error) {
+	err = b.do(c, func(t *txn) (err error) {
+		bid, err = b.getBid(t, bidId)
+		return
+	})
+	return
+}
+
+// Transactional function to enqueue a bid, while keeping accounts in balance
+func (b *Backend) EnqueueBid(c context.Context, bid *Bid) (string, error) {
+	var bidId string
+	f := func(c context.Context) error {
+		t := txnFrom(c)
+		dao := b.NewAccountingDao(c, true)
+
+		if err := bid.CheckBalance(dao); err != nil {
+			return err
+		}
+
+		bidId = strconv.FormatInt(t.newId(), 10)
+		if err := t.put(bidKey(bidId), bid); err != nil {
+			return err
+		}
+
+		if err := bid.Book(dao, bidId); err != nil {
+			return err
+		}
+
+		if err := b.addRetireBidTask(t, bidId, bid); err != nil {
+			return err
+		}
+
+		// Put the new bid into the list of incoming bids, to be processed by TriggerBatchProcessing.
+		if err := b.addIncomingBid(t, bidId, bid); err != nil {
+			return err
+		}
+
+		return dao.Flush()
+	}
+
+	if err := b.RunInTransaction(c, f); err != nil {
+		return "", err
+	}
+
+	return bidId, nil
+}
+
+// Retires a bid, reimbursing the bid's price and fee to the buyer.
+func (b *Backend) RetireBid(c context.Context, bidId string) error {
+	return b.RunInTransaction(c, func(c context.Context) error {
+		t := txnFrom(c)
+		now := time.Now()
+		dao := b.NewAccountingDao(c, true)
+		bid, err := b.getBid(t, bidId)
+		if err != nil {
+			return err
+		}
+
+		if bid.State == Matched {
+			b.Logf(c, db.LevelInfo, "Not retiring matched bid %v", bidId)
+			return nil
+		}
+
+		if err := bid.Retire(dao, bidId, now); err != nil {
+			return err
+		}
+
+		if err := t.put(bidKey(bidId), bid); err != nil {
+			return err
+		}
+
+		return dao.Flush()
+	})
+}
+
+// Marks a bid as placed. This is purely informational for the user.
+func (b *Backend) PlaceBid(c context.Context, bidId string) error {
+	return b.do(c, func(t *txn) error {
+		bid, err := b.getBid(t, bidId)
+		if err != nil {
+			return err
+		}
+
+		if bid.State != InQueue {
+			b.Logf(c, db.LevelInfo, "Not placing bid %v : State=%v", bidId, bid.State)
+			return nil
+		}
+
+		bid.State = Placed
+		return t.put(bidKey(bidId), bid)
+	})
+}
+
+// Given IDs of two bids, matches both in a transaction.
+func (b *Backend) MatchBids(c context.Context, matched time.Time, newBidId, oldBidId string) error {
+	return b.RunInTransaction(c, func(c context.Context) error {
+		t := txnFrom(c)
+		newBid, err := b.getBid(t, newBidId)
+		if err != nil {
+			return err
+		}
+		oldBid, err := b.getBid(t, oldBidId)
+		if err != nil {
+			return err
+		}
+
+		// Older bid may still be in state InQueue, due to asynchronicity
+		if oldBid.State == InQueue {
+			oldBid.State = Placed
+		}
+
+		dao := b.NewAccountingDao(c, true)
+		var timeouts PhaseTimeouts
+		if article, err := LookupArticle(dao, newBid.Article); err == nil {
+			timeouts = article.Timeouts
+		} else if err != ErrNoSuchObject {
+			return err
+		}
+
+		// Also modifies newBid and oldBid
+		tx, err := NewTransaction(matched, timeouts, newBidId, oldBidId, newBid, oldBid)
+		if err != nil {
+			return err
+		}
+
+		txId := strconv.FormatInt(t.newId(), 10)
+		if err := t.put(txKey(txId), tx); err != nil {
+			return err
+		}
+
+		// Store both bids and schedule the transaction's retirement
+		newBid.Transaction = &txId
+		if err := t.put(bidKey(newBidId), newBid); err != nil {
+			return err
+		}
+		oldBid.Transaction = &txId
+		if err := t.put(bidKey(oldBidId), oldBid); err != nil {
+			return err
+		}
+
+		if err := b.addRetireTransactionTask(t, txId, tx); err != nil {
+			return err
+		}
+
+		buyerBid := oldBid
+		if newBid.Type == Buy {
+			buyerBid = newBid
+		}
+
+		if err := tx.Book(dao, txId, buyerBid); err != nil {
+			return err
+		}
+
+		return dao.Flush()
+	})
+}
+
+func (b *Backend) GetTransaction(c context.Context, txId string) (tx *Transaction, err error) {
+	err = b.do(c, func(t *txn) (err error) {
+		tx, err = b.getTransaction(t, txId)
+		return
+	})
+	return
+}
+
+func (b *Backend) GetTransactionMessages(c context.Context, txId string) ([]Tmessage, error) {
+	messages := make([]Tmessage, 0, 16)
+	err := b.do(c, func(t *txn) error {
+		return t.scan(messagePrefix(txId), func(key string, data []byte) error {
+			var message Tmessage
+			if err := decode(data, &message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Received.Before(messages[j].Received)
+	})
+	if len(messages) > 101 {
+		messages = messages[:101]
+	}
+	return messages, nil
+}
+
+// Sends a message (defined by its argument values) to the transaction and performs
+// the corresponding changes atomically.
+func (b *Backend) UpdateTransaction(c context.Context, txId string, now time.Time, address string,
+	values map[string]string, document, signature string) error {
+	return b.do(c, func(t *txn) error {
+		tx, err := b.getTransaction(t, txId)
+		if err != nil {
+			return err
+		}
+
+		message := tx.SendMessage(now, address, values)
+
+		if !message.Accepted {
+			return fmt.Errorf("Message not accepted: %v", message.RejectMessage)
+		}
+
+		message.Received = now
+		message.Document = document
+		message.Signature = signature
+
+		if err := t.put(fmt.Sprintf("%v%020d", messagePrefix(txId), t.newId()), message); err != nil {
+			return err
+		}
+
+		if err := t.put(txKey(txId), tx); err != nil {
+			return err
+		}
+
+		return b.addRetireTransactionTask(t, txId, tx)
+	})
+}
+
+// Transactions in phase FINISHED will cause the price to be credited on the seller's
+// account, and the fee to be deducted.
+// All other phases will lead to price and fee being reimbursed to the buyer.
+func (b *Backend) RetireTransaction(c context.Context, txId string) error {
+	return b.RunInTransaction(c, func(c context.Context) error {
+		t := txnFrom(c)
+		now := time.Now()
+		dao := b.NewAccountingDao(c, true)
+		tx, err := b.getTransaction(t, txId)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Retire(dao, txId, now); err == ErrTooYoung {
+			return db.ErrTransactionTooYoung
+		} else if err == ErrAlreadyRetired {
+			return db.ErrTransactionAlreadyRetired
+		} else if err != nil {
+			return err
+		}
+
+		if err := t.put(txKey(txId), tx); err != nil {
+			return err
+		}
+
+		return dao.Flush()
+	})
+}
+
+func (b *Backend) QueryAccountKeys(c context.Context, limit int, requestdepositaddress bool, handler func(string)) error {
+	count := 0
+	return b.do(c, func(t *txn) error {
+		return t.scan("Account/", func(key string, data []byte) error {
+			if count >= limit {
+				return nil
+			}
+			if requestdepositaddress {
+				var account ParticipantAccount
+				if err := decode(data, &account); err != nil {
+					return err
+				} else if account.DepositAddressRequest == "" {
+					return nil
+				}
+			}
+			count++
+			handler(strings.TrimPrefix(key, "Account/"))
+			return nil
+		})
+	})
+}
+
+// Queries transactions matching the given constraints. Invokes handler func for every transaction found.
+func (b *Backend) QueryTransactions(c context.Context, limit int, article ArticleId, currency money.Currency,
+	begin, end time.Time, handler db.TxFunc) error {
+	type keyedTx struct {
+		key string
+		tx  Transaction
+	}
+	result := make([]keyedTx, 0, 16)
+	err := b.do(c, func(t *txn) error {
+		return t.scan("Tx/", func(key string, data []byte) error {
+			var tx Transaction
+			if err := decode(data, &tx); err != nil {
+				return err
+			}
+			if tx.Article != article || tx.Price.Currency != currency ||
+				tx.Matched.Before(begin) || !tx.Matched.Before(end) {
+				return nil
+			}
+			result = append(result, keyedTx{strings.TrimPrefix(key, "Tx/"), tx})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].tx.Matched.Before(result[j].tx.Matched)
+	})
+	for i, r := range result {
+		if i >= limit {
+			break
+		}
+		handler(r.key, r.tx)
+	}
+	return nil
+}
+
+// Queries account movements (ledger entries) in ascending timestamp order, beginning at a specific point in time.
+func (b *Backend) QueryAccountMovements(c context.Context, begin time.Time, limit int) ([]AccountMovement, error) {
+	result := make([]AccountMovement, 0, limit)
+	err := b.do(c, func(t *txn) error {
+		return t.scan("AccountMovement/", func(key string, data []byte) error {
+			var movement AccountMovement
+			if err := decode(data, &movement); err != nil {
+				return err
+			}
+			if movement.Timestamp.Before(begin) {
+				return nil
+			}
+			result = append(result, movement)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (b *Backend) QueryArticles(c context.Context, handler func(*Article)) error {
+	return b.do(c, func(t *txn) error {
+		return t.scan("Article/", func(key string, data []byte) error {
+			var article Article
+			if err := decode(data, &article); err != nil {
+				return err
+			}
+			handler(&article)
+			return nil
+		})
+	})
+}
+
+func (b *Backend) PutNonce(c context.Context, nonce string, info *db.Nonce) error {
+	return b.do(c, func(t *txn) error {
+		return t.put(nonceKey(nonce), info)
+	})
+}
+
+func (b *Backend) ConsumeNonce(c context.Context, nonce string) (*db.Nonce, error) {
+	var result db.Nonce
+	err := b.do(c, func(t *txn) error {
+		if err := t.get(nonceKey(nonce), &result); err == errNoSuchEntity {
+			return db.ErrNoSuchNonce
+		} else if err != nil {
+			return err
+		}
+		t.delete(nonceKey(nonce))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *Backend) DeleteExpiredNonces(c context.Context, now time.Time) error {
+	return b.do(c, func(t *txn) error {
+		count := 0
+		err := t.scan("Nonce/", func(key string, data []byte) error {
+			var nonce db.Nonce
+			if err := decode(data, &nonce); err != nil {
+				return err
+			}
+			if !nonce.Expires.After(now) {
+				t.delete(key)
+				count++
+			}
+			return nil
+		})
+		if count > 0 {
+			b.Logf(c, db.LevelInfo, "Delete %v expired nonces", count)
+		}
+		return err
+	})
+}
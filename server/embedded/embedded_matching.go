@@ -0,0 +1,96 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/matching"
+)
+
+// Like in the App Engine backend, bids in state "Placed" have a corresponding
+// entry in the hot zone of their article/currency combination. Each hot zone
+// is stored as a single matching.Book.
+func hotZoneKey(matchKey string) string {
+	return "HotZone/" + matchKey
+}
+
+func incomingPrefix(matchKey string) string {
+	return "HotIncoming/" + matchKey + "/"
+}
+
+// Puts a freshly enqueued bid into the list of incoming bids of its article/currency.
+func (b *Backend) addIncomingBid(t *txn, bidId string, bid *bitwrk.Bid) error {
+	key := fmt.Sprintf("%v%020d", incomingPrefix(bid.MatchKey()), t.newId())
+	order := matching.NewOrder(bidId, bid)
+	return t.put(key, &order)
+}
+
+// Function TriggerBatchProcessing matches all incoming bids of an article/currency combination.
+// As transactions are serialized, no further synchronization is needed.
+func (b *Backend) TriggerBatchProcessing(c context.Context, matchKey string) error {
+	return b.do(c, func(t *txn) error {
+		incomingBids := make([]matching.Order, 0, 16)
+		err := t.scan(incomingPrefix(matchKey), func(key string, data []byte) error {
+			var order matching.Order
+			if err := decode(data, &order); err != nil {
+				b.Logf(c, db.LevelError, "Couldn't decode incoming bid %v: %v", key, err)
+			} else {
+				incomingBids = append(incomingBids, order)
+			}
+			t.delete(key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(incomingBids) == 0 {
+			return nil
+		}
+		return b.matchIncomingBids(c, t, b.clock.Now(), matchKey, incomingBids)
+	})
+}
+
+// Takes a list of hot bids, all belonging to the same article/currency, and tries to match them against
+// existing bids, in sequence.
+func (b *Backend) matchIncomingBids(c context.Context, t *txn, now time.Time, matchKey string, incomingBids []matching.Order) error {
+	b.Logf(c, db.LevelInfo, "Matching hot bids [%v]: %v", matchKey, incomingBids)
+
+	var book matching.Book
+	if err := t.get(hotZoneKey(matchKey), &book); err != nil && err != errNoSuchEntity {
+		return err
+	}
+
+	result, err := book.Match(now, incomingBids)
+	if err != nil {
+		return err
+	}
+	b.Logf(c, db.LevelInfo, "Removed %v expired bids", len(result.Expired))
+
+	if err := t.put(hotZoneKey(matchKey), &book); err != nil {
+		return err
+	}
+
+	if len(result.Matches) == 0 && len(result.Placed) == 0 {
+		return nil
+	}
+	return b.addApplyChangesTask(t, now, result.MatchedKeys(), result.PlacedKeys())
+}
@@ -0,0 +1,233 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package embedded
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+)
+
+// A task is a POST request to one of the "/_ah/queue/" handlers, executed at a
+// specific time. Tasks are stored in the database together with the changes of the
+// transaction that created them.
+type task struct {
+	Path    string
+	Values  url.Values
+	ETA     time.Time
+	Retries int
+}
+
+// Type taskQueue executes stored tasks in order of their ETA. Failing tasks are
+// retried with exponential backoff.
+type taskQueue struct {
+	b       *Backend
+	mutex   sync.Mutex
+	handler http.Handler
+	wakeup  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newTaskQueue(b *Backend) *taskQueue {
+	return &taskQueue{
+		b:      b,
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+func (q *taskQueue) start(handler http.Handler) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.handler != nil {
+		panic("Task queue already started")
+	}
+	q.handler = handler
+	q.done = make(chan struct{})
+	q.stopped = make(chan struct{})
+	go q.loop()
+}
+
+func (q *taskQueue) stop() {
+	q.mutex.Lock()
+	done, stopped := q.done, q.stopped
+	q.mutex.Unlock()
+	if done != nil {
+		close(done)
+		<-stopped
+	}
+}
+
+// Signals the queue that new tasks are available.
+func (q *taskQueue) notify() {
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (q *taskQueue) loop() {
+	defer close(q.stopped)
+	for {
+		next, err := q.runDueTasks(time.Now())
+		if err != nil {
+			log.Printf("Error executing tasks: %v", err)
+			next = time.Now().Add(10 * time.Second)
+		}
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-q.done:
+			return
+		case <-q.wakeup:
+		case <-timer:
+		}
+	}
+}
+
+// Executes all tasks due at the given time. Returns the ETA of the next pending task,
+// or the zero time if there is none.
+func (q *taskQueue) runDueTasks(now time.Time) (time.Time, error) {
+	type keyedTask struct {
+		key  string
+		task task
+	}
+	var due []keyedTask
+	var next time.Time
+	err := q.b.store.update(func(t *txn) error {
+		return t.scan("Task/", func(key string, data []byte) error {
+			var tk task
+			if err := decode(data, &tk); err != nil {
+				return err
+			}
+			if !tk.ETA.After(now) {
+				due = append(due, keyedTask{key, tk})
+			} else if next.IsZero() || tk.ETA.Before(next) {
+				next = tk.ETA
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, d := range due {
+		tk := d.task
+		if status := q.execute(&tk); status >= 200 && status < 300 {
+			err = q.b.store.update(func(t *txn) error {
+				t.delete(d.key)
+				return nil
+			})
+		} else {
+			// Retry after 1s, 2s, 4s, ..., but at least once per hour
+			backoff := time.Hour
+			if tk.Retries < 12 {
+				backoff = time.Second << uint(tk.Retries)
+			}
+			tk.Retries++
+			tk.ETA = time.Now().Add(backoff)
+			log.Printf("Task %v to %v failed with status %v. Retry #%v at %v",
+				d.key, tk.Path, status, tk.Retries, tk.ETA)
+			err = q.b.store.update(func(t *txn) error {
+				return t.put(d.key, &tk)
+			})
+			if next.IsZero() || tk.ETA.Before(next) {
+				next = tk.ETA
+			}
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return next, nil
+}
+
+// Dispatches a task to the handler and returns the resulting HTTP status.
+func (q *taskQueue) execute(tk *task) int {
+	r, err := http.NewRequest("POST", tk.Path, strings.NewReader(tk.Values.Encode()))
+	if err != nil {
+		log.Printf("Couldn't create request for task %v: %v", tk.Path, err)
+		return http.StatusInternalServerError
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-AppEngine-RetryCount", fmt.Sprint(tk.Retries))
+	r.RemoteAddr = "127.0.0.1:0"
+	w := &statusRecorder{header: make(http.Header), status: http.StatusOK}
+	q.handler.ServeHTTP(w, r)
+	return w.status
+}
+
+// Type statusRecorder is a http.ResponseWriter that discards everything but the status.
+type statusRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return len(b), nil
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+// Adds a task to be executed at the time given by eta, or instantly if eta is zero.
+func (b *Backend) addTask(t *txn, tag string, eta time.Time, values url.Values) error {
+	tk := task{
+		Path:   "/_ah/queue/" + tag,
+		Values: values,
+		ETA:    eta,
+	}
+	if err := t.put(fmt.Sprintf("Task/%020d", t.newId()), &tk); err != nil {
+		return err
+	}
+	t.onCommit(b.queue.notify)
+	return nil
+}
+
+func (b *Backend) addApplyChangesTask(t *txn, matched time.Time, matchedBids []string, placedBids []string) error {
+	return b.addTask(t, "apply-changes", time.Time{}, url.Values{
+		"matched":   {strings.Join(matchedBids, " ")},
+		"placed":    {strings.Join(placedBids, " ")},
+		"timestamp": {matched.Format(time.RFC3339Nano)}})
+}
+
+func (b *Backend) addRetireTransactionTask(t *txn, txId string, tx *bitwrk.Transaction) error {
+	return b.addTask(t, "retire-tx", tx.Timeout, url.Values{"tx": {txId}})
+}
+
+func (b *Backend) addRetireBidTask(t *txn, bidId string, bid *bitwrk.Bid) error {
+	return b.addTask(t, "retire-bid", bid.Expires, url.Values{"bid": {bidId}})
+}
@@ -0,0 +1,310 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package embedded
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errNoSuchEntity = errors.New("No such entity")
+
+// A record describes the changes performed by a committed transaction. The database
+// file is a sequence of records, each prefixed by its length and CRC32 checksum.
+type record struct {
+	Puts    map[string][]byte
+	Deletes []string
+	LastId  int64
+}
+
+// Type store is a simple key-value store kept in memory and backed by a single
+// append-only file. Transactions are serialized using a mutex.
+type store struct {
+	mutex   sync.Mutex
+	file    *os.File
+	entries map[string][]byte
+	lastId  int64
+}
+
+// Opens the store in the given file, creating it if necessary. The file is compacted
+// while opening.
+func openStore(filename string) (*store, error) {
+	s := &store{entries: make(map[string][]byte)}
+	if f, err := os.Open(filename); os.IsNotExist(err) {
+		// Start with an empty store
+	} else if err != nil {
+		return nil, err
+	} else {
+		err := s.replay(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading database %#v: %v", filename, err)
+		}
+	}
+
+	if err := s.compact(filename); err != nil {
+		return nil, err
+	}
+
+	if f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	} else {
+		s.file = f
+	}
+	return s, nil
+}
+
+func (s *store) replay(r io.Reader) error {
+	count := 0
+	for {
+		var rec record
+		if err := readRecord(r, &rec); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			// An incomplete record at the end of the file is the result of an interrupted
+			// write. The transaction wasn't committed, so it is safe to ignore it.
+			log.Printf("Ignoring incomplete record #%v at end of database", count)
+			break
+		} else if err != nil {
+			return fmt.Errorf("record #%v: %v", count, err)
+		}
+		s.apply(&rec)
+		count++
+	}
+	return nil
+}
+
+// Writes all entries into a new file which then replaces the old one.
+func (s *store) compact(filename string) error {
+	tempname := filename + ".tmp"
+	f, err := os.OpenFile(tempname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	rec := record{Puts: s.entries, LastId: s.lastId}
+	if err := writeRecord(f, &rec); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempname, filename)
+}
+
+func (s *store) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+func (s *store) apply(rec *record) {
+	for k, v := range rec.Puts {
+		s.entries[k] = v
+	}
+	for _, k := range rec.Deletes {
+		delete(s.entries, k)
+	}
+	if rec.LastId > s.lastId {
+		s.lastId = rec.LastId
+	}
+}
+
+func writeRecord(w io.Writer, rec *record) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(rec); err != nil {
+		return err
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	if _, err := w.Write(append(header[:], payload.Bytes()...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readRecord(r io.Reader, rec *record) error {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, payload); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return errors.New("checksum mismatch")
+	}
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(rec)
+}
+
+// Type txn holds the uncommitted changes of a transaction. A nil value marks a deletion.
+type txn struct {
+	s           *store
+	changes     map[string][]byte
+	lastId      int64
+	afterCommit []func()
+}
+
+// Runs f inside a transaction. The transaction is committed if f returns nil.
+func (s *store) update(f func(t *txn) error) error {
+	var hooks []func()
+	err := func() error {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		t := &txn{s: s, changes: make(map[string][]byte), lastId: s.lastId}
+		if err := f(t); err != nil {
+			return err
+		}
+		if err := t.commit(); err != nil {
+			return err
+		}
+		hooks = t.afterCommit
+		return nil
+	}()
+	if err == nil {
+		for _, hook := range hooks {
+			hook()
+		}
+	}
+	return err
+}
+
+func (t *txn) commit() error {
+	if len(t.changes) == 0 && t.lastId == t.s.lastId {
+		return nil
+	}
+	rec := record{Puts: make(map[string][]byte), LastId: t.lastId}
+	for k, v := range t.changes {
+		if v == nil {
+			rec.Deletes = append(rec.Deletes, k)
+		} else {
+			rec.Puts[k] = v
+		}
+	}
+	// Remember where the record starts so that a failed write or sync can be undone.
+	// Otherwise, the record would be replayed on the next start although the
+	// transaction reported an error.
+	offset, err := t.s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := writeRecord(t.s.file, &rec); err != nil {
+		return t.s.rollback(offset, err)
+	}
+	if err := t.s.file.Sync(); err != nil {
+		return t.s.rollback(offset, err)
+	}
+	t.s.apply(&rec)
+	return nil
+}
+
+// Truncates the file to the given offset, discarding a record that couldn't be committed.
+// Returns the error that caused the rollback.
+func (s *store) rollback(offset int64, cause error) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return fmt.Errorf("%v (rollback failed: %v)", cause, err)
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("%v (rollback failed: %v)", cause, err)
+	}
+	return cause
+}
+
+// Returns a new, unique numerical ID.
+func (t *txn) newId() int64 {
+	t.lastId++
+	return t.lastId
+}
+
+func (t *txn) get(key string, v interface{}) error {
+	data, ok := t.changes[key]
+	if !ok {
+		data, ok = t.s.entries[key]
+	}
+	if !ok || data == nil {
+		return errNoSuchEntity
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (t *txn) put(key string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	t.changes[key] = buf.Bytes()
+	return nil
+}
+
+func (t *txn) delete(key string) {
+	t.changes[key] = nil
+}
+
+// Calls f for every key starting with the given prefix, in ascending order.
+// Iteration stops when f returns an error, which is then returned.
+func (t *txn) scan(prefix string, f func(key string, data []byte) error) error {
+	keys := make([]string, 0, 16)
+	for k := range t.s.entries {
+		if _, changed := t.changes[k]; !changed && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	for k, v := range t.changes {
+		if v != nil && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		data, ok := t.changes[k]
+		if !ok {
+			data = t.s.entries[k]
+		}
+		if err := f(k, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Registers a function to be called after the transaction has been committed successfully.
+func (t *txn) onCommit(f func()) {
+	t.afterCommit = append(t.afterCommit, f)
+}
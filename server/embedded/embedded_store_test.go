@@ -0,0 +1,201 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package embedded
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitwrk-embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.db")
+
+	s, err := openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Committed transaction
+	if err := s.update(func(tx *txn) error {
+		tx.newId()
+		if err := tx.put("A/1", "one"); err != nil {
+			return err
+		}
+		return tx.put("A/2", "two")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolled back transaction
+	errRollback := errors.New("rollback")
+	if err := s.update(func(tx *txn) error {
+		tx.delete("A/1")
+		if err := tx.put("A/3", "three"); err != nil {
+			return err
+		}
+		return errRollback
+	}); err != errRollback {
+		t.Fatalf("Expected rollback, got: %v", err)
+	}
+
+	// Deletion and read-your-own-writes
+	if err := s.update(func(tx *txn) error {
+		tx.delete("A/2")
+		var v string
+		if err := tx.get("A/2", &v); err != errNoSuchEntity {
+			t.Errorf("Expected A/2 to be deleted, got: %v %v", v, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	if s.lastId != 1 {
+		t.Errorf("Expected last ID 1, got %v", s.lastId)
+	}
+
+	var keys []string
+	if err := s.update(func(tx *txn) error {
+		return tx.scan("A/", func(key string, data []byte) error {
+			keys = append(keys, key)
+			var v string
+			if err := decode(data, &v); err != nil {
+				return err
+			} else if v != "one" {
+				t.Errorf("Unexpected value for %v: %v", key, v)
+			}
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "A/1" {
+		t.Errorf("Unexpected keys after reopening: %v", keys)
+	}
+}
+
+func TestStoreIgnoresIncompleteRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitwrk-embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.db")
+
+	s, err := openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.update(func(tx *txn) error { return tx.put("A/1", "one") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.update(func(tx *txn) error { return tx.put("A/2", "two") }); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	// Simulate a write that was interrupted halfway
+	if info, err := os.Stat(filename); err != nil {
+		t.Fatal(err)
+	} else if err := os.Truncate(filename, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if _, ok := s.entries["A/1"]; !ok {
+		t.Errorf("Expected A/1 to survive")
+	}
+	if _, ok := s.entries["A/2"]; ok {
+		t.Errorf("Expected A/2 to be lost")
+	}
+}
+
+func TestStoreRollbackDiscardsTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitwrk-embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.db")
+
+	s, err := openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.update(func(tx *txn) error { return tx.put("A/1", "one") }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a write that failed halfway: a partial record followed by a rollback
+	offset, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.file.Write([]byte{0, 0, 1, 0, 42}); err != nil {
+		t.Fatal(err)
+	}
+	errWrite := errors.New("write failed")
+	if err := s.rollback(offset, errWrite); err != errWrite {
+		t.Fatalf("Expected original error, got: %v", err)
+	}
+	if info, err := os.Stat(filename); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != offset {
+		t.Errorf("Expected file size %v after rollback, got %v", offset, info.Size())
+	}
+
+	// Records committed after the rollback must still be readable
+	if err := s.update(func(tx *txn) error { return tx.put("A/2", "two") }); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	s, err = openStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if _, ok := s.entries["A/1"]; !ok {
+		t.Errorf("Expected A/1 to survive")
+	}
+	if _, ok := s.entries["A/2"]; !ok {
+		t.Errorf("Expected A/2 to survive")
+	}
+}
@@ -1,2 +1,3 @@
 // Package gae contains Google App Engine specific operations, dealing with the datastore and task queues.
+// Function NewBackend makes them available to the server as a db.Backend.
 package gae
@@ -0,0 +1,169 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gae
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/server/db"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/user"
+)
+
+// Type backend makes the functions of this package available as a db.Backend.
+type backend struct{}
+
+// Make sure db.Backend is implemented
+var _ db.Backend = backend{}
+
+// Returns a backend running on Google App Engine.
+func NewBackend() db.Backend {
+	return backend{}
+}
+
+func (backend) NewContext(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
+
+func (backend) RunInTransaction(c context.Context, f func(c context.Context) error) error {
+	return datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true})
+}
+
+func (backend) NewAccountingDao(c context.Context, transactional bool) bitwrk.CachedAccountingDao {
+	return NewGaeAccountingDao(c, transactional)
+}
+
+func (backend) GetBid(c context.Context, bidId string) (*bitwrk.Bid, error) {
+	return GetBid(c, bidId)
+}
+
+func (backend) EnqueueBid(c context.Context, bid *bitwrk.Bid) (string, error) {
+	return EnqueueBid(c, bid)
+}
+
+func (backend) TriggerBatchProcessing(c context.Context, matchKey string) error {
+	return TriggerBatchProcessing(c, matchKey)
+}
+
+func (backend) PlaceBid(c context.Context, bidId string) error {
+	return PlaceBid(c, bidId)
+}
+
+func (backend) RetireBid(c context.Context, bidId string) error {
+	return RetireBid(c, bidId)
+}
+
+func (backend) MatchBids(c context.Context, matched time.Time, newBidId, oldBidId string) error {
+	return MatchBids(c, matched, newBidId, oldBidId)
+}
+
+func (backend) GetTransaction(c context.Context, txId string) (*bitwrk.Transaction, error) {
+	return GetTransaction(c, txId)
+}
+
+func (backend) GetTransactionMessages(c context.Context, txId string) ([]bitwrk.Tmessage, error) {
+	return GetTransactionMessages(c, txId)
+}
+
+func (backend) UpdateTransaction(c context.Context, txId string, now time.Time, address string,
+	values map[string]string, document, signature string) error {
+	return UpdateTransaction(c, txId, now, address, values, document, signature)
+}
+
+func (backend) RetireTransaction(c context.Context, txId string) error {
+	return RetireTransaction(c, txId)
+}
+
+func (backend) QueryAccountKeys(c context.Context, limit int, requestdepositaddress bool, handler func(string)) error {
+	return QueryAccountKeys(c, limit, requestdepositaddress, handler)
+}
+
+func (backend) QueryTransactions(c context.Context, limit int, article bitwrk.ArticleId, currency money.Currency,
+	begin, end time.Time, handler db.TxFunc) error {
+	return QueryTransactions(c, limit, article, currency, begin, end, handler)
+}
+
+func (backend) QueryAccountMovements(c context.Context, begin time.Time, limit int) ([]bitwrk.AccountMovement, error) {
+	return QueryAccountMovements(c, begin, limit)
+}
+
+func (backend) QueryArticles(c context.Context, handler func(*bitwrk.Article)) error {
+	return QueryArticles(c, handler)
+}
+
+func (backend) PutNonce(c context.Context, nonce string, info *db.Nonce) error {
+	return PutNonce(c, nonce, info)
+}
+
+func (backend) ConsumeNonce(c context.Context, nonce string) (*db.Nonce, error) {
+	return ConsumeNonce(c, nonce)
+}
+
+func (backend) DeleteExpiredNonces(c context.Context, now time.Time) error {
+	return DeleteExpiredNonces(c, now)
+}
+
+func (backend) CacheGet(c context.Context, key string) ([]byte, error) {
+	if item, err := memcache.Get(c, key); err == memcache.ErrCacheMiss {
+		return nil, db.ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	} else {
+		return item.Value, nil
+	}
+}
+
+func (backend) CacheAdd(c context.Context, key string, value []byte, expiration time.Duration) error {
+	return memcache.Add(c, &memcache.Item{Key: key, Value: value, Expiration: expiration})
+}
+
+func (backend) CurrentUser(c context.Context) *db.User {
+	if u := user.Current(c); u != nil {
+		return &db.User{Email: u.Email, Admin: u.Admin}
+	}
+	return nil
+}
+
+func (backend) LoginURL(c context.Context, dest string) (string, error) {
+	return user.LoginURL(c, dest)
+}
+
+func (backend) LogoutURL(c context.Context, dest string) (string, error) {
+	return user.LogoutURL(c, dest)
+}
+
+func (backend) Logf(c context.Context, level db.LogLevel, format string, args ...interface{}) {
+	switch level {
+	case db.LevelDebug:
+		log.Debugf(c, format, args...)
+	case db.LevelInfo:
+		log.Infof(c, format, args...)
+	case db.LevelWarning:
+		log.Warningf(c, format, args...)
+	case db.LevelError:
+		log.Errorf(c, format, args...)
+	default:
+		log.Criticalf(c, format, args...)
+	}
+}
@@ -237,6 +237,22 @@ func (codec txCodec) Load(props []datastore.Property) error {
 		case "ResultDecryptionKey":
 			tx.ResultDecryptionKey = new(Tkey)
 			copy(tx.ResultDecryptionKey[:], p.Value.([]byte))
+		case "Arbiter":
+			arbiter := p.Value.(string)
+			tx.Arbiter = &arbiter
+		case "SellerShare":
+			share := int(p.Value.(int64))
+			tx.SellerShare = &share
+		case "Timeouts":
+			if timeouts, err := ParsePhaseTimeouts(p.Value.(string)); err != nil {
+				return err
+			} else {
+				tx.Timeouts = timeouts
+			}
+		case "ProposedExtension":
+			tx.ProposedExtension = time.Duration(p.Value.(int64))
+		case "Extension":
+			tx.Extension = time.Duration(p.Value.(int64))
 		default:
 			return fmt.Errorf("Unknown property %s", p.Name)
 		}
@@ -287,6 +303,26 @@ func (codec txCodec) Save() ([]datastore.Property, error) {
 			datastore.Property{Name: "EncryptedResultHashSignature", Value: tx.EncryptedResultReceipt.HashSignature[:], NoIndex: true},
 			datastore.Property{Name: "ResultDecryptionKey", Value: tx.ResultDecryptionKey[:], NoIndex: true})
 	}
+	if tx.Arbiter != nil {
+		props = append(props,
+			datastore.Property{Name: "Arbiter", Value: *tx.Arbiter, NoIndex: true})
+	}
+	if tx.SellerShare != nil {
+		props = append(props,
+			datastore.Property{Name: "SellerShare", Value: int64(*tx.SellerShare), NoIndex: true})
+	}
+	if tx.Timeouts != (PhaseTimeouts{}) {
+		props = append(props,
+			datastore.Property{Name: "Timeouts", Value: tx.Timeouts.String(), NoIndex: true})
+	}
+	if tx.ProposedExtension != 0 {
+		props = append(props,
+			datastore.Property{Name: "ProposedExtension", Value: int64(tx.ProposedExtension), NoIndex: true})
+	}
+	if tx.Extension != 0 {
+		props = append(props,
+			datastore.Property{Name: "Extension", Value: int64(tx.Extension), NoIndex: true})
+	}
 	return props, nil
 }
 
@@ -403,7 +439,8 @@ func (codec movementCodec) Load(props []datastore.Property) error {
 			s := DepositUid(p.Value.(*datastore.Key))
 			movement.DepositKey = &s
 		case "WithdrawalKey":
-			panic("Loading withdrawal keys not implemented yet")
+			s := WithdrawalUid(p.Value.(*datastore.Key))
+			movement.WithdrawalKey = &s
 		default:
 			return fmt.Errorf("Unknown property %s", p.Name)
 		}
@@ -463,7 +500,8 @@ func (codec movementCodec) Save() ([]datastore.Property, error) {
 			datastore.Property{Name: "DepositKey", Value: DepositKey(codec.context, *movement.DepositKey), NoIndex: true})
 	}
 	if movement.WithdrawalKey != nil {
-		panic("Storing withdrawal keys not yet implemented")
+		props = append(props,
+			datastore.Property{Name: "WithdrawalKey", Value: WithdrawalKey(codec.context, *movement.WithdrawalKey), NoIndex: true})
 	}
 
 	return props, nil
@@ -520,6 +558,63 @@ func (codec depositCodec) Load(props []datastore.Property) error {
 	return nil
 }
 
+type withdrawalCodec struct {
+	withdrawal *Withdrawal
+}
+
+// Make sure datastore.PropertyLoadSaver is implemented.
+var _ datastore.PropertyLoadSaver = withdrawalCodec{nil}
+
+func (codec withdrawalCodec) Save() ([]datastore.Property, error) {
+	withdrawal := codec.withdrawal
+	return []datastore.Property{
+		datastore.Property{Name: "Account", Value: withdrawal.Account},
+		datastore.Property{Name: "Amount", Value: withdrawal.Amount.Amount},
+		datastore.Property{Name: "Currency", Value: withdrawal.Amount.Currency.String()},
+		datastore.Property{Name: "Address", Value: withdrawal.Address, NoIndex: true},
+		datastore.Property{Name: "State", Value: int64(withdrawal.State)},
+		datastore.Property{Name: "Reference", Value: withdrawal.Reference, NoIndex: true},
+		datastore.Property{Name: "Created", Value: withdrawal.Created},
+		datastore.Property{Name: "Settled", Value: withdrawal.Settled},
+		datastore.Property{Name: "Request", Value: withdrawal.Request, NoIndex: true},
+		datastore.Property{Name: "Settlement", Value: withdrawal.Settlement, NoIndex: true},
+	}, nil
+}
+
+func (codec withdrawalCodec) Load(props []datastore.Property) error {
+	withdrawal := codec.withdrawal
+	withdrawal.Amount.Currency = money.BTC
+
+	for _, p := range props {
+		switch p.Name {
+		case "Account":
+			withdrawal.Account = p.Value.(string)
+		case "Amount":
+			withdrawal.Amount.Amount = p.Value.(int64)
+		case "Currency":
+			withdrawal.Amount.Currency.MustParse(p.Value.(string))
+		case "Address":
+			withdrawal.Address = p.Value.(string)
+		case "State":
+			withdrawal.State = WithdrawalState(p.Value.(int64))
+		case "Reference":
+			withdrawal.Reference = p.Value.(string)
+		case "Created":
+			withdrawal.Created = p.Value.(time.Time)
+		case "Settled":
+			withdrawal.Settled = p.Value.(time.Time)
+		case "Request":
+			withdrawal.Request = p.Value.(string)
+		case "Settlement":
+			withdrawal.Settlement = p.Value.(string)
+		default:
+			return fmt.Errorf("Unknown property %s", p.Name)
+		}
+	}
+
+	return nil
+}
+
 type relationCodec struct {
 	relation *Relation
 }
@@ -566,3 +661,63 @@ func (codec relationCodec) Load(props []datastore.Property) error {
 
 	return nil
 }
+
+type articleCodec struct {
+	article *Article
+}
+
+// Make sure datastore.PropertyLoadSaver is implemented.
+var _ datastore.PropertyLoadSaver = articleCodec{nil}
+
+func (codec articleCodec) Save() ([]datastore.Property, error) {
+	article := codec.article
+	return []datastore.Property{
+		datastore.Property{Name: "Id", Value: string(article.Id), NoIndex: true},
+		datastore.Property{Name: "Description", Value: article.Description, NoIndex: true},
+		datastore.Property{Name: "Enabled", Value: article.Enabled, NoIndex: true},
+		datastore.Property{Name: "Timeouts", Value: article.Timeouts.String(), NoIndex: true},
+		datastore.Property{Name: "MaxWorkSize", Value: article.MaxWorkSize, NoIndex: true},
+		datastore.Property{Name: "FeeRatioNumerator", Value: article.FeeRatioNumerator, NoIndex: true},
+		datastore.Property{Name: "FeeRatioDenominator", Value: article.FeeRatioDenominator, NoIndex: true},
+		datastore.Property{Name: "LastModified", Value: article.LastModified},
+		datastore.Property{Name: "Document", Value: article.Document, NoIndex: true},
+		datastore.Property{Name: "Signature", Value: article.Signature, NoIndex: true},
+	}, nil
+}
+
+func (codec articleCodec) Load(props []datastore.Property) error {
+	article := codec.article
+
+	for _, p := range props {
+		switch p.Name {
+		case "Id":
+			article.Id = ArticleId(p.Value.(string))
+		case "Description":
+			article.Description = p.Value.(string)
+		case "Enabled":
+			article.Enabled = p.Value.(bool)
+		case "Timeouts":
+			if timeouts, err := ParsePhaseTimeouts(p.Value.(string)); err != nil {
+				return err
+			} else {
+				article.Timeouts = timeouts
+			}
+		case "MaxWorkSize":
+			article.MaxWorkSize = p.Value.(int64)
+		case "FeeRatioNumerator":
+			article.FeeRatioNumerator = p.Value.(int64)
+		case "FeeRatioDenominator":
+			article.FeeRatioDenominator = p.Value.(int64)
+		case "LastModified":
+			article.LastModified = p.Value.(time.Time)
+		case "Document":
+			article.Document = p.Value.(string)
+		case "Signature":
+			article.Signature = p.Value.(string)
+		default:
+			return fmt.Errorf("Unknown property %s", p.Name)
+		}
+	}
+
+	return nil
+}
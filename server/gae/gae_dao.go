@@ -104,6 +104,22 @@ func (dao *gaeAccountingDao) SaveDeposit(uid string, deposit *Deposit) error {
 	return err
 }
 
+func (dao *gaeAccountingDao) GetWithdrawal(uid string) (Withdrawal, error) {
+	key := WithdrawalKey(dao.c, uid)
+	withdrawal := Withdrawal{}
+	if err := datastore.Get(dao.c, key, withdrawalCodec{&withdrawal}); err == datastore.ErrNoSuchEntity {
+		return Withdrawal{}, ErrNoSuchObject
+	} else {
+		return withdrawal, err
+	}
+}
+
+func (dao *gaeAccountingDao) SaveWithdrawal(uid string, withdrawal *Withdrawal) error {
+	key := WithdrawalKey(dao.c, uid)
+	_, err := datastore.Put(dao.c, key, datastore.PropertyLoadSaver(withdrawalCodec{withdrawal}))
+	return err
+}
+
 func (dao *gaeAccountingDao) GetRelation(source, target string, reltype RelationType) (*Relation, error) {
 	key := RelationKey(dao.c, source, target, reltype)
 	var relation Relation
@@ -121,6 +137,23 @@ func (dao *gaeAccountingDao) SaveRelation(relation *Relation) error {
 	return err
 }
 
+func (dao *gaeAccountingDao) GetArticle(id ArticleId) (*Article, error) {
+	key := ArticleKey(dao.c, id)
+	var article Article
+	if err := datastore.Get(dao.c, key, articleCodec{&article}); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNoSuchObject
+	} else if err != nil {
+		return nil, err
+	}
+	return &article, nil
+}
+
+func (dao *gaeAccountingDao) SaveArticle(article *Article) error {
+	key := ArticleKey(dao.c, article.Id)
+	_, err := datastore.Put(dao.c, key, datastore.PropertyLoadSaver(articleCodec{article}))
+	return err
+}
+
 func NewGaeAccountingDao(c context.Context, transactional bool) CachedAccountingDao {
 	return NewCachedAccountingDao(&gaeAccountingDao{c: c}, transactional)
 }
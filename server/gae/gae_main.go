@@ -23,6 +23,7 @@ import (
 	"time"
 
 	. "github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/server/db"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/memcache"
@@ -42,6 +43,10 @@ func RelationKey(c context.Context, source, target string, reltype RelationType)
 	return datastore.NewKey(c, "Relation", source+"/"+reltype.String()+"/"+target, 0, nil)
 }
 
+func ArticleKey(c context.Context, id ArticleId) *datastore.Key {
+	return datastore.NewKey(c, "Article", string(id), 0, nil)
+}
+
 func DepositKey(c context.Context, uid string) *datastore.Key {
 	return datastore.NewKey(c, "Deposit", uid, 0, nil)
 }
@@ -50,6 +55,14 @@ func DepositUid(key *datastore.Key) string {
 	return key.StringID()
 }
 
+func WithdrawalKey(c context.Context, uid string) *datastore.Key {
+	return datastore.NewKey(c, "Withdrawal", uid, 0, nil)
+}
+
+func WithdrawalUid(key *datastore.Key) string {
+	return key.StringID()
+}
+
 func GetBid(c context.Context, bidId string) (bid *Bid, err error) {
 	key, err := datastore.DecodeKey(bidId)
 	if err != nil {
@@ -60,11 +73,8 @@ func GetBid(c context.Context, bidId string) (bid *Bid, err error) {
 	return
 }
 
-var ErrTransactionTooYoung = fmt.Errorf("Transaction is too young to be retired")
-var ErrTransactionAlreadyRetired = fmt.Errorf("Transaction has already been retired")
-
 // Transactional function to enqueue a bid, while keeping accounts in balance
-func EnqueueBid(c context.Context, bid *Bid) (*datastore.Key, error) {
+func EnqueueBid(c context.Context, bid *Bid) (string, error) {
 	var bidKey *datastore.Key
 	f := func(c context.Context) error {
 		dao := NewGaeAccountingDao(c, true)
@@ -108,10 +118,10 @@ func EnqueueBid(c context.Context, bid *Bid) (*datastore.Key, error) {
 	}
 
 	if err := datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true}); err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return bidKey, nil
+	return bidKey.Encode(), nil
 }
 
 // Function TriggerBatchProcessing performs the actual matching process specific to a matchKey.
@@ -148,7 +158,12 @@ func TriggerBatchProcessing(c context.Context, matchKey string) error {
 }
 
 // This will reimburse the bid's price and fee to the buyer.
-func RetireBid(c context.Context, key *datastore.Key) error {
+func RetireBid(c context.Context, bidId string) error {
+	key, err := datastore.DecodeKey(bidId)
+	if err != nil {
+		return err
+	}
+
 	f := func(c context.Context) error {
 		now := time.Now()
 		dao := NewGaeAccountingDao(c, true)
@@ -222,11 +237,16 @@ func PlaceBid(c context.Context, bidId string) error {
 // Transactions in phase FINISHED will cause the price to be credited on the seller's
 // account, and the fee to be deducted.
 // All other phases will lead to price and fee being reimbursed to the buyer.
-// Returns ErrTransactionTooYoung if the transaction has not passed its timout at the
+// Returns db.ErrTransactionTooYoung if the transaction has not passed its timout at the
 // time of the call.
-// Returns ErrTransactionAlreadyRetired if the transaction has already been retired at
+// Returns db.ErrTransactionAlreadyRetired if the transaction has already been retired at
 // the time of the call.
-func RetireTransaction(c context.Context, key *datastore.Key) error {
+func RetireTransaction(c context.Context, txId string) error {
+	key, err := datastore.DecodeKey(txId)
+	if err != nil {
+		return err
+	}
+
 	f := func(c context.Context) error {
 		now := time.Now()
 		dao := NewGaeAccountingDao(c, true)
@@ -236,9 +256,9 @@ func RetireTransaction(c context.Context, key *datastore.Key) error {
 		}
 
 		if err := tx.Retire(dao, key.Encode(), now); err == ErrTooYoung {
-			return ErrTransactionTooYoung
+			return db.ErrTransactionTooYoung
 		} else if err == ErrAlreadyRetired {
-			return ErrTransactionAlreadyRetired
+			return db.ErrTransactionAlreadyRetired
 		} else if err != nil {
 			return err
 		}
@@ -253,7 +273,12 @@ func RetireTransaction(c context.Context, key *datastore.Key) error {
 	return datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true})
 }
 
-func GetTransaction(c context.Context, key *datastore.Key) (*Transaction, error) {
+func GetTransaction(c context.Context, txId string) (*Transaction, error) {
+	key, err := datastore.DecodeKey(txId)
+	if err != nil {
+		return nil, err
+	}
+
 	var tx Transaction
 	if err := datastore.Get(c, key, txCodec{&tx}); err != nil {
 		return nil, err
@@ -262,7 +287,12 @@ func GetTransaction(c context.Context, key *datastore.Key) (*Transaction, error)
 	return &tx, nil
 }
 
-func GetTransactionMessages(c context.Context, key *datastore.Key) ([]Tmessage, error) {
+func GetTransactionMessages(c context.Context, txId string) ([]Tmessage, error) {
+	key, err := datastore.DecodeKey(txId)
+	if err != nil {
+		return nil, err
+	}
+
 	query := datastore.NewQuery("Tmessage").Ancestor(key).Limit(101).Order("Received")
 	messages := make([]Tmessage, 0, 101)
 	if _, err := query.GetAll(c, &messages); err != nil {
@@ -275,14 +305,19 @@ func GetTransactionMessages(c context.Context, key *datastore.Key) ([]Tmessage,
 // Sends a message (defined by its argument values) to the transaction and performs
 // the corresponding changes atomically.
 // Returns the updated transaction on success.
-func UpdateTransaction(c context.Context, txKey *datastore.Key,
+func UpdateTransaction(c context.Context, txId string,
 	now time.Time,
 	address string,
 	values map[string]string,
 	document, signature string) error {
 
+	txKey, err := datastore.DecodeKey(txId)
+	if err != nil {
+		return err
+	}
+
 	f := func(c context.Context) error {
-		tx, err := GetTransaction(c, txKey)
+		tx, err := GetTransaction(c, txId)
 		if err != nil {
 			return err
 		}
@@ -306,7 +341,7 @@ func UpdateTransaction(c context.Context, txKey *datastore.Key,
 			return err
 		}
 
-		return addRetireTransactionTask(c, txKey.Encode(), tx)
+		return addRetireTransactionTask(c, txId, tx)
 	}
 
 	return datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true})
@@ -24,6 +24,7 @@ import (
 
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/server/matching"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/taskqueue"
@@ -58,18 +59,32 @@ func newHotBid(key *datastore.Key, bid *bitwrk.Bid) *hotBid {
 		Expires: bid.Expires}
 }
 
-func (this *hotBid) hotterThan(other *hotBid) bool {
-	// If prices are equal, sort by expiry date (earliest expiry served first)
-	if this.Price.Amount == other.Price.Amount {
-		return this.Expires.Before(other.Expires)
-	}
-	if this.Type == bitwrk.Sell {
-		// Order sells by ascending price (cheapest sell bid served first)
-		return this.Price.Amount < other.Price.Amount
-	} else {
-		// Order buys by descending price (highest buy bid served first)
-		return this.Price.Amount > other.Price.Amount
+// Returns the order used by the matching engine to represent this hot bid.
+func (this *hotBid) order() matching.Order {
+	return matching.Order{
+		Key:     this.BidKey.Encode(),
+		Type:    this.Type,
+		Price:   this.Price,
+		Expires: this.Expires}
+}
+
+func hotBidFromOrder(order *matching.Order) (*hotBid, error) {
+	key, err := datastore.DecodeKey(order.Key)
+	if err != nil {
+		return nil, err
 	}
+	return &hotBid{
+		BidKey:  key,
+		Type:    order.Type,
+		Price:   order.Price,
+		Expires: order.Expires}, nil
+}
+
+func (this *hotBid) hotterThan(other *hotBid) bool {
+	// Priority is defined by the matching engine. Keys don't matter for comparison.
+	a := matching.Order{Type: this.Type, Price: this.Price, Expires: this.Expires}
+	b := matching.Order{Type: other.Type, Price: other.Price, Expires: other.Expires}
+	return a.HotterThan(&b)
 }
 
 type storedHotBid struct {
@@ -100,7 +115,7 @@ func (h *hotBidsHeap) Pop() interface{} {
 func (h hotBidsHeap) Get(i int) hotBid { return h[i] }
 
 // Provides a unified view on a queue consisting both of datastore-persisted hot bids,
-// as well as ephemeral bids stored in memory.
+// as well as ephemeral bids stored in memory. Implements matching.Queue.
 // Tries to minimize datastore access by initializing lazily. Buys and sells are
 // treated as separate queues, but can be treated almost the same.
 type hotBidsQueue struct {
@@ -166,8 +181,11 @@ func (q *hotBidsQueue) withTip(handleStoredTip, handleCachedTip func()) {
 	}
 }
 
+// Make sure matching.Queue is implemented
+var _ matching.Queue = (*hotBidsQueue)(nil)
+
 // Returns the current tip of the queue, i.e. the 'hottest' bid.
-func (q *hotBidsQueue) Tip() (*hotBid, error) {
+func (q *hotBidsQueue) Tip() (*matching.Order, error) {
 	if err := q.init(); err != nil {
 		return nil, err
 	}
@@ -177,7 +195,8 @@ func (q *hotBidsQueue) Tip() (*hotBid, error) {
 		func() { result = q.storedTip.hotBid; found = true },
 		func() { result = q.cachedHeap.Get(0); found = true })
 	if found {
-		return &result, nil
+		order := result.order()
+		return &order, nil
 	} else {
 		return nil, nil
 	}
@@ -201,11 +220,15 @@ func (q *hotBidsQueue) Pop() error {
 }
 
 // Inserts a new hot bid into the heap of ephemeral bids.
-func (q *hotBidsQueue) Insert(bid *hotBid) error {
+func (q *hotBidsQueue) Insert(order *matching.Order) error {
 	if err := q.init(); err != nil {
 		return err
 	}
-	heap.Push(q.cachedHeap, *bid)
+	if bid, err := hotBidFromOrder(order); err != nil {
+		return err
+	} else {
+		heap.Push(q.cachedHeap, *bid)
+	}
 	return nil
 }
 
@@ -223,15 +246,6 @@ func (q *hotBidsQueue) Persist(parentKey *datastore.Key) error {
 	return nil
 }
 
-func (q *hotBidsQueue) Flush() []string {
-	result := make([]string, 0, 16)
-	for q.cachedHeap.Len() > 0 {
-		result = append(result, q.cachedHeap.Get(0).BidKey.Encode())
-		heap.Pop(q.cachedHeap)
-	}
-	return result
-}
-
 func MatchIncomingBids(c context.Context, matchKey string) error {
 	incomingBids := make([]hotBid, 0, 16)
 
@@ -297,53 +311,16 @@ func matchIncomingBids(c context.Context, now time.Time, matchKey string, incomi
 	hotBuys := newHotBidsQueue(c, hotBids.Filter("Type=", bitwrk.Buy).Order("-Price"), bitwrk.Buy)
 	hotSells := newHotBidsQueue(c, hotBids.Filter("Type=", bitwrk.Sell).Order("Price"), bitwrk.Sell)
 
-	matched := make([]string, 0, 16)
-
-	for len(incomingBids) > 0 {
-		bid := incomingBids[0]
-		incomingBids = incomingBids[1:]
-
-		var thisQueue, otherQueue *hotBidsQueue
-		if bid.Type == bitwrk.Buy {
-			thisQueue, otherQueue = hotBuys, hotSells
-		} else {
-			thisQueue, otherQueue = hotSells, hotBuys
-		}
-
-		// Pop bids from queue that have expired
-		skipped := 0
-		for {
-			if other, err := otherQueue.Tip(); err != nil {
-				return err
-			} else if other == nil || other.Expires.After(now) {
-				break
-			} else {
-				skipped++
-				log.Infof(c, "Skipping hot bid %v", other)
-				if err := otherQueue.Pop(); err != nil {
-					return err
-				}
-			}
-		}
-		log.Infof(c, "Skipped %v expired bids", skipped)
-
-		// See if we have a match
-		if other, err := otherQueue.Tip(); err != nil {
-			return err
-		} else if other != nil && other.hotterThan(&bid) {
-			// This is a match. Take other bid out of queue and schedule transaction creation
-			if err := otherQueue.Pop(); err != nil {
-				return err
-			}
+	orders := make([]matching.Order, 0, len(incomingBids))
+	for i := range incomingBids {
+		orders = append(orders, incomingBids[i].order())
+	}
 
-			matched = append(matched, bid.BidKey.Encode(), other.BidKey.Encode())
-		} else {
-			// No match. Store bid for later matching.
-			if err := thisQueue.Insert(&bid); err != nil {
-				return err
-			}
-		}
+	result, err := matching.Match(now, hotBuys, hotSells, orders)
+	if err != nil {
+		return err
 	}
+	log.Infof(c, "Skipped %v expired bids", len(result.Expired))
 
 	if err := hotBuys.Persist(parentKey); err != nil {
 		return err
@@ -352,12 +329,10 @@ func matchIncomingBids(c context.Context, now time.Time, matchKey string, incomi
 		return err
 	}
 
-	placed := append(hotBuys.Flush(), hotSells.Flush()...)
-
-	if len(matched) == 0 && len(placed) == 0 {
+	if len(result.Matches) == 0 && len(result.Placed) == 0 {
 		return nil
 	} else {
-		return addApplyChangesTask(c, matchKey, now, matched, placed)
+		return addApplyChangesTask(c, matchKey, now, result.MatchedKeys(), result.PlacedKeys())
 	}
 }
 
@@ -386,9 +361,17 @@ func MatchBids(c context.Context, matched time.Time, newBidId, oldBidId string)
 			oldBid.State = bitwrk.Placed
 		}
 
+		dao := NewGaeAccountingDao(c, true)
+		var timeouts bitwrk.PhaseTimeouts
+		if article, err := bitwrk.LookupArticle(dao, newBid.Article); err == nil {
+			timeouts = article.Timeouts
+		} else if err != bitwrk.ErrNoSuchObject {
+			return err
+		}
+
 		// Also modifies newBid and oldBid
 		var tx *bitwrk.Transaction
-		if t, err := bitwrk.NewTransaction(matched, newBidId, oldBidId, &newBid, &oldBid); err != nil {
+		if t, err := bitwrk.NewTransaction(matched, timeouts, newBidId, oldBidId, &newBid, &oldBid); err != nil {
 			return err
 		} else {
 			tx = t
@@ -424,7 +407,6 @@ func MatchBids(c context.Context, matched time.Time, newBidId, oldBidId string)
 				buyerBid = &oldBid
 			}
 
-			dao := NewGaeAccountingDao(c, true)
 			if err := tx.Book(dao, txKey.Encode(), buyerBid); err != nil {
 				return err
 			}
@@ -0,0 +1,84 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gae
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/indyjo/bitwrk/server/db"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// Nonces are placed in 256 shards for better concurrency, using the first
+// two hexadecimal characters as shard ID.
+func nonceShardKey(c context.Context, nonce string) *datastore.Key {
+	return datastore.NewKey(c, "Nonces", nonce[:2], 0, nil)
+}
+
+func NonceKey(c context.Context, nonce string) *datastore.Key {
+	return datastore.NewKey(c, "Nonce", nonce, 0, nonceShardKey(c, nonce))
+}
+
+func PutNonce(c context.Context, nonce string, info *db.Nonce) error {
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		_, err := datastore.Put(c, NonceKey(c, nonce), info)
+		return err
+	}, nil)
+}
+
+func ConsumeNonce(c context.Context, nonce string) (*db.Nonce, error) {
+	if len(nonce) < 2 {
+		return nil, db.ErrNoSuchNonce
+	}
+	key := NonceKey(c, nonce)
+	var result db.Nonce
+	err := datastore.RunInTransaction(c, func(c context.Context) error {
+		if err := datastore.Get(c, key, &result); err == datastore.ErrNoSuchEntity {
+			return db.ErrNoSuchNonce
+		} else if err != nil {
+			return err
+		}
+		return datastore.Delete(c, key)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Deletes expired nonces of a randomly chosen shard.
+func DeleteExpiredNonces(c context.Context, now time.Time) error {
+	parentKey := nonceShardKey(c, fmt.Sprintf("%02x", rand.Intn(256)))
+	query := datastore.NewQuery("Nonce").KeysOnly().Limit(1000)
+	query = query.Ancestor(parentKey)
+	query = query.Filter("Expires <=", now)
+	keys, err := query.GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	log.Infof(c, "Delete %v expired nonces", len(keys))
+	return datastore.DeleteMulti(c, keys)
+}
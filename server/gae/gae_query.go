@@ -22,6 +22,7 @@ import (
 
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/common/money"
+	"github.com/indyjo/bitwrk/server/db"
 	"google.golang.org/appengine/datastore"
 )
 
@@ -47,11 +48,9 @@ func QueryAccountKeys(c context.Context, limit int, requestdepositaddress bool,
 	return nil
 }
 
-type TxFunc func(key string, tx bitwrk.Transaction)
-
 // Queries transactions matching the given constraints. Invokes handler func for every transaction found.
 func QueryTransactions(c context.Context, limit int, article bitwrk.ArticleId, currency money.Currency,
-	begin, end time.Time, handler TxFunc) error {
+	begin, end time.Time, handler db.TxFunc) error {
 	query := datastore.NewQuery("Tx").Limit(limit)
 	query = query.Filter("Article =", article)
 	query = query.Filter("Currency =", currency.String())
@@ -105,3 +104,20 @@ func QueryAccountMovements(c context.Context, begin time.Time, limit int) ([]bit
 
 	return result, nil
 }
+
+// Queries all entries of the article catalog. Invokes handler func for every article found.
+func QueryArticles(c context.Context, handler func(*bitwrk.Article)) error {
+	iter := datastore.NewQuery("Article").Order("__key__").Run(c)
+	for {
+		var article bitwrk.Article
+		if _, err := iter.Next(articleCodec{&article}); err == datastore.Done {
+			break
+		} else if err != nil {
+			return err
+		} else {
+			handler(&article)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package log provides request-scoped logging for server handlers. Log output is forwarded
+// to the backend installed in package db.
+package log
+
+import (
+	"context"
+
+	"github.com/indyjo/bitwrk/server/db"
+)
+
+func Debugf(c context.Context, format string, args ...interface{}) {
+	db.Logf(c, db.LevelDebug, format, args...)
+}
+
+func Infof(c context.Context, format string, args ...interface{}) {
+	db.Logf(c, db.LevelInfo, format, args...)
+}
+
+func Warningf(c context.Context, format string, args ...interface{}) {
+	db.Logf(c, db.LevelWarning, format, args...)
+}
+
+func Errorf(c context.Context, format string, args ...interface{}) {
+	db.Logf(c, db.LevelError, format, args...)
+}
+
+func Criticalf(c context.Context, format string, args ...interface{}) {
+	db.Logf(c, db.LevelCritical, format, args...)
+}
@@ -0,0 +1,151 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package matching
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+)
+
+// Type SliceQueue is an in-memory Queue backed by a slice, hottest order first.
+type SliceQueue []Order
+
+// Make sure Queue is implemented
+var _ Queue = &SliceQueue{}
+
+func (q *SliceQueue) Tip() (*Order, error) {
+	if len(*q) == 0 {
+		return nil, nil
+	}
+	tip := (*q)[0]
+	return &tip, nil
+}
+
+func (q *SliceQueue) Pop() error {
+	if len(*q) != 0 {
+		*q = (*q)[1:]
+	}
+	return nil
+}
+
+func (q *SliceQueue) Insert(order *Order) error {
+	// Orders of equal priority keep their insertion order
+	i := sort.Search(len(*q), func(i int) bool { return order.HotterThan(&(*q)[i]) })
+	*q = append(*q, Order{})
+	copy((*q)[i+1:], (*q)[i:])
+	(*q)[i] = *order
+	return nil
+}
+
+// Removes all orders that have expired at the given time and returns them.
+func (q *SliceQueue) RemoveExpired(now time.Time) []Order {
+	var expired []Order
+	remaining := (*q)[:0]
+	for _, o := range *q {
+		if o.Expired(now) {
+			expired = append(expired, o)
+		} else {
+			remaining = append(remaining, o)
+		}
+	}
+	*q = remaining
+	return expired
+}
+
+// Type Book is an in-memory order book of one article/currency combination.
+// Its fields are exported so it can be persisted as a whole.
+type Book struct {
+	Buys, Sells SliceQueue
+}
+
+// Matches incoming orders against the book, then removes all expired orders.
+func (b *Book) Match(now time.Time, incoming []Order) (*Result, error) {
+	result, err := Match(now, &b.Buys, &b.Sells, incoming)
+	if err != nil {
+		return nil, err
+	}
+	result.Expired = append(result.Expired, b.Buys.RemoveExpired(now)...)
+	result.Expired = append(result.Expired, b.Sells.RemoveExpired(now)...)
+	return result, nil
+}
+
+// Type Engine manages an order book per article/currency combination and timestamps
+// incoming bids using the configured clock. It is safe for concurrent use.
+type Engine struct {
+	mutex sync.Mutex
+	clock Clock
+	books map[string]*Book
+}
+
+func NewEngine(clock Clock) *Engine {
+	return &Engine{
+		clock: clock,
+		books: make(map[string]*Book),
+	}
+}
+
+// Returns the book for the given match key (see bitwrk.Bid.MatchKey), creating it if necessary.
+func (e *Engine) Book(matchKey string) *Book {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.book(matchKey)
+}
+
+func (e *Engine) book(matchKey string) *Book {
+	b, ok := e.books[matchKey]
+	if !ok {
+		b = &Book{}
+		e.books[matchKey] = b
+	}
+	return b
+}
+
+// Matches a single bid against the book of its article and currency.
+func (e *Engine) Submit(key string, bid *bitwrk.Bid) (*Result, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.book(bid.MatchKey()).Match(e.clock.Now(), []Order{NewOrder(key, bid)})
+}
+
+// Matches a batch of orders against the book identified by matchKey.
+func (e *Engine) SubmitBatch(matchKey string, orders []Order) (*Result, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.book(matchKey).Match(e.clock.Now(), orders)
+}
+
+// Removes expired orders from all books and returns them, sorted by match key.
+func (e *Engine) RemoveExpired() []Order {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	now := e.clock.Now()
+	matchKeys := make([]string, 0, len(e.books))
+	for matchKey := range e.books {
+		matchKeys = append(matchKeys, matchKey)
+	}
+	sort.Strings(matchKeys)
+	var expired []Order
+	for _, matchKey := range matchKeys {
+		b := e.books[matchKey]
+		expired = append(expired, b.Buys.RemoveExpired(now)...)
+		expired = append(expired, b.Sells.RemoveExpired(now)...)
+	}
+	return expired
+}
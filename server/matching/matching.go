@@ -0,0 +1,205 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package matching implements the order book logic used to match buys and sells.
+// It is independent of any storage: persistent order books are accessed through
+// the Queue interface.
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Returned when orders of different currencies are about to be compared.
+var ErrCurrencyMismatch = errors.New("Orders of different currencies can't be matched")
+
+// Interface Clock abstracts the current time, allowing for deterministic tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The clock returning the system time.
+var SystemClock Clock = systemClock{}
+
+// Type ManualClock is a clock that only advances when told to.
+type ManualClock struct {
+	T time.Time
+}
+
+func (c *ManualClock) Now() time.Time {
+	return c.T
+}
+
+// Sets the clock to the given time.
+func (c *ManualClock) Set(t time.Time) {
+	c.T = t
+}
+
+// Type Order contains the information about a bid necessary for matching and
+// expiration. Orders are identified by the key of their bid.
+type Order struct {
+	Key     string
+	Type    bitwrk.BidType
+	Price   money.Money
+	Expires time.Time
+}
+
+func NewOrder(key string, bid *bitwrk.Bid) Order {
+	return Order{
+		Key:     key,
+		Type:    bid.Type,
+		Price:   bid.Price,
+		Expires: bid.Expires}
+}
+
+func (o Order) String() string {
+	return fmt.Sprintf("%v %v %v (expires %v)", o.Type, o.Key, o.Price, o.Expires.Format(time.RFC3339Nano))
+}
+
+// Returns whether this order is served before the other order. Sells are ordered by
+// ascending price, buys by descending price. If prices are equal, the order expiring
+// earlier is served first.
+//
+// If this order and other are of different types, this means that both match.
+func (o *Order) HotterThan(other *Order) bool {
+	if o.Price.Amount == other.Price.Amount {
+		return o.Expires.Before(other.Expires)
+	}
+	if o.Type == bitwrk.Sell {
+		return o.Price.Amount < other.Price.Amount
+	} else {
+		return o.Price.Amount > other.Price.Amount
+	}
+}
+
+// Returns whether the order has expired at the given time.
+func (o *Order) Expired(now time.Time) bool {
+	return !o.Expires.After(now)
+}
+
+// Interface Queue is a priority queue of orders of one type, hottest order first.
+type Queue interface {
+	// Returns the hottest order, or nil if the queue is empty.
+	Tip() (*Order, error)
+	// Removes the hottest order.
+	Pop() error
+	// Inserts a new order.
+	Insert(order *Order) error
+}
+
+// Type Pair describes an incoming order matched against an order already waiting in the book.
+type Pair struct {
+	Incoming, Resting Order
+}
+
+// Type Result describes the outcome of matching a batch of incoming orders.
+type Result struct {
+	// Pairs of matched orders, in the order they were matched
+	Matches []Pair
+	// Incoming orders which were placed into the book and are still waiting
+	Placed []Order
+	// Expired orders which were taken out of the book while looking for a match
+	Expired []Order
+}
+
+// Returns the keys of matched orders as a flat list of pairs (incoming, resting).
+func (r *Result) MatchedKeys() []string {
+	result := make([]string, 0, 2*len(r.Matches))
+	for _, m := range r.Matches {
+		result = append(result, m.Incoming.Key, m.Resting.Key)
+	}
+	return result
+}
+
+// Returns the keys of placed orders.
+func (r *Result) PlacedKeys() []string {
+	result := make([]string, 0, len(r.Placed))
+	for _, o := range r.Placed {
+		result = append(result, o.Key)
+	}
+	return result
+}
+
+// Function Match takes a list of incoming orders, all belonging to the same article
+// and currency, and tries to match them against the orders in the given queues, in sequence.
+// Orders which can't be matched are inserted into the queue of their type.
+func Match(now time.Time, buys, sells Queue, incoming []Order) (*Result, error) {
+	result := &Result{}
+	inserted := make([]Order, 0, len(incoming))
+	matchedKeys := make(map[string]bool)
+
+	for i := range incoming {
+		order := incoming[i]
+		thisQueue, otherQueue := buys, sells
+		if order.Type == bitwrk.Sell {
+			thisQueue, otherQueue = sells, buys
+		}
+
+		// Pop orders from the other queue that have expired
+		for {
+			if other, err := otherQueue.Tip(); err != nil {
+				return nil, err
+			} else if other == nil || !other.Expired(now) {
+				break
+			} else {
+				result.Expired = append(result.Expired, *other)
+				if err := otherQueue.Pop(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// See if we have a match
+		if other, err := otherQueue.Tip(); err != nil {
+			return nil, err
+		} else if other != nil && other.Price.Currency != order.Price.Currency {
+			return nil, ErrCurrencyMismatch
+		} else if other != nil && other.HotterThan(&order) {
+			// This is a match. Take other order out of queue.
+			result.Matches = append(result.Matches, Pair{Incoming: order, Resting: *other})
+			matchedKeys[other.Key] = true
+			if err := otherQueue.Pop(); err != nil {
+				return nil, err
+			}
+		} else {
+			// No match. Store order for later matching.
+			if err := thisQueue.Insert(&order); err != nil {
+				return nil, err
+			}
+			inserted = append(inserted, order)
+		}
+	}
+
+	// Orders inserted in this batch may have been matched by later incoming orders
+	for _, order := range inserted {
+		if !matchedKeys[order.Key] {
+			result.Placed = append(result.Placed, order)
+		}
+	}
+
+	return result, nil
+}
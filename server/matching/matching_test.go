@@ -0,0 +1,247 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package matching
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+var t0 = time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func order(key string, bidType bitwrk.BidType, price string, expiresIn time.Duration) Order {
+	return Order{
+		Key:     key,
+		Type:    bidType,
+		Price:   money.MustParse(price),
+		Expires: t0.Add(expiresIn),
+	}
+}
+
+func keys(orders []Order) []string {
+	result := make([]string, 0, len(orders))
+	for _, o := range orders {
+		result = append(result, o.Key)
+	}
+	return result
+}
+
+func TestHotterThan(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Order
+		want bool
+	}{
+		{"cheaper sell", order("a", bitwrk.Sell, "mBTC 1", time.Minute), order("b", bitwrk.Sell, "mBTC 2", time.Minute), true},
+		{"pricier sell", order("a", bitwrk.Sell, "mBTC 2", time.Minute), order("b", bitwrk.Sell, "mBTC 1", time.Minute), false},
+		{"pricier buy", order("a", bitwrk.Buy, "mBTC 2", time.Minute), order("b", bitwrk.Buy, "mBTC 1", time.Minute), true},
+		{"cheaper buy", order("a", bitwrk.Buy, "mBTC 1", time.Minute), order("b", bitwrk.Buy, "mBTC 2", time.Minute), false},
+		{"equal price, earlier expiry", order("a", bitwrk.Buy, "mBTC 1", time.Minute), order("b", bitwrk.Buy, "mBTC 1", time.Hour), true},
+		{"equal price, later expiry", order("a", bitwrk.Buy, "mBTC 1", time.Hour), order("b", bitwrk.Buy, "mBTC 1", time.Minute), false},
+		{"equal price and expiry", order("a", bitwrk.Sell, "mBTC 1", time.Minute), order("b", bitwrk.Sell, "mBTC 1", time.Minute), false},
+		{"sell matches higher buy", order("a", bitwrk.Sell, "mBTC 1", time.Minute), order("b", bitwrk.Buy, "mBTC 2", time.Minute), true},
+		{"sell doesn't match lower buy", order("a", bitwrk.Sell, "mBTC 2", time.Minute), order("b", bitwrk.Buy, "mBTC 1", time.Minute), false},
+		{"buy matches lower sell", order("a", bitwrk.Buy, "mBTC 2", time.Minute), order("b", bitwrk.Sell, "mBTC 1", time.Minute), true},
+	}
+	for _, test := range tests {
+		if got := test.a.HotterThan(&test.b); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		book     Book
+		now      time.Duration
+		incoming []Order
+		matches  [][2]string
+		placed   []string
+		expired  []string
+		buys     []string
+		sells    []string
+	}{
+		{
+			name:     "empty book",
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 1", time.Minute)},
+			placed:   []string{"b1"},
+			buys:     []string{"b1"},
+		},
+		{
+			name:     "simple match",
+			book:     Book{Sells: SliceQueue{order("s1", bitwrk.Sell, "mBTC 1", time.Minute)}},
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 2", time.Minute)},
+			matches:  [][2]string{{"b1", "s1"}},
+		},
+		{
+			name:     "price too low",
+			book:     Book{Sells: SliceQueue{order("s1", bitwrk.Sell, "mBTC 2", time.Minute)}},
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 1", time.Minute)},
+			placed:   []string{"b1"},
+			buys:     []string{"b1"},
+			sells:    []string{"s1"},
+		},
+		{
+			// The resting sell expires first and therefore has priority
+			name:     "equal prices, resting order expires first",
+			book:     Book{Sells: SliceQueue{order("s1", bitwrk.Sell, "mBTC 1", time.Minute)}},
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 1", time.Hour)},
+			matches:  [][2]string{{"b1", "s1"}},
+		},
+		{
+			// The resting sell expires last. Equal prices are only matched by expiry order.
+			name:     "equal prices, incoming order expires first",
+			book:     Book{Sells: SliceQueue{order("s1", bitwrk.Sell, "mBTC 1", time.Hour)}},
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 1", time.Minute)},
+			placed:   []string{"b1"},
+			buys:     []string{"b1"},
+			sells:    []string{"s1"},
+		},
+		{
+			name: "equal prices, earliest expiry served first",
+			book: Book{Sells: SliceQueue{
+				order("s1", bitwrk.Sell, "mBTC 1", 2*time.Minute),
+				order("s2", bitwrk.Sell, "mBTC 1", 3*time.Minute),
+			}},
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 1", time.Hour)},
+			matches:  [][2]string{{"b1", "s1"}},
+			sells:    []string{"s2"},
+		},
+		{
+			name: "cheapest sell served first",
+			book: Book{Sells: SliceQueue{
+				order("s1", bitwrk.Sell, "mBTC 1", time.Hour),
+				order("s2", bitwrk.Sell, "mBTC 2", time.Minute),
+			}},
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 3", time.Minute)},
+			matches:  [][2]string{{"b1", "s1"}},
+			sells:    []string{"s2"},
+		},
+		{
+			name: "expired tip is skipped",
+			book: Book{Sells: SliceQueue{
+				order("s1", bitwrk.Sell, "mBTC 1", time.Minute),
+				order("s2", bitwrk.Sell, "mBTC 2", time.Hour),
+			}},
+			now:      time.Minute,
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 2", 2*time.Hour)},
+			matches:  [][2]string{{"b1", "s2"}},
+			expired:  []string{"s1"},
+		},
+		{
+			name: "all tips expired",
+			book: Book{Sells: SliceQueue{
+				order("s1", bitwrk.Sell, "mBTC 1", time.Minute),
+				order("s2", bitwrk.Sell, "mBTC 2", 2*time.Minute),
+			}},
+			now:      2 * time.Minute,
+			incoming: []Order{order("b1", bitwrk.Buy, "mBTC 2", time.Hour)},
+			placed:   []string{"b1"},
+			expired:  []string{"s1", "s2"},
+			buys:     []string{"b1"},
+		},
+		{
+			name: "expired order on own side is removed",
+			book: Book{Buys: SliceQueue{
+				order("b1", bitwrk.Buy, "mBTC 1", time.Minute),
+			}},
+			now:      time.Hour,
+			incoming: []Order{order("b2", bitwrk.Buy, "mBTC 1", 2*time.Hour)},
+			placed:   []string{"b2"},
+			expired:  []string{"b1"},
+			buys:     []string{"b2"},
+		},
+		{
+			name: "placed and matched in same batch",
+			incoming: []Order{
+				order("b1", bitwrk.Buy, "mBTC 2", time.Minute),
+				order("b2", bitwrk.Buy, "mBTC 1", time.Minute),
+				order("s1", bitwrk.Sell, "mBTC 1", time.Hour),
+			},
+			matches: [][2]string{{"s1", "b1"}},
+			placed:  []string{"b2"},
+			buys:    []string{"b2"},
+		},
+	}
+
+	for _, test := range tests {
+		book := test.book
+		result, err := book.Match(t0.Add(test.now), test.incoming)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		var matches [][2]string
+		for _, m := range result.Matches {
+			matches = append(matches, [2]string{m.Incoming.Key, m.Resting.Key})
+		}
+		if len(matches) != 0 || len(test.matches) != 0 {
+			if !reflect.DeepEqual(matches, test.matches) {
+				t.Errorf("%v: expected matches %v, got %v", test.name, test.matches, matches)
+			}
+		}
+
+		check := func(what string, expected []string, got []Order) {
+			if len(expected) == 0 && len(got) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(keys(got), expected) {
+				t.Errorf("%v: expected %v %v, got %v", test.name, what, expected, keys(got))
+			}
+		}
+		check("placed", test.placed, result.Placed)
+		check("expired", test.expired, result.Expired)
+		check("buys", test.buys, book.Buys)
+		check("sells", test.sells, book.Sells)
+	}
+}
+
+func TestMatchCurrencyMismatch(t *testing.T) {
+	book := Book{Sells: SliceQueue{order("s1", bitwrk.Sell, "mBTC 1", time.Minute)}}
+	if _, err := book.Match(t0, []Order{order("b1", bitwrk.Buy, "EUR 1", time.Minute)}); err != ErrCurrencyMismatch {
+		t.Errorf("Expected ErrCurrencyMismatch, got: %v", err)
+	}
+}
+
+func TestEngineSeparatesCurrencies(t *testing.T) {
+	clock := &ManualClock{T: t0}
+	engine := NewEngine(clock)
+	sell := &bitwrk.Bid{Type: bitwrk.Sell, Article: "foo", Price: money.MustParse("mBTC 1"), Expires: t0.Add(time.Minute)}
+	buy := &bitwrk.Bid{Type: bitwrk.Buy, Article: "foo", Price: money.MustParse("EUR 1"), Expires: t0.Add(time.Minute)}
+
+	if result, err := engine.Submit("s1", sell); err != nil {
+		t.Fatal(err)
+	} else if len(result.Placed) != 1 {
+		t.Errorf("Expected sell to be placed: %v", result)
+	}
+	if result, err := engine.Submit("b1", buy); err != nil {
+		t.Fatal(err)
+	} else if len(result.Matches) != 0 || len(result.Placed) != 1 {
+		t.Errorf("Expected buy to be placed in its own book: %v", result)
+	}
+
+	clock.Set(t0.Add(time.Minute))
+	if expired := engine.RemoveExpired(); !reflect.DeepEqual(keys(expired), []string{"s1", "b1"}) {
+		t.Errorf("Unexpected expired orders: %v", keys(expired))
+	}
+}
@@ -0,0 +1,128 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package matching
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+)
+
+// Type Event is one line of a replay log. An event with a key submits a bid at the
+// given time. An event without a key just advances the clock and removes expired bids.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Key     string    `json:"key,omitempty"`
+	Type    string    `json:"type,omitempty"` // "BUY" or "SELL"
+	Article string    `json:"article,omitempty"`
+	Price   string    `json:"price,omitempty"` // e.g. "mBTC 1.5"
+	Expires time.Time `json:"expires"`
+	// Alternative to Expires: Lifetime of the bid, relative to Time, e.g. "2m"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Converts the event into a bid.
+func (e *Event) Bid() (*bitwrk.Bid, error) {
+	bid := &bitwrk.Bid{
+		Article: bitwrk.ArticleId(e.Article),
+		State:   bitwrk.InQueue,
+		Created: e.Time,
+		Expires: e.Expires,
+	}
+	switch strings.ToUpper(e.Type) {
+	case "BUY":
+		bid.Type = bitwrk.Buy
+	case "SELL":
+		bid.Type = bitwrk.Sell
+	default:
+		return nil, fmt.Errorf("Unknown bid type %#v", e.Type)
+	}
+	if price, err := money.Parse(e.Price); err != nil {
+		return nil, err
+	} else {
+		bid.Price = price
+	}
+	if e.Timeout != "" {
+		if d, err := time.ParseDuration(e.Timeout); err != nil {
+			return nil, err
+		} else {
+			bid.Expires = e.Time.Add(d)
+		}
+	}
+	return bid, nil
+}
+
+// Function Replay reads a sequence of JSON-encoded events, one per line, and feeds them
+// into a fresh engine driven by a manual clock. Empty lines and lines starting with '#'
+// are ignored. Every match, placement and expiration is written to w, one per line, so
+// that the output can be compared against a known-good transcript.
+func Replay(r io.Reader, w io.Writer) error {
+	clock := &ManualClock{}
+	engine := NewEngine(clock)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return fmt.Errorf("Line %v: %v", lineNo, err)
+		}
+		if event.Time.Before(clock.Now()) {
+			return fmt.Errorf("Line %v: Time goes backwards", lineNo)
+		}
+		clock.Set(event.Time)
+
+		if event.Key == "" {
+			for _, o := range engine.RemoveExpired() {
+				fmt.Fprintf(w, "%v EXPIRE %v\n", lineNo, o.Key)
+			}
+			continue
+		}
+
+		if bid, err := event.Bid(); err != nil {
+			return fmt.Errorf("Line %v: %v", lineNo, err)
+		} else if result, err := engine.Submit(event.Key, bid); err != nil {
+			return fmt.Errorf("Line %v: %v", lineNo, err)
+		} else {
+			writeResult(w, lineNo, result)
+		}
+	}
+	return scanner.Err()
+}
+
+func writeResult(w io.Writer, lineNo int, result *Result) {
+	for _, o := range result.Expired {
+		fmt.Fprintf(w, "%v EXPIRE %v\n", lineNo, o.Key)
+	}
+	for _, m := range result.Matches {
+		fmt.Fprintf(w, "%v MATCH %v %v\n", lineNo, m.Incoming.Key, m.Resting.Key)
+	}
+	for _, o := range result.Placed {
+		fmt.Fprintf(w, "%v PLACE %v\n", lineNo, o.Key)
+	}
+}
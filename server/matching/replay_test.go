@@ -0,0 +1,68 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2013-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package matching
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files in testdata")
+
+// Replays every testdata/*.events file and compares the output against the
+// corresponding *.golden file. Run with -update to regenerate the golden files.
+func TestReplay(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No replay files found")
+	}
+	for _, file := range files {
+		golden := strings.TrimSuffix(file, ".events") + ".golden"
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = Replay(f, &out)
+		f.Close()
+		if err != nil {
+			t.Errorf("%v: %v", file, err)
+			continue
+		}
+
+		if *update {
+			if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if expected, err := ioutil.ReadFile(golden); err != nil {
+			t.Errorf("%v: %v", file, err)
+		} else if !bytes.Equal(expected, out.Bytes()) {
+			t.Errorf("%v: output differs from %v.\nExpected:\n%s\nGot:\n%s", file, golden, expected, out.Bytes())
+		}
+	}
+}
@@ -20,50 +20,39 @@ package nonce
 import (
 	"context"
 	"crypto/md5"
+	"crypto/rand"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 )
 
-type Nonce struct {
-	Created, Expires      time.Time
-	UserAgent, RemoteAddr string
-}
-
-// Nonces are placed in 256 shards for better concurrency, using the first
-// two hexadecimal characters as shard ID.
-func nonceShardKey(c context.Context, nonce string) *datastore.Key {
-	return datastore.NewKey(c, "Nonces", nonce[:2], 0, nil)
-}
-
-func NonceKey(c context.Context, nonce string) *datastore.Key {
-	return datastore.NewKey(c, "Nonce", nonce, 0, nonceShardKey(c, nonce))
-}
+type Nonce = db.Nonce
 
 // Handler function for /nonce
 func HandleGetNonce(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := db.NewContext(r)
 	hash := md5.New()
 	now := time.Now()
 
-	fmt.Fprintf(hash, "%v%v", appengine.RequestID(c), now.UnixNano())
+	var random [16]byte
+	if _, err := io.ReadFull(rand.Reader, random[:]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(hash, "%x%v", random, now.UnixNano())
 	nonce := fmt.Sprintf("%x", hash.Sum(make([]byte, 0, 16)))
 
 	obj := &Nonce{
-		now,
-		now.Add(180 * time.Second),
-		r.UserAgent(),
-		r.RemoteAddr}
+		Created:    now,
+		Expires:    now.Add(180 * time.Second),
+		UserAgent:  r.UserAgent(),
+		RemoteAddr: r.RemoteAddr}
 
-	err := datastore.RunInTransaction(c, func(c context.Context) error {
-		_, err := datastore.Put(c, NonceKey(c, nonce), obj)
-		return err
-	}, nil)
-	if err != nil {
+	if err := db.PutNonce(c, nonce, obj); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -77,9 +66,9 @@ func HandleGetNonce(w http.ResponseWriter, r *http.Request) {
 
 	w.Write([]byte(nonce))
 
-	// Delete expired nonces of a different shard
-	if err := deleteExpired(c, now, nonceShardKey(c, nonce[2:])); err != nil {
-		log.Warningf(c, "deleteExpired failed: %v", err)
+	// Delete some expired nonces
+	if err := db.DeleteExpiredNonces(c, now); err != nil {
+		log.Warningf(c, "DeleteExpiredNonces failed: %v", err)
 	}
 }
 
@@ -92,38 +81,13 @@ func CheckNonce(c context.Context, nonce string) error {
 		return errInvalidNonce
 	}
 
-	key := NonceKey(c, nonce)
-	return datastore.RunInTransaction(c, func(c context.Context) error {
-		var dbNonce Nonce
-		if err := datastore.Get(c, key, &dbNonce); err != nil {
-			return errInvalidNonce
-		}
-
-		if !dbNonce.Expires.After(now) {
-			return errInvalidNonce
-		}
-
-		if err := datastore.Delete(c, key); err != nil {
-			return err
-		}
-
-		return nil
-	}, nil)
-}
-
-func deleteExpired(c context.Context, now time.Time, parentKey *datastore.Key) error {
-	query := datastore.NewQuery("Nonce").KeysOnly().Limit(1000)
-	query = query.Ancestor(parentKey)
-	query = query.Filter("Expires <=", now)
-	keys, err := query.GetAll(c, nil)
-	if err != nil {
+	if dbNonce, err := db.ConsumeNonce(c, nonce); err == db.ErrNoSuchNonce {
+		return errInvalidNonce
+	} else if err != nil {
 		return err
+	} else if !dbNonce.Expires.After(now) {
+		return errInvalidNonce
 	}
 
-	if len(keys) == 0 {
-		return nil
-	}
-
-	log.Infof(c, "Delete %v expired nonces", len(keys))
-	return datastore.DeleteMulti(c, keys)
+	return nil
 }
@@ -22,14 +22,13 @@ import (
 	"net/http"
 	"strconv"
 
-	db "github.com/indyjo/bitwrk/server/gae"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 )
 
 // Handles requests for sets of account IDs.
 func HandleQueryAccounts(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := db.NewContext(r)
 
 	limitStr := r.FormValue("limit")
 	var limit int
@@ -0,0 +1,46 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
+)
+
+// Handles requests for the article catalog. Only articles with an entry of their own are
+// listed, built-in defaults are not.
+func HandleQueryArticles(w http.ResponseWriter, r *http.Request) {
+	c := db.NewContext(r)
+
+	result := make([]bitwrk.Article, 0, 16)
+	handler := func(article *bitwrk.Article) {
+		result = append(result, *article)
+	}
+
+	if err := db.QueryArticles(c, handler); err != nil {
+		log.Errorf(c, "QueryArticles failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
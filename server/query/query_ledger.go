@@ -22,14 +22,14 @@ import (
 	"strconv"
 	"time"
 
-	db "github.com/indyjo/bitwrk/server/gae"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 )
 
 // Handles requests for account movements (ledger entries)
 func HandleQueryAccountMovements(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := db.NewContext(r)
 	limitStr := r.FormValue("limit")
 	var limit int
 	if limitStr == "" {
@@ -61,3 +61,59 @@ func HandleQueryAccountMovements(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(result)
 	}
 }
+
+// Verifies the ledgers of the given accounts (parameter "account", may be repeated) or, if
+// none are given, of up to "limit" accounts. Admin-only, as it reads every account movement.
+func HandleVerifyLedger(w http.ResponseWriter, r *http.Request) {
+	c := db.NewContext(r)
+	if !db.IsAdmin(c) {
+		http.Error(w, "Action requires admin privileges", http.StatusForbidden)
+		return
+	}
+
+	limitStr := r.FormValue("limit")
+	var limit int
+	if limitStr == "" {
+		limit = 100
+	} else if n, err := strconv.ParseUint(limitStr, 10, 14); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else {
+		limit = int(n)
+	}
+
+	participants := r.Form["account"]
+	if len(participants) == 0 {
+		handler := func(key string) {
+			participants = append(participants, key)
+		}
+		if err := db.QueryAccountKeys(c, limit, false, handler); err != nil {
+			log.Errorf(c, "QueryAccountKeys failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	dao := db.NewAccountingDao(c, false)
+	result := make([]*bitwrk.LedgerReport, 0, len(participants))
+	for _, participant := range participants {
+		account, err := dao.GetAccount(participant)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if report, err := bitwrk.VerifyLedger(&account, dao.GetMovement); err != nil {
+			log.Errorf(c, "Verifying ledger of %v failed: %v", participant, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			if !report.Ok() {
+				log.Warningf(c, "Ledger of %v has discrepancies: %v", participant, report.Discrepancies)
+			}
+			result = append(result, report)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -28,12 +28,9 @@ import (
 
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/common/money"
-	db "github.com/indyjo/bitwrk/server/gae"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	"github.com/indyjo/bitwrk/server/util"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
-	"google.golang.org/appengine/user"
 )
 
 type timeslot struct {
@@ -140,7 +137,7 @@ func (r resolution) finestTileResolution() resolution {
 func HandleQueryPrices(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	c := appengine.NewContext(r)
+	c := db.NewContext(r)
 
 	needLogin := false
 
@@ -149,7 +146,7 @@ func HandleQueryPrices(w http.ResponseWriter, r *http.Request) {
 	if articleStr == "" {
 		http.Error(w, "article argument missing", http.StatusNotFound)
 		return
-	} else if _, err := util.CheckArticle(c, articleStr); err != nil {
+	} else if _, _, err := util.CheckArticle(c, articleStr); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	} else {
@@ -219,7 +216,7 @@ func HandleQueryPrices(w http.ResponseWriter, r *http.Request) {
 	begin = begin.Truncate(tile.interval)
 
 	// Enforce admin permissions if necessary
-	if needLogin && !user.IsAdmin(c) {
+	if needLogin && !db.IsAdmin(c) {
 		http.Error(w, "Action requires admin privileges", http.StatusForbidden)
 		return
 	}
@@ -302,11 +299,11 @@ func queryPrices(c context.Context, article bitwrk.ArticleId, currency money.Cur
 
 	// First try to answer from cache
 	key := fmt.Sprintf("prices-tile-%v/%v-%v-%v-%v", tile.name, res.name, begin.Format(time.RFC3339), article, currency)
-	if item, err := memcache.Get(c, key); err == nil {
+	if value, err := db.CacheGet(c, key); err == nil {
 		result := make([]timeslot, 0)
-		if err := json.Unmarshal(item.Value, &result); err != nil {
+		if err := json.Unmarshal(value, &result); err != nil {
 			// Shouldn't happen
-			log.Errorf(c, "Couldn't unmarshal memcache entry for: %v : %v", key, err)
+			log.Errorf(c, "Couldn't unmarshal cache entry for: %v : %v", key, err)
 		} else {
 			return result, nil
 		}
@@ -358,20 +355,21 @@ func queryPrices(c context.Context, article bitwrk.ArticleId, currency money.Cur
 	}
 
 	// Before returning, update the cache.
-	item := memcache.Item{Key: key}
-	if data, err := json.Marshal(result); err != nil {
+	var data []byte
+	if d, err := json.Marshal(result); err != nil {
 		// Shouldn't happen
 		log.Errorf(c, "Error marshalling result: %v", err)
 	} else {
-		item.Value = data
+		data = d
 	}
 
 	// Tiles very close to now expire after 10 seconds
+	var expiration time.Duration
 	if begin.Add(tile.interval).After(time.Now().Add(-2 * time.Minute)) {
-		item.Expiration = 10 * time.Second
+		expiration = 10 * time.Second
 	}
 
-	if err := memcache.Add(c, &item); err != nil {
+	if err := db.CacheAdd(c, key, data, expiration); err != nil {
 		log.Errorf(c, "Error caching item for %v: %v", key, err)
 	}
 
@@ -380,8 +378,8 @@ func queryPrices(c context.Context, article bitwrk.ArticleId, currency money.Cur
 
 // Query for a list of transactions. Admin-only for now.
 func HandleQueryTrades(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	if !user.IsAdmin(c) {
+	c := db.NewContext(r)
+	if !db.IsAdmin(c) {
 		http.Error(w, "Action requires admin privileges", http.StatusForbidden)
 		return
 	}
@@ -403,7 +401,7 @@ func HandleQueryTrades(w http.ResponseWriter, r *http.Request) {
 	if articleStr == "" {
 		http.Error(w, "article argument missing", http.StatusNotFound)
 		return
-	} else if _, err := util.CheckArticle(c, articleStr); err != nil {
+	} else if _, _, err := util.CheckArticle(c, articleStr); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	} else {
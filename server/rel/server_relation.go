@@ -26,12 +26,10 @@ import (
 	"net/http"
 	"strings"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/server/config"
-	"github.com/indyjo/bitwrk/server/gae"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	nonce2 "github.com/indyjo/bitwrk/server/nonce"
 	"github.com/indyjo/bitwrk/server/util"
 )
@@ -48,6 +46,7 @@ const createHtml = `
 <select id="type" name="type">
 <option value="trusts" selected>trusts</option>
 <option value="worksfor">works for</option>
+<option value="arbiter">appoints as arbiter</option>
 </select> &larr; Choose the type of relation you would like to establish<br />
 <input id="target" type="text" name="target" size="64" value="1BiTWrKBPKT2yKdfEw77EAsCHgpjkqgPkv" onclick="select()" onchange="update()" /> &larr; The target account.<br />
 <select id="enabled" name="enabled">
@@ -74,7 +73,7 @@ func HandleCreate(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error executing template: "+err.Error(), http.StatusInternalServerError)
 		}
 	} else if r.Method == "POST" {
-		c := appengine.NewContext(r)
+		c := db.NewContext(r)
 
 		relType := r.FormValue("type")
 		source := r.FormValue("source")
@@ -132,7 +131,7 @@ func createRelation(c context.Context, relType, source, target, nonce, enabled,
 	}
 
 	// No need to run in transaction, there is only one write operation and no read
-	dao := gae.NewGaeAccountingDao(c, false)
+	dao := db.NewAccountingDao(c, false)
 	return dao.SaveRelation(relation)
 }
 
@@ -157,8 +156,8 @@ func HandleRender(w http.ResponseWriter, r *http.Request) {
 		rtype = t
 	}
 
-	c := appengine.NewContext(r)
-	dao := gae.NewGaeAccountingDao(c, false)
+	c := db.NewContext(r)
+	dao := db.NewAccountingDao(c, false)
 
 	var relation *bitwrk.Relation
 	if rn, err := dao.GetRelation(parts[0], parts[2], rtype); err == bitwrk.ErrNoSuchObject {
@@ -28,12 +28,10 @@ import (
 	"bitbucket.org/ww/goautoneg"
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/common/money"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 
 	"github.com/indyjo/bitwrk/server/config"
-	db "github.com/indyjo/bitwrk/server/gae"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	"github.com/indyjo/bitwrk/server/nonce"
 	"github.com/indyjo/bitwrk/server/util"
 )
@@ -113,8 +111,8 @@ func handleAccount(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		c := appengine.NewContext(r)
-		dao := db.NewGaeAccountingDao(c, false)
+		c := db.NewContext(r)
+		dao := db.NewAccountingDao(c, false)
 		var err error
 		account, err := dao.GetAccount(accountId)
 
@@ -137,7 +135,7 @@ func handleAccount(w http.ResponseWriter, r *http.Request) {
 			log.Errorf(c, "Error rendering %v as %v: %v", r.URL, contentType, err)
 		}
 	} else if r.Method == "POST" {
-		c := appengine.NewContext(r)
+		c := db.NewContext(r)
 		log.Infof(c, "Got POST for account: %v", accountId)
 		action := r.FormValue("action")
 		if action == "storedepositinfo" {
@@ -203,7 +201,7 @@ func requestDepositAddress(c context.Context, r *http.Request, participant strin
 	}
 
 	f := func(c context.Context) error {
-		dao := db.NewGaeAccountingDao(c, true)
+		dao := db.NewAccountingDao(c, true)
 		if account, err := dao.GetAccount(participant); err != nil {
 			return err
 		} else if account.DepositAddressRequest != "" {
@@ -222,7 +220,7 @@ func requestDepositAddress(c context.Context, r *http.Request, participant strin
 		return dao.Flush()
 	}
 
-	if err := datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true}); err != nil {
+	if err := db.RunInTransaction(c, f); err != nil {
 		// Transaction failed
 		log.Errorf(c, "Transaction failed: %v", err)
 		return err
@@ -262,7 +260,7 @@ func storeDepositInfo(c context.Context, r *http.Request, participant string) (e
 	}
 
 	f := func(c context.Context) error {
-		dao := db.NewGaeAccountingDao(c, true)
+		dao := db.NewAccountingDao(c, true)
 		if account, err := dao.GetAccount(participant); err != nil {
 			return err
 		} else {
@@ -282,7 +280,7 @@ func storeDepositInfo(c context.Context, r *http.Request, participant string) (e
 		return dao.Flush()
 	}
 
-	if err := datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true}); err != nil {
+	if err := db.RunInTransaction(c, f); err != nil {
 		// Transaction failed
 		log.Errorf(c, "Transaction failed: %v", err)
 		return err
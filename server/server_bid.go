@@ -29,12 +29,10 @@ import (
 
 	"bitbucket.org/ww/goautoneg"
 	"github.com/indyjo/bitwrk/common/bitwrk"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 
 	"github.com/indyjo/bitwrk/server/config"
-	db "github.com/indyjo/bitwrk/server/gae"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	"github.com/indyjo/bitwrk/server/nonce"
 	"github.com/indyjo/bitwrk/server/util"
 )
@@ -108,7 +106,7 @@ func handleRenderBid(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		c := appengine.NewContext(r)
+		c := db.NewContext(r)
 		bid, err := db.GetBid(c, bidId)
 		if err != nil {
 			http.Error(w, "Bid not found: "+bidId, http.StatusNotFound)
@@ -148,7 +146,7 @@ func handleCreateBid(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error executing template: "+err.Error(), http.StatusInternalServerError)
 		}
 	} else if r.Method == "POST" {
-		c := appengine.NewContext(r)
+		c := db.NewContext(r)
 		if err := r.ParseForm(); err != nil {
 			log.Errorf(c, "Couldn't parse form data: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -167,7 +165,7 @@ func handleCreateBid(w http.ResponseWriter, r *http.Request) {
 
 // The server's set of defaults for new bids:
 //  - State is InQueue
-//  - Fee is 3 percent
+//  - Fee is 3 percent, unless specified otherwise by the article catalog
 //  - Created is time.Now()
 //  - Exprires is 120s from now
 var newBidDefaults = bitwrk.NewBidDefaults{
@@ -193,18 +191,25 @@ func enqueueBid(c context.Context, w http.ResponseWriter, r *http.Request) (err
 		return fmt.Errorf("Error in CheckNonce: %v", err)
 	}
 
-	trusted, err := util.CheckArticle(c, bidArticle)
+	articleInfo, trusted, err := util.CheckArticle(c, bidArticle)
 	if err != nil {
 		return
 	}
 
+	// Articles may override the server's default fee
+	defaults := newBidDefaults
+	if articleInfo.FeeRatioDenominator != 0 {
+		defaults.FeeRatioNumerator = articleInfo.FeeRatioNumerator
+		defaults.FeeRatioDenominator = articleInfo.FeeRatioDenominator
+	}
+
 	err = util.CheckBitcoinAddress(bidAddress)
 	if err != nil {
 		return
 	}
 
 	bid, err := bitwrk.ParseBid(bidType, bidArticle, bidPrice, bidAddress, bidNonce, bidSignature,
-		&newBidDefaults)
+		&defaults)
 	if err != nil {
 		return
 	}
@@ -218,7 +223,7 @@ func enqueueBid(c context.Context, w http.ResponseWriter, r *http.Request) (err
 
 	// If this is a trusted sell, check that seller is trusted by configured account.
 	if bid.Type == bitwrk.Sell && trusted && config.CfgRequireTrustsRelation {
-		dao := db.NewGaeAccountingDao(c, false)
+		dao := db.NewAccountingDao(c, false)
 		rel, err := dao.GetRelation(config.CfgTrustsRelationAccount, bidAddress, bitwrk.RELATION_TYPE_TRUSTS)
 		if err == bitwrk.ErrNoSuchObject || (err == nil && !rel.Enabled) {
 			return errSellerNotTrusted
@@ -243,11 +248,11 @@ func enqueueBid(c context.Context, w http.ResponseWriter, r *http.Request) (err
 	return
 }
 
-func redirectToBid(bidKey *datastore.Key, w http.ResponseWriter, r *http.Request) {
-	bidUrl, _ := url.Parse("/bid/" + bidKey.Encode())
+func redirectToBid(bidKey string, w http.ResponseWriter, r *http.Request) {
+	bidUrl, _ := url.Parse("/bid/" + bidKey)
 	bidUrl = r.URL.ResolveReference(bidUrl)
 	w.Header().Set("Location", bidUrl.RequestURI())
-	w.Header().Set("X-Bid-Key", bidKey.Encode())
+	w.Header().Set("X-Bid-Key", bidKey)
 	w.WriteHeader(http.StatusSeeOther)
 }
 
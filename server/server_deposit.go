@@ -26,12 +26,10 @@ import (
 
 	"bitbucket.org/ww/goautoneg"
 	"github.com/indyjo/bitwrk/common/bitwrk"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 
 	"github.com/indyjo/bitwrk/server/config"
-	db "github.com/indyjo/bitwrk/server/gae"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	"github.com/indyjo/bitwrk/server/nonce"
 	"github.com/indyjo/bitwrk/server/util"
 )
@@ -98,7 +96,7 @@ func handleCreateDeposit(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error executing template: "+err.Error(), http.StatusInternalServerError)
 		}
 	} else if r.Method == "POST" {
-		c := appengine.NewContext(r)
+		c := db.NewContext(r)
 		depositType := r.FormValue("type")
 		depositAccount := r.FormValue("account")
 		depositAmount := r.FormValue("amount")
@@ -143,14 +141,14 @@ func createDeposit(c context.Context, depositType, depositAccount, depositAmount
 	}
 
 	f := func(c context.Context) error {
-		dao := db.NewGaeAccountingDao(c, true)
+		dao := db.NewAccountingDao(c, true)
 		if err := deposit.Place(depositUid, dao); err != nil {
 			return err
 		}
 		return dao.Flush()
 	}
 
-	if err := datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true}); err != nil {
+	if err := db.RunInTransaction(c, f); err != nil {
 		// Transaction failed
 		return err
 	}
@@ -179,8 +177,8 @@ func handleRenderDeposit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
-	dao := db.NewGaeAccountingDao(c, false)
+	c := db.NewContext(r)
+	dao := db.NewAccountingDao(c, false)
 
 	deposit, err := dao.GetDeposit(uid)
 	if err != nil {
@@ -24,9 +24,8 @@ import (
 
 	"bitbucket.org/ww/goautoneg"
 	"github.com/indyjo/bitwrk/common/bitwrk"
-	db "github.com/indyjo/bitwrk/server/gae"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 )
 
 const movementViewHtml = `
@@ -46,6 +45,9 @@ const movementViewHtml = `
 {{if .DepositKey}}
 &raquo; <a href="/deposit/{{.DepositKey}}">Deposit</a>
 {{end}}
+{{if .WithdrawalKey}}
+&raquo; <a href="/withdrawal/{{.WithdrawalKey}}">Withdrawal</a>
+{{end}}
 </td></tr>
 <tr><th>Fee</th><td>{{.Fee}}</td></tr>
 <tr><th>World</th><td>{{.World}}</td></tr>
@@ -81,8 +83,8 @@ func handleAccountMovement(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		c := appengine.NewContext(r)
-		dao := db.NewGaeAccountingDao(c, false)
+		c := db.NewContext(r)
+		dao := db.NewAccountingDao(c, false)
 		var err error
 		movement, err := dao.GetMovement(movementKey)
 
@@ -20,10 +20,9 @@ import (
 	"fmt"
 	"net/http"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/user"
-
+	"github.com/indyjo/bitwrk/server/article"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	"github.com/indyjo/bitwrk/server/nonce"
 	"github.com/indyjo/bitwrk/server/query"
 	"github.com/indyjo/bitwrk/server/rel"
@@ -40,15 +39,21 @@ func init() {
 	http.HandleFunc("/account/", handleAccount)
 	http.HandleFunc("/rel", rel.HandleCreate)
 	http.HandleFunc("/rel/", rel.HandleRender)
+	http.HandleFunc("/article", article.HandleCreate)
+	http.HandleFunc("/article/", article.HandleRender)
 	http.HandleFunc("/ledger/", handleAccountMovement)
 	http.HandleFunc("/myip", handleMyIp)
 	http.HandleFunc("/motd", handleMessageOfTheDay)
 	http.HandleFunc("/deposit", handleCreateDeposit)
 	http.HandleFunc("/deposit/", handleRenderDeposit)
+	http.HandleFunc("/withdrawal", handleCreateWithdrawal)
+	http.HandleFunc("/withdrawal/", handleWithdrawal)
 	http.HandleFunc("/query/accounts", query.HandleQueryAccounts)
+	http.HandleFunc("/query/articles", query.HandleQueryArticles)
 	http.HandleFunc("/query/ledger", query.HandleQueryAccountMovements)
 	http.HandleFunc("/query/prices", query.HandleQueryPrices)
 	http.HandleFunc("/query/trades", query.HandleQueryTrades)
+	http.HandleFunc("/query/verifyledger", query.HandleVerifyLedger)
 	http.HandleFunc("/_ah/queue/apply-changes", handleApplyChanges)
 	http.HandleFunc("/_ah/queue/retire-tx", handleRetireTransaction)
 	http.HandleFunc("/_ah/queue/retire-bid", handleRetireBid)
@@ -60,9 +65,9 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
-	if u := user.Current(c); u == nil {
-		url, err := user.LoginURL(c, r.URL.String())
+	c := db.NewContext(r)
+	if u := db.CurrentUser(c); u == nil {
+		url, err := db.LoginURL(c, r.URL.String())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -81,9 +86,9 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
-	if u := user.Current(c); u != nil {
-		url, err := user.LogoutURL(c, r.URL.String())
+	c := db.NewContext(r)
+	if u := db.CurrentUser(c); u != nil {
+		url, err := db.LogoutURL(c, r.URL.String())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
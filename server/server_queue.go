@@ -21,31 +21,19 @@ import (
 	"strings"
 	"time"
 
-	db "github.com/indyjo/bitwrk/server/gae"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 )
 
-func mustDecodeKey(s string) *datastore.Key {
-	if key, err := datastore.DecodeKey(s); err != nil {
-		panic(err)
-	} else {
-		return key
-	}
-	return nil // never reached
-}
-
 func handleRetireTransaction(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	c := appengine.NewContext(r)
-	keyString := r.FormValue("tx")
-	key := mustDecodeKey(keyString)
-	log.Infof(c, "Retiring transaction %v (%v)", keyString, key)
+	c := db.NewContext(r)
+	key := r.FormValue("tx")
+	log.Infof(c, "Retiring transaction %v", key)
 	if err := db.RetireTransaction(c, key); err == db.ErrTransactionTooYoung {
 		log.Infof(c, "Transaction is too young to be retired")
 	} else if err == db.ErrTransactionAlreadyRetired {
@@ -62,10 +50,9 @@ func handleRetireBid(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
-	keyString := r.FormValue("bid")
-	key := mustDecodeKey(keyString)
-	log.Infof(c, "Retiring bid %v (%v)", keyString, key)
+	c := db.NewContext(r)
+	key := r.FormValue("bid")
+	log.Infof(c, "Retiring bid %v", key)
 	if err := db.RetireBid(c, key); err != nil {
 		log.Warningf(c, "Error retiring bid: %v", err)
 		http.Error(w, "Error retiring bid", http.StatusInternalServerError)
@@ -78,7 +65,7 @@ func handleApplyChanges(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
+	c := db.NewContext(r)
 	log.Infof(c, "Placing bids: %v", r.FormValue("placed"))
 	placedKeys := strings.Split(r.FormValue("placed"), " ")
 	if len(placedKeys) == 1 && placedKeys[0] == "" {
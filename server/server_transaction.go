@@ -19,6 +19,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -31,11 +32,9 @@ import (
 	"github.com/indyjo/bitwrk/common/bitcoin"
 	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/server/config"
-	db "github.com/indyjo/bitwrk/server/gae"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
 	"github.com/indyjo/bitwrk/server/util"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
 const txViewHtml = `
@@ -51,9 +50,30 @@ const txViewHtml = `
 <tr><th>Addresses</th><td><a href="/account/{{.Tx.Buyer}}">{{.Tx.Buyer}}</a></td><td><a href="/account/{{.Tx.Seller}}">{{.Tx.Seller}}</a></td></tr>
 <tr><th>Price</th><td colspan="2">{{.Tx.Price}}</td></tr>
 <tr><th>Phase</th><td colspan="2">{{.Tx.Phase}}</td></tr>
+<tr><th>Timeout</th><td colspan="2">{{.Tx.Timeout}}</td></tr>
+{{if .Tx.Extension}}
+<tr><th>Extended by</th><td colspan="2">{{.Tx.Extension}}</td></tr>
+{{end}}
+{{if .Tx.ProposedExtension}}
+<tr><th>Proposed extension</th><td colspan="2">{{.Tx.ProposedExtension}}</td></tr>
+{{end}}
 {{if .Tx.WorkerURL}}
 <tr><th>Worker's URL</th><td colspan="2">{{.Tx.WorkerURL}}</td></tr>
 {{end}}
+{{if .Tx.WorkHash}}
+<tr><th>Work hash</th><td colspan="2">{{.Tx.WorkHash}}</td></tr>
+{{end}}
+{{if .Tx.EncryptedResultReceipt}}
+<tr><th>Encrypted result hash</th><td colspan="2">{{.Tx.EncryptedResultReceipt.Hash}}</td></tr>
+<tr><th>Buyer's receipt</th><td colspan="2">{{.Tx.EncryptedResultReceipt.HashSignature}}</td></tr>
+{{end}}
+{{if .Tx.ResultDecryptionKey}}
+<tr><th>Result decryption key</th><td colspan="2">{{.Tx.ResultDecryptionKey}}</td></tr>
+{{end}}
+{{if .Tx.Arbiter}}
+<tr><th>Arbiter</th><td colspan="2"><a href="/account/{{.Tx.Arbiter}}">{{.Tx.Arbiter}}</a></td></tr>
+<tr><th>Seller's share</th><td colspan="2">{{.Tx.SellerShare}}%</td></tr>
+{{end}}
 </table>
 
 <script src="/js/getjson.js" ></script>
@@ -141,6 +161,17 @@ const txViewHtml = `
 </form>
 </tr>
 
+<tr>
+<form action="/tx/{{.Id}}" method="POST">
+<th>Seller / Buyer</th>
+<td><select name="address"><option value="{{.Tx.Seller}}">Seller proposes</option><option value="{{.Tx.Buyer}}">Buyer grants</option></select></td>
+<td><input id="extendtimeout" type="text" name="extendtimeout" placeholder="Extension, e.g. 10m" onchange="update()"/></td>
+<td/>
+<td><input type="signature" name="signature" placeholder="Paste signature here"/></td>
+<td><input type="submit" /></td>
+</form>
+</tr>
+
 <tr>
 <form action="/tx/{{.Id}}" method="POST">
 <th>Buyer</th>
@@ -164,6 +195,17 @@ const txViewHtml = `
 <td><input type="submit" /></td>
 </form>
 </tr>
+
+<tr>
+<form action="/tx/{{.Id}}" method="POST">
+<th>Arbiter</th>
+<td><input type="text" name="address" placeholder="Arbiter's address" /></td>
+<td><input id="sellershare" type="text" name="sellershare" placeholder="Seller's share (0-100%)" onchange="update()"/></td>
+<td/>
+<td><input type="signature" name="signature" placeholder="Paste signature here"/></td>
+<td><input type="submit" /></td>
+</form>
+</tr>
 </table>
 
 </body>
@@ -188,20 +230,19 @@ func handleTx(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
+	c := db.NewContext(r)
 	txId := r.URL.Path[4:]
 
-	txKey, err := datastore.DecodeKey(txId)
-	if err != nil {
-		log.Warningf(c, "Illegal tx id queried: '%v'", txId)
-		http.Error(w, "Transaction not found: "+txId, http.StatusNotFound)
+	if strings.HasSuffix(txId, "/messages") {
+		handleTxMessages(c, w, r, strings.TrimSuffix(txId, "/messages"))
 		return
 	}
 
 	var tx *bitwrk.Transaction
 	var messages []bitwrk.Tmessage
+	var err error
 	if r.Method == "POST" {
-		err = updateTransaction(c, r, txId, txKey)
+		err = updateTransaction(c, r, txId)
 		if err != nil {
 			message := fmt.Sprintf("Couldn't update transaction %#v: %v", txId, err)
 			log.Warningf(c, "%v", message)
@@ -213,7 +254,7 @@ func handleTx(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// GET only
-	tx, err = db.GetTransaction(c, txKey)
+	tx, err = db.GetTransaction(c, txId)
 	if err != nil {
 		log.Warningf(c, "Datastore lookup failed for tx id: '%v'", txId)
 		log.Warningf(c, "Reason: %v", err)
@@ -229,7 +270,7 @@ func handleTx(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, _ = db.GetTransactionMessages(c, txKey)
+	messages, _ = db.GetTransactionMessages(c, txId)
 
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("ETag", etag)
@@ -245,6 +286,33 @@ func handleTx(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Handler for /tx/<txid>/messages, serving the messages received by a transaction as JSON.
+// Together with the transaction, they allow anyone to verify the signatures of all
+// participants.
+func handleTxMessages(c context.Context, w http.ResponseWriter, r *http.Request, txId string) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := db.GetTransaction(c, txId); err != nil {
+		http.Error(w, "Transaction not found: "+txId, http.StatusNotFound)
+		return
+	}
+	messages, err := db.GetTransactionMessages(c, txId)
+	if err != nil {
+		log.Errorf(c, "Error querying messages of tx %v: %v", txId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []bitwrk.Tmessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(messages); err != nil {
+		log.Errorf(c, "Error rendering %v: %v", r.URL, err)
+	}
+}
+
 func redirectToTransaction(txId string, w http.ResponseWriter, r *http.Request) {
 	txUrl, _ := url.Parse("/tx/" + txId)
 	txUrl = r.URL.ResolveReference(txUrl)
@@ -284,7 +352,19 @@ func makeDocument(m map[string]string) string {
 	return strings.Join(arguments, "&")
 }
 
-func updateTransaction(c context.Context, r *http.Request, txId string, txKey *datastore.Key) error {
+var errNotAnArbiter = errors.New("sender is not an appointed arbiter")
+
+// Checks that the given participant has been appointed as arbiter by the configured account.
+func checkArbiter(c context.Context, address string) error {
+	dao := db.NewAccountingDao(c, false)
+	rel, err := dao.GetRelation(config.CfgArbitrationAccount, address, bitwrk.RELATION_TYPE_ARBITER)
+	if err == bitwrk.ErrNoSuchObject || (err == nil && !rel.Enabled) {
+		return errNotAnArbiter
+	}
+	return err
+}
+
+func updateTransaction(c context.Context, r *http.Request, txId string) error {
 	now := time.Now()
 
 	r.ParseForm()
@@ -350,7 +430,14 @@ func updateTransaction(c context.Context, r *http.Request, txId string, txKey *d
 	// no need for txid in values anymore
 	delete(values, "txid")
 
-	if err := db.UpdateTransaction(c, txKey, now, address, values, document, signature); err != nil {
+	// Rulings on disputed results are only accepted from appointed arbiters
+	if _, ok := values["sellershare"]; ok {
+		if err := checkArbiter(c, address); err != nil {
+			return err
+		}
+	}
+
+	if err := db.UpdateTransaction(c, txId, now, address, values, document, signature); err != nil {
 		return err
 	}
 
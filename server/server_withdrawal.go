@@ -0,0 +1,241 @@
+//  BitWrk - A Bitcoin-friendly, anonymous marketplace for computing power
+//  Copyright (C) 2019-2019  Jonas Eschenburg <jonas@bitwrk.net>
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"time"
+
+	"bitbucket.org/ww/goautoneg"
+	"github.com/indyjo/bitwrk/common/bitwrk"
+	"github.com/indyjo/bitwrk/common/money"
+
+	"github.com/indyjo/bitwrk/server/config"
+	"github.com/indyjo/bitwrk/server/db"
+	"github.com/indyjo/bitwrk/server/log"
+	"github.com/indyjo/bitwrk/server/nonce"
+	"github.com/indyjo/bitwrk/server/util"
+)
+
+const withdrawalViewHtml = `
+<!doctype html>
+<html>
+<head><title>View Withdrawal</title></head>
+<body>
+<table>
+<tr><th>Withdrawal</th><td>{{.Uid}}</td></tr>
+<tr><th>Account</th><td><a href="/account/{{.Withdrawal.Account}}">{{.Withdrawal.Account}}</a></td></tr>
+<tr><th>Amount</th><td>{{.Withdrawal.Amount}}</td></tr>
+<tr><th>Address</th><td>{{.Withdrawal.Address}}</td></tr>
+<tr><th>State</th><td>{{.Withdrawal.State}}</td></tr>
+<tr><th>Created</th><td>{{.Withdrawal.Created}}</td></tr>
+{{if .Withdrawal.Settlement}}
+<tr><th>Settled</th><td>{{.Withdrawal.Settled}}</td></tr>
+<tr><th>Reference</th><td>{{.Withdrawal.Reference}}</td></tr>
+{{end}}
+</table>
+<script src="/js/getjson.js" ></script>
+</body>
+</html>
+`
+
+var withdrawalViewTemplate = template.Must(template.New("withdrawalView").Parse(withdrawalViewHtml))
+
+// Handler function for /withdrawal
+func handleCreateWithdrawal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := db.NewContext(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := bitwrk.WithdrawalRequest{}
+	req.FromValues(r.Form)
+
+	if err := createWithdrawal(c, &req); err != nil {
+		http.Error(w, "Error creating withdrawal: "+err.Error(), http.StatusInternalServerError)
+		log.Warningf(c, "Error creating withdrawal %#v: %v", req, err)
+	} else {
+		http.Redirect(w, r, "/withdrawal/"+req.Uid, http.StatusSeeOther)
+	}
+}
+
+func createWithdrawal(c context.Context, req *bitwrk.WithdrawalRequest) (err error) {
+	// Important: checking (and invalidating) the nonce must be the first thing we do!
+	err = nonce.CheckNonce(c, req.Nonce)
+	if config.CfgRequireValidNonce && err != nil {
+		return fmt.Errorf("Error in CheckNonce: %v", err)
+	}
+
+	if err := util.CheckBitcoinAddress(req.Participant); err != nil {
+		return err
+	}
+
+	// Bitcoin addresses must have the right network id
+	if err := util.CheckBitcoinAddress(req.Address); err != nil {
+		return err
+	}
+
+	if req.Signer != req.Participant {
+		return fmt.Errorf("Signer must be %#v", req.Participant)
+	}
+
+	if config.CfgRequireValidSignature {
+		if err := req.VerifyWith(req.Participant); err != nil {
+			return fmt.Errorf("After verifying %#v against %v: %v", req, req.Participant, err)
+		}
+	}
+
+	withdrawal, err := bitwrk.NewWithdrawal(req, time.Now())
+	if err != nil {
+		return err
+	}
+
+	minimum := money.MustParse(config.CfgMinimumWithdrawal)
+	if withdrawal.Amount.Currency != minimum.Currency || withdrawal.Amount.Amount < minimum.Amount {
+		return fmt.Errorf("Minimum withdrawal amount is %v", minimum)
+	}
+
+	f := func(c context.Context) error {
+		dao := db.NewAccountingDao(c, true)
+		if err := withdrawal.Place(req.Uid, dao); err != nil {
+			return err
+		}
+		return dao.Flush()
+	}
+
+	if err := db.RunInTransaction(c, f); err != nil {
+		// Transaction failed
+		return err
+	}
+
+	log.Infof(c, "Withdrawal requested: %#v", withdrawal)
+	return
+}
+
+// Handler function for /withdrawal/<uid>. Renders the withdrawal on GET and
+// accepts the payment processor's settlement on POST.
+func handleWithdrawal(w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Path[12:]
+
+	if r.Method == "POST" {
+		c := db.NewContext(r)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s := bitwrk.WithdrawalSettlement{}
+		s.FromValues(r.Form)
+		if s.Uid != uid {
+			http.Error(w, fmt.Sprintf("UID must be %#v", uid), http.StatusBadRequest)
+		} else if err := settleWithdrawal(c, &s); err != nil {
+			http.Error(w, "Error settling withdrawal: "+err.Error(), http.StatusInternalServerError)
+			log.Warningf(c, "Error settling withdrawal %#v: %v", s, err)
+		} else {
+			http.Redirect(w, r, r.RequestURI, http.StatusSeeOther)
+		}
+		return
+	} else if r.Method != "GET" {
+		http.Error(w, "Method not allowed: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	acceptable := []string{"text/html", "application/json"}
+	contentType := goautoneg.Negotiate(r.Header.Get("Accept"), acceptable)
+	if contentType == "" {
+		http.Error(w,
+			fmt.Sprintf("No accepted content type found. Supported: %v", acceptable),
+			http.StatusNotAcceptable)
+		return
+	}
+
+	c := db.NewContext(r)
+	dao := db.NewAccountingDao(c, false)
+
+	withdrawal, err := dao.GetWithdrawal(uid)
+	if err != nil {
+		http.Error(w, "Withdrawal not found: "+uid, http.StatusNotFound)
+		log.Warningf(c, "Non-existing withdrawal queried: '%v'", uid)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if contentType == "application/json" {
+		err = renderWithdrawalJson(w, withdrawal)
+	} else {
+		err = renderWithdrawalHtml(w, uid, withdrawal)
+	}
+
+	if err != nil {
+		log.Errorf(c, "Error rendering %v as %v: %v", r.URL, contentType, err)
+	}
+}
+
+func settleWithdrawal(c context.Context, s *bitwrk.WithdrawalSettlement) (err error) {
+	// Important: checking (and invalidating) the nonce must be the first thing we do!
+	err = nonce.CheckNonce(c, s.Nonce)
+	if config.CfgRequireValidNonce && err != nil {
+		return fmt.Errorf("Error in CheckNonce: %v", err)
+	}
+
+	if s.Signer != config.CfgTrustedAccount {
+		return fmt.Errorf("Signer must be %#v", config.CfgTrustedAccount)
+	}
+
+	if config.CfgRequireValidSignature {
+		if err := s.VerifyWith(config.CfgTrustedAccount); err != nil {
+			return err
+		}
+	}
+
+	f := func(c context.Context) error {
+		dao := db.NewAccountingDao(c, true)
+		if _, err := bitwrk.SettleWithdrawal(dao, s, time.Now()); err != nil {
+			return err
+		}
+		return dao.Flush()
+	}
+
+	if err := db.RunInTransaction(c, f); err != nil {
+		// Transaction failed
+		return err
+	}
+
+	log.Infof(c, "Withdrawal settled: %#v", s)
+	return
+}
+
+func renderWithdrawalHtml(w io.Writer, uid string, withdrawal bitwrk.Withdrawal) error {
+	type context struct {
+		Uid        string
+		Withdrawal bitwrk.Withdrawal
+	}
+	return withdrawalViewTemplate.Execute(w, context{uid, withdrawal})
+}
+
+func renderWithdrawalJson(w io.Writer, withdrawal bitwrk.Withdrawal) error {
+	return json.NewEncoder(w).Encode(withdrawal)
+}
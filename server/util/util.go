@@ -20,11 +20,12 @@ package util
 import (
 	"context"
 	"fmt"
-	"regexp"
 	"strings"
 
 	"github.com/indyjo/bitwrk/common/bitcoin"
+	"github.com/indyjo/bitwrk/common/bitwrk"
 	"github.com/indyjo/bitwrk/server/config"
+	"github.com/indyjo/bitwrk/server/db"
 )
 
 // Check whether a bitcoin address is from the 'right' network,
@@ -43,29 +44,23 @@ func CheckBitcoinAddress(address string) error {
 	return nil
 }
 
-var blenderRegexp = regexp.MustCompile(`^(net\.bitwrk/blender/0/2\.(69|7[0-9]|8[0-2])/(512M|2G|8G|32G))$`)
-
 // Function CheckArticle accepts an article id string and returns
+//  - the catalog entry applying to the article
 //  - whether the article contains a "~trusted" clause
-//  - nil or an error if the article doesn't match the articles traded on the service.
-func CheckArticle(_ context.Context, article string) (bool, error) {
-	trusted := false
-	if strings.HasSuffix(article, "~trusted") {
-		article = article[:len(article) - len("~trusted")]
-		trusted = true
-	}
-	switch article {
-	case "fnord", "snafu", "foobar",
-		"net.bitwrk/gorays/0":
-		// TODO: add real article management
-	default:
-		if blenderRegexp.MatchString(article) {
-		} else {
-			return trusted, fmt.Errorf("Article not traded here: %#v", article)
-		}
+//  - nil or an error if the article isn't traded on the service.
+// Articles without an entry in the catalog are checked against a built-in list.
+func CheckArticle(c context.Context, article string) (*bitwrk.Article, bool, error) {
+	_, trusted := bitwrk.ArticleBase(bitwrk.ArticleId(article))
+	dao := db.NewAccountingDao(c, false)
+	if info, err := bitwrk.LookupArticle(dao, bitwrk.ArticleId(article)); err == bitwrk.ErrNoSuchObject {
+		return nil, trusted, fmt.Errorf("Article not traded here: %#v", article)
+	} else if err != nil {
+		return nil, trusted, err
+	} else if !info.Enabled {
+		return nil, trusted, fmt.Errorf("Article no longer traded here: %#v", article)
+	} else {
+		return info, trusted, nil
 	}
-
-	return trusted, nil
 }
 
 // Given a string in format host, host:port or [host]:port, returns the host part.
@@ -0,0 +1,18 @@
+function update() {
+    var article = document.getElementById("article").value.replace(/\s+/g, '');
+    var description = document.getElementById("description").value.replace(/\s+/g, '');
+    var enabled = document.getElementById("enabled").value.replace(/\s+/g, '');
+    var feeratio = document.getElementById("feeratio").value.replace(/\s+/g, '');
+    var maxworksize = document.getElementById("maxworksize").value.replace(/\s+/g, '');
+    var nonce = document.getElementById("nonce").value.replace(/\s+/g, '');
+    var timeouts = document.getElementById("timeouts").value.replace(/\s+/g, '');
+
+    var q = "article=" + encodeURIComponent(article);
+    q = q + "&description=" + encodeURIComponent(description);
+    q = q + "&enabled=" + encodeURIComponent(enabled);
+    q = q + "&feeratio=" + encodeURIComponent(feeratio);
+    q = q + "&maxworksize=" + encodeURIComponent(maxworksize);
+    q = q + "&nonce=" + encodeURIComponent(nonce);
+    q = q + "&timeouts=" + encodeURIComponent(timeouts);
+    document.getElementById("query").value = q;
+}
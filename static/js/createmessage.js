@@ -22,8 +22,10 @@ function update() {
     q = append(q, "encresulthash");
     q = append(q, "encresulthashsig");
     q = append(q, "encresultkey");
+    q = append(q, "extendtimeout");
     q = appendCheck(q, "rejectresult");
     q = appendCheck(q, "rejectwork");
+    q = append(q, "sellershare");
     q = append(q, "txid");
     q = append(q, "workerurl");
     q = append(q, "workhash");